@@ -2,7 +2,9 @@ package pub
 
 import (
 	"context"
+	"github.com/go-fed/activity/streams/vocab"
 	"net/http"
+	"net/url"
 )
 
 // Actor represents ActivityPub's actor concept. It conceptually has an inbox
@@ -40,6 +42,23 @@ type Actor interface {
 	// http.StatusMethodNotAllowed status code in the response. No side
 	// effects occur.
 	PostInbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error)
+	// PostSharedInbox returns true if the request was handled as an
+	// ActivityPub POST to the shared inbox of this server. If false, the
+	// request was not an ActivityPub request and may still be handled by
+	// the caller in another way.
+	//
+	// If the error is nil, then the ResponseWriter's headers and response
+	// has already been written. If a non-nil error is returned, then no
+	// response has been written.
+	//
+	// The request is authenticated and authorized once, then the side
+	// effects of PostInbox occur for each actor of this server that the
+	// activity is addressed to.
+	//
+	// If the Federated Protocol is not enabled, writes the
+	// http.StatusMethodNotAllowed status code in the response. No side
+	// effects occur.
+	PostSharedInbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error)
 	// GetInbox returns true if the request was handled as an ActivityPub
 	// GET to an actor's inbox. If false, the request was not an ActivityPub
 	// request and may still be handled by the caller in another way, such
@@ -50,10 +69,11 @@ type Actor interface {
 	// response has been written.
 	//
 	// If the request is an ActivityPub request, the Actor will defer to the
-	// application to determine the correct authorization of the request and
-	// the resulting OrderedCollection to respond with. The Actor handles
-	// serializing this OrderedCollection and responding with the correct
-	// headers and http.StatusOK.
+	// application to determine the correct authorization of the request.
+	// It responds with the OrderedCollection of the inbox, which links to
+	// its first page, or with the OrderedCollectionPage requested by the
+	// 'page', 'max_id', and 'min_id' query parameters, obtained from the
+	// Database, along with the correct headers and http.StatusOK.
 	GetInbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error)
 	// PostOutbox returns true if the request was handled as an ActivityPub
 	// POST to an actor's outbox. If false, the request was not an
@@ -84,9 +104,30 @@ type Actor interface {
 	// response has been written.
 	//
 	// If the request is an ActivityPub request, the Actor will defer to the
-	// application to determine the correct authorization of the request and
-	// the resulting OrderedCollection to respond with. The Actor handles
-	// serializing this OrderedCollection and responding with the correct
-	// headers and http.StatusOK.
+	// application to determine the correct authorization of the request.
+	// It responds with the OrderedCollection of the outbox, which links to
+	// its first page, or with the OrderedCollectionPage requested by the
+	// 'page', 'max_id', and 'min_id' query parameters, obtained from the
+	// Database, along with the correct headers and http.StatusOK.
 	GetOutbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error)
 }
+
+// FederatingActor is an Actor that may also send activities of its own accord,
+// such as those the application creates on behalf of its users instead of
+// receiving them from a client.
+//
+// It is returned by NewFederatingActor and NewActor.
+type FederatingActor interface {
+	Actor
+	// Send the ActivityStreams value on behalf of the actor owning the
+	// outbox. It is handled like a POST to the outbox by a client: it is
+	// wrapped in a Create if it is not an Activity, given new ids, and
+	// added to the outbox with its side effects, before being delivered
+	// to federating peers.
+	//
+	// The side effects of the Social API only occur if it is enabled.
+	//
+	// The returned Activity is the one that was delivered, with its new
+	// id.
+	Send(c context.Context, outboxIRI *url.URL, t vocab.Type) (Activity, error)
+}
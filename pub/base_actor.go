@@ -5,12 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
 )
 
-// baseActor must satisfy the Actor interface.
+// baseActor must satisfy the Actor and FederatingActor interfaces.
 var _ Actor = &baseActor{}
+var _ FederatingActor = &baseActor{}
 
 // baseActor is an application-independent ActivityPub implementation. It does
 // not implement the entire protocol, and relies on a delegate to do so. It
@@ -78,7 +82,7 @@ func NewSocialActor(c CommonBehavior,
 func NewFederatingActor(c CommonBehavior,
 	s2s FederatingProtocol,
 	db Database,
-	clock Clock) Actor {
+	clock Clock) FederatingActor {
 	return &baseActor{
 		delegate: &sideEffectActor{
 			common: c,
@@ -104,7 +108,7 @@ func NewActor(c CommonBehavior,
 	c2s SocialProtocol,
 	s2s FederatingProtocol,
 	db Database,
-	clock Clock) Actor {
+	clock Clock) FederatingActor {
 	return &baseActor{
 		delegate: &sideEffectActor{
 			common: c,
@@ -153,72 +157,157 @@ func (b *baseActor) PostInbox(c context.Context, w http.ResponseWriter, r *http.
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return true, nil
 	}
+	activity, err := b.authenticateAndAuthorizePostInbox(c, w, r)
+	if err != nil {
+		return true, err
+	} else if activity == nil {
+		return true, nil
+	}
+	// Post the activity to the actor's inbox and trigger side effects for
+	// that particular Activity type. It is up to the delegate to resolve
+	// the given map.
+	err = b.delegate.PostInbox(c, r.URL, activity)
+	if err != nil {
+		// Special case: We know it is a bad request if the object or
+		// target properties needed to be populated, but weren't.
+		//
+		// Send the rejection to the peer.
+		if err == ErrObjectRequired || err == ErrTargetRequired {
+			w.WriteHeader(http.StatusBadRequest)
+			return true, nil
+		} else if err == ErrBlocked {
+			// Do not reveal the block to the peer.
+			w.WriteHeader(http.StatusAccepted)
+			return true, nil
+		} else if e, ok := err.(*InboxPolicyError); ok {
+			w.WriteHeader(e.Status)
+			return true, nil
+		}
+		return true, err
+	}
+	// Our side effects are complete, now delegate determining whether to
+	// do inbox forwarding, as well as the action to do it.
+	if err := b.delegate.InboxForwarding(c, r.URL, activity); err != nil {
+		return true, err
+	}
+	// Request has been processed. Begin responding to the request.
+	//
+	// Simply respond with an OK status to the peer.
+	w.WriteHeader(http.StatusOK)
+	return true, nil
+}
+
+// PostSharedInbox implements the generic algorithm for handling a POST request
+// to the shared inbox of the server independent on an application. It relies on
+// a delegate to implement application specific functionality.
+func (b *baseActor) PostSharedInbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	// Do nothing if it is not an ActivityPub POST request.
+	if !isActivityPubPost(r) {
+		return false, nil
+	}
+	// If the Federated Protocol is not enabled, then this endpoint is not
+	// enabled.
+	if !b.enableFederatedProtocol {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return true, nil
+	}
+	activity, err := b.authenticateAndAuthorizePostInbox(c, w, r)
+	if err != nil {
+		return true, err
+	} else if activity == nil {
+		return true, nil
+	}
+	// Determine the inboxes of the local actors that are recipients.
+	inboxes, err := b.delegate.SharedInboxRecipients(c, r.URL, activity)
+	if err != nil {
+		return true, err
+	}
+	// Post the activity to each actor's inbox and trigger side effects for
+	// that particular Activity type.
+	var posted []*url.URL
+	for _, inbox := range inboxes {
+		err = b.delegate.PostInbox(c, inbox, activity)
+		if _, ok := err.(*InboxPolicyError); ok || err == ErrBlocked {
+			// Only this recipient has blocked the actor or rejected
+			// the activity.
+			continue
+		} else if err != nil {
+			// Special case: We know it is a bad request if the
+			// object or target properties needed to be populated,
+			// but weren't.
+			//
+			// Send the rejection to the peer.
+			if err == ErrObjectRequired || err == ErrTargetRequired {
+				w.WriteHeader(http.StatusBadRequest)
+				return true, nil
+			}
+			return true, err
+		}
+		posted = append(posted, inbox)
+	}
+	// Our side effects are complete, now delegate determining whether to
+	// do inbox forwarding, as well as the action to do it. This also
+	// saves the activity in the database, so only do it once.
+	if len(posted) > 0 {
+		if err := b.delegate.InboxForwarding(c, posted[0], activity); err != nil {
+			return true, err
+		}
+	}
+	// Request has been processed. Begin responding to the request.
+	//
+	// Simply respond with an OK status to the peer.
+	w.WriteHeader(http.StatusOK)
+	return true, nil
+}
+
+// authenticateAndAuthorizePostInbox authenticates a POST request to an inbox,
+// then obtains and authorizes its activity.
+//
+// If a nil activity and nil error are returned, then the request was rejected
+// and the response has already been written.
+func (b *baseActor) authenticateAndAuthorizePostInbox(c context.Context, w http.ResponseWriter, r *http.Request) (Activity, error) {
 	// Check the peer request is authentic.
 	authenticated, err := b.delegate.AuthenticatePostInbox(c, w, r)
 	if err != nil {
-		return true, err
+		return nil, err
 	} else if !authenticated {
-		return true, nil
+		return nil, nil
 	}
 	// Begin processing the request, but have not yet applied
 	// authorization (ex: blocks). Obtain the activity reject unknown
 	// activities.
 	raw, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return true, err
+		return nil, err
 	}
 	var m map[string]interface{}
 	if err = json.Unmarshal(raw, &m); err != nil {
-		return true, err
+		return nil, err
 	}
 	asValue, err := streams.ToType(c, m)
 	if err != nil && !streams.IsUnmatchedErr(err) {
-		return true, err
+		return nil, err
 	} else if streams.IsUnmatchedErr(err) {
 		// Respond with bad request -- we do not understand the type.
 		w.WriteHeader(http.StatusBadRequest)
-		return true, nil
+		return nil, nil
 	}
 	activity, ok := asValue.(Activity)
 	if !ok {
-		return true, fmt.Errorf("activity streams value is not an Activity: %T", asValue)
+		return nil, fmt.Errorf("activity streams value is not an Activity: %T", asValue)
 	}
 	if activity.GetActivityStreamsId() == nil {
 		w.WriteHeader(http.StatusBadRequest)
-		return true, nil
+		return nil, nil
 	}
 	// Check authorization of the activity.
 	authorized, err := b.delegate.AuthorizePostInbox(c, w, activity)
 	if err != nil {
-		return true, err
+		return nil, err
 	} else if !authorized {
-		return true, nil
+		return nil, nil
 	}
-	// Post the activity to the actor's inbox and trigger side effects for
-	// that particular Activity type. It is up to the delegate to resolve
-	// the given map.
-	err = b.delegate.PostInbox(c, r.URL, activity)
-	if err != nil {
-		// Special case: We know it is a bad request if the object or
-		// target properties needed to be populated, but weren't.
-		//
-		// Send the rejection to the peer.
-		if err == ErrObjectRequired || err == ErrTargetRequired {
-			w.WriteHeader(http.StatusBadRequest)
-			return true, nil
-		}
-		return true, err
-	}
-	// Our side effects are complete, now delegate determining whether to
-	// do inbox forwarding, as well as the action to do it.
-	if err := b.delegate.InboxForwarding(c, r.URL, activity); err != nil {
-		return true, err
-	}
-	// Request has been processed. Begin responding to the request.
-	//
-	// Simply respond with an OK status to the peer.
-	w.WriteHeader(http.StatusOK)
-	return true, nil
+	return activity, nil
 }
 
 // GetInbox implements the generic algorithm for handling a GET request to an
@@ -241,14 +330,16 @@ func (b *baseActor) GetInbox(c context.Context, w http.ResponseWriter, r *http.R
 	if err != nil {
 		return true, err
 	}
-	// Deduplicate the 'orderedItems' property by ID.
-	err = dedupeOrderedItems(oc)
-	if err != nil {
-		return true, err
+	// Deduplicate the 'orderedItems' property of a page by ID.
+	if oi, ok := oc.(orderedItemser); ok {
+		err = dedupeOrderedItems(oi)
+		if err != nil {
+			return true, err
+		}
 	}
 	// Request has been processed. Begin responding to the request.
 	//
-	// Serialize the OrderedCollection.
+	// Serialize the OrderedCollection or OrderedCollectionPage.
 	m, err := serialize(oc)
 	if err != nil {
 		return true, err
@@ -258,15 +349,7 @@ func (b *baseActor) GetInbox(c context.Context, w http.ResponseWriter, r *http.R
 		return true, err
 	}
 	// Write the response.
-	addResponseHeaders(w.Header(), b.clock, raw)
-	w.WriteHeader(http.StatusOK)
-	n, err := w.Write(raw)
-	if err != nil {
-		return true, err
-	} else if n != len(raw) {
-		return true, fmt.Errorf("ResponseWriter.Write wrote %d of %d bytes", n, len(raw))
-	}
-	return true, nil
+	return true, writeResponse(w, r, b.clock, raw, http.StatusOK, time.Time{})
 }
 
 // PostOutbox implements the generic algorithm for handling a POST request to an
@@ -349,7 +432,8 @@ func (b *baseActor) PostOutbox(c context.Context, w http.ResponseWriter, r *http
 	// servers and/or the client who sent this request.
 	//
 	// If we are federating and the type is a deliverable one, then deliver
-	// the activity to federating peers.
+	// the activity to federating peers. The delegate may only enqueue the
+	// deliveries, so that the client does not wait on every peer.
 	if b.enableFederatedProtocol && deliverable {
 		if err := b.delegate.Deliver(c, r.URL, activity); err != nil {
 			return true, err
@@ -361,6 +445,55 @@ func (b *baseActor) PostOutbox(c context.Context, w http.ResponseWriter, r *http
 	return true, nil
 }
 
+// Send handles an ActivityStreams value sent by the application on behalf of
+// the actor owning the outbox, as if a client had POSTed it to the outbox, and
+// delivers it to federating peers.
+func (b *baseActor) Send(c context.Context, outboxIRI *url.URL, t vocab.Type) (Activity, error) {
+	if !b.enableFederatedProtocol {
+		return nil, fmt.Errorf("cannot send: the Federated Protocol is not enabled")
+	}
+	// If the value is not an Activity or type extending from Activity, then
+	// we need to wrap it in a Create Activity.
+	if !IsAnActivityType(t) {
+		var err error
+		t, err = b.delegate.WrapInCreate(c, t, outboxIRI)
+		if err != nil {
+			return nil, err
+		}
+	}
+	activity, ok := t.(Activity)
+	if !ok {
+		return nil, fmt.Errorf("activity streams value is not an Activity: %T", t)
+	}
+	if err := send(c, b.delegate, outboxIRI, activity); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+// send posts an activity generated by this server to the outbox, as if a client
+// posted it, and delivers it to its recipients if the side effects permit.
+func send(c context.Context, delegate DelegateActor, outboxIRI *url.URL, activity Activity) error {
+	// Delegate generating new IDs for the activity and all new objects.
+	if err := delegate.AddNewIds(c, activity); err != nil {
+		return err
+	}
+	m, err := serialize(activity)
+	if err != nil {
+		return err
+	}
+	// Post the activity to the actor's outbox and trigger side effects for
+	// that particular Activity type.
+	deliverable, err := delegate.PostOutbox(c, activity, outboxIRI, m)
+	if err != nil {
+		return err
+	}
+	if deliverable {
+		return delegate.Deliver(c, outboxIRI, activity)
+	}
+	return nil
+}
+
 // GetOutbox implements the generic algorithm for handling a Get request to an
 // actor's outbox independent on an application. It relies on a delegate to
 // implement application specific functionality.
@@ -383,7 +516,7 @@ func (b *baseActor) GetOutbox(c context.Context, w http.ResponseWriter, r *http.
 	}
 	// Request has been processed. Begin responding to the request.
 	//
-	// Serialize the OrderedCollection.
+	// Serialize the OrderedCollection or OrderedCollectionPage.
 	m, err := serialize(oc)
 	if err != nil {
 		return true, err
@@ -393,13 +526,5 @@ func (b *baseActor) GetOutbox(c context.Context, w http.ResponseWriter, r *http.
 		return true, err
 	}
 	// Write the response.
-	addResponseHeaders(w.Header(), b.clock, raw)
-	w.WriteHeader(http.StatusOK)
-	n, err := w.Write(raw)
-	if err != nil {
-		return true, err
-	} else if n != len(raw) {
-		return true, fmt.Errorf("ResponseWriter.Write wrote %d of %d bytes", n, len(raw))
-	}
-	return true, nil
+	return true, writeResponse(w, r, b.clock, raw, http.StatusOK, time.Time{})
 }
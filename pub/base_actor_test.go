@@ -105,7 +105,7 @@ func TestBaseActorSocialProtocol(t *testing.T) {
 		assertEqual(t, handled, true)
 		assertEqual(t, resp.Code, http.StatusOK)
 		respV := resp.Result()
-		assertEqual(t, respV.Header.Get(contentTypeHeader), "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\"")
+		assertEqual(t, respV.Header.Get(contentTypeHeader), "application/activity+json")
 		assertEqual(t, respV.Header.Get(dateHeader), nowDateHeader())
 		assertNotEqual(t, len(respV.Header.Get(digestHeader)), 0)
 		b, err := ioutil.ReadAll(respV.Body)
@@ -332,7 +332,7 @@ func TestBaseActorSocialProtocol(t *testing.T) {
 		assertEqual(t, handled, true)
 		assertEqual(t, resp.Code, http.StatusOK)
 		respV := resp.Result()
-		assertEqual(t, respV.Header.Get(contentTypeHeader), "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\"")
+		assertEqual(t, respV.Header.Get(contentTypeHeader), "application/activity+json")
 		assertEqual(t, respV.Header.Get(dateHeader), nowDateHeader())
 		assertNotEqual(t, len(respV.Header.Get(digestHeader)), 0)
 		b, err := ioutil.ReadAll(respV.Body)
@@ -491,6 +491,97 @@ func TestBaseActorFederatingProtocol(t *testing.T) {
 		assertEqual(t, handled, true)
 		assertEqual(t, resp.Code, http.StatusBadRequest)
 	})
+	t.Run("PostInboxAcceptedForErrBlocked", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		delegate, _, a := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := toAPRequest(toPostInboxRequest(testCreate))
+		delegate.EXPECT().AuthenticatePostInbox(ctx, resp, req).Return(true, nil)
+		delegate.EXPECT().AuthorizePostInbox(ctx, resp, toDeserializedForm(testCreate)).Return(true, nil)
+		delegate.EXPECT().PostInbox(ctx, mustParse(testMyInboxIRI), toDeserializedForm(testCreate)).Return(ErrBlocked)
+		// Run the test
+		handled, err := a.PostInbox(ctx, resp, req)
+		// Verify results
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusAccepted)
+	})
+	t.Run("PostInboxRespondsWithInboxPolicyStatus", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		delegate, _, a := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := toAPRequest(toPostInboxRequest(testCreate))
+		delegate.EXPECT().AuthenticatePostInbox(ctx, resp, req).Return(true, nil)
+		delegate.EXPECT().AuthorizePostInbox(ctx, resp, toDeserializedForm(testCreate)).Return(true, nil)
+		delegate.EXPECT().PostInbox(ctx, mustParse(testMyInboxIRI), toDeserializedForm(testCreate)).Return(&InboxPolicyError{Policy: "test", Status: http.StatusUnprocessableEntity})
+		// Run the test
+		handled, err := a.PostInbox(ctx, resp, req)
+		// Verify results
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusUnprocessableEntity)
+	})
+	t.Run("PostSharedInboxPostsToEachRecipient", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		delegate, _, a := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := toAPRequest(toPostSharedInboxRequest(testCreate))
+		delegate.EXPECT().AuthenticatePostInbox(ctx, resp, req).Return(true, nil)
+		delegate.EXPECT().AuthorizePostInbox(ctx, resp, toDeserializedForm(testCreate)).Return(true, nil)
+		delegate.EXPECT().SharedInboxRecipients(ctx, mustParse(testMySharedInboxIRI), toDeserializedForm(testCreate)).Return([]*url.URL{mustParse(testMyInboxIRI), mustParse(testMyOtherInboxIRI)}, nil)
+		delegate.EXPECT().PostInbox(ctx, mustParse(testMyInboxIRI), toDeserializedForm(testCreate)).Return(nil)
+		delegate.EXPECT().PostInbox(ctx, mustParse(testMyOtherInboxIRI), toDeserializedForm(testCreate)).Return(nil)
+		delegate.EXPECT().InboxForwarding(ctx, mustParse(testMyInboxIRI), toDeserializedForm(testCreate)).Return(nil)
+		// Run the test
+		handled, err := a.PostSharedInbox(ctx, resp, req)
+		// Verify results
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusOK)
+	})
+	t.Run("PostSharedInboxSkipsRecipientsBlockingTheActor", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		delegate, _, a := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := toAPRequest(toPostSharedInboxRequest(testCreate))
+		delegate.EXPECT().AuthenticatePostInbox(ctx, resp, req).Return(true, nil)
+		delegate.EXPECT().AuthorizePostInbox(ctx, resp, toDeserializedForm(testCreate)).Return(true, nil)
+		delegate.EXPECT().SharedInboxRecipients(ctx, mustParse(testMySharedInboxIRI), toDeserializedForm(testCreate)).Return([]*url.URL{mustParse(testMyInboxIRI), mustParse(testMyOtherInboxIRI)}, nil)
+		delegate.EXPECT().PostInbox(ctx, mustParse(testMyInboxIRI), toDeserializedForm(testCreate)).Return(ErrBlocked)
+		delegate.EXPECT().PostInbox(ctx, mustParse(testMyOtherInboxIRI), toDeserializedForm(testCreate)).Return(nil)
+		delegate.EXPECT().InboxForwarding(ctx, mustParse(testMyOtherInboxIRI), toDeserializedForm(testCreate)).Return(nil)
+		// Run the test
+		handled, err := a.PostSharedInbox(ctx, resp, req)
+		// Verify results
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusOK)
+	})
+	t.Run("PostSharedInboxRespondsWithStatusIfNoRecipients", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		delegate, _, a := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := toAPRequest(toPostSharedInboxRequest(testCreate))
+		delegate.EXPECT().AuthenticatePostInbox(ctx, resp, req).Return(true, nil)
+		delegate.EXPECT().AuthorizePostInbox(ctx, resp, toDeserializedForm(testCreate)).Return(true, nil)
+		delegate.EXPECT().SharedInboxRecipients(ctx, mustParse(testMySharedInboxIRI), toDeserializedForm(testCreate)).Return(nil, nil)
+		// Run the test
+		handled, err := a.PostSharedInbox(ctx, resp, req)
+		// Verify results
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusOK)
+	})
 	t.Run("GetInboxIgnoresNonActivityPubRequest", func(t *testing.T) {
 		// Setup
 		ctl := gomock.NewController(t)
@@ -540,7 +631,7 @@ func TestBaseActorFederatingProtocol(t *testing.T) {
 		assertEqual(t, handled, true)
 		assertEqual(t, resp.Code, http.StatusOK)
 		respV := resp.Result()
-		assertEqual(t, respV.Header.Get(contentTypeHeader), "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\"")
+		assertEqual(t, respV.Header.Get(contentTypeHeader), "application/activity+json")
 		assertEqual(t, respV.Header.Get(dateHeader), nowDateHeader())
 		assertNotEqual(t, len(respV.Header.Get(digestHeader)), 0)
 		b, err := ioutil.ReadAll(respV.Body)
@@ -643,13 +734,34 @@ func TestBaseActorFederatingProtocol(t *testing.T) {
 		assertEqual(t, handled, true)
 		assertEqual(t, resp.Code, http.StatusOK)
 		respV := resp.Result()
-		assertEqual(t, respV.Header.Get(contentTypeHeader), "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\"")
+		assertEqual(t, respV.Header.Get(contentTypeHeader), "application/activity+json")
 		assertEqual(t, respV.Header.Get(dateHeader), nowDateHeader())
 		assertNotEqual(t, len(respV.Header.Get(digestHeader)), 0)
 		b, err := ioutil.ReadAll(respV.Body)
 		assertEqual(t, err, nil)
 		assertByteEqual(t, b, []byte(testOrderedCollectionUniqueElemsString))
 	})
+	t.Run("SendWrapsInCreateAndDelivers", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		delegate, _, a := setupFn(ctl)
+		outboxIRI := mustParse(testMyOutboxIRI)
+		delegate.EXPECT().WrapInCreate(ctx, testMyNote, outboxIRI).DoAndReturn(func(c context.Context, t vocab.Type, u *url.URL) (vocab.ActivityStreamsCreate, error) {
+			return wrappedInCreate(t), nil
+		})
+		delegate.EXPECT().AddNewIds(ctx, wrappedInCreate(testMyNote)).DoAndReturn(func(c context.Context, activity Activity) error {
+			activity = withNewId(activity)
+			return nil
+		})
+		delegate.EXPECT().PostOutbox(ctx, withNewId(wrappedInCreate(testMyNote)), outboxIRI, gomock.Any()).Return(true, nil)
+		delegate.EXPECT().Deliver(ctx, outboxIRI, withNewId(wrappedInCreate(testMyNote))).Return(nil)
+		// Run the test
+		activity, err := a.(FederatingActor).Send(ctx, outboxIRI, testMyNote)
+		// Verify results
+		assertEqual(t, err, nil)
+		assertEqual(t, activity.GetActivityStreamsId().Get().String(), testNewActivityIRI)
+	})
 }
 
 // TestBaseActor tests the Actor returned with NewCustomActor and having both
@@ -0,0 +1,197 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/url"
+)
+
+// blockObjects adds the objects of a Block to the 'blocked' collection of the
+// actor owning the outbox, and removes them from its 'followers' and
+// 'following'.
+func (w SocialWrappedCallbacks) blockObjects(c context.Context, op vocab.ActivityStreamsObjectProperty) error {
+	if err := w.db.Lock(c, w.outboxIRI); err != nil {
+		return err
+	}
+	// WARNING: Unlock not deferred.
+	actorIRI, err := w.db.ActorForOutbox(c, w.outboxIRI)
+	w.db.Unlock(c, w.outboxIRI)
+	if err != nil {
+		return err
+	}
+	// Unlock must be called by now and every branch above.
+	ids, err := objectIds(op)
+	if err != nil {
+		return err
+	}
+	if err := w.db.Lock(c, actorIRI); err != nil {
+		return err
+	}
+	defer w.db.Unlock(c, actorIRI)
+	blocked, err := w.db.Blocked(c, actorIRI)
+	if err != nil {
+		return err
+	}
+	items := blocked.GetActivityStreamsItems()
+	if items == nil {
+		items = streams.NewActivityStreamsItemsProperty()
+		blocked.SetActivityStreamsItems(items)
+	}
+	already, err := collectionIds(blocked)
+	if err != nil {
+		return err
+	}
+	for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
+		id, err := ToId(iter)
+		if err != nil {
+			return err
+		}
+		if !already[id.String()] {
+			items.PrependIRI(id)
+			already[id.String()] = true
+		}
+	}
+	if err := w.db.Update(c, blocked); err != nil {
+		return err
+	}
+	for _, collectionFn := range []func(c context.Context, actorIRI *url.URL) (vocab.ActivityStreamsCollection, error){
+		w.db.Followers,
+		w.db.Following,
+	} {
+		col, err := collectionFn(c, actorIRI)
+		if err != nil {
+			return err
+		}
+		if err := removeIds(col, ids); err != nil {
+			return err
+		}
+		if err := w.db.Update(c, col); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InboxBlocker may be implemented by a FederatingProtocol to decide which
+// actors are blocked by each actor of this server, in place of the default of
+// consulting the 'blocked' collection of the actor with BlockedByCollection.
+//
+// Activities of blocked actors are neither added to the inbox nor have any side
+// effects. They are still answered as if accepted, so that their senders do not
+// learn of the block.
+type InboxBlocker interface {
+	// BlockedBy determines whether any of the actors given by their ids
+	// is blocked by the actor of this server.
+	BlockedBy(c context.Context, actorIRI *url.URL, actorIRIs []*url.URL) (blocked bool, err error)
+}
+
+// BlockedByCollection determines whether any of the actors given by their ids
+// is in the 'blocked' collection of the actor of this server, as obtained from
+// the Database. It is used when the FederatingProtocol is not an InboxBlocker.
+//
+// It takes the lock of the actor's IRI itself.
+func BlockedByCollection(c context.Context, db Database, actorIRI *url.URL, actorIRIs []*url.URL) (bool, error) {
+	blocked, err := blockedIds(c, db, actorIRI)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range actorIRIs {
+		if blocked[id.String()] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// blockedIds returns the ids in the 'blocked' collection of the actor owned by
+// this server.
+func blockedIds(c context.Context, db Database, actorIRI *url.URL) (map[string]bool, error) {
+	if err := db.Lock(c, actorIRI); err != nil {
+		return nil, err
+	}
+	defer db.Unlock(c, actorIRI)
+	blocked, err := db.Blocked(c, actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	return collectionIds(blocked)
+}
+
+// isBlockedByInboxActor determines whether any actor of the activity is blocked
+// by the actor owning the inbox, with the FederatingProtocol if it is an
+// InboxBlocker.
+func (a *sideEffectActor) isBlockedByInboxActor(c context.Context, inboxIRI *url.URL, activity Activity) (bool, error) {
+	if err := a.db.Lock(c, inboxIRI); err != nil {
+		return false, err
+	}
+	// WARNING: Unlock not deferred.
+	actorIRI, err := a.db.ActorForInbox(c, inboxIRI)
+	a.db.Unlock(c, inboxIRI)
+	if err != nil {
+		return false, err
+	}
+	// Unlock must be called by now and every branch above.
+	var actorIRIs []*url.URL
+	if actor := activity.GetActivityStreamsActor(); actor != nil {
+		for iter := actor.Begin(); iter != actor.End(); iter = iter.Next() {
+			id, err := ToId(iter)
+			if err != nil {
+				return false, err
+			}
+			actorIRIs = append(actorIRIs, id)
+		}
+	}
+	if blocker, ok := a.s2s.(InboxBlocker); ok {
+		return blocker.BlockedBy(c, actorIRI, actorIRIs)
+	}
+	return BlockedByCollection(c, a.db, actorIRI, actorIRIs)
+}
+
+// withoutBlocked removes the actors blocked by the actor owning the outbox.
+func (a *sideEffectActor) withoutBlocked(c context.Context, outboxIRI *url.URL, actors []vocab.Type) ([]vocab.Type, error) {
+	if err := a.db.Lock(c, outboxIRI); err != nil {
+		return nil, err
+	}
+	// WARNING: Unlock not deferred.
+	actorIRI, err := a.db.ActorForOutbox(c, outboxIRI)
+	a.db.Unlock(c, outboxIRI)
+	if err != nil {
+		return nil, err
+	}
+	// Unlock must be called by now and every branch above.
+	blocked, err := blockedIds(c, a.db, actorIRI)
+	if err != nil {
+		return nil, err
+	} else if len(blocked) == 0 {
+		return actors, nil
+	}
+	var permitted []vocab.Type
+	for _, actor := range actors {
+		id, err := GetId(actor)
+		if err != nil {
+			return nil, err
+		}
+		if !blocked[id.String()] {
+			permitted = append(permitted, actor)
+		}
+	}
+	return permitted, nil
+}
+
+// collectionIds returns the ids of the items of a Collection.
+func collectionIds(col vocab.ActivityStreamsCollection) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	items := col.GetActivityStreamsItems()
+	if items == nil {
+		return ids, nil
+	}
+	for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+		id, err := ToId(iter)
+		if err != nil {
+			return nil, err
+		}
+		ids[id.String()] = true
+	}
+	return ids, nil
+}
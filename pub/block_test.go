@@ -0,0 +1,219 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/golang/mock/gomock"
+	"net/url"
+	"testing"
+)
+
+// blockerFederatingProtocol is a FederatingProtocol deciding blocks itself.
+type blockerFederatingProtocol struct {
+	*MockFederatingProtocol
+	blocked map[string]bool
+}
+
+// BlockedBy returns true if any of the actors is blocked.
+func (p blockerFederatingProtocol) BlockedBy(c context.Context, actorIRI *url.URL, actorIRIs []*url.URL) (bool, error) {
+	for _, id := range actorIRIs {
+		if p.blocked[id.String()] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TestBlock ensures blocked actors are recorded, removed from the followers and
+// following of the blocking actor, and excluded from delivery.
+func TestBlock(t *testing.T) {
+	ctx := context.Background()
+	me := mustParse("https://example.com/addison")
+	outboxIRI := mustParse(testMyOutboxIRI)
+	peer := mustParse(testFederatedActorIRI)
+	peer2 := mustParse(testFederatedActorIRI2)
+	collection := func(iris ...*url.URL) vocab.ActivityStreamsCollection {
+		col := streams.NewActivityStreamsCollection()
+		items := streams.NewActivityStreamsItemsProperty()
+		for _, iri := range iris {
+			items.AppendIRI(iri)
+		}
+		col.SetActivityStreamsItems(items)
+		return col
+	}
+	itemIds := func(col vocab.ActivityStreamsCollection) (ids []string) {
+		items := col.GetActivityStreamsItems()
+		for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+			ids = append(ids, iter.GetIRI().String())
+		}
+		return
+	}
+	blockOf := func(object *url.URL) vocab.ActivityStreamsBlock {
+		block := streams.NewActivityStreamsBlock()
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(me)
+		block.SetActivityStreamsActor(actor)
+		op := streams.NewActivityStreamsObjectProperty()
+		op.AppendIRI(object)
+		block.SetActivityStreamsObject(op)
+		return block
+	}
+	t.Run("BlockRecordsAndUnfollows", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		deliverable := true
+		w := SocialWrappedCallbacks{db: db, outboxIRI: outboxIRI, deliverable: &deliverable}
+		blocked := collection(peer2)
+		followers := collection(peer, peer2)
+		following := collection(peer)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Blocked(ctx, me).Return(blocked, nil),
+			db.EXPECT().Update(ctx, blocked).Return(nil),
+			db.EXPECT().Followers(ctx, me).Return(followers, nil),
+			db.EXPECT().Update(ctx, followers).Return(nil),
+			db.EXPECT().Following(ctx, me).Return(following, nil),
+			db.EXPECT().Update(ctx, following).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := w.block(ctx, blockOf(peer))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, deliverable, false)
+		ids := itemIds(blocked)
+		assertEqual(t, len(ids), 2)
+		assertEqual(t, ids[0], testFederatedActorIRI)
+		ids = itemIds(followers)
+		assertEqual(t, len(ids), 1)
+		assertEqual(t, ids[0], testFederatedActorIRI2)
+		assertEqual(t, len(itemIds(following)), 0)
+	})
+	t.Run("BlockDoesNotDuplicate", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		deliverable := true
+		w := SocialWrappedCallbacks{db: db, outboxIRI: outboxIRI, deliverable: &deliverable}
+		blocked := collection(peer)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Blocked(ctx, me).Return(blocked, nil),
+			db.EXPECT().Update(ctx, blocked).Return(nil),
+			db.EXPECT().Followers(ctx, me).Return(collection(), nil),
+			db.EXPECT().Update(ctx, gomock.Any()).Return(nil),
+			db.EXPECT().Following(ctx, me).Return(collection(), nil),
+			db.EXPECT().Update(ctx, gomock.Any()).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := w.block(ctx, blockOf(peer))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(itemIds(blocked)), 1)
+	})
+	t.Run("UndoBlockRemovesBlocked", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		w := SocialWrappedCallbacks{db: db, outboxIRI: outboxIRI}
+		blocked := collection(peer, peer2)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Blocked(ctx, me).Return(blocked, nil),
+			db.EXPECT().Update(ctx, blocked).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := w.undoBlock(ctx, blockOf(peer))
+		// Verify
+		assertEqual(t, err, nil)
+		ids := itemIds(blocked)
+		assertEqual(t, len(ids), 1)
+		assertEqual(t, ids[0], testFederatedActorIRI2)
+	})
+	t.Run("BlockedByCollection", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Blocked(ctx, me).Return(collection(peer2), nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		blocked, err := BlockedByCollection(ctx, db, me, []*url.URL{peer, peer2})
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, blocked, true)
+	})
+	t.Run("InboxBlockerReplacesCollection", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		inboxIRI := mustParse(testMyInboxIRI)
+		a := &sideEffectActor{
+			s2s: blockerFederatingProtocol{
+				MockFederatingProtocol: NewMockFederatingProtocol(ctl),
+				blocked:                map[string]bool{testFederatedActorIRI: true},
+			},
+			db: db,
+		}
+		follow := streams.NewActivityStreamsFollow()
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(peer)
+		follow.SetActivityStreamsActor(actor)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+		)
+		// Run
+		err := a.PostInbox(ctx, inboxIRI, follow)
+		// Verify
+		assertEqual(t, err, ErrBlocked)
+	})
+	t.Run("DeliveryExcludesBlocked", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		a := &sideEffectActor{db: db}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Blocked(ctx, me).Return(collection(peer), nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		p1 := streams.NewActivityStreamsPerson()
+		p1.SetActivityStreamsId(streams.NewActivityStreamsIdProperty())
+		p1.GetActivityStreamsId().Set(peer)
+		p2 := streams.NewActivityStreamsPerson()
+		p2.SetActivityStreamsId(streams.NewActivityStreamsIdProperty())
+		p2.GetActivityStreamsId().Set(peer2)
+		// Run
+		permitted, err := a.withoutBlocked(ctx, outboxIRI, []vocab.Type{p1, p2})
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(permitted), 1)
+		assertEqual(t, permitted[0], vocab.Type(p2))
+	})
+}
@@ -0,0 +1,256 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// defaultBoxPageLimit is the number of items in each page of an inbox
+	// or outbox served by an Actor.
+	defaultBoxPageLimit = 20
+	// Query parameters selecting a page of an inbox or outbox.
+	pageQuery  = "page"
+	maxIdQuery = "max_id"
+	minIdQuery = "min_id"
+)
+
+// BoxPageQuery selects a page of the items of an inbox or outbox. Items are
+// ordered newest first.
+type BoxPageQuery struct {
+	// MaxId, when set, selects the items older than the item with this
+	// id, beginning with the newest of them.
+	MaxId *url.URL
+	// MinId, when set, selects the items newer than the item with this
+	// id, ending with the oldest of them. It is ignored when MaxId is set.
+	MinId *url.URL
+	// Limit is the maximum number of items in the page. When zero or
+	// less, the page has no maximum.
+	Limit int
+}
+
+// BoxPage is a page of the items of an inbox or outbox.
+type BoxPage struct {
+	// Items are the ids of the activities in the page, newest first.
+	Items []*url.URL
+	// TotalItems is the number of items in the whole inbox or outbox.
+	TotalItems int
+	// HasOlder is true if there are items older than the last of Items.
+	HasOlder bool
+	// HasNewer is true if there are items newer than the first of Items.
+	HasNewer bool
+}
+
+// PageBoxItems returns the page selected by the query from all the items of an
+// inbox or outbox, ordered newest first. It is useful for Database
+// implementations able to load every item id cheaply.
+//
+// An unknown MaxId or MinId selects an empty page.
+func PageBoxItems(items []*url.URL, q BoxPageQuery) BoxPage {
+	start, end := 0, len(items)
+	if q.MaxId != nil {
+		i := indexOfIRI(items, q.MaxId)
+		if i < 0 {
+			return BoxPage{TotalItems: len(items)}
+		}
+		start = i + 1
+		if q.Limit > 0 && start+q.Limit < end {
+			end = start + q.Limit
+		}
+	} else if q.MinId != nil {
+		i := indexOfIRI(items, q.MinId)
+		if i < 0 {
+			return BoxPage{TotalItems: len(items)}
+		}
+		end = i
+		if q.Limit > 0 && end-q.Limit > start {
+			start = end - q.Limit
+		}
+	} else if q.Limit > 0 && q.Limit < end {
+		end = q.Limit
+	}
+	page := BoxPage{
+		Items:      make([]*url.URL, end-start),
+		TotalItems: len(items),
+		HasOlder:   end < len(items),
+		HasNewer:   start > 0,
+	}
+	copy(page.Items, items[start:end])
+	return page
+}
+
+// indexOfIRI returns the index of the IRI in the items, or -1 if absent.
+func indexOfIRI(items []*url.URL, iri *url.URL) int {
+	s := iri.String()
+	for i, item := range items {
+		if item.String() == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// boxPageFunc obtains a page of the inbox or outbox at the IRI.
+type boxPageFunc func(c context.Context, boxIRI *url.URL, q BoxPageQuery) (BoxPage, error)
+
+// parsePageRequest determines the inbox, outbox, or collection requested, and
+// whether and which page of it is requested by the 'page', 'max_id', and
+// 'min_id' query parameters.
+func parsePageRequest(r *http.Request) (iri *url.URL, q BoxPageQuery, isPage bool, err error) {
+	u := *r.URL
+	u.RawQuery = ""
+	u.Fragment = ""
+	iri = &u
+	query := r.URL.Query()
+	q.Limit = defaultBoxPageLimit
+	if v := query.Get(maxIdQuery); len(v) > 0 {
+		isPage = true
+		if q.MaxId, err = url.Parse(v); err != nil {
+			return
+		}
+	}
+	if v := query.Get(minIdQuery); len(v) > 0 {
+		isPage = true
+		if q.MinId, err = url.Parse(v); err != nil {
+			return
+		}
+	}
+	if len(query.Get(pageQuery)) > 0 {
+		isPage = true
+	}
+	return
+}
+
+// pageIRI returns the IRI of a page of the inbox, outbox, or collection,
+// optionally relative to an item.
+func pageIRI(iri *url.URL, cursorKey string, cursor *url.URL) *url.URL {
+	u := *iri
+	v := url.Values{}
+	v.Set(pageQuery, "true")
+	if cursor != nil {
+		v.Set(cursorKey, cursor.String())
+	}
+	u.RawQuery = v.Encode()
+	return &u
+}
+
+// boxHookFunc returns the page of an inbox or outbox to serve, given the page
+// obtained from the database.
+type boxHookFunc func(c context.Context, boxIRI *url.URL, q BoxPageQuery, page BoxPage) (BoxPage, error)
+
+// boxFilterFunc returns the items of a page of an inbox or outbox that are
+// served.
+type boxFilterFunc func(c context.Context, items []*url.URL) ([]*url.URL, error)
+
+// getBox obtains the OrderedCollection of the inbox or outbox at the IRI when
+// no page is requested, or else the requested OrderedCollectionPage. The page
+// is passed to the hook if it is not nil, and then its items are filtered if
+// the filter is not nil.
+func getBox(c context.Context, db Database, getPage boxPageFunc, boxIRI *url.URL, q BoxPageQuery, isPage bool, hook boxHookFunc, filter boxFilterFunc) (vocab.Type, error) {
+	err := db.Lock(c, boxIRI)
+	if err != nil {
+		return nil, err
+	}
+	// WARNING: Unlock not deferred
+	page, err := getPage(c, boxIRI, q)
+	db.Unlock(c, boxIRI)
+	if err != nil {
+		return nil, err
+	}
+	// Unlock must be called by now and every branch above.
+	if hook != nil {
+		page, err = hook(c, boxIRI, q, page)
+		if err != nil {
+			return nil, err
+		}
+	}
+	items := page.Items
+	if isPage && filter != nil {
+		items, err = filter(c, items)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return toPageDocument(boxIRI, q, isPage, page, items, true), nil
+}
+
+// toPageDocument builds the summary of the collection at the IRI when no page
+// is requested, or else the requested page of it listing the items. An ordered
+// collection is an OrderedCollection with OrderedCollectionPages.
+//
+// The items may be fewer than the items of the page, which determine the
+// links to the neighbouring pages.
+//
+// The summary only has the total number of items and a link to the first
+// page. Each page links to the next page of older items and the previous page
+// of newer items, if there are any.
+func toPageDocument(iri *url.URL, q BoxPageQuery, isPage bool, page BoxPage, items []*url.URL, ordered bool) vocab.Type {
+	total := streams.NewActivityStreamsTotalItemsProperty()
+	total.Set(page.TotalItems)
+	id := streams.NewActivityStreamsIdProperty()
+	if !isPage {
+		id.Set(iri)
+		first := streams.NewActivityStreamsFirstProperty()
+		first.SetIRI(pageIRI(iri, "", nil))
+		if ordered {
+			oc := streams.NewActivityStreamsOrderedCollection()
+			oc.SetActivityStreamsId(id)
+			oc.SetActivityStreamsTotalItems(total)
+			oc.SetActivityStreamsFirst(first)
+			return oc
+		}
+		col := streams.NewActivityStreamsCollection()
+		col.SetActivityStreamsId(id)
+		col.SetActivityStreamsTotalItems(total)
+		col.SetActivityStreamsFirst(first)
+		return col
+	}
+	cursorKey, cursor := "", (*url.URL)(nil)
+	if q.MaxId != nil {
+		cursorKey, cursor = maxIdQuery, q.MaxId
+	} else if q.MinId != nil {
+		cursorKey, cursor = minIdQuery, q.MinId
+	}
+	id.Set(pageIRI(iri, cursorKey, cursor))
+	partOf := streams.NewActivityStreamsPartOfProperty()
+	partOf.SetIRI(iri)
+	var next vocab.ActivityStreamsNextProperty
+	if page.HasOlder && len(page.Items) > 0 {
+		next = streams.NewActivityStreamsNextProperty()
+		next.SetIRI(pageIRI(iri, maxIdQuery, page.Items[len(page.Items)-1]))
+	}
+	var prev vocab.ActivityStreamsPrevProperty
+	if page.HasNewer && len(page.Items) > 0 {
+		prev = streams.NewActivityStreamsPrevProperty()
+		prev.SetIRI(pageIRI(iri, minIdQuery, page.Items[0]))
+	}
+	if ordered {
+		ocp := streams.NewActivityStreamsOrderedCollectionPage()
+		ocp.SetActivityStreamsId(id)
+		ocp.SetActivityStreamsPartOf(partOf)
+		ocp.SetActivityStreamsTotalItems(total)
+		oi := streams.NewActivityStreamsOrderedItemsProperty()
+		for _, item := range items {
+			oi.AppendIRI(item)
+		}
+		ocp.SetActivityStreamsOrderedItems(oi)
+		ocp.SetActivityStreamsNext(next)
+		ocp.SetActivityStreamsPrev(prev)
+		return ocp
+	}
+	cp := streams.NewActivityStreamsCollectionPage()
+	cp.SetActivityStreamsId(id)
+	cp.SetActivityStreamsPartOf(partOf)
+	cp.SetActivityStreamsTotalItems(total)
+	ip := streams.NewActivityStreamsItemsProperty()
+	for _, item := range items {
+		ip.AppendIRI(item)
+	}
+	cp.SetActivityStreamsItems(ip)
+	cp.SetActivityStreamsNext(next)
+	cp.SetActivityStreamsPrev(prev)
+	return cp
+}
@@ -0,0 +1,86 @@
+package pub
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+// TestPageBoxItems ensures the cursors and limit select the right items.
+func TestPageBoxItems(t *testing.T) {
+	var items []*url.URL
+	for i := 5; i > 0; i-- {
+		items = append(items, mustParse(fmt.Sprintf("https://example.com/activity/%d", i)))
+	}
+	tests := []struct {
+		name      string
+		q         BoxPageQuery
+		wantFirst string
+		wantLen   int
+		wantOlder bool
+		wantNewer bool
+	}{
+		{
+			name:      "FirstPage",
+			q:         BoxPageQuery{Limit: 2},
+			wantFirst: "https://example.com/activity/5",
+			wantLen:   2,
+			wantOlder: true,
+		},
+		{
+			name:      "OlderThanMaxId",
+			q:         BoxPageQuery{MaxId: items[1], Limit: 2},
+			wantFirst: "https://example.com/activity/3",
+			wantLen:   2,
+			wantOlder: true,
+			wantNewer: true,
+		},
+		{
+			name:      "LastPage",
+			q:         BoxPageQuery{MaxId: items[2], Limit: 2},
+			wantFirst: "https://example.com/activity/2",
+			wantLen:   2,
+			wantNewer: true,
+		},
+		{
+			name:      "NewerThanMinId",
+			q:         BoxPageQuery{MinId: items[3], Limit: 2},
+			wantFirst: "https://example.com/activity/4",
+			wantLen:   2,
+			wantOlder: true,
+			wantNewer: true,
+		},
+		{
+			name:      "NewestPageFromMinId",
+			q:         BoxPageQuery{MinId: items[1], Limit: 2},
+			wantFirst: "https://example.com/activity/5",
+			wantLen:   1,
+			wantOlder: true,
+		},
+		{
+			name:    "UnknownCursor",
+			q:       BoxPageQuery{MaxId: mustParse("https://example.com/activity/9"), Limit: 2},
+			wantLen: 0,
+		},
+		{
+			name:      "NoLimit",
+			q:         BoxPageQuery{},
+			wantFirst: "https://example.com/activity/5",
+			wantLen:   5,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Run
+			page := PageBoxItems(items, test.q)
+			// Verify
+			assertEqual(t, page.TotalItems, 5)
+			assertEqual(t, len(page.Items), test.wantLen)
+			if test.wantLen > 0 {
+				assertEqual(t, page.Items[0].String(), test.wantFirst)
+			}
+			assertEqual(t, page.HasOlder, test.wantOlder)
+			assertEqual(t, page.HasNewer, test.wantNewer)
+		})
+	}
+}
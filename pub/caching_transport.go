@@ -0,0 +1,241 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DereferenceResponse is the result of a conditional dereference.
+type DereferenceResponse struct {
+	// Body is the fetched ActivityStreams value. It is empty if
+	// NotModified is true.
+	Body []byte
+	// NotModified is true if the peer responded that the value has not
+	// changed since the validators in the request.
+	NotModified bool
+	// Header contains the response headers, such as Cache-Control,
+	// Expires, ETag, and Last-Modified.
+	Header http.Header
+}
+
+// ConditionalDereferencer is a Transport that is able to make conditional
+// GET requests, which allows the CachingTransport to revalidate its stale
+// entries instead of fetching them again.
+type ConditionalDereferencer interface {
+	// ConditionalDereference fetches the ActivityStreams object located
+	// at this IRI with a GET request.
+	//
+	// If etag is not empty, it must be sent in an If-None-Match header. If
+	// lastModified is not empty, it must be sent in an If-Modified-Since
+	// header. A 304 Not Modified response to such a request must be
+	// returned with NotModified set to true, and not as an error.
+	ConditionalDereference(c context.Context, iri *url.URL, etag, lastModified string) (DereferenceResponse, error)
+}
+
+// ConditionalDereferencer must be implemented by HttpSigTransport.
+var _ ConditionalDereferencer = &HttpSigTransport{}
+
+// CachedDereference is a dereferenced ActivityStreams value kept by a
+// DereferenceCache.
+type CachedDereference struct {
+	// Body is the dereferenced ActivityStreams value.
+	Body []byte
+	// ETag and LastModified are the validators the peer responded with,
+	// and may be empty.
+	ETag         string
+	LastModified string
+	// Expires is when the entry becomes stale. Stale entries with
+	// validators are revalidated, other stale entries are fetched again.
+	Expires time.Time
+}
+
+// DereferenceCache stores the values fetched by a CachingTransport.
+//
+// It must be safe to use concurrently.
+type DereferenceCache interface {
+	// Get returns the entry for the IRI, if there is one.
+	Get(c context.Context, iri *url.URL) (entry CachedDereference, found bool, err error)
+	// Set saves the entry for the IRI, replacing any existing one.
+	Set(c context.Context, iri *url.URL, entry CachedDereference) error
+	// Delete removes the entry for the IRI, if there is one.
+	Delete(c context.Context, iri *url.URL) error
+}
+
+// CachingTransport must be a Transport.
+var _ Transport = &CachingTransport{}
+
+// CachingTransport wraps a Transport to keep the values it dereferences in a
+// DereferenceCache, so that the same peer actors are not fetched again every
+// time they are needed to process an activity.
+//
+// Cached values are kept for as long as permitted by the Cache-Control and
+// Expires headers of the peer's response, and are not kept when the response
+// contains "Cache-Control: no-store". If the wrapped Transport is also a
+// ConditionalDereferencer, stale values are revalidated using their ETag and
+// Last-Modified headers. Otherwise, and when the peer sent no caching headers,
+// values are kept for the default time to live.
+//
+// Deliveries are passed on to the wrapped Transport.
+//
+// Values are cached by IRI, without any fragment. A DereferenceCache should
+// only be shared between the CachingTransports of different actors when peers
+// respond the same way to each of them.
+type CachingTransport struct {
+	t          Transport
+	cache      DereferenceCache
+	clock      Clock
+	defaultTTL time.Duration
+}
+
+// NewCachingTransport returns a Transport that caches the values dereferenced
+// by the wrapped Transport.
+//
+// If cache is nil, an in-memory cache of up to 1024 values is used. The
+// defaultTTL is how long values are cached when the peer provided no
+// Cache-Control or Expires header. It may be zero, in which case such values
+// are only cached if they can be revalidated.
+func NewCachingTransport(t Transport, cache DereferenceCache, clock Clock, defaultTTL time.Duration) *CachingTransport {
+	if cache == nil {
+		cache = NewMemoryDereferenceCache(defaultMemoryDereferenceCacheSize)
+	}
+	return &CachingTransport{
+		t:          t,
+		cache:      cache,
+		clock:      clock,
+		defaultTTL: defaultTTL,
+	}
+}
+
+// Dereference returns the cached value at this IRI if it is still fresh.
+// Otherwise it is revalidated or fetched with the wrapped Transport.
+func (t *CachingTransport) Dereference(c context.Context, iri *url.URL) ([]byte, error) {
+	key := cacheKey(iri)
+	entry, found, err := t.cache.Get(c, key)
+	if err != nil {
+		return nil, err
+	}
+	now := t.clock.Now()
+	if found && now.Before(entry.Expires) {
+		return copyBytes(entry.Body), nil
+	}
+	cd, ok := t.t.(ConditionalDereferencer)
+	if !ok {
+		b, err := t.t.Dereference(c, iri)
+		if err != nil {
+			return nil, err
+		}
+		if t.defaultTTL > 0 {
+			err = t.cache.Set(c, key, CachedDereference{
+				Body:    copyBytes(b),
+				Expires: now.Add(t.defaultTTL),
+			})
+		}
+		return b, err
+	}
+	var etag, lastModified string
+	if found {
+		etag, lastModified = entry.ETag, entry.LastModified
+	}
+	resp, err := cd.ConditionalDereference(c, iri, etag, lastModified)
+	if err != nil {
+		return nil, err
+	}
+	next := CachedDereference{
+		Body:         resp.Body,
+		ETag:         resp.Header.Get(etagHeader),
+		LastModified: resp.Header.Get(lastModifiedHeader),
+	}
+	if resp.NotModified {
+		if !found {
+			return nil, fmt.Errorf("cannot dereference %s: not modified but not cached", iri)
+		}
+		next.Body = entry.Body
+		// A 304 response need not repeat the validators.
+		if len(next.ETag) == 0 {
+			next.ETag = entry.ETag
+		}
+		if len(next.LastModified) == 0 {
+			next.LastModified = entry.LastModified
+		}
+	}
+	var noStore bool
+	next.Expires, noStore = cacheExpiry(resp.Header, now, t.defaultTTL)
+	if noStore {
+		err = t.cache.Delete(c, key)
+	} else if now.Before(next.Expires) || len(next.ETag) > 0 || len(next.LastModified) > 0 {
+		// Stale values are still cached when they can be revalidated.
+		next.Body = copyBytes(next.Body)
+		err = t.cache.Set(c, key, next)
+	}
+	return copyBytes(next.Body), err
+}
+
+// Deliver sends an ActivityStreams object with the wrapped Transport.
+func (t *CachingTransport) Deliver(c context.Context, b []byte, to *url.URL) error {
+	return t.t.Deliver(c, b, to)
+}
+
+// BatchDeliver sends an ActivityStreams object to multiple recipients with the
+// wrapped Transport.
+func (t *CachingTransport) BatchDeliver(c context.Context, b []byte, recipients []*url.URL) error {
+	return t.t.BatchDeliver(c, b, recipients)
+}
+
+// Invalidate removes any cached value for the IRI, so that it is fetched again
+// the next time it is dereferenced.
+func (t *CachingTransport) Invalidate(c context.Context, iri *url.URL) error {
+	return t.cache.Delete(c, cacheKey(iri))
+}
+
+// cacheKey returns the IRI values are cached by, which omits the fragment so
+// that, for example, an actor and its "#main-key" share a single entry.
+func cacheKey(iri *url.URL) *url.URL {
+	k := *iri
+	k.Fragment = ""
+	k.RawFragment = ""
+	return &k
+}
+
+// cacheExpiry determines when a response becomes stale from its Cache-Control
+// and Expires headers, falling back to the default time to live. It also
+// reports whether the response must not be stored.
+func cacheExpiry(h http.Header, now time.Time, defaultTTL time.Duration) (expires time.Time, noStore bool) {
+	if cc := h.Get(cacheControlHeader); len(cc) > 0 {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if directive == "no-store" {
+				return now, true
+			} else if directive == "no-cache" {
+				return now, false
+			} else if strings.HasPrefix(directive, "max-age=") {
+				if secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+					return now.Add(time.Duration(secs) * time.Second), false
+				}
+			}
+		}
+	}
+	if e := h.Get(expiresHeader); len(e) > 0 {
+		// An invalid date, such as "0", means already expired.
+		t, err := http.ParseTime(e)
+		if err != nil {
+			return now, false
+		}
+		return t, false
+	}
+	return now.Add(defaultTTL), false
+}
+
+// copyBytes returns a copy of b, so that callers cannot modify cached values.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,134 @@
+package pub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/go-fed/httpsig"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestCachingTransport ensures dereferenced values are cached and revalidated
+// according to the peer's caching headers.
+func TestCachingTransport(t *testing.T) {
+	ctx := context.Background()
+	body := []byte(`{"type":"Person"}`)
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respond := func(code int, cacheControl string) *http.Response {
+		h := http.Header{}
+		h.Set(etagHeader, `"v1"`)
+		if len(cacheControl) > 0 {
+			h.Set(cacheControlHeader, cacheControl)
+		}
+		b := body
+		if code == http.StatusNotModified {
+			b = nil
+		}
+		return &http.Response{
+			StatusCode: code,
+			Header:     h,
+			Body:       ioutil.NopCloser(bytes.NewReader(b)),
+		}
+	}
+	setupFn := func(ctl *gomock.Controller) (client *MockHttpClient, cl *MockClock, tp *CachingTransport) {
+		getSigner, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, []string{httpsig.RequestTarget, "host", "date"}, httpsig.Signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client = NewMockHttpClient(ctl)
+		sigClock := NewMockClock(ctl)
+		sigClock.EXPECT().Now().Return(now()).AnyTimes()
+		cl = NewMockClock(ctl)
+		sig := NewHttpSigTransport(client, "myApp", sigClock, getSigner, nil, testMyOutboxIRI+"#main-key", privKey, nil)
+		tp = NewCachingTransport(sig, nil, cl, 0)
+		return
+	}
+	// Run tests
+	t.Run("CachesUntilMaxAge", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		client, cl, tp := setupFn(ctl)
+		cl.EXPECT().Now().Return(now()).Times(2)
+		client.EXPECT().Do(gomock.Any()).Return(respond(http.StatusOK, "max-age=60"), nil)
+		// Run
+		b1, err1 := tp.Dereference(ctx, mustParse(testFederatedActorIRI))
+		b2, err2 := tp.Dereference(ctx, mustParse(testFederatedActorIRI+"#main-key"))
+		// Verify
+		assertEqual(t, err1, nil)
+		assertEqual(t, err2, nil)
+		assertByteEqual(t, b1, body)
+		assertByteEqual(t, b2, body)
+	})
+	t.Run("RevalidatesStaleValues", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		client, cl, tp := setupFn(ctl)
+		gomock.InOrder(
+			cl.EXPECT().Now().Return(now()),
+			cl.EXPECT().Now().Return(now().Add(time.Minute)),
+		)
+		var req *http.Request
+		gomock.InOrder(
+			client.EXPECT().Do(gomock.Any()).Return(respond(http.StatusOK, "max-age=30"), nil),
+			client.EXPECT().Do(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+				req = r
+				return respond(http.StatusNotModified, ""), nil
+			}),
+		)
+		// Run
+		_, err1 := tp.Dereference(ctx, mustParse(testFederatedActorIRI))
+		b, err2 := tp.Dereference(ctx, mustParse(testFederatedActorIRI))
+		// Verify
+		assertEqual(t, err1, nil)
+		assertEqual(t, err2, nil)
+		assertEqual(t, req.Header.Get(ifNoneMatchHeader), `"v1"`)
+		assertByteEqual(t, b, body)
+	})
+	t.Run("DoesNotCacheNoStore", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		client, cl, tp := setupFn(ctl)
+		cl.EXPECT().Now().Return(now()).Times(2)
+		client.EXPECT().Do(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+			assertEqual(t, r.Header.Get(ifNoneMatchHeader), "")
+			return respond(http.StatusOK, "no-store"), nil
+		}).Times(2)
+		// Run
+		_, err1 := tp.Dereference(ctx, mustParse(testFederatedActorIRI))
+		_, err2 := tp.Dereference(ctx, mustParse(testFederatedActorIRI))
+		// Verify
+		assertEqual(t, err1, nil)
+		assertEqual(t, err2, nil)
+	})
+	t.Run("InvalidateFetchesAgain", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		client, cl, tp := setupFn(ctl)
+		cl.EXPECT().Now().Return(now()).Times(2)
+		client.EXPECT().Do(gomock.Any()).Return(respond(http.StatusOK, "max-age=60"), nil)
+		client.EXPECT().Do(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+			assertEqual(t, r.Header.Get(ifNoneMatchHeader), "")
+			return respond(http.StatusOK, "max-age=60"), nil
+		})
+		// Run
+		_, err1 := tp.Dereference(ctx, mustParse(testFederatedActorIRI))
+		err2 := tp.Invalidate(ctx, mustParse(testFederatedActorIRI))
+		_, err3 := tp.Dereference(ctx, mustParse(testFederatedActorIRI))
+		// Verify
+		assertEqual(t, err1, nil)
+		assertEqual(t, err2, nil)
+		assertEqual(t, err3, nil)
+	})
+}
@@ -9,3 +9,13 @@ type Clock interface {
 	// Now returns the current time.
 	Now() time.Time
 }
+
+// TimerClock is a Clock that also waits for time to pass. When the Clock of a
+// DeliveryQueue is a TimerClock, the queue waits with it between polls of the
+// DeliveryStore, so that tests may control when it polls.
+type TimerClock interface {
+	Clock
+	// After returns a channel receiving the current time once d has
+	// passed.
+	After(d time.Duration) <-chan time.Time
+}
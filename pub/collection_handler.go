@@ -0,0 +1,135 @@
+package pub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CollectionPager obtains pages of the items of collections, such as the
+// 'followers', 'following', 'liked', 'likes', and 'shares' of a value, for a
+// HandlerFunc from NewCollectionHandler.
+type CollectionPager interface {
+	// GetCollectionPage returns the page of the items of the Collection or
+	// OrderedCollection at the IRI selected by the query, and whether the
+	// collection is an OrderedCollection.
+	//
+	// Items of an OrderedCollection are newest first. Items of a
+	// Collection are paged in the order they are stored.
+	GetCollectionPage(c context.Context, collectionIRI *url.URL, q BoxPageQuery) (page BoxPage, ordered bool, err error)
+}
+
+// NewCollectionHandler creates a HandlerFunc to serve collections in pages
+// instead of in whole.
+//
+// A request without the 'page', 'max_id', or 'min_id' query parameters is
+// served a Collection or OrderedCollection with only 'totalItems' and a link to
+// its 'first' page. Other requests are served the CollectionPage or
+// OrderedCollectionPage selected by the query parameters, which links to the
+// collection with 'partOf' and to its neighbouring pages with 'next' and
+// 'prev'.
+//
+// The AuthenticateFunc may deny requests, for example to hide the followers of
+// an actor.
+func NewCollectionHandler(authFn AuthenticateFunc, pager CollectionPager, clock Clock) HandlerFunc {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) (isASRequest bool, err error) {
+		// Do nothing if it is not an ActivityPub GET request
+		if !isActivityPubGet(r) {
+			return
+		}
+		isASRequest = true
+		// Authenticate the request
+		var shouldReturn bool
+		if shouldReturn, err = authFn(c, w, r); err != nil {
+			return
+		} else if shouldReturn {
+			return
+		}
+		// Obtain the requested page of the collection
+		iri, q, isPage, err := parsePageRequest(r)
+		if err != nil {
+			return
+		}
+		page, ordered, err := pager.GetCollectionPage(c, iri, q)
+		if err != nil {
+			return
+		}
+		// Serialize the summary or page.
+		m, err := serialize(toPageDocument(iri, q, isPage, page, page.Items, ordered))
+		if err != nil {
+			return
+		}
+		raw, err := json.Marshal(m)
+		if err != nil {
+			return
+		}
+		// Write the response.
+		err = writeResponse(w, r, clock, raw, http.StatusOK, time.Time{})
+		return
+	}
+}
+
+// databaseCollectionPager pages collections stored whole in a Database.
+type databaseCollectionPager struct {
+	db Database
+}
+
+// databaseCollectionPager must be a CollectionPager.
+var _ CollectionPager = &databaseCollectionPager{}
+
+// NewDatabaseCollectionPager returns a CollectionPager for collections stored
+// whole in the Database, such as the ones returned by its Followers,
+// Following, and Liked methods.
+//
+// Each page is cut from the whole collection obtained with Get, so it only
+// saves serializing every item. Applications with large collections should
+// page them in their own storage instead.
+func NewDatabaseCollectionPager(db Database) CollectionPager {
+	return &databaseCollectionPager{db: db}
+}
+
+// GetCollectionPage obtains the collection from the Database, and returns the
+// page of its items selected by the query.
+func (d *databaseCollectionPager) GetCollectionPage(c context.Context, collectionIRI *url.URL, q BoxPageQuery) (page BoxPage, ordered bool, err error) {
+	err = d.db.Lock(c, collectionIRI)
+	if err != nil {
+		return
+	}
+	// WARNING: Unlock not deferred
+	t, err := d.db.Get(c, collectionIRI)
+	d.db.Unlock(c, collectionIRI)
+	if err != nil {
+		return
+	}
+	var ids []*url.URL
+	if oc, ok := t.(orderedItemser); ok {
+		ordered = true
+		if oi := oc.GetActivityStreamsOrderedItems(); oi != nil {
+			for iter := oi.Begin(); iter != oi.End(); iter = iter.Next() {
+				var id *url.URL
+				if id, err = ToId(iter); err != nil {
+					return
+				}
+				ids = append(ids, id)
+			}
+		}
+	} else if col, ok := t.(itemser); ok {
+		if items := col.GetActivityStreamsItems(); items != nil {
+			for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+				var id *url.URL
+				if id, err = ToId(iter); err != nil {
+					return
+				}
+				ids = append(ids, id)
+			}
+		}
+	} else {
+		err = fmt.Errorf("%s is not a Collection nor an OrderedCollection", collectionIRI)
+		return
+	}
+	page = PageBoxItems(ids, q)
+	return
+}
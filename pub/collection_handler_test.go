@@ -0,0 +1,131 @@
+package pub
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestNewCollectionHandler ensures collections are served in pages.
+func TestNewCollectionHandler(t *testing.T) {
+	ctx := context.Background()
+	followersIRI := mustParse("https://example.com/addison/followers")
+	setupFn := func(ctl *gomock.Controller, authFn AuthenticateFunc) (db *MockDatabase, clock *MockClock, h HandlerFunc) {
+		db = NewMockDatabase(ctl)
+		clock = NewMockClock(ctl)
+		h = NewCollectionHandler(authFn, NewDatabaseCollectionPager(db), clock)
+		return
+	}
+	permit := func(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+		return false, nil
+	}
+	followers := func() vocab.ActivityStreamsCollection {
+		col := streams.NewActivityStreamsCollection()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(followersIRI)
+		col.SetActivityStreamsId(id)
+		items := streams.NewActivityStreamsItemsProperty()
+		items.AppendIRI(mustParse(testFederatedActorIRI))
+		items.AppendIRI(mustParse(testFederatedActorIRI2))
+		col.SetActivityStreamsItems(items)
+		return col
+	}
+	// decode returns the JSON body of the response.
+	decode := func(t *testing.T, resp *httptest.ResponseRecorder) map[string]interface{} {
+		b, err := ioutil.ReadAll(resp.Result().Body)
+		assertEqual(t, err, nil)
+		var m map[string]interface{}
+		assertEqual(t, json.Unmarshal(b, &m), nil)
+		return m
+	}
+	t.Run("IgnoresNonActivityPubRequest", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, _, h := setupFn(ctl, permit)
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", followersIRI.String(), nil)
+		// Run
+		handled, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, false)
+	})
+	t.Run("DeniesIfNotAuthenticated", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, _, h := setupFn(ctl, func(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+			w.WriteHeader(http.StatusForbidden)
+			return true, nil
+		})
+		resp := httptest.NewRecorder()
+		req := toAPRequest(httptest.NewRequest("GET", followersIRI.String(), nil))
+		// Run
+		handled, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusForbidden)
+	})
+	t.Run("ServesSummary", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db, clock, h := setupFn(ctl, permit)
+		resp := httptest.NewRecorder()
+		req := toAPRequest(httptest.NewRequest("GET", followersIRI.String(), nil))
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, followersIRI),
+			db.EXPECT().Get(ctx, followersIRI).Return(followers(), nil),
+			db.EXPECT().Unlock(ctx, followersIRI),
+		)
+		clock.EXPECT().Now().Return(now())
+		// Run
+		handled, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusOK)
+		m := decode(t, resp)
+		assertEqual(t, m["type"], "Collection")
+		assertEqual(t, m["totalItems"], float64(2))
+		assertEqual(t, m["first"], followersIRI.String()+"?page=true")
+		_, hasItems := m["items"]
+		assertEqual(t, hasItems, false)
+	})
+	t.Run("ServesPage", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db, clock, h := setupFn(ctl, permit)
+		resp := httptest.NewRecorder()
+		req := toAPRequest(httptest.NewRequest("GET", followersIRI.String()+"?max_id="+url.QueryEscape(testFederatedActorIRI), nil))
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, followersIRI),
+			db.EXPECT().Get(ctx, followersIRI).Return(followers(), nil),
+			db.EXPECT().Unlock(ctx, followersIRI),
+		)
+		clock.EXPECT().Now().Return(now())
+		// Run
+		handled, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusOK)
+		m := decode(t, resp)
+		assertEqual(t, m["type"], "CollectionPage")
+		assertEqual(t, m["partOf"], followersIRI.String())
+		assertEqual(t, m["items"], testFederatedActorIRI2)
+		assertEqual(t, m["prev"], followersIRI.String()+"?min_id="+url.QueryEscape(testFederatedActorIRI2)+"&page=true")
+		_, hasNext := m["next"]
+		assertEqual(t, hasNext, false)
+	})
+}
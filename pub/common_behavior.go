@@ -76,4 +76,14 @@ type CommonBehavior interface {
 	// returned Transport so that any private credentials are able to be
 	// garbage collected.
 	NewTransport(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error)
+	// Visibility returns the policy determining which items of the pages
+	// of an inbox or outbox are served to the requester, based on how
+	// they are addressed.
+	//
+	// Returning nil serves every item, leaving it to the authentication
+	// of the GET requests to restrict them.
+	//
+	// Always called, regardless whether the Federated Protocol or Social
+	// API is enabled.
+	Visibility(c context.Context) *VisibilityPolicy
 }
@@ -29,17 +29,18 @@ type Database interface {
 	//
 	// The library makes this call only after acquiring a lock first.
 	InboxContains(c context.Context, inbox, id *url.URL) (contains bool, err error)
-	// GetInbox returns the first ordered collection page of the outbox at
-	// the specified IRI, for prepending new items.
+	// PrependInboxItem adds the id of an activity to the front of the
+	// items of the inbox at the specified IRI, so that it is the newest
+	// item. Note that the activity must not be added as an independent
+	// database entry. A separate call to Create will do that.
 	//
 	// The library makes this call only after acquiring a lock first.
-	GetInbox(c context.Context, inboxIRI *url.URL) (inbox vocab.ActivityStreamsOrderedCollectionPage, err error)
-	// SetInbox saves the inbox value given from GetInbox, with new items
-	// prepended. Note that the new items must not be added as independent
-	// database entries. Separate calls to Create will do that.
+	PrependInboxItem(c context.Context, inboxIRI, item *url.URL) error
+	// GetInboxPage returns a page of the items of the inbox at the
+	// specified IRI, newest first, as selected by the query.
 	//
 	// The library makes this call only after acquiring a lock first.
-	SetInbox(c context.Context, inbox vocab.ActivityStreamsOrderedCollectionPage) error
+	GetInboxPage(c context.Context, inboxIRI *url.URL, q BoxPageQuery) (page BoxPage, err error)
 	// Owns returns true if the database has an entry for the IRI and it
 	// exists in the database.
 	//
@@ -92,17 +93,18 @@ type Database interface {
 	//
 	// The library makes this call only after acquiring a lock first.
 	Delete(c context.Context, id *url.URL) error
-	// GetOutbox returns the first ordered collection page of the outbox
-	// at the specified IRI, for prepending new items.
+	// PrependOutboxItem adds the id of an activity to the front of the
+	// items of the outbox at the specified IRI, so that it is the newest
+	// item. Note that the activity must not be added as an independent
+	// database entry. A separate call to Create will do that.
 	//
 	// The library makes this call only after acquiring a lock first.
-	GetOutbox(c context.Context, inboxIRI *url.URL) (inbox vocab.ActivityStreamsOrderedCollectionPage, err error)
-	// SetOutbox saves the outbox value given from GetOutbox, with new items
-	// prepended. Note that the new items must not be added as independent
-	// database entries. Separate calls to Create will do that.
+	PrependOutboxItem(c context.Context, outboxIRI, item *url.URL) error
+	// GetOutboxPage returns a page of the items of the outbox at the
+	// specified IRI, newest first, as selected by the query.
 	//
 	// The library makes this call only after acquiring a lock first.
-	SetOutbox(c context.Context, inbox vocab.ActivityStreamsOrderedCollectionPage) error
+	GetOutboxPage(c context.Context, outboxIRI *url.URL, q BoxPageQuery) (page BoxPage, err error)
 	// NewId creates a new IRI id for the provided activity or object. The
 	// implementation does not need to set the 'id' property and simply
 	// needs to determine the value.
@@ -124,6 +126,15 @@ type Database interface {
 	//
 	// The library makes this call only after acquiring a lock first.
 	Following(c context.Context, actorIRI *url.URL) (followers vocab.ActivityStreamsCollection, err error)
+	// LocalFollowers returns the ids of the actors owned by this
+	// application that follow the actor with the given id, which is
+	// typically a federated peer.
+	//
+	// It is used to determine which actors are the recipients of an
+	// activity received by the shared inbox.
+	//
+	// The library makes this call only after acquiring a lock first.
+	LocalFollowers(c context.Context, actorIRI *url.URL) (followers []*url.URL, err error)
 	// Liked obtains the Liked Collection for an actor with the
 	// given id.
 	//
@@ -131,4 +142,17 @@ type Database interface {
 	//
 	// The library makes this call only after acquiring a lock first.
 	Liked(c context.Context, actorIRI *url.URL) (followers vocab.ActivityStreamsCollection, err error)
+	// Blocked obtains the Collection of the actors blocked by the actor
+	// with the given id. It is private to the actor, and is not one of its
+	// ActivityStreams properties.
+	//
+	// It must never be served: unaddressed values are visible to everyone,
+	// so it must not be returned by Get for an IRI that can be requested.
+	// Giving it an id with a fragment, such as the actor's id followed by
+	// "#blocked", and storing it apart from the other values achieves this.
+	//
+	// If modified, the library will then call Update.
+	//
+	// The library makes this call only after acquiring a lock first.
+	Blocked(c context.Context, actorIRI *url.URL) (blocked vocab.ActivityStreamsCollection, err error)
 }
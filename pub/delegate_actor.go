@@ -90,9 +90,25 @@ type DelegateActor interface {
 	// later) must decide whether it has seen this activity before in order
 	// to determine whether to do the forwarding algorithm.
 	//
+	// Rewrites by the InboxPolicies of the inbox's owner only apply to the
+	// activity added to its inbox and to its side effects. InboxForwarding
+	// is given the activity as it was received.
+	//
 	// If the error is ErrObjectRequired or ErrTargetRequired, then a Bad
 	// Request status is sent in the response.
 	PostInbox(c context.Context, inboxIRI *url.URL, activity Activity) error
+	// SharedInboxRecipients determines the inboxes of the actors owned by
+	// this application that an activity POSTed to the shared inbox is
+	// addressed to.
+	//
+	// Only called if the Federated Protocol is enabled.
+	//
+	// The actors may be addressed directly, or as followers of the actor
+	// sending the activity either by addressing its followers collection
+	// or the Public special collection.
+	//
+	// PostInbox is then called once for each of the returned inboxes.
+	SharedInboxRecipients(c context.Context, sharedInboxIRI *url.URL, activity Activity) (inboxIRIs []*url.URL, err error)
 	// InboxForwarding delegates inbox forwarding logic when a POST request
 	// is received in the Actor's inbox.
 	//
@@ -110,6 +126,9 @@ type DelegateActor interface {
 	// Activity is examined for the information about who to inbox forward
 	// to.
 	//
+	// Like Deliver, the forwarded deliveries may be enqueued to be made
+	// after returning.
+	//
 	// If an error is returned, it is returned to the caller of PostInbox.
 	InboxForwarding(c context.Context, inboxIRI *url.URL, activity Activity) error
 	// PostOutbox delegates the logic for side effects and adding to the
@@ -145,6 +164,9 @@ type DelegateActor interface {
 	// The provided url is the outbox of the sender. The Activity contains
 	// the information about the intended recipients.
 	//
+	// The delegate may enqueue the deliveries to be made after returning,
+	// instead of making them before returning.
+	//
 	// If an error is returned, it is returned to the caller of PostOutbox.
 	Deliver(c context.Context, outbox *url.URL, activity Activity) error
 	// AuthenticatePostOutbox delegates the authentication and authorization
@@ -191,22 +213,22 @@ type DelegateActor interface {
 	//
 	// Only called if the Social API is enabled.
 	WrapInCreate(c context.Context, value vocab.Type, outboxIRI *url.URL) (vocab.ActivityStreamsCreate, error)
-	// GetOutbox returns the OrderedCollection inbox of the actor for this
-	// context. It is up to the implementation to provide the correct
-	// collection for the kind of authorization given in the request.
+	// GetOutbox returns the OrderedCollection outbox of the actor for this
+	// context when no page is requested, or the OrderedCollectionPage
+	// requested by the 'page', 'max_id', and 'min_id' query parameters.
 	//
 	// AuthenticateGetOutbox will be called prior to this.
 	//
 	// Always called, regardless whether the Federated Protocol or Social
 	// API is enabled.
-	GetOutbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error)
+	GetOutbox(c context.Context, r *http.Request) (vocab.Type, error)
 	// GetInbox returns the OrderedCollection inbox of the actor for this
-	// context. It is up to the implementation to provide the correct
-	// collection for the kind of authorization given in the request.
+	// context when no page is requested, or the OrderedCollectionPage
+	// requested by the 'page', 'max_id', and 'min_id' query parameters.
 	//
 	// AuthenticateGetInbox will be called prior to this.
 	//
 	// Always called, regardless whether the Federated Protocol or Social
 	// API is enabled.
-	GetInbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error)
+	GetInbox(c context.Context, r *http.Request) (vocab.Type, error)
 }
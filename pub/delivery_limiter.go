@@ -0,0 +1,119 @@
+package pub
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"sync"
+)
+
+// DeliveryLimiter bounds the deliveries made by HttpSigTransports, both in
+// total and to each peer host, and paces the deliveries to each host with a
+// token bucket.
+//
+// A single DeliveryLimiter should be shared by all the HttpSigTransports of an
+// application, so that the limits apply across all of them.
+//
+// It is safe to use concurrently.
+type DeliveryLimiter struct {
+	// global bounds all concurrent deliveries, if not nil.
+	global chan struct{}
+	// Limits applied to each host.
+	maxPerHost int
+	hostRate   rate.Limit
+	hostBurst  int
+	// hostsMu guards hosts.
+	hostsMu sync.Mutex
+	hosts   map[string]*hostLimiter
+}
+
+// hostLimiter bounds and paces the deliveries to a single host.
+type hostLimiter struct {
+	// sem bounds concurrent deliveries to the host, if not nil.
+	sem    chan struct{}
+	bucket *rate.Limiter
+}
+
+// NewDeliveryLimiter returns a new DeliveryLimiter.
+//
+// At most maxConcurrent deliveries are made at the same time, of which at most
+// maxConcurrentPerHost are to the same host. Zero or negative numbers do not
+// limit concurrency.
+//
+// Deliveries to each host are made at a rate of at most perHostRate per second,
+// with bursts of up to perHostBurst deliveries. A zero or negative rate does
+// not limit the rate.
+func NewDeliveryLimiter(maxConcurrent, maxConcurrentPerHost int, perHostRate rate.Limit, perHostBurst int) *DeliveryLimiter {
+	l := &DeliveryLimiter{
+		maxPerHost: maxConcurrentPerHost,
+		hostRate:   perHostRate,
+		hostBurst:  perHostBurst,
+		hosts:      make(map[string]*hostLimiter),
+	}
+	if maxConcurrent > 0 {
+		l.global = make(chan struct{}, maxConcurrent)
+	}
+	if l.hostRate <= 0 {
+		l.hostRate = rate.Inf
+	}
+	if l.hostBurst <= 0 {
+		l.hostBurst = 1
+	}
+	return l
+}
+
+// acquire waits until a delivery to the host is permitted, or until the
+// context is done. The returned function must be called once the delivery is
+// complete.
+func (l *DeliveryLimiter) acquire(c context.Context, host string) (release func(), err error) {
+	h := l.host(host)
+	// Wait on the host first, so that deliveries to a slow host do not
+	// hold on to the global slots needed by other hosts.
+	if h.sem != nil {
+		select {
+		case h.sem <- struct{}{}:
+		case <-c.Done():
+			return nil, c.Err()
+		}
+	}
+	releaseHost := func() {
+		if h.sem != nil {
+			<-h.sem
+		}
+	}
+	if err = h.bucket.Wait(c); err != nil {
+		releaseHost()
+		return nil, err
+	}
+	if l.global != nil {
+		select {
+		case l.global <- struct{}{}:
+		case <-c.Done():
+			releaseHost()
+			return nil, c.Err()
+		}
+	}
+	release = func() {
+		if l.global != nil {
+			<-l.global
+		}
+		releaseHost()
+	}
+	return
+}
+
+// host obtains the limits for a host, creating them if needed.
+func (l *DeliveryLimiter) host(host string) *hostLimiter {
+	l.hostsMu.Lock()
+	defer l.hostsMu.Unlock()
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostLimiter{
+			bucket: rate.NewLimiter(l.hostRate, l.hostBurst),
+		}
+		if l.maxPerHost > 0 {
+			h.sem = make(chan struct{}, l.maxPerHost)
+		}
+		l.hosts[host] = h
+	}
+	return h
+}
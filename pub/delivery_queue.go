@@ -0,0 +1,352 @@
+package pub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// PendingDelivery is a serialized ActivityStreams value waiting to be sent to
+// a single recipient's inbox on behalf of an actor.
+type PendingDelivery struct {
+	// Id uniquely identifies this delivery within the DeliveryStore.
+	Id string
+	// BoxIRI is the inbox or outbox of the actor on whose behalf the
+	// delivery is made. It is passed to NewTransport so that the request
+	// carries that actor's credentials.
+	BoxIRI *url.URL
+	// ActivityId is the id of the serialized activity, and is reported in
+	// the DeliveryReport of each attempt.
+	ActivityId *url.URL
+	// Recipient is the inbox IRI receiving the payload.
+	Recipient *url.URL
+	// Payload is the serialized ActivityStreams value to POST.
+	Payload []byte
+	// Attempts is the number of delivery attempts made so far.
+	Attempts int
+	// NextAttempt is the earliest time the next attempt may be made.
+	NextAttempt time.Time
+	// LastError describes why the most recent attempt failed, if it did.
+	LastError string
+}
+
+// DeliveryStore persists the deliveries that have not yet succeeded, so that
+// they are retried across restarts of the application.
+//
+// The DeliveryQueue never calls the store concurrently for the same delivery
+// id, but may call it concurrently for different ones.
+type DeliveryStore interface {
+	// Push saves new pending deliveries.
+	Push(c context.Context, d []PendingDelivery) error
+	// Due returns at most 'max' pending deliveries whose NextAttempt is
+	// at or before 'now'.
+	Due(c context.Context, now time.Time, max int) ([]PendingDelivery, error)
+	// Reschedule saves a pending delivery that failed but will be tried
+	// again, with its Attempts, NextAttempt, and LastError updated.
+	Reschedule(c context.Context, d PendingDelivery) error
+	// Complete removes a pending delivery that succeeded.
+	Complete(c context.Context, id string) error
+	// Abandon removes a pending delivery that will not be tried again,
+	// either because it failed permanently or because it ran out of
+	// attempts. Implementations may keep a record of it for inspection.
+	Abandon(c context.Context, d PendingDelivery, cause error) error
+}
+
+// DeliveryPolicy determines how the DeliveryQueue retries and paces
+// deliveries.
+type DeliveryPolicy struct {
+	// MaxAttempts is the number of attempts made before a delivery is
+	// abandoned. Zero or negative numbers retry forever.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry. Each following retry
+	// waits twice as long as the previous one.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between two retries.
+	MaxBackoff time.Duration
+	// Jitter randomly spreads each delay by up to this fraction of it, so
+	// that deliveries failing together do not all retry together. Must
+	// be between 0 and 1.
+	Jitter float64
+	// PollInterval is how often the queue checks the DeliveryStore for
+	// deliveries that have become due. Must be positive.
+	PollInterval time.Duration
+	// BatchSize is the maximum number of deliveries fetched from the
+	// DeliveryStore at a time. Must be positive.
+	BatchSize int
+	// Concurrency is the maximum number of deliveries attempted at the
+	// same time.
+	Concurrency int
+}
+
+// DefaultDeliveryPolicy retries a failing delivery over roughly a day before
+// abandoning it.
+var DefaultDeliveryPolicy = DeliveryPolicy{
+	MaxAttempts:  12,
+	MinBackoff:   30 * time.Second,
+	MaxBackoff:   12 * time.Hour,
+	Jitter:       0.2,
+	PollInterval: 10 * time.Second,
+	BatchSize:    100,
+	Concurrency:  8,
+}
+
+// DeliveryQueue sends federated deliveries in the background, persisting them
+// in a DeliveryStore until they succeed.
+//
+// Responses with a 4xx status code are treated as a permanent failure and the
+// delivery is abandoned, with the exception of 408 Request Timeout and 429 Too
+// Many Requests. Responses with a 5xx status code, timeouts, and other network
+// errors are retried with exponential backoff and jitter according to the
+// DeliveryPolicy.
+//
+// Applications must call Run in its own goroutine for deliveries to be made.
+type DeliveryQueue struct {
+	store        DeliveryStore
+	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error)
+	clock        Clock
+	policy       DeliveryPolicy
+	report       func(c context.Context, report DeliveryReport)
+	// wake signals Run that new deliveries were enqueued.
+	wake chan struct{}
+	// randMu guards rand.
+	randMu sync.Mutex
+	rand   *mrand.Rand
+}
+
+// NewDeliveryQueue returns a new DeliveryQueue, or an error if the policy's
+// PollInterval or BatchSize is not positive.
+//
+// The newTransport function is used to obtain a Transport for each attempt,
+// and is typically the application's CommonBehavior NewTransport method.
+//
+// The report function is called with the outcome of each attempt, as a
+// DeliveryReport with a single recipient, and is typically the application's
+// FederatingProtocol ReportDelivery method. It may be nil.
+func NewDeliveryQueue(store DeliveryStore,
+	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error),
+	clock Clock,
+	policy DeliveryPolicy,
+	report func(c context.Context, report DeliveryReport)) (*DeliveryQueue, error) {
+	if policy.PollInterval <= 0 {
+		return nil, fmt.Errorf("delivery policy poll interval must be positive: %s", policy.PollInterval)
+	} else if policy.BatchSize <= 0 {
+		return nil, fmt.Errorf("delivery policy batch size must be positive: %d", policy.BatchSize)
+	}
+	return &DeliveryQueue{
+		store:        store,
+		newTransport: newTransport,
+		clock:        clock,
+		policy:       policy,
+		report:       report,
+		wake:         make(chan struct{}, 1),
+		rand:         mrand.New(mrand.NewSource(clock.Now().UnixNano())),
+	}, nil
+}
+
+// Enqueue persists the delivery of the payload, which is the serialized
+// activity with the id, to each recipient on behalf of the actor owning the box
+// IRI, and returns without waiting for the deliveries to be made.
+func (q *DeliveryQueue) Enqueue(c context.Context, boxIRI, activityId *url.URL, payload []byte, recipients []*url.URL) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	now := q.clock.Now()
+	d := make([]PendingDelivery, 0, len(recipients))
+	for _, r := range recipients {
+		id, err := newDeliveryId()
+		if err != nil {
+			return err
+		}
+		d = append(d, PendingDelivery{
+			Id:          id,
+			BoxIRI:      boxIRI,
+			ActivityId:  activityId,
+			Recipient:   r,
+			Payload:     payload,
+			NextAttempt: now,
+		})
+	}
+	if err := q.store.Push(c, d); err != nil {
+		return err
+	}
+	// Wake up Run without blocking if it is already awake.
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run makes deliveries as they become due, until the context is done or the
+// DeliveryStore returns an error.
+//
+// Run must not be called more than once at a time for the same DeliveryStore.
+func (q *DeliveryQueue) Run(c context.Context) error {
+	for {
+		n, err := q.DeliverDue(c)
+		if err != nil {
+			return err
+		}
+		// A full batch means more deliveries may already be due, unless
+		// they were left due because the context is done.
+		if err = c.Err(); err != nil {
+			return err
+		} else if n >= q.policy.BatchSize {
+			continue
+		}
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-q.wake:
+		case <-q.after(q.policy.PollInterval):
+		}
+	}
+}
+
+// after waits with the Clock if it is a TimerClock, and with the system timer
+// otherwise.
+func (q *DeliveryQueue) after(d time.Duration) <-chan time.Time {
+	if tc, ok := q.clock.(TimerClock); ok {
+		return tc.After(d)
+	}
+	return time.After(d)
+}
+
+// DeliverDue makes one attempt at each delivery that is currently due, up to
+// the policy's BatchSize, and returns the number of deliveries attempted.
+//
+// An error is returned only if the DeliveryStore failed; delivery failures are
+// recorded in the DeliveryStore instead.
+func (q *DeliveryQueue) DeliverDue(c context.Context) (n int, err error) {
+	due, err := q.store.Due(c, q.clock.Now(), q.policy.BatchSize)
+	if err != nil {
+		return
+	}
+	n = len(due)
+	concurrency := q.policy.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	errCh := make(chan error, len(due))
+	var wg sync.WaitGroup
+	for _, d := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d PendingDelivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := q.attempt(c, d); err != nil {
+				errCh <- err
+			}
+		}(d)
+	}
+	wg.Wait()
+	close(errCh)
+	if e, ok := <-errCh; ok {
+		err = e
+	}
+	return
+}
+
+// attempt tries a single delivery, reports its outcome, and records it in the
+// store.
+func (q *DeliveryQueue) attempt(c context.Context, d PendingDelivery) error {
+	start := q.clock.Now()
+	var rr RecipientReport
+	t, err := q.newTransport(c, d.BoxIRI, goFedUserAgent())
+	if err != nil {
+		rr = RecipientReport{
+			Recipient: d.Recipient,
+			Class:     InternalDeliveryError,
+			Retryable: true,
+			Err:       err,
+		}
+	} else {
+		err = t.Deliver(c, d.Payload, d.Recipient)
+		rr = newRecipientReport(c, d.Recipient, q.clock.Now().Sub(start), err)
+	}
+	if q.report != nil {
+		q.report(c, DeliveryReport{
+			BoxIRI:     d.BoxIRI,
+			ActivityId: d.ActivityId,
+			Recipients: []RecipientReport{rr},
+		})
+	}
+	if err == nil {
+		return q.store.Complete(c, d.Id)
+	}
+	// Shutting down is not the peer's fault, so do not count it against
+	// the delivery. It is still due the next time the queue runs.
+	if c.Err() != nil {
+		return nil
+	}
+	// Nor is a deferral due to the peer host's open circuit, so wait until
+	// the host is due to be probed again.
+	if e, ok := err.(*CircuitOpenError); ok && e.Deferred {
+		d.LastError = err.Error()
+		d.NextAttempt = e.NextProbe
+		return q.store.Reschedule(c, d)
+	}
+	d.Attempts++
+	d.LastError = err.Error()
+	if !isRetryableError(err) || (q.policy.MaxAttempts > 0 && d.Attempts >= q.policy.MaxAttempts) {
+		return q.store.Abandon(c, d, err)
+	}
+	d.NextAttempt = q.clock.Now().Add(q.backoff(d.Attempts))
+	return q.store.Reschedule(c, d)
+}
+
+// backoff returns the delay to wait before the next attempt, given the number
+// of attempts already made.
+func (q *DeliveryQueue) backoff(attempts int) time.Duration {
+	delay := q.policy.MinBackoff
+	for i := 1; i < attempts && delay < q.policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if q.policy.MaxBackoff > 0 && delay > q.policy.MaxBackoff {
+		delay = q.policy.MaxBackoff
+	}
+	if q.policy.Jitter > 0 {
+		q.randMu.Lock()
+		f := q.rand.Float64()
+		q.randMu.Unlock()
+		delay += time.Duration(float64(delay) * q.policy.Jitter * (2*f - 1))
+	}
+	return delay
+}
+
+// isRetryableError determines whether a failed delivery is worth attempting
+// again.
+func isRetryableError(err error) bool {
+	if e, ok := err.(*CircuitOpenError); ok {
+		return e.Deferred
+	}
+	if _, ok := err.(*DomainPolicyError); ok {
+		return false
+	}
+	if e, ok := err.(*HttpStatusError); ok {
+		if e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests {
+			return true
+		}
+		return e.StatusCode >= 500
+	}
+	// Timeouts and other network errors, such as a refused connection or a
+	// failed name lookup, may be resolved by the next attempt.
+	return true
+}
+
+// newDeliveryId returns a random id for a PendingDelivery.
+func newDeliveryId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,290 @@
+package pub
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestDeliveryQueue ensures deliveries are completed, retried, and abandoned.
+func TestDeliveryQueue(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte(`{"type":"Create"}`)
+	policy := DeliveryPolicy{
+		MaxAttempts:  3,
+		MinBackoff:   time.Minute,
+		MaxBackoff:   time.Hour,
+		PollInterval: time.Second,
+		BatchSize:    10,
+		Concurrency:  1,
+	}
+	var reports []DeliveryReport
+	setupFn := func(ctl *gomock.Controller) (tp *MockTransport, store DeliveryStore, q *DeliveryQueue) {
+		tp = NewMockTransport(ctl)
+		cl := NewMockClock(ctl)
+		cl.EXPECT().Now().Return(now()).AnyTimes()
+		store = NewMemoryDeliveryStore()
+		reports = nil
+		var err error
+		q, err = NewDeliveryQueue(store, func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (Transport, error) {
+			return tp, nil
+		}, cl, policy, func(c context.Context, report DeliveryReport) {
+			reports = append(reports, report)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	// Run tests
+	t.Run("CompletesSuccessfulDelivery", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		tp, store, q := setupFn(ctl)
+		tp.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(nil)
+		// Run
+		err := q.Enqueue(ctx, mustParse(testMyOutboxIRI), mustParse(testFederatedActivityIRI), payload, []*url.URL{mustParse(testFederatedActorIRI)})
+		assertEqual(t, err, nil)
+		n, err := q.DeliverDue(ctx)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, n, 1)
+		due, err := store.Due(ctx, now().Add(time.Hour), 0)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(due), 0)
+		assertEqual(t, len(reports), 1)
+		assertEqual(t, reports[0].BoxIRI.String(), testMyOutboxIRI)
+		assertEqual(t, reports[0].ActivityId.String(), testFederatedActivityIRI)
+		assertEqual(t, reports[0].Delivered(), 1)
+	})
+	t.Run("ReschedulesRetryableFailure", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		tp, store, q := setupFn(ctl)
+		tp.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(&HttpStatusError{
+			Method:     "POST",
+			IRI:        mustParse(testFederatedActorIRI),
+			StatusCode: http.StatusServiceUnavailable,
+		})
+		// Run
+		err := q.Enqueue(ctx, mustParse(testMyOutboxIRI), mustParse(testFederatedActivityIRI), payload, []*url.URL{mustParse(testFederatedActorIRI)})
+		assertEqual(t, err, nil)
+		_, err = q.DeliverDue(ctx)
+		// Verify
+		assertEqual(t, err, nil)
+		due, err := store.Due(ctx, now(), 0)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(due), 0)
+		due, err = store.Due(ctx, now().Add(time.Minute), 0)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(due), 1)
+		assertEqual(t, due[0].Attempts, 1)
+		assertEqual(t, len(reports), 1)
+		failed := reports[0].Failed()
+		assertEqual(t, len(failed), 1)
+		assertEqual(t, failed[0].Recipient.String(), testFederatedActorIRI)
+		assertEqual(t, failed[0].StatusCode, http.StatusServiceUnavailable)
+		assertEqual(t, failed[0].Class, ServerDeliveryError)
+		assertEqual(t, failed[0].Retryable, true)
+	})
+	t.Run("DefersDeliveryToOpenCircuit", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		tp, store, q := setupFn(ctl)
+		tp.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(&CircuitOpenError{
+			Host:      mustParse(testFederatedActorIRI).Host,
+			NextProbe: now().Add(time.Hour),
+			Deferred:  true,
+		})
+		// Run
+		err := q.Enqueue(ctx, mustParse(testMyOutboxIRI), mustParse(testFederatedActivityIRI), payload, []*url.URL{mustParse(testFederatedActorIRI)})
+		assertEqual(t, err, nil)
+		_, err = q.DeliverDue(ctx)
+		// Verify
+		assertEqual(t, err, nil)
+		due, err := store.Due(ctx, now().Add(time.Minute), 0)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(due), 0)
+		due, err = store.Due(ctx, now().Add(time.Hour), 0)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(due), 1)
+		assertEqual(t, due[0].Attempts, 0)
+	})
+	t.Run("AbandonsPermanentFailure", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		tp, store, q := setupFn(ctl)
+		tp.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(&HttpStatusError{
+			Method:     "POST",
+			IRI:        mustParse(testFederatedActorIRI),
+			StatusCode: http.StatusGone,
+		})
+		// Run
+		err := q.Enqueue(ctx, mustParse(testMyOutboxIRI), mustParse(testFederatedActivityIRI), payload, []*url.URL{mustParse(testFederatedActorIRI)})
+		assertEqual(t, err, nil)
+		_, err = q.DeliverDue(ctx)
+		// Verify
+		assertEqual(t, err, nil)
+		due, err := store.Due(ctx, now().Add(24*time.Hour), 0)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(due), 0)
+	})
+	t.Run("AbandonsAfterMaxAttempts", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		tp, store, q := setupFn(ctl)
+		tp.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(testErr)
+		err := store.Push(ctx, []PendingDelivery{
+			{
+				Id:          "1",
+				BoxIRI:      mustParse(testMyOutboxIRI),
+				Recipient:   mustParse(testFederatedActorIRI),
+				Payload:     payload,
+				Attempts:    2,
+				NextAttempt: now(),
+			},
+		})
+		assertEqual(t, err, nil)
+		// Run
+		_, err = q.DeliverDue(ctx)
+		// Verify
+		assertEqual(t, err, nil)
+		due, err := store.Due(ctx, now().Add(24*time.Hour), 0)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(due), 0)
+	})
+}
+
+// TestDeliveryQueueRun ensures Run polls the DeliveryStore with the Clock, and
+// stops once its context is done.
+func TestDeliveryQueueRun(t *testing.T) {
+	payload := []byte(`{"type":"Create"}`)
+	policy := DeliveryPolicy{
+		MaxAttempts:  3,
+		MinBackoff:   time.Minute,
+		MaxBackoff:   time.Hour,
+		PollInterval: time.Second,
+		BatchSize:    1,
+		Concurrency:  1,
+	}
+	setupFn := func(ctl *gomock.Controller) (tp *MockTransport, cl *MockTimerClock, q *DeliveryQueue) {
+		tp = NewMockTransport(ctl)
+		cl = NewMockTimerClock(ctl)
+		cl.EXPECT().Now().Return(now()).AnyTimes()
+		var err error
+		q, err = NewDeliveryQueue(NewMemoryDeliveryStore(), func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (Transport, error) {
+			return tp, nil
+		}, cl, policy, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	// Run tests
+	t.Run("WaitsWithClock", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		ctx, cancel := context.WithCancel(context.Background())
+		_, cl, q := setupFn(ctl)
+		cl.EXPECT().After(policy.PollInterval).DoAndReturn(func(d time.Duration) <-chan time.Time {
+			cancel()
+			return make(chan time.Time)
+		})
+		// Run
+		err := q.Run(ctx)
+		// Verify
+		assertEqual(t, err, context.Canceled)
+	})
+	t.Run("StopsAfterFullBatchOnceCanceled", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		ctx, cancel := context.WithCancel(context.Background())
+		tp, _, q := setupFn(ctl)
+		err := q.Enqueue(ctx, mustParse(testMyOutboxIRI), mustParse(testFederatedActivityIRI), payload, []*url.URL{mustParse(testFederatedActorIRI)})
+		assertEqual(t, err, nil)
+		tp.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).DoAndReturn(func(c context.Context, b []byte, to *url.URL) error {
+			cancel()
+			return c.Err()
+		})
+		// Run
+		err = q.Run(ctx)
+		// Verify
+		assertEqual(t, err, context.Canceled)
+	})
+}
+
+// TestNewDeliveryQueueRejectsBusyLoopingPolicy ensures a DeliveryQueue is not
+// created with a policy that would make Run poll without waiting.
+func TestNewDeliveryQueueRejectsBusyLoopingPolicy(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	cl := NewMockClock(ctl)
+	cl.EXPECT().Now().Return(now()).AnyTimes()
+	newTransport := func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (Transport, error) {
+		return nil, nil
+	}
+	noPoll := DefaultDeliveryPolicy
+	noPoll.PollInterval = 0
+	noBatch := DefaultDeliveryPolicy
+	noBatch.BatchSize = 0
+	for name, policy := range map[string]DeliveryPolicy{"ZeroPollInterval": noPoll, "ZeroBatchSize": noBatch} {
+		t.Run(name, func(t *testing.T) {
+			q, err := NewDeliveryQueue(NewMemoryDeliveryStore(), newTransport, cl, policy, nil)
+			assertEqual(t, q == nil, true)
+			assertNotEqual(t, err, nil)
+		})
+	}
+}
+
+// TestIsRetryableError ensures only transient failures are retried.
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			"Bad Request",
+			&HttpStatusError{StatusCode: http.StatusBadRequest},
+			false,
+		},
+		{
+			"Not Found",
+			&HttpStatusError{StatusCode: http.StatusNotFound},
+			false,
+		},
+		{
+			"Too Many Requests",
+			&HttpStatusError{StatusCode: http.StatusTooManyRequests},
+			true,
+		},
+		{
+			"Internal Server Error",
+			&HttpStatusError{StatusCode: http.StatusInternalServerError},
+			true,
+		},
+		{
+			"Network Error",
+			errors.New("connection refused"),
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := isRetryableError(test.err); actual != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
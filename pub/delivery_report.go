@@ -0,0 +1,148 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DeliveryErrorClass broadly categorizes why a delivery failed.
+type DeliveryErrorClass string
+
+const (
+	// NoDeliveryError is the class of successful and enqueued deliveries.
+	NoDeliveryError DeliveryErrorClass = ""
+	// ClientDeliveryError is the class of deliveries the peer responded to
+	// with a 4xx status code.
+	ClientDeliveryError DeliveryErrorClass = "client"
+	// ServerDeliveryError is the class of deliveries the peer responded to
+	// with a 5xx status code, or another unexpected status code.
+	ServerDeliveryError DeliveryErrorClass = "server"
+	// NetworkDeliveryError is the class of deliveries that did not get a
+	// response from the peer, such as when the connection is refused or
+	// times out.
+	NetworkDeliveryError DeliveryErrorClass = "network"
+	// CanceledDeliveryError is the class of deliveries that were not made
+	// because the context was canceled or its deadline exceeded.
+	CanceledDeliveryError DeliveryErrorClass = "canceled"
+	// InternalDeliveryError is the class of deliveries that could not be
+	// attempted due to a failure on this server, such as being unable to
+	// obtain a Transport.
+	InternalDeliveryError DeliveryErrorClass = "internal"
+	// SkippedDeliveryError is the class of deliveries that were not
+	// attempted because the circuit of the peer host is open. They are
+	// retryable if the PeerHealthPolicy defers them.
+	SkippedDeliveryError DeliveryErrorClass = "skipped"
+	// PolicyDeliveryError is the class of deliveries that were not
+	// attempted because the InstancePolicy refuses to deliver to the peer
+	// domain. They are intentional, and never retried.
+	PolicyDeliveryError DeliveryErrorClass = "policy"
+)
+
+// RecipientReport is the outcome of delivering an activity to one recipient.
+type RecipientReport struct {
+	// Recipient is the inbox IRI delivered to.
+	Recipient *url.URL
+	// Queued is true if the delivery was added to the DeliveryQueue, in
+	// which case it has yet to be attempted and the remaining fields are
+	// not set. The DeliveryQueue reports the outcome of each attempt
+	// separately.
+	Queued bool
+	// StatusCode is the HTTP status code of the peer's response if the
+	// delivery failed with one, and zero otherwise.
+	StatusCode int
+	// Latency is how long the delivery attempt took.
+	Latency time.Duration
+	// Class categorizes the failure, and is NoDeliveryError if the
+	// delivery succeeded.
+	Class DeliveryErrorClass
+	// Retryable is true if the delivery failed, but attempting it again
+	// may succeed.
+	Retryable bool
+	// Err is nil if the delivery succeeded.
+	Err error
+}
+
+// DeliveryReport is the outcome of delivering an activity to its recipients,
+// either from an actor's outbox or by inbox forwarding.
+type DeliveryReport struct {
+	// BoxIRI is the outbox, or the inbox when forwarding, that the
+	// activity was delivered from.
+	BoxIRI *url.URL
+	// ActivityId is the id of the delivered activity.
+	ActivityId *url.URL
+	// Recipients contains one report for each recipient, in the order
+	// they were delivered to.
+	Recipients []RecipientReport
+}
+
+// Delivered returns the number of recipients the activity was delivered to.
+// Enqueued deliveries are not counted.
+func (r DeliveryReport) Delivered() (n int) {
+	for _, rr := range r.Recipients {
+		if !rr.Queued && rr.Err == nil {
+			n++
+		}
+	}
+	return
+}
+
+// Failed returns the reports of the recipients the activity could not be
+// delivered to.
+func (r DeliveryReport) Failed() (failed []RecipientReport) {
+	for _, rr := range r.Recipients {
+		if rr.Err != nil {
+			failed = append(failed, rr)
+		}
+	}
+	return
+}
+
+// err returns an error describing the failed deliveries, or nil if there are
+// none. Deliveries refused by the InstancePolicy are intentional, and are not
+// errors.
+func (r DeliveryReport) err() error {
+	var s []string
+	for _, rr := range r.Failed() {
+		if rr.Class != PolicyDeliveryError {
+			s = append(s, fmt.Sprintf("%s=%s", rr.Recipient, rr.Err.Error()))
+		}
+	}
+	if len(s) == 0 {
+		return nil
+	}
+	return fmt.Errorf("requests failed: %s", strings.Join(s, ";"))
+}
+
+// newRecipientReport describes the outcome of a delivery attempt made with the
+// given context.
+func newRecipientReport(c context.Context, recipient *url.URL, latency time.Duration, err error) RecipientReport {
+	rr := RecipientReport{
+		Recipient: recipient,
+		Latency:   latency,
+		Err:       err,
+	}
+	if err == nil {
+		return rr
+	}
+	rr.Retryable = isRetryableError(err)
+	if _, ok := err.(*CircuitOpenError); ok {
+		rr.Class = SkippedDeliveryError
+	} else if _, ok := err.(*DomainPolicyError); ok {
+		rr.Class = PolicyDeliveryError
+	} else if e, ok := err.(*HttpStatusError); ok {
+		rr.StatusCode = e.StatusCode
+		if e.StatusCode >= 400 && e.StatusCode < 500 {
+			rr.Class = ClientDeliveryError
+		} else {
+			rr.Class = ServerDeliveryError
+		}
+	} else if c.Err() != nil {
+		rr.Class = CanceledDeliveryError
+	} else {
+		rr.Class = NetworkDeliveryError
+	}
+	return rr
+}
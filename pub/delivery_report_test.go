@@ -0,0 +1,96 @@
+package pub
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestNewRecipientReport ensures failed deliveries are properly classified.
+func TestNewRecipientReport(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name          string
+		c             context.Context
+		err           error
+		expectedClass DeliveryErrorClass
+		expectedCode  int
+		retryable     bool
+	}{
+		{
+			"Success",
+			context.Background(),
+			nil,
+			NoDeliveryError,
+			0,
+			false,
+		},
+		{
+			"Gone",
+			context.Background(),
+			&HttpStatusError{StatusCode: http.StatusGone},
+			ClientDeliveryError,
+			http.StatusGone,
+			false,
+		},
+		{
+			"Too Many Requests",
+			context.Background(),
+			&HttpStatusError{StatusCode: http.StatusTooManyRequests},
+			ClientDeliveryError,
+			http.StatusTooManyRequests,
+			true,
+		},
+		{
+			"Bad Gateway",
+			context.Background(),
+			&HttpStatusError{StatusCode: http.StatusBadGateway},
+			ServerDeliveryError,
+			http.StatusBadGateway,
+			true,
+		},
+		{
+			"Network Error",
+			context.Background(),
+			testErr,
+			NetworkDeliveryError,
+			0,
+			true,
+		},
+		{
+			"Skipped",
+			context.Background(),
+			&CircuitOpenError{Host: "example.com", Deferred: true},
+			SkippedDeliveryError,
+			0,
+			true,
+		},
+		{
+			"Refused By Policy",
+			context.Background(),
+			&DomainPolicyError{Host: "example.com", Severity: DomainNoDeliver},
+			PolicyDeliveryError,
+			0,
+			false,
+		},
+		{
+			"Canceled",
+			canceled,
+			testErr,
+			CanceledDeliveryError,
+			0,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := newRecipientReport(test.c, mustParse(testFederatedActorIRI), time.Second, test.err)
+			assertEqual(t, rr.Class, test.expectedClass)
+			assertEqual(t, rr.StatusCode, test.expectedCode)
+			assertEqual(t, rr.Retryable, test.retryable)
+			assertEqual(t, rr.Latency, time.Second)
+		})
+	}
+}
@@ -2,7 +2,6 @@ package pub
 
 import (
 	"context"
-	"github.com/go-fed/activity/streams/vocab"
 	"net/http"
 	"net/url"
 )
@@ -32,6 +31,9 @@ type FederatingProtocol interface {
 	// Finally, if the authentication and authorization succeeds, then
 	// authenticated must be true and error nil. The request will continue
 	// to be processed.
+	//
+	// An HttpSigVerifier may be used to authenticate the HTTP Signature
+	// of the request.
 	AuthenticatePostInbox(c context.Context, w http.ResponseWriter, r *http.Request) (authenticated bool, err error)
 	// Blocked should determine whether to permit a set of actors given by
 	// their ids are able to interact with this particular end user due to
@@ -47,6 +49,12 @@ type FederatingProtocol interface {
 	// Finally, if the authentication and authorization succeeds, then
 	// blocked must be false and error nil. The request will continue
 	// to be processed.
+	//
+	// Blocks by the actor owning the inbox are instead applied once the
+	// activity is posted to it, by default with BlockedByCollection, or
+	// with the FederatingProtocol if it is an InboxBlocker. So Blocked
+	// only needs to apply any other blocking, and can otherwise return
+	// false.
 	Blocked(c context.Context, actorIRIs []*url.URL) (blocked bool, err error)
 	// Callbacks returns the application logic that handles ActivityStreams
 	// received from federating peers.
@@ -87,6 +95,27 @@ type FederatingProtocol interface {
 	//
 	// Zero or negative numbers indicate infinite recursion.
 	MaxDeliveryRecursionDepth(c context.Context) int
+	// DeliveryQueue returns the queue that outbound deliveries are added
+	// to, for both activities posted to an outbox and inbox forwarding.
+	// Requests are then made in the background and retried until they
+	// succeed, so that the peer or client is not kept waiting on every
+	// recipient's server.
+	//
+	// The application is responsible for running the DeliveryQueue.
+	//
+	// If nil is returned, deliveries are instead made immediately while
+	// handling the request and are not retried.
+	DeliveryQueue(c context.Context) *DeliveryQueue
+	// ReportDelivery is called with the outcome of delivering an activity
+	// to each of its recipients, both for activities posted to an outbox
+	// and for inbox forwarding. It is called once per activity, after all
+	// of its recipients were delivered to or enqueued to be delivered to.
+	// Pass it to NewDeliveryQueue to also be told the outcome of each
+	// enqueued delivery attempt.
+	//
+	// The application may use it to inform users of how many peers
+	// received an activity, or to retry failed deliveries on its own.
+	ReportDelivery(c context.Context, report DeliveryReport)
 	// FilterForwarding allows the implementation to apply business logic
 	// such as blocks, spam filtering, and so on to a list of potential
 	// Collections and OrderedCollections of recipients when inbox
@@ -95,13 +124,18 @@ type FederatingProtocol interface {
 	// The activity is provided as a reference for more intelligent
 	// logic to be used, but the implementation must not modify it.
 	FilterForwarding(c context.Context, potentialRecipients []*url.URL, a Activity) (filteredRecipients []*url.URL, err error)
-	// GetInbox returns the OrderedCollection inbox of the actor for this
-	// context. It is up to the implementation to provide the correct
-	// collection for the kind of authorization given in the request.
+	// GetInbox is called with the page of the inbox selected by the
+	// request, as returned by the Database's GetInboxPage, and returns the
+	// page to serve. When no page is requested, only the TotalItems of
+	// the returned page are served.
+	//
+	// It is up to the implementation to remove the items that the kind
+	// of authorization given in the request does not permit, or to simply
+	// return the page unchanged. The VisibilityPolicy, if any, is applied
+	// to the items of the returned page afterwards.
 	//
 	// AuthenticateGetInbox will be called prior to this.
 	//
-	// Always called, regardless whether the Federated Protocol or Social
-	// API is enabled.
-	GetInbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error)
+	// Only called if the Federated Protocol is enabled.
+	GetInbox(c context.Context, r *http.Request, inboxIRI *url.URL, q BoxPageQuery, page BoxPage) (BoxPage, error)
 }
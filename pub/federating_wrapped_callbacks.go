@@ -23,6 +23,10 @@ const (
 	// OnFollowAutomaticallyAccept triggers the side effect of sending a
 	// Reject of this Follow request in response.
 	OnFollowAutomaticallyReject
+	// OnFollowManualApproval triggers the side effect of saving this
+	// Follow request in the PendingFollowStore, until the application
+	// calls ApproveFollow or RejectFollow for it.
+	OnFollowManualApproval
 )
 
 // FederatingWrappedCallbacks lists the callback functions that already have
@@ -52,6 +56,11 @@ type FederatingWrappedCallbacks struct {
 	//
 	// Delete removes the federated entry from the database.
 	Delete func(context.Context, vocab.ActivityStreamsDelete) error
+	// DereferenceCache, if not nil, is the cache used by the
+	// CachingTransports of the application. The objects of federated
+	// Update and Delete Activities, such as peer actors, are evicted from
+	// it so that their latest versions are fetched when next needed.
+	DereferenceCache DereferenceCache
 	// Follow handles additional side effects for the Follow ActivityStreams
 	// type, specific to the application using go-fed.
 	//
@@ -60,24 +69,42 @@ type FederatingWrappedCallbacks struct {
 	Follow func(context.Context, vocab.ActivityStreamsFollow) error
 	// OnFollow determines what action to take for this particular callback
 	// if a Follow Activity is handled.
+	//
+	// An Accept or Reject sent in response is given a new id, created in
+	// the database, added to the outbox, and delivered like any activity
+	// posted to the outbox.
 	OnFollow OnFollowBehavior
+	// PendingFollows saves the Follow requests awaiting approval. It is
+	// required when OnFollow is OnFollowManualApproval. A Follow request
+	// that is undone is removed from it.
+	PendingFollows PendingFollowStore
 	// Accept handles additional side effects for the Accept ActivityStreams
 	// type, specific to the application using go-fed.
 	//
 	// The wrapping function determines if this 'Accept' is in response to a
 	// 'Follow'. If so, then the 'actor' is added to the original 'actor's
-	// 'following' collection.
+	// 'following' collection, and the 'Follow' is no longer pending in
+	// OutgoingFollows.
 	//
 	// Otherwise, no side effects are done by go-fed.
 	Accept func(context.Context, vocab.ActivityStreamsAccept) error
 	// Reject handles additional side effects for the Reject ActivityStreams
 	// type, specific to the application using go-fed.
 	//
-	// The wrapping function has no default side effects. However, if this
-	// 'Reject' is in response to a 'Follow' then the client MUST NOT go
-	// forward with adding the 'actor' to the original 'actor's 'following'
-	// collection by the client application.
+	// The wrapping function drops a rejected 'Follow' that is pending in
+	// OutgoingFollows, and otherwise has no default side effects. However,
+	// if this 'Reject' is in response to a 'Follow' then the client MUST
+	// NOT go forward with adding the 'actor' to the original 'actor's
+	// 'following' collection by the client application.
 	Reject func(context.Context, vocab.ActivityStreamsReject) error
+	// OutgoingFollows, if not nil, keeps the Follows sent by the actors of
+	// this application until they are accepted or rejected. The Follows
+	// are saved by the SocialWrappedCallbacks, so it should be the same
+	// PendingFollowStore as their OutgoingFollows.
+	//
+	// An Accept of a pending Follow uses the stored Follow instead of
+	// fetching it.
+	OutgoingFollows PendingFollowStore
 	// Add handles additional side effects for the Add ActivityStreams
 	// type, specific to the application using go-fed.
 	//
@@ -112,9 +139,14 @@ type FederatingWrappedCallbacks struct {
 	// It enforces that the actors on the Undo must correspond to all of the
 	// 'object' actors in some manner.
 	//
-	// It is expected that the application will implement the proper
-	// reversal of activities that are being undone.
+	// The wrapping function then reverses the default side effects of the
+	// undone Follows, Likes, and Announces, unless disabled by
+	// DisableUndo. Any other reversal is left to the application.
 	Undo func(context.Context, vocab.ActivityStreamsUndo) error
+	// DisableUndo lists the types of Activities whose default side effects
+	// are not reversed when they are undone, such as when the application
+	// reverses them itself. By default, all are reversed.
+	DisableUndo UndoSideEffects
 	// Block handles additional side effects for the Block ActivityStreams
 	// type, specific to the application using go-fed.
 	//
@@ -133,6 +165,9 @@ type FederatingWrappedCallbacks struct {
 	inboxIRI *url.URL
 	// newTransport creates a new Transport.
 	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error)
+	// send gives a new id to an activity generated in response to one in
+	// the inbox, adds it to the outbox, and delivers it.
+	send func(c context.Context, inboxIRI *url.URL, activity Activity) error
 }
 
 // callbacks returns the WrappedCallbacks members into a single interface slice
@@ -262,6 +297,13 @@ func (w FederatingWrappedCallbacks) create(c context.Context, a vocab.ActivitySt
 			return err
 		}
 		defer w.db.Unlock(c, id)
+		// An activity delivered to the shared inbox is posted to the
+		// inbox of each local recipient, so only create the object once.
+		if exists, err := w.db.Exists(c, id); err != nil {
+			return err
+		} else if exists {
+			return nil
+		}
 		if err := w.db.Create(c, t); err != nil {
 			return err
 		}
@@ -306,7 +348,7 @@ func (w FederatingWrappedCallbacks) update(c context.Context, a vocab.ActivitySt
 		if err := w.db.Update(c, t); err != nil {
 			return err
 		}
-		return nil
+		return w.evictFromCache(c, id)
 	}
 	for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
 		if err := loopFn(iter); err != nil {
@@ -343,7 +385,7 @@ func (w FederatingWrappedCallbacks) deleteFn(c context.Context, a vocab.Activity
 		if err := w.db.Delete(c, id); err != nil {
 			return err
 		}
-		return nil
+		return w.evictFromCache(c, id)
 	}
 	for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
 		if err := loopFn(iter); err != nil {
@@ -356,6 +398,15 @@ func (w FederatingWrappedCallbacks) deleteFn(c context.Context, a vocab.Activity
 	return nil
 }
 
+// evictFromCache removes the dereferenced value of the id from the
+// DereferenceCache, if there is one.
+func (w FederatingWrappedCallbacks) evictFromCache(c context.Context, id *url.URL) error {
+	if w.DereferenceCache == nil {
+		return nil
+	}
+	return w.DereferenceCache.Delete(c, cacheKey(id))
+}
+
 // follow implements the federating Follow activity side effects.
 func (w FederatingWrappedCallbacks) follow(c context.Context, a vocab.ActivityStreamsFollow) error {
 	op := a.GetActivityStreamsObject()
@@ -392,76 +443,24 @@ func (w FederatingWrappedCallbacks) follow(c context.Context, a vocab.ActivitySt
 		}
 	}
 	if isMe {
-		// Prepare the response.
-		var response Activity
-		if w.OnFollow == OnFollowAutomaticallyAccept {
-			response = streams.NewActivityStreamsAccept()
-		} else if w.OnFollow == OnFollowAutomaticallyReject {
-			response = streams.NewActivityStreamsReject()
-		} else {
-			return fmt.Errorf("unknown OnFollowBehavior: %d", w.OnFollow)
-		}
-		// Set us as the 'actor'.
-		me := streams.NewActivityStreamsActorProperty()
-		response.SetActivityStreamsActor(me)
-		me.AppendIRI(actorIRI)
-		// Set the Follow as the 'object' property.
-		op := streams.NewActivityStreamsObjectProperty()
-		response.SetActivityStreamsObject(op)
-		op.AppendActivityStreamsFollow(a)
-		// Add all actors on the original Follow to the 'to' property.
-		recipients := make([]*url.URL, 0)
-		to := streams.NewActivityStreamsToProperty()
-		response.SetActivityStreamsTo(to)
-		followActors := a.GetActivityStreamsActor()
-		for iter := followActors.Begin(); iter != followActors.End(); iter = iter.Next() {
-			id, err := ToId(iter)
-			if err != nil {
-				return err
-			}
-			to.AppendIRI(id)
-			recipients = append(recipients, id)
-		}
-		if w.OnFollow == OnFollowAutomaticallyAccept {
-			// If automatically accepting, then also update our
-			// followers collection with the new actors.
-			//
-			// If automatically rejecting, do not update the
-			// followers collection.
-			if err := w.db.Lock(c, actorIRI); err != nil {
-				return err
+		switch w.OnFollow {
+		case OnFollowAutomaticallyAccept, OnFollowAutomaticallyReject:
+			accept := w.OnFollow == OnFollowAutomaticallyAccept
+			send := func(c context.Context, response Activity) error {
+				return w.send(c, w.inboxIRI, response)
 			}
-			// WARNING: Unlock not deferred.
-			followers, err := w.db.Followers(c, actorIRI)
-			if err != nil {
-				w.db.Unlock(c, actorIRI)
+			if err := respondToFollow(c, w.db, actorIRI, a, accept, send); err != nil {
 				return err
 			}
-			items := followers.GetActivityStreamsItems()
-			for _, elem := range recipients {
-				items.PrependIRI(elem)
+		case OnFollowManualApproval:
+			if w.PendingFollows == nil {
+				return fmt.Errorf("OnFollowManualApproval requires a PendingFollowStore")
 			}
-			if err = w.db.Update(c, followers); err != nil {
-				w.db.Unlock(c, actorIRI)
+			if err := w.PendingFollows.AddPendingFollow(c, actorIRI, a); err != nil {
 				return err
 			}
-			w.db.Unlock(c, actorIRI)
-			// Unlock must be called by now and every branch above.
-		}
-		m, err := serialize(response)
-		if err != nil {
-			return err
-		}
-		b, err := json.Marshal(m)
-		if err != nil {
-			return err
-		}
-		t, err := w.newTransport(c, w.inboxIRI, goFedUserAgent())
-		if err != nil {
-			return err
-		}
-		if err := t.BatchDeliver(c, b, recipients); err != nil {
-			return err
+		default:
+			return fmt.Errorf("unknown OnFollowBehavior: %d", w.OnFollow)
 		}
 	}
 	if w.Follow != nil {
@@ -489,7 +488,23 @@ func (w FederatingWrappedCallbacks) accept(c context.Context, a vocab.ActivitySt
 		//
 		// Determine if we are in a follow on the 'object' property.
 		var maybeMyFollowIRI *url.URL
+		var pendingFollow vocab.ActivityStreamsFollow
 		for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
+			// Prefer a Follow we sent and still keep as pending
+			// over fetching it.
+			if w.OutgoingFollows != nil {
+				id, err := ToId(iter)
+				if err != nil {
+					return err
+				}
+				pendingFollow, err = w.OutgoingFollows.GetPendingFollow(c, actorIRI, id)
+				if err != nil {
+					return err
+				} else if pendingFollow != nil {
+					maybeMyFollowIRI = id
+					break
+				}
+			}
 			t := iter.GetType()
 			if t == nil && iter.IsIRI() {
 				// Attempt to dereference the IRI instead
@@ -513,7 +528,7 @@ func (w FederatingWrappedCallbacks) accept(c context.Context, a vocab.ActivitySt
 				return fmt.Errorf("cannot handle federated create: object is neither a value nor IRI")
 			}
 			// Ensure it is a Follow.
-			if !streams.ActivityStreamsFollowIsExtendedBy(t) {
+			if !isFollow(t) {
 				continue
 			}
 			follow, ok := t.(Activity)
@@ -543,7 +558,13 @@ func (w FederatingWrappedCallbacks) accept(c context.Context, a vocab.ActivitySt
 		}
 		// If we received an Accept whose 'object' is a Follow with an
 		// Accept that we sent, add to the following collection.
-		if maybeMyFollowIRI != nil {
+		if maybeMyFollowIRI != nil && pendingFollow != nil {
+			// The pending Follow is known to be ours, so only
+			// ensure the Accept is from the followed actor.
+			if err := mustBeFollowedBy(pendingFollow, a.GetActivityStreamsActor()); err != nil {
+				return err
+			}
+		} else if maybeMyFollowIRI != nil {
 			// Verify our Follow request exists and the peer didn't
 			// fabricate it.
 			//
@@ -558,7 +579,7 @@ func (w FederatingWrappedCallbacks) accept(c context.Context, a vocab.ActivitySt
 				if err != nil {
 					return err
 				}
-				if !streams.ActivityStreamsFollowIsExtendedBy(t) {
+				if !isFollow(t) {
 					return fmt.Errorf("peer gave an Accept wrapping a Follow but provided a non-Follow id")
 				}
 				follow, ok := t.(Activity)
@@ -581,6 +602,8 @@ func (w FederatingWrappedCallbacks) accept(c context.Context, a vocab.ActivitySt
 			if err != nil {
 				return err
 			}
+		}
+		if maybeMyFollowIRI != nil {
 			// Add the peer to our following collection.
 			actors := a.GetActivityStreamsActor()
 			if actors == nil || actors.Len() == 0 {
@@ -596,6 +619,10 @@ func (w FederatingWrappedCallbacks) accept(c context.Context, a vocab.ActivitySt
 				return err
 			}
 			items := following.GetActivityStreamsItems()
+			if items == nil {
+				items = streams.NewActivityStreamsItemsProperty()
+				following.SetActivityStreamsItems(items)
+			}
 			for iter := actors.Begin(); iter != actors.End(); iter = iter.Next() {
 				id, err := ToId(iter)
 				if err != nil {
@@ -610,6 +637,13 @@ func (w FederatingWrappedCallbacks) accept(c context.Context, a vocab.ActivitySt
 			}
 			w.db.Unlock(c, actorIRI)
 			// Unlock must be called by now and every branch above.
+			//
+			// The Follow is no longer pending.
+			if pendingFollow != nil {
+				if err := w.OutgoingFollows.RemovePendingFollow(c, actorIRI, maybeMyFollowIRI); err != nil {
+					return err
+				}
+			}
 		}
 	}
 	if w.Accept != nil {
@@ -620,6 +654,40 @@ func (w FederatingWrappedCallbacks) accept(c context.Context, a vocab.ActivitySt
 
 // reject implements the federating Reject activity side effects.
 func (w FederatingWrappedCallbacks) reject(c context.Context, a vocab.ActivityStreamsReject) error {
+	op := a.GetActivityStreamsObject()
+	if w.OutgoingFollows != nil && op != nil && op.Len() > 0 {
+		// Get this actor's id.
+		if err := w.db.Lock(c, w.inboxIRI); err != nil {
+			return err
+		}
+		// WARNING: Unlock not deferred.
+		actorIRI, err := w.db.ActorForInbox(c, w.inboxIRI)
+		w.db.Unlock(c, w.inboxIRI)
+		if err != nil {
+			return err
+		}
+		// Unlock must be called by now and every branch above.
+		//
+		// Drop the rejected Follows we sent and keep as pending.
+		for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
+			id, err := ToId(iter)
+			if err != nil {
+				return err
+			}
+			follow, err := w.OutgoingFollows.GetPendingFollow(c, actorIRI, id)
+			if err != nil {
+				return err
+			} else if follow == nil {
+				continue
+			}
+			if err := mustBeFollowedBy(follow, a.GetActivityStreamsActor()); err != nil {
+				return err
+			}
+			if err := w.OutgoingFollows.RemovePendingFollow(c, actorIRI, id); err != nil {
+				return err
+			}
+		}
+	}
 	if w.Reject != nil {
 		return w.Reject(c, a)
 	}
@@ -712,6 +780,13 @@ func (w FederatingWrappedCallbacks) like(c context.Context, a vocab.ActivityStre
 			likesT = col
 			likes.SetActivityStreamsCollection(col)
 		}
+		// An activity delivered to the shared inbox is posted to the
+		// inbox of each local recipient, so only add it once.
+		if has, err := containsId(likesT, id); err != nil {
+			return err
+		} else if has {
+			return nil
+		}
 		// Prepend the activity's 'id' on the 'likes' Collection or
 		// OrderedCollection.
 		if col, ok := likesT.(itemser); ok {
@@ -793,6 +868,13 @@ func (w FederatingWrappedCallbacks) announce(c context.Context, a vocab.Activity
 			sharesT = col
 			shares.SetActivityStreamsCollection(col)
 		}
+		// An activity delivered to the shared inbox is posted to the
+		// inbox of each local recipient, so only add it once.
+		if has, err := containsId(sharesT, id); err != nil {
+			return err
+		} else if has {
+			return nil
+		}
 		// Prepend the activity's 'id' on the 'shares' Collection or
 		// OrderedCollection.
 		if col, ok := sharesT.(itemser); ok {
@@ -836,7 +918,11 @@ func (w FederatingWrappedCallbacks) undo(c context.Context, a vocab.ActivityStre
 		return ErrObjectRequired
 	}
 	actors := a.GetActivityStreamsActor()
-	if err := mustHaveActivityActorsMatchObjectActors(c, actors, op, w.newTransport, w.inboxIRI); err != nil {
+	undone, err := mustHaveActivityActorsMatchObjectActors(c, actors, op, w.db, w.newTransport, w.inboxIRI)
+	if err != nil {
+		return err
+	}
+	if err := undoActivities(c, undone, w.DisableUndo, w.undoFollow, w.undoLike, w.undoAnnounce, nil); err != nil {
 		return err
 	}
 	if w.Undo != nil {
@@ -0,0 +1,615 @@
+// Package filedb is a reference implementation of the pub.Database interface
+// that stores ActivityStreams values as JSON files on the local disk.
+//
+// It is suitable for small servers, and documents what each of the Database
+// methods must do for applications writing their own implementation. Reading a
+// page of an inbox or outbox reads every item ever added to it, so servers
+// with large boxes need a Database that indexes them.
+package filedb
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// objectsDir contains a JSON file for each value, named by the hash
+	// of its id.
+	objectsDir = "objects"
+	// inboxesDir and outboxesDir contain a file for each inbox or outbox,
+	// named by the hash of its IRI, whose content is the IRI of its actor.
+	inboxesDir  = "inboxes"
+	outboxesDir = "outboxes"
+	// itemsDir contains a directory for each inbox or outbox, named by the
+	// hash of its IRI. It holds itemsLog, and an empty file for each item
+	// named by the hash of the item's id.
+	itemsDir = "items"
+	// itemsLog lists the ids of the items of an inbox or outbox, one per
+	// line, oldest first, so that adding an item only appends to it.
+	itemsLog = "log"
+	// blockedDir contains the private blocked collection of each local
+	// actor, named by the hash of its id. It is kept apart from objectsDir
+	// so that Get never returns it.
+	blockedDir = "blocked"
+	// blockedFragment is the fragment of the ids of blocked collections,
+	// which cannot be requested over HTTP.
+	blockedFragment = "blocked"
+	// fileMode is the permission of the files written.
+	fileMode = 0600
+	// dirMode is the permission of the directories created.
+	dirMode = 0700
+)
+
+// Database must be a pub.Database.
+var _ pub.Database = &Database{}
+
+// Database is a pub.Database storing each ActivityStreams value as a JSON file
+// in a directory, keyed by its id, so that its contents survive restarts.
+//
+// It owns the IRIs of a single host. Writes replace files atomically, so a
+// value is never partially written.
+//
+// The locks taken by Lock exist only in memory, so a directory must only be
+// used by one Database at a time.
+type Database struct {
+	root string
+	host string
+	// mu guards locks.
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// NewDatabase returns a Database storing its files in the root directory,
+// which is created if needed, and owning the IRIs of the host.
+func NewDatabase(root, host string) (*Database, error) {
+	for _, dir := range []string{objectsDir, inboxesDir, outboxesDir, itemsDir, blockedDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), dirMode); err != nil {
+			return nil, err
+		}
+	}
+	return &Database{
+		root:  root,
+		host:  host,
+		locks: make(map[string]chan struct{}),
+	}, nil
+}
+
+// Lock takes a lock for the id, which may not exist in the database, waiting
+// until the lock is available or the context is done.
+//
+// The lock is only exclusive: the library never reads a value without locking
+// it, so no finer grained locks are needed.
+func (d *Database) Lock(c context.Context, id *url.URL) error {
+	d.mu.Lock()
+	l, ok := d.locks[id.String()]
+	if !ok {
+		l = make(chan struct{}, 1)
+		d.locks[id.String()] = l
+	}
+	d.mu.Unlock()
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-c.Done():
+		return c.Err()
+	}
+}
+
+// Unlock releases the lock for the id. It returns an error if the lock was not
+// held, in which case nothing changes.
+func (d *Database) Unlock(c context.Context, id *url.URL) error {
+	d.mu.Lock()
+	l, ok := d.locks[id.String()]
+	d.mu.Unlock()
+	if ok {
+		select {
+		case <-l:
+			return nil
+		default:
+		}
+	}
+	return fmt.Errorf("unlock of %s which is not locked", id)
+}
+
+// InboxContains returns true if the id was prepended to the inbox.
+func (d *Database) InboxContains(c context.Context, inbox, id *url.URL) (contains bool, err error) {
+	_, err = os.Stat(filepath.Join(d.itemsPath(inbox), fileName(id.String())))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// PrependInboxItem adds the id to the front of the inbox. The activity is not
+// stored, as the library calls Create for it.
+func (d *Database) PrependInboxItem(c context.Context, inboxIRI, item *url.URL) error {
+	return d.prependItem(inboxIRI, item)
+}
+
+// GetInboxPage returns the page of the inbox selected by the query. An inbox
+// that has never had an item is empty.
+func (d *Database) GetInboxPage(c context.Context, inboxIRI *url.URL, q pub.BoxPageQuery) (page pub.BoxPage, err error) {
+	return d.getPage(inboxIRI, q)
+}
+
+// Owns returns true if the IRI is on the Database's host and a value is stored
+// for it. Federated values stored for the IRIs of other hosts are not owned.
+func (d *Database) Owns(c context.Context, id *url.URL) (owns bool, err error) {
+	if id.Host != d.host {
+		return false, nil
+	}
+	return d.Exists(c, id)
+}
+
+// ActorForOutbox returns the id of the actor whose 'outbox' is the IRI, as
+// recorded when the actor was created or updated.
+func (d *Database) ActorForOutbox(c context.Context, outboxIRI *url.URL) (actorIRI *url.URL, err error) {
+	return d.actorForBox(outboxesDir, outboxIRI)
+}
+
+// ActorForInbox returns the id of the actor whose 'inbox' is the IRI, as
+// recorded when the actor was created or updated.
+func (d *Database) ActorForInbox(c context.Context, inboxIRI *url.URL) (actorIRI *url.URL, err error) {
+	return d.actorForBox(inboxesDir, inboxIRI)
+}
+
+// Exists returns true if a value is stored for the id, whether it is owned or
+// a copy of a federated value.
+func (d *Database) Exists(c context.Context, id *url.URL) (exists bool, err error) {
+	_, err = os.Stat(d.objectPath(id))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get returns the value stored for the id, or an error if there is none.
+func (d *Database) Get(c context.Context, id *url.URL) (value vocab.Type, err error) {
+	m, err := d.getJSON(id)
+	if err != nil {
+		return nil, err
+	}
+	return streams.ToType(c, m)
+}
+
+// Create stores a new value. The library may call it more than once for the
+// same value, so an existing value is replaced.
+func (d *Database) Create(c context.Context, asType vocab.Type) error {
+	return d.set(asType)
+}
+
+// Update replaces the value stored with the same id. Blocked collections are
+// stored apart from the other values.
+func (d *Database) Update(c context.Context, asType vocab.Type) error {
+	id, err := pub.GetId(asType)
+	if err != nil {
+		return err
+	}
+	if !d.isBlockedId(id) {
+		return d.set(asType)
+	}
+	m, err := pub.Serialize(asType)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return d.writeFile(d.blockedPath(id), b)
+}
+
+// Delete removes the value stored for the id. Deleting a value that does not
+// exist is not an error.
+func (d *Database) Delete(c context.Context, id *url.URL) error {
+	m, err := d.getJSON(id)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := d.removeBoxes(m, id); err != nil {
+		return err
+	}
+	// The items of the actor's boxes go with it.
+	for _, property := range []string{"inbox", "outbox"} {
+		if box, ok := m[property].(string); ok {
+			if err := os.RemoveAll(filepath.Join(d.root, itemsDir, fileName(box))); err != nil {
+				return err
+			}
+		}
+	}
+	err = os.Remove(d.objectPath(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// PrependOutboxItem adds the id to the front of the outbox.
+func (d *Database) PrependOutboxItem(c context.Context, outboxIRI, item *url.URL) error {
+	return d.prependItem(outboxIRI, item)
+}
+
+// GetOutboxPage returns the page of the outbox selected by the query. An
+// outbox that has never had an item is empty.
+func (d *Database) GetOutboxPage(c context.Context, outboxIRI *url.URL, q pub.BoxPageQuery) (page pub.BoxPage, err error) {
+	return d.getPage(outboxIRI, q)
+}
+
+// NewId returns a new random IRI on the Database's host, such as
+// "https://example.com/note/3f2a...". Random ids need no state to remain
+// unique across restarts.
+func (d *Database) NewId(c context.Context, t vocab.Type) (id *url.URL, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &url.URL{
+		Scheme: "https",
+		Host:   d.host,
+		Path:   fmt.Sprintf("/%s/%s", strings.ToLower(t.GetTypeName()), hex.EncodeToString(b)),
+	}, nil
+}
+
+// Followers returns the Collection that is the actor's 'followers'. The
+// library adds or removes items, then calls Update with it.
+func (d *Database) Followers(c context.Context, actorIRI *url.URL) (followers vocab.ActivityStreamsCollection, err error) {
+	return d.actorCollection(c, actorIRI, "followers")
+}
+
+// Following returns the Collection that is the actor's 'following'.
+func (d *Database) Following(c context.Context, actorIRI *url.URL) (following vocab.ActivityStreamsCollection, err error) {
+	return d.actorCollection(c, actorIRI, "following")
+}
+
+// LocalFollowers returns the actors of the Database's host whose 'following'
+// Collection contains the actor.
+func (d *Database) LocalFollowers(c context.Context, actorIRI *url.URL) (followers []*url.URL, err error) {
+	actors, err := d.localActors()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range actors {
+		following, err := d.Following(c, id)
+		if err != nil {
+			continue
+		}
+		items := following.GetActivityStreamsItems()
+		for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+			if iid, err := pub.ToId(iter); err == nil && iid.String() == actorIRI.String() {
+				followers = append(followers, id)
+				break
+			}
+		}
+	}
+	return
+}
+
+// Liked returns the Collection that is the actor's 'liked'.
+func (d *Database) Liked(c context.Context, actorIRI *url.URL) (liked vocab.ActivityStreamsCollection, err error) {
+	return d.actorCollection(c, actorIRI, "liked")
+}
+
+// Blocked returns the private Collection of the actors blocked by the actor.
+// Its id is the actor's id with the "blocked" fragment, and it is stored apart
+// from the other values, so that it is never served.
+func (d *Database) Blocked(c context.Context, actorIRI *url.URL) (blocked vocab.ActivityStreamsCollection, err error) {
+	id := *actorIRI
+	id.Fragment = blockedFragment
+	b, err := ioutil.ReadFile(d.blockedPath(&id))
+	if os.IsNotExist(err) {
+		blocked = streams.NewActivityStreamsCollection()
+		idProp := streams.NewActivityStreamsIdProperty()
+		idProp.Set(&id)
+		blocked.SetActivityStreamsId(idProp)
+	} else if err != nil {
+		return nil, err
+	} else {
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		t, err := streams.ToType(c, m)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if blocked, ok = t.(vocab.ActivityStreamsCollection); !ok {
+			return nil, fmt.Errorf("blocked of %s is not a Collection", actorIRI)
+		}
+	}
+	// The library expects to be able to add items to the collection.
+	if blocked.GetActivityStreamsItems() == nil {
+		blocked.SetActivityStreamsItems(streams.NewActivityStreamsItemsProperty())
+	}
+	return blocked, nil
+}
+
+// prependItem appends the id to the log of the inbox or outbox, and records
+// that the box contains it.
+func (d *Database) prependItem(boxIRI, item *url.URL) error {
+	dir := d.itemsPath(boxIRI)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, itemsLog), os.O_WRONLY|os.O_APPEND|os.O_CREATE, fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(item.String() + "\n"); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return d.writeFile(filepath.Join(dir, fileName(item.String())), nil)
+}
+
+// getPage reads the log of the inbox or outbox, and returns the page of it
+// selected by the query.
+//
+// The whole log is read for every page, so the cost of serving a page grows
+// with the number of items ever added to the box. This is acceptable for the
+// small servers filedb is meant for; larger ones should index their boxes.
+func (d *Database) getPage(boxIRI *url.URL, q pub.BoxPageQuery) (pub.BoxPage, error) {
+	b, err := ioutil.ReadFile(filepath.Join(d.itemsPath(boxIRI), itemsLog))
+	if os.IsNotExist(err) {
+		return pub.BoxPage{}, nil
+	} else if err != nil {
+		return pub.BoxPage{}, err
+	}
+	lines := strings.Split(string(b), "\n")
+	// The last line is either empty or was partially written by an append
+	// that failed, and is ignored.
+	lines = lines[:len(lines)-1]
+	items := make([]*url.URL, len(lines))
+	for i, line := range lines {
+		item, err := url.Parse(line)
+		if err != nil {
+			return pub.BoxPage{}, err
+		}
+		// Newest first.
+		items[len(lines)-1-i] = item
+	}
+	return pub.PageBoxItems(items, q), nil
+}
+
+// actorCollection returns the Collection whose IRI is the value of the
+// actor's property.
+func (d *Database) actorCollection(c context.Context, actorIRI *url.URL, property string) (vocab.ActivityStreamsCollection, error) {
+	m, err := d.getJSON(actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := m[property].(string)
+	if !ok {
+		return nil, fmt.Errorf("actor %s has no %s IRI", actorIRI, property)
+	}
+	id, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return d.collection(c, id, actorIRI, property)
+}
+
+// collection returns the Collection with the id, which is the one of the actor
+// named by the property.
+func (d *Database) collection(c context.Context, id, actorIRI *url.URL, property string) (vocab.ActivityStreamsCollection, error) {
+	var col vocab.ActivityStreamsCollection
+	if exists, err := d.Exists(c, id); err != nil {
+		return nil, err
+	} else if !exists {
+		// Create the collection the first time it is needed.
+		col = streams.NewActivityStreamsCollection()
+		idProp := streams.NewActivityStreamsIdProperty()
+		idProp.Set(id)
+		col.SetActivityStreamsId(idProp)
+	} else {
+		t, err := d.Get(c, id)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if col, ok = t.(vocab.ActivityStreamsCollection); !ok {
+			return nil, fmt.Errorf("%s of %s is not a Collection", property, actorIRI)
+		}
+	}
+	// The library expects to be able to add items to the collection.
+	if col.GetActivityStreamsItems() == nil {
+		col.SetActivityStreamsItems(streams.NewActivityStreamsItemsProperty())
+	}
+	return col, nil
+}
+
+// localActors returns the ids of the actors of the Database's host that have
+// an inbox, sorted.
+func (d *Database) localActors() ([]*url.URL, error) {
+	files, err := ioutil.ReadDir(filepath.Join(d.root, inboxesDir))
+	if err != nil {
+		return nil, err
+	}
+	var actors []*url.URL
+	for _, f := range files {
+		// Skip the temporary files of writes in progress.
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(d.root, inboxesDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		id, err := url.Parse(string(b))
+		if err != nil {
+			return nil, err
+		}
+		if id.Host == d.host {
+			actors = append(actors, id)
+		}
+	}
+	sort.Slice(actors, func(i, j int) bool {
+		return actors[i].String() < actors[j].String()
+	})
+	return actors, nil
+}
+
+// actorForBox returns the actor recorded for the inbox or outbox.
+func (d *Database) actorForBox(dir string, boxIRI *url.URL) (*url.URL, error) {
+	b, err := ioutil.ReadFile(filepath.Join(d.root, dir, fileName(boxIRI.String())))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no actor for %s", boxIRI)
+	} else if err != nil {
+		return nil, err
+	}
+	return url.Parse(string(b))
+}
+
+// getJSON returns the JSON value stored for the id. The error satisfies
+// os.IsNotExist if there is none.
+func (d *Database) getJSON(id *url.URL) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(d.objectPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// set stores the value as JSON, keyed by its id, and records which local actor
+// its inbox and outbox belong to.
+func (d *Database) set(t vocab.Type) error {
+	id, err := pub.GetId(t)
+	if err != nil {
+		return err
+	}
+	m, err := pub.Serialize(t)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// Boxes the value no longer has must not resolve to it.
+	if old, err := d.getJSON(id); err == nil {
+		if err := d.removeBoxes(old, id); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for dir, property := range map[string]string{inboxesDir: "inbox", outboxesDir: "outbox"} {
+		if box, ok := d.localBox(m, id, property); ok {
+			if err := d.writeFile(filepath.Join(d.root, dir, fileName(box)), []byte(id.String())); err != nil {
+				return err
+			}
+		}
+	}
+	return d.writeFile(d.objectPath(id), b)
+}
+
+// localBox returns the IRI of the inbox or outbox named by the property of the
+// stored value, if both the value and the box are on the Database's host.
+// Federated values must not make local boxes resolve to them.
+func (d *Database) localBox(m map[string]interface{}, id *url.URL, property string) (string, bool) {
+	if id.Host != d.host {
+		return "", false
+	}
+	box, ok := m[property].(string)
+	if !ok {
+		return "", false
+	}
+	boxIRI, err := url.Parse(box)
+	if err != nil || boxIRI.Host != d.host {
+		return "", false
+	}
+	return box, true
+}
+
+// removeBoxes removes the records of the inbox and outbox of the stored value.
+func (d *Database) removeBoxes(m map[string]interface{}, id *url.URL) error {
+	for dir, property := range map[string]string{inboxesDir: "inbox", outboxesDir: "outbox"} {
+		if box, ok := d.localBox(m, id, property); ok {
+			err := os.Remove(filepath.Join(d.root, dir, fileName(box)))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile atomically replaces the file with the contents, by writing them to
+// a temporary file first.
+func (d *Database) writeFile(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), fileMode)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// objectPath returns the path of the file storing the value with the id.
+func (d *Database) objectPath(id *url.URL) string {
+	return filepath.Join(d.root, objectsDir, fileName(id.String())+".json")
+}
+
+// isBlockedId determines whether the id is of the blocked collection of a local
+// actor.
+func (d *Database) isBlockedId(id *url.URL) bool {
+	return id.Host == d.host && id.Fragment == blockedFragment
+}
+
+// blockedPath returns the path of the file storing the blocked collection with
+// the id.
+func (d *Database) blockedPath(id *url.URL) string {
+	return filepath.Join(d.root, blockedDir, fileName(id.String())+".json")
+}
+
+// itemsPath returns the path of the directory storing the items of the inbox
+// or outbox.
+func (d *Database) itemsPath(boxIRI *url.URL) string {
+	return filepath.Join(d.root, itemsDir, fileName(boxIRI.String()))
+}
+
+// fileName returns the name of the file storing the data of the IRI. IRIs are
+// hashed, as they may be longer than a file name or contain any character.
+func fileName(iri string) string {
+	h := sha256.Sum256([]byte(iri))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,264 @@
+package filedb
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+const testHost = "example.com"
+
+// mustParse parses the IRI or panics.
+func mustParse(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// setupFn returns a Database in a new temporary directory, and a function
+// removing the directory.
+func setupFn(t *testing.T) (root string, db *Database, teardown func()) {
+	root, err := ioutil.TempDir("", "filedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDatabase(root, testHost)
+	if err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+	return root, db, func() { os.RemoveAll(root) }
+}
+
+// createPerson stores a Person with an inbox, outbox, and followers.
+func createPerson(t *testing.T, db *Database) {
+	p := streams.NewActivityStreamsPerson()
+	id := streams.NewActivityStreamsIdProperty()
+	id.Set(mustParse("https://example.com/users/alex"))
+	p.SetActivityStreamsId(id)
+	inbox := streams.NewActivityStreamsInboxProperty()
+	inbox.SetIRI(mustParse("https://example.com/users/alex/inbox"))
+	p.SetActivityStreamsInbox(inbox)
+	outbox := streams.NewActivityStreamsOutboxProperty()
+	outbox.SetIRI(mustParse("https://example.com/users/alex/outbox"))
+	p.SetActivityStreamsOutbox(outbox)
+	followers := streams.NewActivityStreamsFollowersProperty()
+	followers.SetIRI(mustParse("https://example.com/users/alex/followers"))
+	p.SetActivityStreamsFollowers(followers)
+	if err := db.Create(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDatabase ensures values are stored and survive restarts.
+func TestDatabase(t *testing.T) {
+	ctx := context.Background()
+	actorIRI := mustParse("https://example.com/users/alex")
+	inboxIRI := mustParse("https://example.com/users/alex/inbox")
+	t.Run("SurvivesRestart", func(t *testing.T) {
+		// Setup
+		root, db, teardown := setupFn(t)
+		defer teardown()
+		createPerson(t, db)
+		followers, err := db.Followers(ctx, actorIRI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		followers.GetActivityStreamsItems().AppendIRI(mustParse("https://other.example.com/users/sam"))
+		if err := db.Update(ctx, followers); err != nil {
+			t.Fatal(err)
+		}
+		// Run
+		db, err = NewDatabase(root, testHost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Verify
+		if a, err := db.ActorForInbox(ctx, inboxIRI); err != nil {
+			t.Fatal(err)
+		} else if a.String() != actorIRI.String() {
+			t.Fatalf("got actor %s, want %s", a, actorIRI)
+		}
+		followers, err = db.Followers(ctx, actorIRI)
+		if err != nil {
+			t.Fatal(err)
+		} else if n := followers.GetActivityStreamsItems().Len(); n != 1 {
+			t.Fatalf("got %d followers, want 1", n)
+		}
+		if local, err := db.LocalFollowers(ctx, mustParse("https://other.example.com/users/sam")); err != nil {
+			t.Fatal(err)
+		} else if len(local) != 0 {
+			t.Fatalf("got %d local followers, want 0", len(local))
+		}
+	})
+	t.Run("InboxContainsPrependedItems", func(t *testing.T) {
+		// Setup
+		_, db, teardown := setupFn(t)
+		defer teardown()
+		activityIRI := mustParse("https://other.example.com/activities/1")
+		// Run
+		err := db.PrependInboxItem(ctx, inboxIRI, activityIRI)
+		// Verify
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contains, err := db.InboxContains(ctx, inboxIRI, activityIRI); err != nil {
+			t.Fatal(err)
+		} else if !contains {
+			t.Fatal("inbox does not contain the prepended activity")
+		}
+	})
+	t.Run("DeleteRemovesActorBoxes", func(t *testing.T) {
+		// Setup
+		_, db, teardown := setupFn(t)
+		defer teardown()
+		createPerson(t, db)
+		// Run
+		err := db.Delete(ctx, actorIRI)
+		// Verify
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := db.Exists(ctx, actorIRI); err != nil || exists {
+			t.Fatalf("got exists=%v err=%v after delete", exists, err)
+		}
+		if _, err := db.ActorForInbox(ctx, inboxIRI); err == nil {
+			t.Fatal("inbox still resolves to the deleted actor")
+		}
+	})
+	t.Run("FederatedValueDoesNotClaimBoxes", func(t *testing.T) {
+		// Setup
+		_, db, teardown := setupFn(t)
+		defer teardown()
+		createPerson(t, db)
+		p := streams.NewActivityStreamsPerson()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(mustParse("https://other.example.com/users/sam"))
+		p.SetActivityStreamsId(id)
+		inbox := streams.NewActivityStreamsInboxProperty()
+		inbox.SetIRI(inboxIRI)
+		p.SetActivityStreamsInbox(inbox)
+		outbox := streams.NewActivityStreamsOutboxProperty()
+		outbox.SetIRI(mustParse("https://other.example.com/users/sam/outbox"))
+		p.SetActivityStreamsOutbox(outbox)
+		// Run
+		err := db.Create(ctx, p)
+		// Verify
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := db.ActorForInbox(ctx, inboxIRI); err != nil || got.String() != actorIRI.String() {
+			t.Fatalf("got actor %v err=%v for the local inbox", got, err)
+		}
+		if _, err := db.ActorForOutbox(ctx, mustParse("https://other.example.com/users/sam/outbox")); err == nil {
+			t.Fatal("federated outbox resolves to an actor")
+		}
+	})
+	t.Run("BlockedIsPrivate", func(t *testing.T) {
+		// Setup
+		root, db, teardown := setupFn(t)
+		defer teardown()
+		createPerson(t, db)
+		blocked, err := db.Blocked(ctx, actorIRI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocked.GetActivityStreamsItems().AppendIRI(mustParse("https://other.example.com/users/sam"))
+		// Run
+		err = db.Update(ctx, blocked)
+		// Verify
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, iri := range []string{"https://example.com/users/alex/blocked", "https://example.com/users/alex#blocked"} {
+			if exists, err := db.Exists(ctx, mustParse(iri)); err != nil || exists {
+				t.Fatalf("got exists=%v err=%v for %s", exists, err, iri)
+			}
+		}
+		db, err = NewDatabase(root, testHost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocked, err = db.Blocked(ctx, actorIRI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := blocked.GetActivityStreamsItems().Len(); n != 1 {
+			t.Fatalf("got %d blocked actors after restart, want 1", n)
+		}
+	})
+	t.Run("NewIdIsOwnedOnceCreated", func(t *testing.T) {
+		// Setup
+		_, db, teardown := setupFn(t)
+		defer teardown()
+		// Run
+		id1, err1 := db.NewId(ctx, streams.NewActivityStreamsNote())
+		id2, err2 := db.NewId(ctx, streams.NewActivityStreamsNote())
+		// Verify
+		if err1 != nil || err2 != nil {
+			t.Fatal(err1, err2)
+		}
+		if id1.String() == id2.String() {
+			t.Fatalf("got the same id twice: %s", id1)
+		}
+		if owns, err := db.Owns(ctx, id1); err != nil || owns {
+			t.Fatalf("owns new id %s before a value is created for it", id1)
+		}
+		n := streams.NewActivityStreamsNote()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(id1)
+		n.SetActivityStreamsId(id)
+		if err := db.Create(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		if owns, err := db.Owns(ctx, id1); err != nil || !owns {
+			t.Fatalf("does not own created id %s", id1)
+		}
+	})
+	t.Run("FederatedValueIsNotOwned", func(t *testing.T) {
+		// Setup
+		_, db, teardown := setupFn(t)
+		defer teardown()
+		n := streams.NewActivityStreamsNote()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(mustParse("https://other.example.com/note/1"))
+		n.SetActivityStreamsId(id)
+		if err := db.Create(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		// Run
+		owns, err := db.Owns(ctx, mustParse("https://other.example.com/note/1"))
+		// Verify
+		if err != nil || owns {
+			t.Fatalf("got owns=%v err=%v for a federated value", owns, err)
+		}
+	})
+	t.Run("LockIsExclusive", func(t *testing.T) {
+		// Setup
+		_, db, teardown := setupFn(t)
+		defer teardown()
+		if err := db.Lock(ctx, actorIRI); err != nil {
+			t.Fatal(err)
+		}
+		tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		// Run
+		err := db.Lock(tctx, actorIRI)
+		// Verify
+		if err == nil {
+			t.Fatal("took a lock that is already held")
+		}
+		if err := db.Unlock(ctx, actorIRI); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Lock(ctx, actorIRI); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/url"
+)
+
+// PendingFollowStore saves Follow requests that are awaiting an answer.
+//
+// It is used both for the Follow requests received by actors that manually
+// approve their followers, until the application approves or rejects them, and
+// for the Follow requests sent by actors, until the followed actors accept or
+// reject them. Applications use a separate store for each.
+//
+// Follow requests are keyed by the IRI of the actor of this application and the
+// id of the Follow.
+type PendingFollowStore interface {
+	// AddPendingFollow saves a Follow request for the actor.
+	AddPendingFollow(c context.Context, actorIRI *url.URL, follow vocab.ActivityStreamsFollow) error
+	// GetPendingFollow returns the pending Follow request with the id for
+	// the actor, or nil if there is none.
+	GetPendingFollow(c context.Context, actorIRI, followIRI *url.URL) (vocab.ActivityStreamsFollow, error)
+	// PendingFollows returns the pending Follow requests for the actor, so
+	// they can be surfaced to the user.
+	PendingFollows(c context.Context, actorIRI *url.URL) ([]vocab.ActivityStreamsFollow, error)
+	// RemovePendingFollow removes the pending Follow request with the id
+	// for the actor.
+	RemovePendingFollow(c context.Context, actorIRI, followIRI *url.URL) error
+}
+
+// ApproveFollow accepts the pending Follow request with the id, on behalf of
+// the actor owning the outbox.
+//
+// The actors of the Follow are added to the 'followers' of the actor, and an
+// Accept of the Follow is sent by the actor, as if posted to its outbox. The
+// Follow is then removed from the PendingFollowStore.
+func ApproveFollow(c context.Context,
+	actor FederatingActor,
+	db Database,
+	pending PendingFollowStore,
+	outboxIRI, followIRI *url.URL) error {
+	return decideFollow(c, actor, db, pending, outboxIRI, followIRI, true)
+}
+
+// RejectFollow rejects the pending Follow request with the id, on behalf of the
+// actor owning the outbox.
+//
+// A Reject of the Follow is sent by the actor, as if posted to its outbox. The
+// Follow is then removed from the PendingFollowStore.
+func RejectFollow(c context.Context,
+	actor FederatingActor,
+	db Database,
+	pending PendingFollowStore,
+	outboxIRI, followIRI *url.URL) error {
+	return decideFollow(c, actor, db, pending, outboxIRI, followIRI, false)
+}
+
+// decideFollow accepts or rejects a pending Follow request.
+func decideFollow(c context.Context,
+	actor FederatingActor,
+	db Database,
+	pending PendingFollowStore,
+	outboxIRI, followIRI *url.URL,
+	accept bool) error {
+	if err := db.Lock(c, outboxIRI); err != nil {
+		return err
+	}
+	// WARNING: Unlock not deferred.
+	actorIRI, err := db.ActorForOutbox(c, outboxIRI)
+	db.Unlock(c, outboxIRI)
+	if err != nil {
+		return err
+	}
+	// Unlock must be called by now and every branch above.
+	follow, err := pending.GetPendingFollow(c, actorIRI, followIRI)
+	if err != nil {
+		return err
+	} else if follow == nil {
+		return fmt.Errorf("no pending follow request %s for %s", followIRI, actorIRI)
+	}
+	send := func(c context.Context, response Activity) error {
+		_, err := actor.Send(c, outboxIRI, response)
+		return err
+	}
+	if err := respondToFollow(c, db, actorIRI, follow, accept, send); err != nil {
+		return err
+	}
+	return pending.RemovePendingFollow(c, actorIRI, followIRI)
+}
+
+// respondToFollow sends an Accept or Reject of the Follow from the actor to the
+// actors of the Follow. When accepting, they are also added to the 'followers'
+// of the actor.
+func respondToFollow(c context.Context,
+	db Database,
+	actorIRI *url.URL,
+	follow vocab.ActivityStreamsFollow,
+	accept bool,
+	send func(c context.Context, response Activity) error) error {
+	// Prepare the response.
+	var response interface {
+		Activity
+		attributedToer
+	}
+	if accept {
+		response = streams.NewActivityStreamsAccept()
+	} else {
+		response = streams.NewActivityStreamsReject()
+	}
+	// Set us as the 'actor' and 'attributedTo'.
+	me := streams.NewActivityStreamsActorProperty()
+	response.SetActivityStreamsActor(me)
+	me.AppendIRI(actorIRI)
+	attrTo := streams.NewActivityStreamsAttributedToProperty()
+	response.SetActivityStreamsAttributedTo(attrTo)
+	attrTo.AppendIRI(actorIRI)
+	// Set the Follow as the 'object' property.
+	op := streams.NewActivityStreamsObjectProperty()
+	response.SetActivityStreamsObject(op)
+	op.AppendActivityStreamsFollow(follow)
+	// Add all actors on the original Follow to the 'to' property.
+	recipients := make([]*url.URL, 0)
+	to := streams.NewActivityStreamsToProperty()
+	response.SetActivityStreamsTo(to)
+	followActors := follow.GetActivityStreamsActor()
+	for iter := followActors.Begin(); iter != followActors.End(); iter = iter.Next() {
+		id, err := ToId(iter)
+		if err != nil {
+			return err
+		}
+		to.AppendIRI(id)
+		recipients = append(recipients, id)
+	}
+	if accept {
+		// If accepting, then also update our followers collection
+		// with the new actors.
+		//
+		// If rejecting, do not update the followers collection.
+		if err := db.Lock(c, actorIRI); err != nil {
+			return err
+		}
+		// WARNING: Unlock not deferred.
+		followers, err := db.Followers(c, actorIRI)
+		if err != nil {
+			db.Unlock(c, actorIRI)
+			return err
+		}
+		items := followers.GetActivityStreamsItems()
+		if items == nil {
+			items = streams.NewActivityStreamsItemsProperty()
+			followers.SetActivityStreamsItems(items)
+		}
+		for _, elem := range recipients {
+			items.PrependIRI(elem)
+		}
+		if err = db.Update(c, followers); err != nil {
+			db.Unlock(c, actorIRI)
+			return err
+		}
+		db.Unlock(c, actorIRI)
+		// Unlock must be called by now and every branch above.
+	}
+	return send(c, response)
+}
+
+// isFollow determines whether the value is a Follow or extends from it.
+func isFollow(t vocab.Type) bool {
+	return t.GetTypeName() == streams.ActivityStreamsFollowName || streams.ActivityStreamsFollowIsExtendedBy(t)
+}
+
+// mustBeFollowedBy ensures that the actors answering a Follow are all objects
+// of it.
+func mustBeFollowedBy(follow vocab.ActivityStreamsFollow, actors vocab.ActivityStreamsActorProperty) error {
+	followed, err := objectIds(follow.GetActivityStreamsObject())
+	if err != nil {
+		return err
+	}
+	answering, err := actorIds(actors)
+	if err != nil {
+		return err
+	}
+	if len(answering) == 0 {
+		return fmt.Errorf("an answer to a Follow has no actors")
+	}
+	for id := range answering {
+		if !followed[id] {
+			return fmt.Errorf("an answer to a Follow is not from the followed actor")
+		}
+	}
+	return nil
+}
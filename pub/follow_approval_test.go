@@ -0,0 +1,392 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/golang/mock/gomock"
+	"net/url"
+	"testing"
+)
+
+// TestFollowApproval ensures Follow requests are held until they are approved
+// or rejected by the application.
+func TestFollowApproval(t *testing.T) {
+	ctx := context.Background()
+	me := mustParse("https://example.com/addison")
+	inboxIRI := mustParse(testMyInboxIRI)
+	outboxIRI := mustParse(testMyOutboxIRI)
+	peer := mustParse(testFederatedActorIRI)
+	followIRI := mustParse(testFederatedActivityIRI)
+	newFollow := func() vocab.ActivityStreamsFollow {
+		follow := streams.NewActivityStreamsFollow()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(followIRI)
+		follow.SetActivityStreamsId(id)
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(peer)
+		follow.SetActivityStreamsActor(actor)
+		op := streams.NewActivityStreamsObjectProperty()
+		op.AppendIRI(me)
+		follow.SetActivityStreamsObject(op)
+		return follow
+	}
+	typeName := func(v vocab.Type) string {
+		if v == nil {
+			return ""
+		}
+		return v.GetTypeName()
+	}
+	t.Run("ManualApprovalStoresPendingFollow", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		pending := NewMemoryPendingFollowStore()
+		w := FederatingWrappedCallbacks{
+			OnFollow:       OnFollowManualApproval,
+			PendingFollows: pending,
+			db:             db,
+			inboxIRI:       inboxIRI,
+		}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+		)
+		// Run
+		err := w.follow(ctx, newFollow())
+		// Verify
+		assertEqual(t, err, nil)
+		follows, err := pending.PendingFollows(ctx, me)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(follows), 1)
+		id, err := GetId(follows[0])
+		assertEqual(t, err, nil)
+		assertEqual(t, id.String(), testFederatedActivityIRI)
+	})
+	t.Run("ManualApprovalRequiresStore", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		w := FederatingWrappedCallbacks{
+			OnFollow: OnFollowManualApproval,
+			db:       db,
+			inboxIRI: inboxIRI,
+		}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+		)
+		// Run
+		err := w.follow(ctx, newFollow())
+		// Verify
+		assertNotEqual(t, err, nil)
+	})
+	t.Run("AutomaticAcceptSendsFromInbox", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		followers := streams.NewActivityStreamsCollection()
+		var sentInboxIRI *url.URL
+		var sent Activity
+		w := FederatingWrappedCallbacks{
+			OnFollow: OnFollowAutomaticallyAccept,
+			db:       db,
+			inboxIRI: inboxIRI,
+			send: func(c context.Context, inboxIRI *url.URL, activity Activity) error {
+				sentInboxIRI = inboxIRI
+				sent = activity
+				return nil
+			},
+		}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Followers(ctx, me).Return(followers, nil),
+			db.EXPECT().Update(ctx, followers).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := w.follow(ctx, newFollow())
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, sentInboxIRI, inboxIRI)
+		assertEqual(t, typeName(sent), "Accept")
+		js, err := Serialize(sent)
+		assertEqual(t, err, nil)
+		assertEqual(t, js["to"], testFederatedActorIRI)
+		assertEqual(t, js["attributedTo"], "https://example.com/addison")
+		assertEqual(t, followers.GetActivityStreamsItems().Len(), 1)
+	})
+	t.Run("ApproveFollowAddsFollowerAndSendsAccept", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		pending := NewMemoryPendingFollowStore()
+		pending.AddPendingFollow(ctx, me, newFollow())
+		followers := streams.NewActivityStreamsCollection()
+		sender := &sendRecorder{}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Followers(ctx, me).Return(followers, nil),
+			db.EXPECT().Update(ctx, followers).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := ApproveFollow(ctx, sender, db, pending, outboxIRI, followIRI)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, sender.outboxIRI, outboxIRI)
+		assertEqual(t, typeName(sender.sent), "Accept")
+		items := followers.GetActivityStreamsItems()
+		assertEqual(t, items.Len(), 1)
+		assertEqual(t, items.At(0).GetIRI().String(), testFederatedActorIRI)
+		follows, err := pending.PendingFollows(ctx, me)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(follows), 0)
+	})
+	t.Run("RejectFollowSendsReject", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		pending := NewMemoryPendingFollowStore()
+		pending.AddPendingFollow(ctx, me, newFollow())
+		sender := &sendRecorder{}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+		)
+		// Run
+		err := RejectFollow(ctx, sender, db, pending, outboxIRI, followIRI)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, typeName(sender.sent), "Reject")
+		follows, err := pending.PendingFollows(ctx, me)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(follows), 0)
+	})
+	t.Run("ApproveUnknownFollowErrors", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		sender := &sendRecorder{}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+		)
+		// Run
+		err := ApproveFollow(ctx, sender, db, NewMemoryPendingFollowStore(), outboxIRI, followIRI)
+		// Verify
+		assertNotEqual(t, err, nil)
+		assertEqual(t, sender.sent, nil)
+	})
+	t.Run("SendFromInboxRunsOutboxSideEffects", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		sp := NewMockSocialProtocol(ctl)
+		a := &sideEffectActor{common: NewMockCommonBehavior(ctl), db: db, c2s: sp}
+		self := streams.NewActivityStreamsPerson()
+		outbox := streams.NewActivityStreamsOutboxProperty()
+		outbox.SetIRI(outboxIRI)
+		self.SetActivityStreamsOutbox(outbox)
+		accept := streams.NewActivityStreamsAccept()
+		acceptIRI := mustParse(testNewActivityIRI)
+		var posted vocab.ActivityStreamsAccept
+		other := []interface{}{
+			func(c context.Context, a vocab.ActivityStreamsAccept) error {
+				posted = a
+				return nil
+			},
+		}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Get(ctx, me).Return(self, nil),
+			db.EXPECT().Unlock(ctx, me),
+			db.EXPECT().NewId(ctx, accept).Return(acceptIRI, nil),
+			sp.EXPECT().Callbacks(ctx).Return(SocialWrappedCallbacks{}, other),
+			db.EXPECT().Lock(ctx, acceptIRI),
+			db.EXPECT().Create(ctx, accept),
+			db.EXPECT().Unlock(ctx, acceptIRI),
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().PrependOutboxItem(ctx, outboxIRI, acceptIRI),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+		)
+		// Run
+		err := a.sendFromInbox(ctx, inboxIRI, accept)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, posted, accept)
+	})
+}
+
+// TestOutgoingFollows ensures sent Follows are kept as pending until they are
+// accepted or rejected.
+func TestOutgoingFollows(t *testing.T) {
+	ctx := context.Background()
+	me := mustParse("https://example.com/addison")
+	inboxIRI := mustParse(testMyInboxIRI)
+	outboxIRI := mustParse(testMyOutboxIRI)
+	peer := mustParse(testFederatedActorIRI)
+	followIRI := mustParse(testNewActivityIRI)
+	newFollow := func() vocab.ActivityStreamsFollow {
+		follow := streams.NewActivityStreamsFollow()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(followIRI)
+		follow.SetActivityStreamsId(id)
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(me)
+		follow.SetActivityStreamsActor(actor)
+		op := streams.NewActivityStreamsObjectProperty()
+		op.AppendIRI(peer)
+		follow.SetActivityStreamsObject(op)
+		return follow
+	}
+	answer := func(actor *url.URL) (vocab.ActivityStreamsActorProperty, vocab.ActivityStreamsObjectProperty) {
+		ap := streams.NewActivityStreamsActorProperty()
+		ap.AppendIRI(actor)
+		op := streams.NewActivityStreamsObjectProperty()
+		op.AppendIRI(followIRI)
+		return ap, op
+	}
+	t.Run("SocialFollowIsPending", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		pending := NewMemoryPendingFollowStore()
+		deliverable := false
+		w := SocialWrappedCallbacks{
+			OutgoingFollows: pending,
+			db:              db,
+			outboxIRI:       outboxIRI,
+			deliverable:     &deliverable,
+		}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+		)
+		// Run
+		err := w.follow(ctx, newFollow())
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, deliverable, true)
+		follows, err := pending.PendingFollows(ctx, me)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(follows), 1)
+	})
+	t.Run("AcceptUsesPendingFollow", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		pending := NewMemoryPendingFollowStore()
+		pending.AddPendingFollow(ctx, me, newFollow())
+		following := streams.NewActivityStreamsCollection()
+		w := FederatingWrappedCallbacks{
+			OutgoingFollows: pending,
+			db:              db,
+			inboxIRI:        inboxIRI,
+		}
+		accept := streams.NewActivityStreamsAccept()
+		ap, op := answer(peer)
+		accept.SetActivityStreamsActor(ap)
+		accept.SetActivityStreamsObject(op)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Following(ctx, me).Return(following, nil),
+			db.EXPECT().Update(ctx, following).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := w.accept(ctx, accept)
+		// Verify
+		assertEqual(t, err, nil)
+		items := following.GetActivityStreamsItems()
+		assertEqual(t, items.Len(), 1)
+		assertEqual(t, items.At(0).GetIRI().String(), testFederatedActorIRI)
+		follows, err := pending.PendingFollows(ctx, me)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(follows), 0)
+	})
+	t.Run("AcceptFromOtherActorErrors", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		pending := NewMemoryPendingFollowStore()
+		pending.AddPendingFollow(ctx, me, newFollow())
+		w := FederatingWrappedCallbacks{
+			OutgoingFollows: pending,
+			db:              db,
+			inboxIRI:        inboxIRI,
+		}
+		accept := streams.NewActivityStreamsAccept()
+		ap, op := answer(mustParse(testFederatedActorIRI2))
+		accept.SetActivityStreamsActor(ap)
+		accept.SetActivityStreamsObject(op)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+		)
+		// Run
+		err := w.accept(ctx, accept)
+		// Verify
+		assertNotEqual(t, err, nil)
+		follows, err := pending.PendingFollows(ctx, me)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(follows), 1)
+	})
+	t.Run("RejectDropsPendingFollow", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		pending := NewMemoryPendingFollowStore()
+		pending.AddPendingFollow(ctx, me, newFollow())
+		w := FederatingWrappedCallbacks{
+			OutgoingFollows: pending,
+			db:              db,
+			inboxIRI:        inboxIRI,
+		}
+		reject := streams.NewActivityStreamsReject()
+		ap, op := answer(peer)
+		reject.SetActivityStreamsActor(ap)
+		reject.SetActivityStreamsObject(op)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+		)
+		// Run
+		err := w.reject(ctx, reject)
+		// Verify
+		assertEqual(t, err, nil)
+		follows, err := pending.PendingFollows(ctx, me)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(follows), 0)
+	})
+}
@@ -3,9 +3,9 @@ package pub
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/go-fed/activity/streams"
 	"net/http"
+	"net/url"
 )
 
 // HandlerFunc determines whether an incoming HTTP request is an ActivityStreams
@@ -44,13 +44,44 @@ type AuthenticateFunc func(c context.Context, w http.ResponseWriter, r *http.Req
 // Strips retrieved ActivityStreams values of sensitive fields ('bto' and 'bcc')
 // before responding with them. Sets the appropriate HTTP status code for
 // Tombstone Activities as well.
+//
+// The response is served as 'application/activity+json' or
+// 'application/ld+json' as preferred by the Accept header. Conditional
+// requests using the ETag, or the Last-Modified time taken from the value's
+// 'updated' or 'published' property, are answered with
+// http.StatusNotModified.
 func NewActivityStreamsHandler(authFn AuthenticateFunc, db Database, clock Clock) HandlerFunc {
+	return NewActivityStreamsHandlerWithOptions(authFn, db, clock, ActivityStreamsHandlerOptions{})
+}
+
+// ActivityStreamsHandlerOptions are the optional policies applied by a
+// HandlerFunc created with NewActivityStreamsHandlerWithOptions. The zero value
+// applies none.
+type ActivityStreamsHandlerOptions struct {
+	// Visibility, if not nil, only serves values the requester may see.
+	// Others are answered with http.StatusNotFound, or
+	// http.StatusForbidden if the policy reveals them.
+	Visibility *VisibilityPolicy
+	// Instance, if not nil, answers requests signed with a key on a
+	// rejected domain with http.StatusForbidden, before authenticating
+	// them.
+	Instance *InstancePolicy
+}
+
+// NewActivityStreamsHandlerWithOptions creates a HandlerFunc like
+// NewActivityStreamsHandler, which also applies the policies of the options.
+func NewActivityStreamsHandlerWithOptions(authFn AuthenticateFunc, db Database, clock Clock, opts ActivityStreamsHandlerOptions) HandlerFunc {
 	return func(c context.Context, w http.ResponseWriter, r *http.Request) (isASRequest bool, err error) {
 		// Do nothing if it is not an ActivityPub GET request
 		if !isActivityPubGet(r) {
 			return
 		}
 		isASRequest = true
+		// Refuse requesters on rejected domains
+		if opts.Instance != nil && opts.Instance.RejectsRequest(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 		// Authenticate the request
 		var shouldReturn bool
 		if shouldReturn, err = authFn(c, w, r); err != nil {
@@ -74,6 +105,20 @@ func NewActivityStreamsHandler(authFn AuthenticateFunc, db Database, clock Clock
 		// Unlock must have been called by this point and in every
 		// branch above
 		//
+		// Hide the value from requesters it is not addressed to.
+		if opts.Visibility != nil {
+			var requester *url.URL
+			if requester, err = opts.Visibility.Requester(c, r); err != nil {
+				return
+			}
+			var visible bool
+			if visible, err = opts.Visibility.IsVisible(c, requester, t); err != nil {
+				return
+			} else if !visible {
+				w.WriteHeader(opts.Visibility.hiddenStatus())
+				return
+			}
+		}
 		// Remove sensitive fields.
 		clearSensitiveFields(t)
 		// Serialize the fetched value.
@@ -85,21 +130,12 @@ func NewActivityStreamsHandler(authFn AuthenticateFunc, db Database, clock Clock
 		if err != nil {
 			return
 		}
-		// Construct the response.
-		addResponseHeaders(w.Header(), clock, raw)
 		// Write the response.
+		status := http.StatusOK
 		if streams.ActivityStreamsTombstoneIsExtendedBy(t) {
-			w.WriteHeader(http.StatusGone)
-		} else {
-			w.WriteHeader(http.StatusOK)
-		}
-		n, err := w.Write(raw)
-		if err != nil {
-			return
-		} else if n != len(raw) {
-			err = fmt.Errorf("only wrote %d of %d bytes", n, len(raw))
-			return
+			status = http.StatusGone
 		}
+		err = writeResponse(w, r, clock, raw, status, lastModified(t))
 		return
 	}
 }
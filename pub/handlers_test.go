@@ -0,0 +1,159 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestNewActivityStreamsHandler ensures values are served with negotiated
+// content types and that conditional requests are answered.
+func TestNewActivityStreamsHandler(t *testing.T) {
+	ctx := context.Background()
+	noteIRI := mustParse(testNoteId1)
+	updated := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	setupFn := func(ctl *gomock.Controller) (db *MockDatabase, clock *MockClock, h HandlerFunc) {
+		db = NewMockDatabase(ctl)
+		clock = NewMockClock(ctl)
+		h = NewActivityStreamsHandler(func(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+			return false, nil
+		}, db, clock)
+		clock.EXPECT().Now().Return(now()).AnyTimes()
+		db.EXPECT().Lock(ctx, noteIRI)
+		db.EXPECT().Get(ctx, noteIRI).Return(note(noteIRI, updated), nil)
+		db.EXPECT().Unlock(ctx, noteIRI)
+		return
+	}
+	request := func(accept string) *http.Request {
+		req := httptest.NewRequest("GET", testNoteId1, nil)
+		req.Header.Set(acceptHeader, accept)
+		return req
+	}
+	t.Run("ServesActivityJSONWhenPreferred", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, _, h := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := request("application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\"; q=0.9, application/activity+json")
+		// Run
+		handled, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusOK)
+		assertEqual(t, resp.Header().Get(contentTypeHeader), "application/activity+json")
+		assertEqual(t, resp.Header().Get(varyHeader), acceptHeader)
+		assertEqual(t, resp.Header().Get(lastModifiedHeader), "Thu, 02 Jan 2020 03:04:05 GMT")
+		assertNotEqual(t, len(resp.Header().Get(etagHeader)), 0)
+	})
+	t.Run("ServesLDJSONWhenPreferred", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, _, h := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := request("application/activity+json; q=0.5, application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\"")
+		// Run
+		_, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, resp.Header().Get(contentTypeHeader), contentTypeHeaderValue)
+	})
+	t.Run("AnswersIfNoneMatch", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, _, h := setupFn(ctl)
+		first := httptest.NewRecorder()
+		if _, err := h(ctx, first, request(activityJSONMediaType)); err != nil {
+			t.Fatal(err)
+		}
+		_, _, h = setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := request(activityJSONMediaType)
+		req.Header.Set(ifNoneMatchHeader, "W/"+first.Header().Get(etagHeader))
+		// Run
+		handled, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusNotModified)
+		assertEqual(t, resp.Body.Len(), 0)
+		assertEqual(t, resp.Header().Get(etagHeader), first.Header().Get(etagHeader))
+	})
+	t.Run("AnswersIfModifiedSince", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, _, h := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := request(activityJSONMediaType)
+		req.Header.Set(ifModifiedSinceHeader, updated.Add(time.Minute).Format(http.TimeFormat))
+		// Run
+		_, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, resp.Code, http.StatusNotModified)
+	})
+	t.Run("ServesModifiedValue", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, _, h := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := request(activityJSONMediaType)
+		req.Header.Set(ifModifiedSinceHeader, updated.Add(-time.Minute).Format(http.TimeFormat))
+		req.Header.Set(ifNoneMatchHeader, "\"stale\"")
+		// Run
+		_, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, resp.Code, http.StatusOK)
+		assertNotEqual(t, resp.Body.Len(), 0)
+	})
+	t.Run("RefusesRequesterOnRejectedDomain", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		clock := NewMockClock(ctl)
+		p, err := NewInstancePolicy(InstancePolicyConfig{
+			Rules: []DomainRule{{Domain: "*.example.com", Severity: DomainReject}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewActivityStreamsHandlerWithOptions(func(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+			t.Fatal("rejected requests must not be authenticated")
+			return false, nil
+		}, db, clock, ActivityStreamsHandlerOptions{Instance: p})
+		resp := httptest.NewRecorder()
+		req := request(activityJSONMediaType)
+		req.Header.Set("Signature", `keyId="`+testFederatedActorIRI+`#main-key",algorithm="rsa-sha256",headers="(request-target) date",signature="c2lnbmF0dXJl"`)
+		// Run
+		handled, err := h(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusForbidden)
+	})
+}
+
+// note returns a Note with the id, updated at the time.
+func note(id *url.URL, updated time.Time) vocab.ActivityStreamsNote {
+	n := streams.NewActivityStreamsNote()
+	idProp := streams.NewActivityStreamsIdProperty()
+	idProp.Set(id)
+	n.SetActivityStreamsId(idProp)
+	u := streams.NewActivityStreamsUpdatedProperty()
+	u.Set(updated)
+	n.SetActivityStreamsUpdated(u)
+	return n
+}
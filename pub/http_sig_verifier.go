@@ -0,0 +1,427 @@
+package pub
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-fed/httpsig"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The Signature header.
+	signatureHeader = "Signature"
+	// The Authorization header, which may carry an HTTP Signature instead
+	// of the Signature header.
+	authorizationHeader = "Authorization"
+	// The 'headers' parameter of an HTTP Signature.
+	signatureHeadersParameter = "headers"
+)
+
+// PublicKey is an actor's public key used to verify HTTP Signatures.
+type PublicKey struct {
+	// Id identifies the key, and is the 'keyId' of the HTTP Signatures it
+	// verifies.
+	Id *url.URL
+	// Owner is the id of the actor owning the key.
+	Owner *url.URL
+	// Key is the public key, such as a *rsa.PublicKey.
+	Key crypto.PublicKey
+}
+
+// PublicKeyLookup finds public keys already known to the application, such as
+// those of its own actors or of peers it has saved, without a network request.
+type PublicKeyLookup interface {
+	// LookupPublicKey returns the public key with the given id.
+	//
+	// If the application does not know the key, then a nil PublicKey and
+	// a nil error must be returned so that it is dereferenced instead.
+	LookupPublicKey(c context.Context, keyId *url.URL) (*PublicKey, error)
+}
+
+// HttpSigVerifierPolicy determines what the HttpSigVerifier accepts.
+type HttpSigVerifierPolicy struct {
+	// Algorithms are the HTTP Signature algorithms tried, in order, when
+	// verifying a signature. The algorithm is not taken from the request
+	// itself, as the 'algorithm' parameter is deprecated.
+	Algorithms []httpsig.Algorithm
+	// MaxClockSkew is how far the Date header of a request may be from the
+	// current time, in either direction.
+	MaxClockSkew time.Duration
+	// KeyCacheTTL is how long dereferenced public keys are kept. Zero or
+	// negative values disable caching.
+	KeyCacheTTL time.Duration
+}
+
+// DefaultHttpSigVerifierPolicy accepts RSA SHA-256 signatures, as used
+// throughout the Fediverse, made within the last hour.
+var DefaultHttpSigVerifierPolicy = HttpSigVerifierPolicy{
+	Algorithms:   []httpsig.Algorithm{httpsig.RSA_SHA256},
+	MaxClockSkew: time.Hour,
+	KeyCacheTTL:  24 * time.Hour,
+}
+
+// cachedPublicKey is a dereferenced PublicKey and when it expires.
+type cachedPublicKey struct {
+	key     *PublicKey
+	expires time.Time
+}
+
+// HttpSigVerifier authenticates requests signed with an HTTP Signature by a
+// federated peer, such as those made by the HttpSigTransport.
+//
+// A request is authentic only if its signature is valid for the public key
+// identified by its 'keyId' and covers the '(request-target)' and Date headers,
+// as well as the Digest header if the request has a body. The Date header must
+// also be within the MaxClockSkew of the current time, and the Digest header
+// must match the body of the request.
+//
+// Public keys are obtained from the PublicKeyLookup if one is provided, and
+// are otherwise dereferenced and cached.
+//
+// It is safe to use concurrently.
+type HttpSigVerifier struct {
+	clock        Clock
+	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error)
+	lookup       PublicKeyLookup
+	policy       HttpSigVerifierPolicy
+	// cacheMu guards cache.
+	cacheMu sync.Mutex
+	cache   map[string]cachedPublicKey
+}
+
+// NewHttpSigVerifier returns a new HttpSigVerifier.
+//
+// The newTransport function is used to dereference public keys, and is
+// typically the application's CommonBehavior NewTransport method. It is passed
+// the IRI of the box that was POSTed to.
+//
+// The lookup is optional and may be nil.
+func NewHttpSigVerifier(clock Clock,
+	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error),
+	lookup PublicKeyLookup,
+	policy HttpSigVerifierPolicy) *HttpSigVerifier {
+	return &HttpSigVerifier{
+		clock:        clock,
+		newTransport: newTransport,
+		lookup:       lookup,
+		policy:       policy,
+		cache:        make(map[string]cachedPublicKey),
+	}
+}
+
+// AuthenticatePostInbox verifies the HTTP Signature of a POST to an inbox and
+// ensures the owner of the signing key is the actor of the activity. It is
+// suitable to be called by an application's FederatingProtocol
+// AuthenticatePostInbox method.
+//
+// An http.StatusUnauthorized status is written in the response if the request
+// is not authentic, and an http.StatusForbidden one if the key owner is not the
+// actor of the activity. In either case, authenticated is false and the error
+// is nil.
+//
+// The body of the request is restored so that it can be read again.
+func (v *HttpSigVerifier) AuthenticatePostInbox(c context.Context, w http.ResponseWriter, r *http.Request) (authenticated bool, err error) {
+	owner, verr := v.Verify(c, r)
+	if verr != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var m map[string]interface{}
+	if err = json.Unmarshal(body, &m); err != nil {
+		return
+	}
+	for _, id := range actorIdsFromJSON(m) {
+		if id == owner.String() {
+			authenticated = true
+			return
+		}
+	}
+	w.WriteHeader(http.StatusForbidden)
+	return
+}
+
+// Requester verifies the HTTP Signature of a GET request made by a signed fetch,
+// and returns the id of the actor owning the key it is signed with. It is
+// suitable as the RequesterFunc of a VisibilityPolicy.
+//
+// A request without a signature, or whose signature cannot be verified, is
+// anonymous.
+func (v *HttpSigVerifier) Requester(c context.Context, r *http.Request) (requester *url.URL, err error) {
+	owner, verr := v.Verify(c, r)
+	if verr != nil {
+		return nil, nil
+	}
+	return owner, nil
+}
+
+// Verify verifies the HTTP Signature, Date, and Digest headers of a request,
+// and returns the id of the actor owning the key it is signed with.
+//
+// The body of the request is restored so that it can be read again.
+func (v *HttpSigVerifier) Verify(c context.Context, r *http.Request) (owner *url.URL, err error) {
+	// The standard library moves the Host header out of the headers, but
+	// it is commonly signed.
+	if len(r.Header.Get(hostHeader)) == 0 && len(r.Host) > 0 {
+		r.Header.Set(hostHeader, r.Host)
+	}
+	verifier, err := httpsig.NewVerifier(r)
+	if err != nil {
+		return
+	}
+	signed := signedHeaders(r.Header)
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	required := []string{httpsig.RequestTarget, strings.ToLower(dateHeader)}
+	if len(body) > 0 {
+		required = append(required, strings.ToLower(digestHeader))
+	}
+	for _, h := range required {
+		if !signed[h] {
+			err = fmt.Errorf("http signature does not cover the %q header", h)
+			return
+		}
+	}
+	date, err := http.ParseTime(r.Header.Get(dateHeader))
+	if err != nil {
+		return
+	}
+	if skew := v.clock.Now().Sub(date); skew > v.policy.MaxClockSkew || skew < -v.policy.MaxClockSkew {
+		err = fmt.Errorf("date header %q is outside of the permitted clock skew", r.Header.Get(dateHeader))
+		return
+	}
+	if len(body) > 0 {
+		if err = verifyDigest(r.Header, body); err != nil {
+			return
+		}
+	}
+	keyId, err := url.Parse(verifier.KeyId())
+	if err != nil {
+		return
+	}
+	key, cached, err := v.publicKey(c, r.URL, keyId)
+	if err != nil {
+		return
+	}
+	err = v.verifyWithKey(verifier, key)
+	if err != nil && cached {
+		// The peer may have rotated its key since it was cached.
+		v.invalidate(keyId)
+		key, _, err = v.publicKey(c, r.URL, keyId)
+		if err != nil {
+			return
+		}
+		err = v.verifyWithKey(verifier, key)
+	}
+	if err != nil {
+		return
+	}
+	owner = key.Owner
+	return
+}
+
+// verifyWithKey attempts to verify the signature with each of the permitted
+// algorithms.
+func (v *HttpSigVerifier) verifyWithKey(verifier httpsig.Verifier, key *PublicKey) (err error) {
+	err = fmt.Errorf("no http signature algorithms are permitted")
+	for _, algo := range v.policy.Algorithms {
+		if err = verifier.Verify(key.Key, algo); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// publicKey obtains the public key with the given id, first from the
+// application, then from the cache, and finally by dereferencing it. Whether
+// the key came from the cache is also returned.
+func (v *HttpSigVerifier) publicKey(c context.Context, boxIRI, keyId *url.URL) (key *PublicKey, cached bool, err error) {
+	if v.lookup != nil {
+		key, err = v.lookup.LookupPublicKey(c, keyId)
+		if err != nil || key != nil {
+			return
+		}
+	}
+	now := v.clock.Now()
+	v.cacheMu.Lock()
+	if ck, ok := v.cache[keyId.String()]; ok && now.Before(ck.expires) {
+		v.cacheMu.Unlock()
+		key = ck.key
+		cached = true
+		return
+	}
+	v.cacheMu.Unlock()
+	t, err := v.newTransport(c, boxIRI, goFedUserAgent())
+	if err != nil {
+		return
+	}
+	b, err := t.Dereference(c, keyId)
+	if err != nil {
+		return
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return
+	}
+	key, err = publicKeyFromJSON(m, keyId)
+	if err != nil {
+		return
+	}
+	if v.policy.KeyCacheTTL > 0 {
+		v.cacheMu.Lock()
+		v.cache[keyId.String()] = cachedPublicKey{
+			key:     key,
+			expires: now.Add(v.policy.KeyCacheTTL),
+		}
+		v.cacheMu.Unlock()
+	}
+	return
+}
+
+// invalidate removes a public key from the cache.
+func (v *HttpSigVerifier) invalidate(keyId *url.URL) {
+	v.cacheMu.Lock()
+	defer v.cacheMu.Unlock()
+	delete(v.cache, keyId.String())
+}
+
+// signedHeaders returns the lowercased names of the headers covered by the
+// HTTP Signature in either the Signature or Authorization header.
+func signedHeaders(h http.Header) map[string]bool {
+	s := h.Get(signatureHeader)
+	if len(s) == 0 {
+		s = strings.TrimPrefix(h.Get(authorizationHeader), signatureHeader+" ")
+	}
+	// The Date header is covered when the parameter is absent.
+	names := []string{strings.ToLower(dateHeader)}
+	for _, p := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 && kv[0] == signatureHeadersParameter {
+			names = strings.Fields(strings.Trim(kv[1], "\""))
+		}
+	}
+	signed := make(map[string]bool, len(names))
+	for _, n := range names {
+		signed[strings.ToLower(n)] = true
+	}
+	return signed
+}
+
+// publicKeyFromJSON finds the public key with the given id in a dereferenced
+// JSON value. The value may be the key itself or the actor owning it.
+//
+// The owner of the key must be on the same host as the key, as otherwise any
+// peer could claim to own the keys of another.
+func publicKeyFromJSON(m map[string]interface{}, keyId *url.URL) (key *PublicKey, err error) {
+	var km map[string]interface{}
+	var owner string
+	if _, ok := m["publicKeyPem"]; ok {
+		km = m
+		owner, _ = m["owner"].(string)
+	} else {
+		owner, _ = m["id"].(string)
+		var candidates []interface{}
+		switch pk := m["publicKey"].(type) {
+		case map[string]interface{}:
+			candidates = []interface{}{pk}
+		case []interface{}:
+			candidates = pk
+		}
+		for _, elem := range candidates {
+			if cm, ok := elem.(map[string]interface{}); ok && cm["id"] == keyId.String() {
+				km = cm
+				break
+			}
+		}
+		if km == nil {
+			err = fmt.Errorf("no public key with id %q", keyId)
+			return
+		}
+		// The actor may not own the key it lists.
+		if o, ok := km["owner"].(string); ok && o != owner {
+			err = fmt.Errorf("public key %q is owned by %q and not by %q", keyId, o, owner)
+			return
+		}
+	}
+	if id, ok := km["id"].(string); !ok || id != keyId.String() {
+		err = fmt.Errorf("public key id does not match %q", keyId)
+		return
+	}
+	ownerIRI, err := url.Parse(owner)
+	if err != nil {
+		return
+	} else if len(owner) == 0 || ownerIRI.Host != keyId.Host {
+		err = fmt.Errorf("public key %q has an invalid owner %q", keyId, owner)
+		return
+	}
+	pemStr, ok := km["publicKeyPem"].(string)
+	if !ok {
+		err = fmt.Errorf("public key %q has no publicKeyPem", keyId)
+		return
+	}
+	pubKey, err := parsePublicKeyPem(pemStr)
+	if err != nil {
+		return
+	}
+	key = &PublicKey{
+		Id:    keyId,
+		Owner: ownerIRI,
+		Key:   pubKey,
+	}
+	return
+}
+
+// parsePublicKeyPem parses a PEM encoded PKIX or PKCS #1 public key.
+func parsePublicKeyPem(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, fmt.Errorf("public key is not PEM encoded")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// actorIdsFromJSON returns the ids in the 'actor' property of a JSON value,
+// whether they are IRIs or embedded values.
+func actorIdsFromJSON(m map[string]interface{}) (ids []string) {
+	var vals []interface{}
+	switch a := m["actor"].(type) {
+	case []interface{}:
+		vals = a
+	default:
+		vals = []interface{}{a}
+	}
+	for _, elem := range vals {
+		switch e := elem.(type) {
+		case string:
+			ids = append(ids, e)
+		case map[string]interface{}:
+			if id, ok := e["id"].(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package pub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/go-fed/httpsig"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestHttpSigVerifier ensures only authentic requests from the activity's
+// actor are accepted.
+func TestHttpSigVerifier(t *testing.T) {
+	ctx := context.Background()
+	keyId := testFederatedActorIRI + "#main-key"
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actorJSON, err := json.Marshal(map[string]interface{}{
+		"@context": "https://www.w3.org/ns/activitystreams",
+		"type":     "Person",
+		"id":       testFederatedActorIRI,
+		"inbox":    testFederatedActorIRI + "/inbox",
+		"publicKey": map[string]interface{}{
+			"id":           keyId,
+			"owner":        testFederatedActorIRI,
+			"publicKeyPem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer})),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyFn := func(actor string) []byte {
+		b, err := json.Marshal(map[string]interface{}{
+			"@context": "https://www.w3.org/ns/activitystreams",
+			"type":     "Listen",
+			"id":       testFederatedActivityIRI,
+			"actor":    actor,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	signedRequestFn := func(body []byte, date time.Time) *http.Request {
+		req := httptest.NewRequest("POST", testMyInboxIRI, bytes.NewBuffer(body))
+		req.Header.Set(dateHeader, date.UTC().Format("Mon, 02 Jan 2006 15:04:05")+" GMT")
+		hashed := sha256.Sum256(body)
+		req.Header.Set(digestHeader, sha256Digest+digestDelimiter+base64.StdEncoding.EncodeToString(hashed[:]))
+		signer, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, []string{httpsig.RequestTarget, "date", "digest"}, httpsig.Signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = signer.SignRequest(privKey, keyId, req); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	setupFn := func(ctl *gomock.Controller) (tp *MockTransport, v *HttpSigVerifier) {
+		tp = NewMockTransport(ctl)
+		cl := NewMockClock(ctl)
+		cl.EXPECT().Now().Return(now()).AnyTimes()
+		v = NewHttpSigVerifier(cl, func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (Transport, error) {
+			return tp, nil
+		}, nil, DefaultHttpSigVerifierPolicy)
+		return
+	}
+	// Run tests
+	t.Run("AuthenticatesSignedRequest", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		tp, v := setupFn(ctl)
+		tp.EXPECT().Dereference(ctx, mustParse(keyId)).Return(actorJSON, nil)
+		resp := httptest.NewRecorder()
+		req := signedRequestFn(bodyFn(testFederatedActorIRI), now())
+		// Run
+		authenticated, err := v.AuthenticatePostInbox(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, authenticated, true)
+	})
+	t.Run("CachesPublicKey", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		tp, v := setupFn(ctl)
+		tp.EXPECT().Dereference(ctx, mustParse(keyId)).Return(actorJSON, nil).Times(1)
+		// Run
+		_, err := v.Verify(ctx, signedRequestFn(bodyFn(testFederatedActorIRI), now()))
+		assertEqual(t, err, nil)
+		owner, err := v.Verify(ctx, signedRequestFn(bodyFn(testFederatedActorIRI), now()))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, owner.String(), testFederatedActorIRI)
+	})
+	t.Run("RejectsMismatchedDigest", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, v := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := signedRequestFn(bodyFn(testFederatedActorIRI), now())
+		req.Body = httptest.NewRequest("POST", testMyInboxIRI, bytes.NewBuffer(bodyFn(testFederatedActorIRI2))).Body
+		// Run
+		authenticated, err := v.AuthenticatePostInbox(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, authenticated, false)
+		assertEqual(t, resp.Code, http.StatusUnauthorized)
+	})
+	t.Run("RejectsDateOutsideOfClockSkew", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, v := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := signedRequestFn(bodyFn(testFederatedActorIRI), now().Add(-2*time.Hour))
+		// Run
+		authenticated, err := v.AuthenticatePostInbox(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, authenticated, false)
+		assertEqual(t, resp.Code, http.StatusUnauthorized)
+	})
+	t.Run("ForbidsKeyOwnerNotActor", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		tp, v := setupFn(ctl)
+		tp.EXPECT().Dereference(ctx, mustParse(keyId)).Return(actorJSON, nil)
+		resp := httptest.NewRecorder()
+		req := signedRequestFn(bodyFn(testFederatedActorIRI2), now())
+		// Run
+		authenticated, err := v.AuthenticatePostInbox(ctx, resp, req)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, authenticated, false)
+		assertEqual(t, resp.Code, http.StatusForbidden)
+	})
+}
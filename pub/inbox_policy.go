@@ -0,0 +1,201 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// InboxVerdict is the outcome of applying an InboxPolicy to an activity.
+type InboxVerdict int
+
+const (
+	// InboxAccept passes the activity unchanged to the next policy.
+	InboxAccept InboxVerdict = iota
+	// InboxReject refuses the activity, which is neither added to the
+	// inbox nor has any side effects.
+	InboxReject
+	// InboxRewrite replaces the activity, which the next policies and the
+	// side effects are applied to instead.
+	InboxRewrite
+)
+
+// String returns the name of the verdict.
+func (v InboxVerdict) String() string {
+	switch v {
+	case InboxAccept:
+		return "accept"
+	case InboxReject:
+		return "reject"
+	case InboxRewrite:
+		return "rewrite"
+	default:
+		return fmt.Sprintf("InboxVerdict(%d)", int(v))
+	}
+}
+
+// InboxDecision is the decision of an InboxPolicy about an activity.
+type InboxDecision struct {
+	// Verdict is the outcome of the policy.
+	Verdict InboxVerdict
+	// Status is the HTTP status answered when the activity is rejected. It
+	// is http.StatusForbidden if zero.
+	Status int
+	// Activity replaces the activity when it is rewritten.
+	Activity Activity
+	// Reason optionally explains the decision in InboxPolicyReports.
+	Reason string
+}
+
+// InboxPolicy inspects an activity received in the inbox of an actor before it
+// is added to the inbox and its side effects are applied, and accepts, rejects,
+// or rewrites it.
+//
+// A rewrite only applies to the inbox of the actor. The activity as received is
+// the one stored and forwarded to other servers, and the one the policies of
+// the other recipients of a shared inbox delivery are applied to. So a policy
+// rewriting an activity must return a modified copy of it rather than modify it
+// in place.
+type InboxPolicy interface {
+	// Name identifies the policy in InboxPolicyReports.
+	Name() string
+	// Apply decides whether the actor accepts the activity. If an error
+	// is returned, it is passed back to the caller of PostInbox.
+	Apply(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error)
+}
+
+// inboxPolicyFunc is an InboxPolicy applying a function.
+type inboxPolicyFunc struct {
+	name  string
+	apply func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error)
+}
+
+// NewInboxPolicy returns an InboxPolicy with the name, applying the function.
+func NewInboxPolicy(name string, apply func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error)) InboxPolicy {
+	return inboxPolicyFunc{
+		name:  name,
+		apply: apply,
+	}
+}
+
+// Name returns the name of the policy.
+func (f inboxPolicyFunc) Name() string {
+	return f.name
+}
+
+// Apply calls the function.
+func (f inboxPolicyFunc) Apply(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error) {
+	return f.apply(c, actorIRI, activity)
+}
+
+// InboxPolicyReport is a decision made by an InboxPolicy.
+type InboxPolicyReport struct {
+	// InboxIRI is the inbox the activity was posted to.
+	InboxIRI *url.URL
+	// ActorIRI is the actor owning the inbox.
+	ActorIRI *url.URL
+	// ActivityId is the id of the activity the policy was applied to,
+	// which is nil if it has none.
+	ActivityId *url.URL
+	// Policy is the name of the policy.
+	Policy string
+	// Decision is the decision of the policy.
+	Decision InboxDecision
+}
+
+// InboxPolicyProvider may be implemented by a FederatingProtocol to apply a
+// chain of InboxPolicies to the activities received by each actor, after they
+// are authorized and before they are added to the inbox.
+//
+// A rejected activity is answered with the Status of the decision. When it was
+// delivered to a shared inbox, it is instead only skipped for the actor
+// rejecting it.
+type InboxPolicyProvider interface {
+	// InboxPolicies returns the policies applied to the activities
+	// received by the actor, in order. The chain stops at the first policy
+	// rejecting the activity.
+	InboxPolicies(c context.Context, actorIRI *url.URL) ([]InboxPolicy, error)
+	// ReportInboxPolicy is called with each decision made by the
+	// policies, so that the application can log them.
+	ReportInboxPolicy(c context.Context, report InboxPolicyReport)
+}
+
+// InboxPolicyError is returned by a DelegateActor's PostInbox when an
+// InboxPolicy rejects the activity, so that the Status is answered.
+type InboxPolicyError struct {
+	// Policy is the name of the policy that rejected the activity.
+	Policy string
+	// Status is the HTTP status to answer with.
+	Status int
+	// Reason is the reason given by the policy, if any.
+	Reason string
+}
+
+// Error returns a description of the rejection.
+func (e *InboxPolicyError) Error() string {
+	if len(e.Reason) > 0 {
+		return fmt.Sprintf("activity rejected by inbox policy %s (%d): %s", e.Policy, e.Status, e.Reason)
+	}
+	return fmt.Sprintf("activity rejected by inbox policy %s (%d)", e.Policy, e.Status)
+}
+
+// applyInboxPolicies applies the InboxPolicies of the actor owning the inbox to
+// the activity, and returns the activity to process, which is rewritten if any
+// policy rewrote it. An InboxPolicyError is returned if a policy rejects it.
+func (a *sideEffectActor) applyInboxPolicies(c context.Context, inboxIRI *url.URL, activity Activity) (Activity, error) {
+	provider, ok := a.s2s.(InboxPolicyProvider)
+	if !ok {
+		return activity, nil
+	}
+	if err := a.db.Lock(c, inboxIRI); err != nil {
+		return nil, err
+	}
+	// WARNING: Unlock not deferred.
+	actorIRI, err := a.db.ActorForInbox(c, inboxIRI)
+	a.db.Unlock(c, inboxIRI)
+	if err != nil {
+		return nil, err
+	}
+	// Unlock must be called by now and every branch above.
+	policies, err := provider.InboxPolicies(c, actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range policies {
+		decision, err := p.Apply(c, actorIRI, activity)
+		if err != nil {
+			return nil, err
+		}
+		if decision.Verdict == InboxRewrite && decision.Activity == nil {
+			return nil, fmt.Errorf("inbox policy %s rewrote an activity without providing one", p.Name())
+		}
+		if decision.Verdict == InboxReject && decision.Status == 0 {
+			decision.Status = http.StatusForbidden
+		}
+		report := InboxPolicyReport{
+			InboxIRI: inboxIRI,
+			ActorIRI: actorIRI,
+			Policy:   p.Name(),
+			Decision: decision,
+		}
+		if id := activity.GetActivityStreamsId(); id != nil {
+			report.ActivityId = id.Get()
+		}
+		provider.ReportInboxPolicy(c, report)
+		switch decision.Verdict {
+		case InboxAccept:
+		case InboxReject:
+			return nil, &InboxPolicyError{
+				Policy: p.Name(),
+				Status: decision.Status,
+				Reason: decision.Reason,
+			}
+		case InboxRewrite:
+			activity = decision.Activity
+		default:
+			return nil, fmt.Errorf("inbox policy %s returned unknown verdict %s", p.Name(), decision.Verdict)
+		}
+	}
+	return activity, nil
+}
@@ -0,0 +1,157 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// inboxPolicyFederatingProtocol is a FederatingProtocol providing
+// InboxPolicies, and keeping their reports.
+type inboxPolicyFederatingProtocol struct {
+	*MockFederatingProtocol
+	policies map[string][]InboxPolicy
+	reports  *[]InboxPolicyReport
+}
+
+// InboxPolicies returns the policies of the actor.
+func (p inboxPolicyFederatingProtocol) InboxPolicies(c context.Context, actorIRI *url.URL) ([]InboxPolicy, error) {
+	return p.policies[actorIRI.String()], nil
+}
+
+// ReportInboxPolicy keeps the report.
+func (p inboxPolicyFederatingProtocol) ReportInboxPolicy(c context.Context, report InboxPolicyReport) {
+	*p.reports = append(*p.reports, report)
+}
+
+// TestInboxPolicies ensures the policies of the actor owning the inbox are
+// applied in order, and that their decisions are reported.
+func TestInboxPolicies(t *testing.T) {
+	ctx := context.Background()
+	me := mustParse("https://example.com/addison")
+	inboxIRI := mustParse(testMyInboxIRI)
+	accept := NewInboxPolicy("accept", func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error) {
+		return InboxDecision{Verdict: InboxAccept}, nil
+	})
+	reject := NewInboxPolicy("reject", func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error) {
+		return InboxDecision{Verdict: InboxReject, Reason: "too many mentions"}, nil
+	})
+	unreachable := NewInboxPolicy("unreachable", func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error) {
+		t.Fatal("policies after a rejection must not be applied")
+		return InboxDecision{}, nil
+	})
+	setupFn := func(ctl *gomock.Controller, policies ...InboxPolicy) (db *MockDatabase, reports *[]InboxPolicyReport, a *sideEffectActor) {
+		setupData()
+		db = NewMockDatabase(ctl)
+		reports = &[]InboxPolicyReport{}
+		a = &sideEffectActor{
+			s2s: inboxPolicyFederatingProtocol{
+				MockFederatingProtocol: NewMockFederatingProtocol(ctl),
+				policies:               map[string][]InboxPolicy{me.String(): policies},
+				reports:                reports,
+			},
+			db: db,
+		}
+		return
+	}
+	expectActor := func(db *MockDatabase, inboxIRI, actorIRI *url.URL) {
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(actorIRI, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+		)
+	}
+	// Run tests
+	t.Run("RewritesActivity", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		rewritten := streams.NewActivityStreamsCreate()
+		var seen Activity
+		rewrite := NewInboxPolicy("rewrite", func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error) {
+			return InboxDecision{Verdict: InboxRewrite, Activity: rewritten}, nil
+		})
+		observe := NewInboxPolicy("observe", func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error) {
+			seen = activity
+			return InboxDecision{Verdict: InboxAccept}, nil
+		})
+		db, reports, a := setupFn(ctl, accept, rewrite, observe)
+		expectActor(db, inboxIRI, me)
+		// Run
+		activity, err := a.applyInboxPolicies(ctx, inboxIRI, testCreate)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, activity, Activity(rewritten))
+		assertEqual(t, seen, Activity(rewritten))
+		assertEqual(t, len(*reports), 3)
+		assertEqual(t, (*reports)[1].Policy, "rewrite")
+		assertEqual(t, (*reports)[1].Decision.Verdict, InboxRewrite)
+		assertEqual(t, (*reports)[1].ActorIRI, me)
+		assertEqual(t, (*reports)[1].ActivityId.String(), testFederatedActivityIRI)
+	})
+	t.Run("RejectStopsChain", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db, reports, a := setupFn(ctl, accept, reject, unreachable)
+		expectActor(db, inboxIRI, me)
+		// Run
+		_, err := a.applyInboxPolicies(ctx, inboxIRI, testCreate)
+		// Verify
+		ipErr, ok := err.(*InboxPolicyError)
+		assertEqual(t, ok, true)
+		assertEqual(t, ipErr.Policy, "reject")
+		assertEqual(t, ipErr.Status, http.StatusForbidden)
+		assertEqual(t, ipErr.Reason, "too many mentions")
+		assertEqual(t, len(*reports), 2)
+		assertEqual(t, (*reports)[1].Decision.Verdict, InboxReject)
+	})
+	t.Run("RejectWithStatus", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		young := NewInboxPolicy("account-age", func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error) {
+			return InboxDecision{Verdict: InboxReject, Status: http.StatusUnprocessableEntity}, nil
+		})
+		db, _, a := setupFn(ctl, young)
+		expectActor(db, inboxIRI, me)
+		// Run
+		_, err := a.applyInboxPolicies(ctx, inboxIRI, testCreate)
+		// Verify
+		ipErr, ok := err.(*InboxPolicyError)
+		assertEqual(t, ok, true)
+		assertEqual(t, ipErr.Status, http.StatusUnprocessableEntity)
+	})
+	t.Run("RewriteRequiresActivity", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		broken := NewInboxPolicy("broken", func(c context.Context, actorIRI *url.URL, activity Activity) (InboxDecision, error) {
+			return InboxDecision{Verdict: InboxRewrite}, nil
+		})
+		db, reports, a := setupFn(ctl, broken)
+		expectActor(db, inboxIRI, me)
+		// Run
+		_, err := a.applyInboxPolicies(ctx, inboxIRI, testCreate)
+		// Verify
+		assertNotEqual(t, err, nil)
+		assertEqual(t, len(*reports), 0)
+	})
+	t.Run("OtherActorsPoliciesNotApplied", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db, reports, a := setupFn(ctl, reject)
+		otherInbox := mustParse(testMyOtherInboxIRI)
+		expectActor(db, otherInbox, mustParse("https://example.com/riley"))
+		// Run
+		activity, err := a.applyInboxPolicies(ctx, otherInbox, testCreate)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, activity, testCreate)
+		assertEqual(t, len(*reports), 0)
+	})
+}
@@ -0,0 +1,412 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/go-fed/httpsig"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DomainSeverity is how federation with a peer domain is restricted by an
+// InstancePolicy.
+type DomainSeverity int
+
+const (
+	// DomainFederate does not restrict federation with the domain.
+	DomainFederate DomainSeverity = iota
+	// DomainMediaStrip accepts activities from the domain, but removes the
+	// media attached to them and to their objects, and to the objects
+	// from the domain embedded in activities from other domains.
+	DomainMediaStrip
+	// DomainNoDeliver accepts activities from the domain, but delivers
+	// nothing to it.
+	DomainNoDeliver
+	// DomainReject refuses activities from the domain, delivers nothing to
+	// it, dereferences nothing from it, and serves neither values nor
+	// inboxes and outboxes to requests signed with its keys.
+	DomainReject
+)
+
+// String returns the name of the severity.
+func (s DomainSeverity) String() string {
+	switch s {
+	case DomainFederate:
+		return "federate"
+	case DomainMediaStrip:
+		return "media-strip"
+	case DomainNoDeliver:
+		return "no-deliver"
+	case DomainReject:
+		return "reject"
+	default:
+		return fmt.Sprintf("DomainSeverity(%d)", int(s))
+	}
+}
+
+// DomainRule restricts federation with a domain.
+type DomainRule struct {
+	// Domain is either a host name, such as "example.com", which matches
+	// only that host, or a wildcard, such as "*.example.com", which
+	// matches the host and all of its subdomains.
+	Domain string
+	// Severity is how federation with the domain is restricted.
+	Severity DomainSeverity
+}
+
+// InstancePolicyConfig is the configuration of an InstancePolicy.
+type InstancePolicyConfig struct {
+	// Rules restrict federation with the domains they match. When several
+	// rules match a host, the most specific one applies.
+	Rules []DomainRule
+	// AllowlistOnly enables limited federation: only the Allowed domains
+	// are federated with, and every other domain is rejected.
+	AllowlistOnly bool
+	// Allowed are the domains federated with when AllowlistOnly is set,
+	// in the same form as the Domain of a DomainRule. Rules still apply to
+	// them.
+	Allowed []string
+}
+
+// domainSet matches hosts against host names and wildcards.
+type domainSet struct {
+	// exact maps host names to their value.
+	exact map[string]DomainSeverity
+	// wildcard maps the domains of wildcards to their value.
+	wildcard map[string]DomainSeverity
+}
+
+// InstancePolicy restricts federation with peer domains on behalf of the whole
+// instance, as opposed to the blocks of a single actor.
+//
+// It applies in AuthorizePostInbox and to GET requests of inboxes and outboxes
+// if the FederatingProtocol is an InstancePolicyProvider, to the Transports
+// wrapped with
+// NewInstancePolicyTransport, and to the handlers created with
+// NewActivityStreamsHandlerWithOptions.
+//
+// Its configuration may be replaced at runtime with Reload. It is safe to use
+// concurrently.
+type InstancePolicy struct {
+	mu      sync.RWMutex
+	config  InstancePolicyConfig
+	rules   domainSet
+	allowed domainSet
+}
+
+// InstancePolicyProvider may be implemented by a FederatingProtocol to have
+// the InstancePolicy applied to activities received in an inbox.
+//
+// Activities with an actor on a rejected domain, and GET requests of inboxes and
+// outboxes signed with a key on a rejected domain, are answered with
+// http.StatusForbidden. The media of activities with an actor on a media-strip
+// domain are removed before they are processed.
+type InstancePolicyProvider interface {
+	InstancePolicy() *InstancePolicy
+}
+
+// NewInstancePolicy returns an InstancePolicy with the configuration, or an
+// error if one of its domains is not valid.
+func NewInstancePolicy(config InstancePolicyConfig) (*InstancePolicy, error) {
+	p := &InstancePolicy{}
+	if err := p.Reload(config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload replaces the configuration of the policy. The previous configuration
+// is kept if the new one is not valid.
+func (p *InstancePolicy) Reload(config InstancePolicyConfig) error {
+	rules := domainSet{
+		exact:    make(map[string]DomainSeverity),
+		wildcard: make(map[string]DomainSeverity),
+	}
+	for _, r := range config.Rules {
+		if err := rules.add(r.Domain, r.Severity); err != nil {
+			return err
+		}
+	}
+	allowed := domainSet{
+		exact:    make(map[string]DomainSeverity),
+		wildcard: make(map[string]DomainSeverity),
+	}
+	for _, d := range config.Allowed {
+		if err := allowed.add(d, DomainFederate); err != nil {
+			return err
+		}
+	}
+	config.Rules = append([]DomainRule(nil), config.Rules...)
+	config.Allowed = append([]string(nil), config.Allowed...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+	p.rules = rules
+	p.allowed = allowed
+	return nil
+}
+
+// Config returns the current configuration of the policy.
+func (p *InstancePolicy) Config() InstancePolicyConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c := p.config
+	c.Rules = append([]DomainRule(nil), c.Rules...)
+	c.Allowed = append([]string(nil), c.Allowed...)
+	return c
+}
+
+// Severity returns how federation with the host of the IRI is restricted.
+func (p *InstancePolicy) Severity(iri *url.URL) DomainSeverity {
+	host := normalizeDomain(iri.Hostname())
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.config.AllowlistOnly {
+		if _, ok := p.allowed.match(host); !ok {
+			return DomainReject
+		}
+	}
+	s, _ := p.rules.match(host)
+	return s
+}
+
+// Rejects determines whether federation with the host of the IRI is refused
+// altogether.
+func (p *InstancePolicy) Rejects(iri *url.URL) bool {
+	return p.Severity(iri) == DomainReject
+}
+
+// Delivers determines whether activities may be delivered to the host of the
+// IRI.
+func (p *InstancePolicy) Delivers(iri *url.URL) bool {
+	s := p.Severity(iri)
+	return s != DomainReject && s != DomainNoDeliver
+}
+
+// StripsMedia determines whether the media of activities from the host of the
+// IRI are removed.
+func (p *InstancePolicy) StripsMedia(iri *url.URL) bool {
+	return p.Severity(iri) == DomainMediaStrip
+}
+
+// RejectsRequest determines whether the request is signed with a key on a
+// rejected domain. Unsigned requests are not rejected.
+//
+// The signature is not verified, so that no key is ever dereferenced from a
+// rejected domain.
+func (p *InstancePolicy) RejectsRequest(r *http.Request) bool {
+	verifier, err := httpsig.NewVerifier(r)
+	if err != nil {
+		return false
+	}
+	keyId, err := url.Parse(verifier.KeyId())
+	if err != nil || len(keyId.Host) == 0 {
+		return false
+	}
+	return p.Rejects(keyId)
+}
+
+// authorize applies the policy to the actors of an activity received in an
+// inbox. It returns false if one of them is on a rejected domain, and
+// otherwise removes the media of the activity and its embedded objects if one
+// of them is on a media-strip domain. The media of embedded objects from a
+// media-strip domain are removed in any case.
+func (p *InstancePolicy) authorize(actors []*url.URL, activity Activity) bool {
+	strip := false
+	for _, iri := range actors {
+		switch p.Severity(iri) {
+		case DomainReject:
+			return false
+		case DomainMediaStrip:
+			strip = true
+		}
+	}
+	p.stripMedia(activity, strip)
+	return true
+}
+
+// add adds a host name or wildcard to the set.
+func (d domainSet) add(raw string, s DomainSeverity) error {
+	domain := normalizeDomain(raw)
+	wildcard := strings.HasPrefix(domain, "*.")
+	if wildcard {
+		domain = strings.TrimPrefix(domain, "*.")
+	}
+	if len(domain) == 0 || strings.ContainsAny(domain, "*/:@ ") {
+		return fmt.Errorf("invalid domain in instance policy: %q", raw)
+	}
+	if wildcard {
+		d.wildcard[domain] = s
+	} else {
+		d.exact[domain] = s
+	}
+	return nil
+}
+
+// match returns the value of the most specific host name or wildcard matching
+// the host, if any.
+func (d domainSet) match(host string) (DomainSeverity, bool) {
+	if s, ok := d.exact[host]; ok {
+		return s, true
+	}
+	for domain := host; len(domain) > 0; {
+		if s, ok := d.wildcard[domain]; ok {
+			return s, true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return DomainFederate, false
+}
+
+// normalizeDomain lowercases a domain and removes any trailing dot.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// stripMedia removes the 'attachment', 'icon', and 'image' of the value if
+// strip is true or if its id, 'actor', or 'attributedTo' is on a media-strip
+// domain. The embedded values of its 'object', such as the object of an
+// Announce, are then stripped in the same way, and always are if the value is.
+//
+// Media referenced elsewhere, such as in the HTML of the 'content', are not
+// removed.
+func (p *InstancePolicy) stripMedia(t vocab.Type, strip bool) {
+	if !strip {
+		// Owners without an id cannot be matched against the domains.
+		iris, _ := ownerIRIs(t)
+		if id, err := GetId(t); err == nil {
+			iris = append(iris, id)
+		}
+		for _, iri := range iris {
+			if p.StripsMedia(iri) {
+				strip = true
+				break
+			}
+		}
+	}
+	if strip {
+		if v, ok := t.(attachmenter); ok {
+			v.SetActivityStreamsAttachment(nil)
+		}
+		if v, ok := t.(iconer); ok {
+			v.SetActivityStreamsIcon(nil)
+		}
+		if v, ok := t.(imager); ok {
+			v.SetActivityStreamsImage(nil)
+		}
+	}
+	o, ok := t.(objecter)
+	if !ok {
+		return
+	}
+	op := o.GetActivityStreamsObject()
+	if op == nil {
+		return
+	}
+	for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
+		if v := iter.GetType(); v != nil {
+			p.stripMedia(v, strip)
+		}
+	}
+}
+
+// DomainPolicyError is returned by a Transport wrapped with
+// NewInstancePolicyTransport when the InstancePolicy refuses a request.
+type DomainPolicyError struct {
+	// Host is the peer host.
+	Host string
+	// Severity is the restriction applied to the host.
+	Severity DomainSeverity
+}
+
+// Error returns a description of the refused request.
+func (e *DomainPolicyError) Error() string {
+	return fmt.Sprintf("instance policy refuses requests to %s (%s)", e.Host, e.Severity)
+}
+
+// instancePolicyTransport must be a Transport.
+var _ Transport = &instancePolicyTransport{}
+
+// instancePolicyTransport makes requests with the wrapped Transport only to the
+// hosts the InstancePolicy permits.
+type instancePolicyTransport struct {
+	t Transport
+	p *InstancePolicy
+}
+
+// NewInstancePolicyTransport returns a Transport that refuses to dereference
+// from rejected domains, and to deliver to rejected and no-deliver domains,
+// returning a DomainPolicyError instead. Other requests are made with the
+// wrapped Transport.
+//
+// When BatchDeliver refuses to deliver to some recipients, it returns a
+// BatchDeliverError whose Results contain the DomainPolicyErrors.
+func NewInstancePolicyTransport(t Transport, p *InstancePolicy) Transport {
+	return &instancePolicyTransport{
+		t: t,
+		p: p,
+	}
+}
+
+// Dereference fetches the IRI with the wrapped Transport, unless its host is
+// rejected.
+func (t *instancePolicyTransport) Dereference(c context.Context, iri *url.URL) ([]byte, error) {
+	if s := t.p.Severity(iri); s == DomainReject {
+		return nil, &DomainPolicyError{Host: iri.Host, Severity: s}
+	}
+	return t.t.Dereference(c, iri)
+}
+
+// Deliver sends the payload with the wrapped Transport, unless the recipient's
+// host may not be delivered to.
+func (t *instancePolicyTransport) Deliver(c context.Context, b []byte, to *url.URL) error {
+	if !t.p.Delivers(to) {
+		return &DomainPolicyError{Host: to.Host, Severity: t.p.Severity(to)}
+	}
+	return t.t.Deliver(c, b, to)
+}
+
+// BatchDeliver sends the payload with the wrapped Transport to the recipients
+// whose host may be delivered to.
+func (t *instancePolicyTransport) BatchDeliver(c context.Context, b []byte, recipients []*url.URL) error {
+	var allowed []*url.URL
+	var skipped []DeliveryResult
+	for _, to := range recipients {
+		if s := t.p.Severity(to); s == DomainReject || s == DomainNoDeliver {
+			skipped = append(skipped, DeliveryResult{
+				Recipient: to,
+				Err:       &DomainPolicyError{Host: to.Host, Severity: s},
+			})
+		} else {
+			allowed = append(allowed, to)
+		}
+	}
+	if len(skipped) == 0 {
+		return t.t.BatchDeliver(c, b, allowed)
+	}
+	var results []DeliveryResult
+	if len(allowed) > 0 {
+		err := t.t.BatchDeliver(c, b, allowed)
+		if batchErr, ok := err.(*BatchDeliverError); ok {
+			results = batchErr.Results
+		} else if err != nil {
+			// The wrapped Transport did not report the outcome of
+			// each delivery, so they all failed in the same way.
+			for _, to := range allowed {
+				results = append(results, DeliveryResult{Recipient: to, Err: err})
+			}
+		} else {
+			for _, to := range allowed {
+				results = append(results, DeliveryResult{Recipient: to})
+			}
+		}
+	}
+	return &BatchDeliverError{Results: append(results, skipped...)}
+}
@@ -0,0 +1,398 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// policyFederatingProtocol is a FederatingProtocol providing an InstancePolicy.
+type policyFederatingProtocol struct {
+	*MockFederatingProtocol
+	policy *InstancePolicy
+}
+
+// InstancePolicy returns the policy.
+func (p policyFederatingProtocol) InstancePolicy() *InstancePolicy {
+	return p.policy
+}
+
+// TestInstancePolicy ensures domains are matched by host name and wildcard, and
+// that limited federation rejects all but the allowed domains.
+func TestInstancePolicy(t *testing.T) {
+	mustPolicy := func(t *testing.T, config InstancePolicyConfig) *InstancePolicy {
+		p, err := NewInstancePolicy(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	t.Run("MatchesMostSpecificDomain", func(t *testing.T) {
+		// Setup
+		p := mustPolicy(t, InstancePolicyConfig{
+			Rules: []DomainRule{
+				{Domain: "*.example.com", Severity: DomainReject},
+				{Domain: "*.media.example.com", Severity: DomainMediaStrip},
+				{Domain: "quiet.media.example.com", Severity: DomainNoDeliver},
+			},
+		})
+		// Verify
+		assertEqual(t, p.Severity(mustParse("https://example.com/a")), DomainReject)
+		assertEqual(t, p.Severity(mustParse("https://other.example.com/a")), DomainReject)
+		assertEqual(t, p.Severity(mustParse("https://a.media.example.com/a")), DomainMediaStrip)
+		assertEqual(t, p.Severity(mustParse("https://QUIET.media.example.com:8443/a")), DomainNoDeliver)
+		assertEqual(t, p.Severity(mustParse("https://example.org/a")), DomainFederate)
+		assertEqual(t, p.Severity(mustParse("https://notexample.com/a")), DomainFederate)
+	})
+	t.Run("ExactDomainExcludesSubdomains", func(t *testing.T) {
+		// Setup
+		p := mustPolicy(t, InstancePolicyConfig{
+			Rules: []DomainRule{{Domain: "example.com", Severity: DomainReject}},
+		})
+		// Verify
+		assertEqual(t, p.Rejects(mustParse("https://example.com/a")), true)
+		assertEqual(t, p.Rejects(mustParse("https://other.example.com/a")), false)
+	})
+	t.Run("AllowlistRejectsOtherDomains", func(t *testing.T) {
+		// Setup
+		p := mustPolicy(t, InstancePolicyConfig{
+			Rules:         []DomainRule{{Domain: "media.example.org", Severity: DomainMediaStrip}},
+			AllowlistOnly: true,
+			Allowed:       []string{"*.example.org"},
+		})
+		// Verify
+		assertEqual(t, p.Severity(mustParse("https://example.org/a")), DomainFederate)
+		assertEqual(t, p.Severity(mustParse("https://media.example.org/a")), DomainMediaStrip)
+		assertEqual(t, p.Severity(mustParse("https://example.com/a")), DomainReject)
+	})
+	t.Run("ReloadReplacesConfig", func(t *testing.T) {
+		// Setup
+		p := mustPolicy(t, InstancePolicyConfig{
+			Rules: []DomainRule{{Domain: "example.com", Severity: DomainReject}},
+		})
+		// Run
+		err := p.Reload(InstancePolicyConfig{
+			Rules: []DomainRule{{Domain: "example.org", Severity: DomainNoDeliver}},
+		})
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, p.Rejects(mustParse("https://example.com/a")), false)
+		assertEqual(t, p.Delivers(mustParse("https://example.org/a")), false)
+	})
+	t.Run("InvalidReloadKeepsConfig", func(t *testing.T) {
+		// Setup
+		p := mustPolicy(t, InstancePolicyConfig{
+			Rules: []DomainRule{{Domain: "example.com", Severity: DomainReject}},
+		})
+		// Run
+		err := p.Reload(InstancePolicyConfig{
+			Rules: []DomainRule{{Domain: "*.*.example.org", Severity: DomainReject}},
+		})
+		// Verify
+		assertNotEqual(t, err, nil)
+		assertEqual(t, p.Rejects(mustParse("https://example.com/a")), true)
+		assertEqual(t, len(p.Config().Rules), 1)
+	})
+}
+
+// TestInstancePolicyTransport ensures requests to domains restricted by the
+// InstancePolicy are refused.
+func TestInstancePolicyTransport(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte(`{"type":"Create"}`)
+	rejected := mustParse("https://rejected.example/inbox")
+	quiet := mustParse("https://quiet.example/inbox")
+	open := mustParse(testFederatedActorIRI + "/inbox")
+	setupFn := func(ctl *gomock.Controller) (mt *MockTransport, tp Transport) {
+		mt = NewMockTransport(ctl)
+		p, err := NewInstancePolicy(InstancePolicyConfig{
+			Rules: []DomainRule{
+				{Domain: "rejected.example", Severity: DomainReject},
+				{Domain: "quiet.example", Severity: DomainNoDeliver},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp = NewInstancePolicyTransport(mt, p)
+		return
+	}
+	// Run tests
+	t.Run("RefusesDereferenceFromRejectedDomain", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		mt, tp := setupFn(ctl)
+		mt.EXPECT().Dereference(ctx, quiet).Return(payload, nil)
+		// Run
+		_, err := tp.Dereference(ctx, rejected)
+		b, qerr := tp.Dereference(ctx, quiet)
+		// Verify
+		dpErr, ok := err.(*DomainPolicyError)
+		assertEqual(t, ok, true)
+		assertEqual(t, dpErr.Severity, DomainReject)
+		assertEqual(t, qerr, nil)
+		assertEqual(t, string(b), string(payload))
+	})
+	t.Run("RefusesDeliveryToNoDeliverDomain", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		mt, tp := setupFn(ctl)
+		mt.EXPECT().Deliver(ctx, payload, open).Return(nil)
+		// Run
+		err := tp.Deliver(ctx, payload, quiet)
+		oerr := tp.Deliver(ctx, payload, open)
+		// Verify
+		dpErr, ok := err.(*DomainPolicyError)
+		assertEqual(t, ok, true)
+		assertEqual(t, dpErr.Severity, DomainNoDeliver)
+		assertEqual(t, isRetryableError(err), false)
+		assertEqual(t, oerr, nil)
+	})
+	t.Run("BatchDeliverReportsSkippedRecipients", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		mt, tp := setupFn(ctl)
+		mt.EXPECT().BatchDeliver(ctx, payload, []*url.URL{open}).Return(nil)
+		// Run
+		err := tp.BatchDeliver(ctx, payload, []*url.URL{rejected, open, quiet})
+		// Verify
+		batchErr, ok := err.(*BatchDeliverError)
+		assertEqual(t, ok, true)
+		assertEqual(t, len(batchErr.Results), 3)
+		failed := batchErr.Failed()
+		assertEqual(t, len(failed), 2)
+		assertEqual(t, failed[0].Recipient, rejected)
+		assertEqual(t, failed[1].Recipient, quiet)
+	})
+}
+
+// TestInstancePolicyAuthorizePostInbox ensures the InstancePolicy of the
+// FederatingProtocol applies to activities received in an inbox.
+func TestInstancePolicyAuthorizePostInbox(t *testing.T) {
+	ctx := context.Background()
+	setupFn := func(ctl *gomock.Controller, severity DomainSeverity) (fp *MockFederatingProtocol, a DelegateActor) {
+		setupData()
+		fp = NewMockFederatingProtocol(ctl)
+		p, err := NewInstancePolicy(InstancePolicyConfig{
+			Rules: []DomainRule{{Domain: mustParse(testFederatedActorIRI).Host, Severity: severity}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a = &sideEffectActor{
+			s2s: policyFederatingProtocol{MockFederatingProtocol: fp, policy: p},
+		}
+		return
+	}
+	withAttachment := func() Activity {
+		create := streams.NewActivityStreamsCreate()
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(mustParse(testFederatedActorIRI))
+		create.SetActivityStreamsActor(actor)
+		note := streams.NewActivityStreamsNote()
+		attachment := streams.NewActivityStreamsAttachmentProperty()
+		attachment.AppendIRI(mustParse("https://other.example.com/media/1.png"))
+		note.SetActivityStreamsAttachment(attachment)
+		op := streams.NewActivityStreamsObjectProperty()
+		op.AppendActivityStreamsNote(note)
+		create.SetActivityStreamsObject(op)
+		return create
+	}
+	// Run tests
+	t.Run("RejectedDomainForbidden", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, a := setupFn(ctl, DomainReject)
+		resp := httptest.NewRecorder()
+		// Run
+		b, err := a.AuthorizePostInbox(ctx, resp, testCreate)
+		// Verify
+		assertEqual(t, b, false)
+		assertEqual(t, err, nil)
+		assertEqual(t, resp.Code, http.StatusForbidden)
+	})
+	t.Run("MediaStripDomainLosesAttachments", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		fp, a := setupFn(ctl, DomainMediaStrip)
+		resp := httptest.NewRecorder()
+		fp.EXPECT().Blocked(ctx, []*url.URL{mustParse(testFederatedActorIRI)}).Return(false, nil)
+		activity := withAttachment()
+		// Run
+		b, err := a.AuthorizePostInbox(ctx, resp, activity)
+		// Verify
+		assertEqual(t, b, true)
+		assertEqual(t, err, nil)
+		note := activity.GetActivityStreamsObject().At(0).GetActivityStreamsNote()
+		assertEqual(t, note.GetActivityStreamsAttachment() == nil, true)
+	})
+	t.Run("AnnouncedObjectFromMediaStripDomainLosesMedia", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		fp, a := setupFn(ctl, DomainMediaStrip)
+		resp := httptest.NewRecorder()
+		announcer := mustParse("https://example.org/kim")
+		fp.EXPECT().Blocked(ctx, []*url.URL{announcer}).Return(false, nil)
+		announce := streams.NewActivityStreamsAnnounce()
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(announcer)
+		announce.SetActivityStreamsActor(actor)
+		note := streams.NewActivityStreamsNote()
+		attrTo := streams.NewActivityStreamsAttributedToProperty()
+		attrTo.AppendIRI(mustParse(testFederatedActorIRI))
+		note.SetActivityStreamsAttributedTo(attrTo)
+		attachment := streams.NewActivityStreamsAttachmentProperty()
+		attachment.AppendIRI(mustParse("https://other.example.com/media/1.png"))
+		note.SetActivityStreamsAttachment(attachment)
+		icon := streams.NewActivityStreamsIconProperty()
+		icon.AppendIRI(mustParse("https://other.example.com/media/2.png"))
+		note.SetActivityStreamsIcon(icon)
+		op := streams.NewActivityStreamsObjectProperty()
+		op.AppendActivityStreamsNote(note)
+		announce.SetActivityStreamsObject(op)
+		// Run
+		b, err := a.AuthorizePostInbox(ctx, resp, announce)
+		// Verify
+		assertEqual(t, b, true)
+		assertEqual(t, err, nil)
+		assertEqual(t, note.GetActivityStreamsAttachment() == nil, true)
+		assertEqual(t, note.GetActivityStreamsIcon() == nil, true)
+	})
+	t.Run("NoDeliverDomainAccepted", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		fp, a := setupFn(ctl, DomainNoDeliver)
+		resp := httptest.NewRecorder()
+		fp.EXPECT().Blocked(ctx, []*url.URL{mustParse(testFederatedActorIRI)}).Return(false, nil)
+		activity := withAttachment()
+		// Run
+		b, err := a.AuthorizePostInbox(ctx, resp, activity)
+		// Verify
+		assertEqual(t, b, true)
+		assertEqual(t, err, nil)
+		note := activity.GetActivityStreamsObject().At(0).GetActivityStreamsNote()
+		assertEqual(t, note.GetActivityStreamsAttachment().Len(), 1)
+	})
+}
+
+// TestInstancePolicyAuthenticateGetBoxes ensures requests of inboxes and
+// outboxes signed with a key on a rejected domain are refused.
+func TestInstancePolicyAuthenticateGetBoxes(t *testing.T) {
+	ctx := context.Background()
+	setupFn := func(ctl *gomock.Controller) (c *MockCommonBehavior, a DelegateActor) {
+		setupData()
+		c = NewMockCommonBehavior(ctl)
+		p, err := NewInstancePolicy(InstancePolicyConfig{
+			Rules: []DomainRule{{Domain: "rejected.example", Severity: DomainReject}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a = &sideEffectActor{
+			common: c,
+			s2s:    policyFederatingProtocol{MockFederatingProtocol: NewMockFederatingProtocol(ctl), policy: p},
+		}
+		return
+	}
+	signed := func(keyId string) *http.Request {
+		req := toAPRequest(httptest.NewRequest("GET", testMyInboxIRI, nil))
+		req.Header.Set("Signature", `keyId="`+keyId+`",algorithm="rsa-sha256",headers="(request-target) date",signature="c2lnbmF0dXJl"`)
+		return req
+	}
+	// Run tests
+	t.Run("RejectedDomainForbiddenFromInbox", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, a := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		// Run
+		b, err := a.AuthenticateGetInbox(ctx, resp, signed("https://rejected.example/dakota#main-key"))
+		// Verify
+		assertEqual(t, b, false)
+		assertEqual(t, err, nil)
+		assertEqual(t, resp.Code, http.StatusForbidden)
+	})
+	t.Run("RejectedDomainForbiddenFromOutbox", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, a := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		// Run
+		b, err := a.AuthenticateGetOutbox(ctx, resp, signed("https://rejected.example/dakota#main-key"))
+		// Verify
+		assertEqual(t, b, false)
+		assertEqual(t, err, nil)
+		assertEqual(t, resp.Code, http.StatusForbidden)
+	})
+	t.Run("OtherDomainAuthenticated", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		c, a := setupFn(ctl)
+		resp := httptest.NewRecorder()
+		req := signed(testFederatedActorIRI + "#main-key")
+		c.EXPECT().AuthenticateGetInbox(ctx, resp, req).Return(true, nil)
+		// Run
+		b, err := a.AuthenticateGetInbox(ctx, resp, req)
+		// Verify
+		assertEqual(t, b, true)
+		assertEqual(t, err, nil)
+	})
+}
+
+// TestInstancePolicyRefusedDeliveryNotAnError ensures recipients the
+// InstancePolicy refuses to deliver to are reported, but do not fail the
+// delivery.
+func TestInstancePolicyRefusedDeliveryNotAnError(t *testing.T) {
+	// Setup
+	ctx := context.Background()
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	setupData()
+	c := NewMockCommonBehavior(ctl)
+	fp := NewMockFederatingProtocol(ctl)
+	mt := NewMockTransport(ctl)
+	clock := NewMockClock(ctl)
+	p, err := NewInstancePolicy(InstancePolicyConfig{
+		Rules: []DomainRule{{Domain: "quiet.example", Severity: DomainNoDeliver}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &sideEffectActor{
+		common: c,
+		s2s:    fp,
+		clock:  clock,
+	}
+	outboxIRI := mustParse(testMyOutboxIRI)
+	quiet := mustParse("https://quiet.example/inbox")
+	open := mustParse(testFederatedActorIRI + "/inbox")
+	var report DeliveryReport
+	fp.EXPECT().DeliveryQueue(ctx).Return(nil)
+	c.EXPECT().NewTransport(ctx, outboxIRI, goFedUserAgent()).Return(NewInstancePolicyTransport(mt, p), nil)
+	clock.EXPECT().Now().Return(now()).AnyTimes()
+	mt.EXPECT().Deliver(ctx, gomock.Any(), open).Return(nil)
+	fp.EXPECT().ReportDelivery(ctx, gomock.Any()).Do(func(c context.Context, r DeliveryReport) {
+		report = r
+	})
+	// Run
+	err = a.deliverToRecipients(ctx, outboxIRI, testCreate, []*url.URL{quiet, open})
+	// Verify
+	assertEqual(t, err, nil)
+	assertEqual(t, report.Delivered(), 1)
+	assertEqual(t, len(report.Failed()), 1)
+	assertEqual(t, report.Failed()[0].Class, PolicyDeliveryError)
+}
@@ -0,0 +1,457 @@
+package pub
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/go-fed/httpsig"
+	"net/url"
+	"time"
+)
+
+const (
+	// publicKeyProperty is the property of an actor listing its public
+	// keys, which is not part of the ActivityStreams vocabulary.
+	publicKeyProperty = "publicKey"
+	// assertionMethodProperty is the property of an actor listing its
+	// public keys as Multikeys, which is not part of the ActivityStreams
+	// vocabulary.
+	assertionMethodProperty = "assertionMethod"
+	// base58Alphabet is the alphabet of the base58btc encoding.
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	// defaultRSABits is the size of generated RSA keys.
+	defaultRSABits = 2048
+)
+
+// KeyType is the type of an actor's key pair.
+type KeyType string
+
+const (
+	// RSAKey is an RSA key pair, which signs HTTP Signatures with the
+	// "rsa-sha256" algorithm understood throughout the Fediverse.
+	RSAKey KeyType = "RSA"
+	// Ed25519Key is an Ed25519 key pair. It is published with the actor
+	// as a Multikey, but the HttpSigTransport does not sign with it.
+	Ed25519Key KeyType = "Ed25519"
+)
+
+// ActorKey is a key pair of an actor on this server.
+type ActorKey struct {
+	// Id identifies the key, and is the 'keyId' of the HTTP Signatures it
+	// makes. It is the actor's IRI with a unique fragment.
+	Id *url.URL
+	// Owner is the id of the actor owning the key.
+	Owner *url.URL
+	// Type is the type of the key pair.
+	Type KeyType
+	// PrivateKey is the private key, either a *rsa.PrivateKey or an
+	// ed25519.PrivateKey.
+	PrivateKey crypto.PrivateKey
+	// Created is when the key pair was generated.
+	Created time.Time
+}
+
+// PublicKey returns the public key of the key pair.
+func (k *ActorKey) PublicKey() crypto.PublicKey {
+	switch pk := k.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return pk.Public()
+	case ed25519.PrivateKey:
+		return pk.Public()
+	}
+	return nil
+}
+
+// PublicKeyPem returns the PEM encoded PKIX public key of the key pair.
+func (k *ActorKey) PublicKeyPem() (string, error) {
+	b, err := x509.MarshalPKIXPublicKey(k.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b})), nil
+}
+
+// publicKeyMultibase returns the public key of an Ed25519 key pair as a
+// Multikey: the multicodec prefix of Ed25519 public keys followed by the key,
+// encoded in base58btc with its multibase prefix.
+func (k *ActorKey) publicKeyMultibase() (string, error) {
+	pk, ok := k.PublicKey().(ed25519.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("cannot encode %s key as a Multikey", k.Type)
+	}
+	return "z" + base58Encode(append([]byte{0xed, 0x01}, pk...)), nil
+}
+
+// canSignHttp returns whether the HttpSigTransport is able to sign requests
+// with the key.
+func (k *ActorKey) canSignHttp() bool {
+	return k.Type == RSAKey
+}
+
+// KeyStore persists the key pairs of the actors on this server for a
+// KeyManager. The private keys must be kept secret.
+type KeyStore interface {
+	// GetKeys returns the key pairs of the actor, newest first. An actor
+	// without keys has none, with a nil error.
+	GetKeys(c context.Context, actorIRI *url.URL) (keys []*ActorKey, err error)
+	// SetKeys replaces the key pairs of the actor, which are newest first.
+	SetKeys(c context.Context, actorIRI *url.URL, keys []*ActorKey) error
+}
+
+// KeyManager generates, publishes, and rotates the key pairs that actors on
+// this server sign requests with.
+//
+// The RSA keys that HTTP Signatures are made with are published in the
+// 'publicKey' property of an actor, as a value with 'id', 'owner', and
+// 'publicKeyPem', which is a list when the actor has more than one, newest
+// first. Peers reading only the first 'publicKey' thus find the signing key.
+// Ed25519 keys are published as Multikeys in the 'assertionMethod' property
+// instead. It is also a PublicKeyLookup, so that an HttpSigVerifier knows
+// the keys of this server's actors without dereferencing them.
+type KeyManager struct {
+	// RSABits is the size of the RSA keys that are generated.
+	RSABits int
+
+	store KeyStore
+	db    Database
+	clock Clock
+}
+
+// KeyManager must be a PublicKeyLookup.
+var _ PublicKeyLookup = &KeyManager{}
+
+// NewKeyManager returns a KeyManager storing keys in the KeyStore, and
+// publishing them with the actors in the Database.
+func NewKeyManager(store KeyStore, db Database, clock Clock) *KeyManager {
+	return &KeyManager{
+		RSABits: defaultRSABits,
+		store:   store,
+		db:      db,
+		clock:   clock,
+	}
+}
+
+// Generate creates a new key pair of the type for the actor, and stores it as
+// the actor's newest key. It does not publish the key; use Publish before
+// storing a new actor, or Rotate for an existing one.
+func (m *KeyManager) Generate(c context.Context, actorIRI *url.URL, keyType KeyType) (key *ActorKey, err error) {
+	key, err = m.newKey(actorIRI, keyType)
+	if err != nil {
+		return
+	}
+	keys, err := m.store.GetKeys(c, actorIRI)
+	if err != nil {
+		return
+	}
+	err = m.store.SetKeys(c, actorIRI, append([]*ActorKey{key}, keys...))
+	return
+}
+
+// SigningKey returns the newest key of the actor that HTTP Signatures are
+// made with.
+func (m *KeyManager) SigningKey(c context.Context, actorIRI *url.URL) (*ActorKey, error) {
+	keys, err := m.store.GetKeys(c, actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.canSignHttp() {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("actor %s has no key to sign HTTP Signatures", actorIRI)
+}
+
+// Publish sets the 'publicKey' property of the actor to its stored keys. The
+// actor must have an 'id'.
+func (m *KeyManager) Publish(c context.Context, actor vocab.Type) error {
+	actorIRI, err := GetId(actor)
+	if err != nil {
+		return err
+	}
+	keys, err := m.store.GetKeys(c, actorIRI)
+	if err != nil {
+		return err
+	}
+	return setPublicKeys(actor, keys)
+}
+
+// Rotate replaces the keys of the type of the actor owning the outbox with a
+// newly generated one, publishes the new key in the actor stored in the
+// Database, and federates an Update of the actor so that peers no longer
+// accept the replaced keys.
+//
+// The Update is addressed to the Public collection and to the followers of the
+// actor, and is sent with the FederatingActor.
+func (m *KeyManager) Rotate(c context.Context, sender FederatingActor, outboxIRI *url.URL, keyType KeyType) (key *ActorKey, err error) {
+	err = m.db.Lock(c, outboxIRI)
+	if err != nil {
+		return
+	}
+	// WARNING: Unlock not deferred
+	actorIRI, err := m.db.ActorForOutbox(c, outboxIRI)
+	m.db.Unlock(c, outboxIRI)
+	if err != nil {
+		return
+	}
+	// Unlock must have been called by this point and in every branch
+	// above.
+	key, err = m.newKey(actorIRI, keyType)
+	if err != nil {
+		return
+	}
+	keys, err := m.store.GetKeys(c, actorIRI)
+	if err != nil {
+		return
+	}
+	rotated := []*ActorKey{key}
+	for _, k := range keys {
+		if k.Type != keyType {
+			rotated = append(rotated, k)
+		}
+	}
+	if err = m.store.SetKeys(c, actorIRI, rotated); err != nil {
+		return
+	}
+	actor, err := m.publishStored(c, actorIRI, rotated)
+	if err != nil {
+		return
+	}
+	update, err := newActorUpdate(actorIRI, actor)
+	if err != nil {
+		return
+	}
+	_, err = sender.Send(c, outboxIRI, update)
+	return
+}
+
+// LookupPublicKey returns the public key of an actor on this server, or nil if
+// the key is not stored.
+func (m *KeyManager) LookupPublicKey(c context.Context, keyId *url.URL) (*PublicKey, error) {
+	actorIRI := *keyId
+	actorIRI.Fragment = ""
+	keys, err := m.store.GetKeys(c, &actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.Id.String() == keyId.String() {
+			return &PublicKey{
+				Id:    k.Id,
+				Owner: k.Owner,
+				Key:   k.PublicKey(),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// TransportFunc returns a function creating HttpSigTransports that sign
+// requests with the signing key of the actor owning the inbox or outbox. It is
+// suitable to be called by an application's CommonBehavior NewTransport method.
+//
+// GET requests sign the "(request-target)", "host", and "date" headers, and
+// POST requests also sign the "digest" header.
+func (m *KeyManager) TransportFunc(client HttpClient, appAgent string, limiter *DeliveryLimiter) func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (Transport, error) {
+	return func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (Transport, error) {
+		actorIRI, err := m.actorForBox(c, actorBoxIRI)
+		if err != nil {
+			return nil, err
+		}
+		key, err := m.SigningKey(c, actorIRI)
+		if err != nil {
+			return nil, err
+		}
+		algs := []httpsig.Algorithm{httpsig.RSA_SHA256}
+		getSigner, _, err := httpsig.NewSigner(algs, []string{httpsig.RequestTarget, "host", "date"}, httpsig.Signature)
+		if err != nil {
+			return nil, err
+		}
+		postSigner, _, err := httpsig.NewSigner(algs, []string{httpsig.RequestTarget, "host", "date", "digest"}, httpsig.Signature)
+		if err != nil {
+			return nil, err
+		}
+		return NewHttpSigTransport(client, appAgent, m.clock, getSigner, postSigner, key.Id.String(), key.PrivateKey, limiter), nil
+	}
+}
+
+// newKey generates a key pair of the type for the actor, with a random
+// fragment in its id.
+func (m *KeyManager) newKey(actorIRI *url.URL, keyType KeyType) (*ActorKey, error) {
+	var priv crypto.PrivateKey
+	var err error
+	switch keyType {
+	case RSAKey:
+		priv, err = rsa.GenerateKey(rand.Reader, m.RSABits)
+	case Ed25519Key:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unknown key type %q", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := *actorIRI
+	id.Fragment = "key-" + hex.EncodeToString(b)
+	return &ActorKey{
+		Id:         &id,
+		Owner:      actorIRI,
+		Type:       keyType,
+		PrivateKey: priv,
+		Created:    m.clock.Now(),
+	}, nil
+}
+
+// publishStored sets the 'publicKey' property of the actor in the Database to
+// the keys, and returns the updated actor.
+func (m *KeyManager) publishStored(c context.Context, actorIRI *url.URL, keys []*ActorKey) (actor vocab.Type, err error) {
+	err = m.db.Lock(c, actorIRI)
+	if err != nil {
+		return
+	}
+	defer m.db.Unlock(c, actorIRI)
+	actor, err = m.db.Get(c, actorIRI)
+	if err != nil {
+		return
+	}
+	if err = setPublicKeys(actor, keys); err != nil {
+		return
+	}
+	err = m.db.Update(c, actor)
+	return
+}
+
+// actorForBox returns the actor owning the outbox or inbox.
+func (m *KeyManager) actorForBox(c context.Context, boxIRI *url.URL) (actorIRI *url.URL, err error) {
+	err = m.db.Lock(c, boxIRI)
+	if err != nil {
+		return
+	}
+	defer m.db.Unlock(c, boxIRI)
+	actorIRI, err = m.db.ActorForOutbox(c, boxIRI)
+	if err != nil || actorIRI == nil {
+		actorIRI, err = m.db.ActorForInbox(c, boxIRI)
+	}
+	return
+}
+
+// setPublicKeys sets the 'publicKey' property of the actor to the keys that
+// sign HTTP Signatures, and its 'assertionMethod' property to the others.
+func setPublicKeys(actor vocab.Type, keys []*ActorKey) error {
+	u, ok := actor.(unknownPropertieser)
+	if !ok || u.GetUnknownProperties() == nil {
+		return fmt.Errorf("cannot set publicKey on %T", actor)
+	}
+	var blocks, methods []interface{}
+	for _, k := range keys {
+		if !k.canSignHttp() {
+			mb, err := k.publicKeyMultibase()
+			if err != nil {
+				return err
+			}
+			methods = append(methods, map[string]interface{}{
+				"id":                 k.Id.String(),
+				"type":               "Multikey",
+				"controller":         k.Owner.String(),
+				"publicKeyMultibase": mb,
+			})
+			continue
+		}
+		p, err := k.PublicKeyPem()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"id":           k.Id.String(),
+			"owner":        k.Owner.String(),
+			"publicKeyPem": p,
+		})
+	}
+	switch len(blocks) {
+	case 0:
+		delete(u.GetUnknownProperties(), publicKeyProperty)
+	case 1:
+		u.GetUnknownProperties()[publicKeyProperty] = blocks[0]
+	default:
+		u.GetUnknownProperties()[publicKeyProperty] = blocks
+	}
+	if len(methods) == 0 {
+		delete(u.GetUnknownProperties(), assertionMethodProperty)
+	} else {
+		u.GetUnknownProperties()[assertionMethodProperty] = methods
+	}
+	return nil
+}
+
+// base58Encode encodes the bytes in base58 with the Bitcoin alphabet.
+func base58Encode(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+	// Digits of the number in base 58, least significant first.
+	var digits []byte
+	for _, v := range b[zeros:] {
+		carry := int(v)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+	s := make([]byte, zeros+len(digits))
+	for i := 0; i < zeros; i++ {
+		s[i] = base58Alphabet[0]
+	}
+	for i, d := range digits {
+		s[len(s)-1-i] = base58Alphabet[d]
+	}
+	return string(s)
+}
+
+// newActorUpdate returns an Update of the actor, by the actor, addressed to the
+// Public collection and to its followers.
+func newActorUpdate(actorIRI *url.URL, actor vocab.Type) (vocab.ActivityStreamsUpdate, error) {
+	public, err := url.Parse(PublicActivityPubIRI)
+	if err != nil {
+		return nil, err
+	}
+	update := streams.NewActivityStreamsUpdate()
+	actorProp := streams.NewActivityStreamsActorProperty()
+	actorProp.AppendIRI(actorIRI)
+	update.SetActivityStreamsActor(actorProp)
+	op := streams.NewActivityStreamsObjectProperty()
+	op.AppendType(actor)
+	update.SetActivityStreamsObject(op)
+	to := streams.NewActivityStreamsToProperty()
+	to.AppendIRI(public)
+	update.SetActivityStreamsTo(to)
+	if f, ok := actor.(followerser); ok {
+		if fp := f.GetActivityStreamsFollowers(); fp != nil {
+			id, err := ToId(fp)
+			if err != nil {
+				return nil, err
+			}
+			cc := streams.NewActivityStreamsCcProperty()
+			cc.AppendIRI(id)
+			update.SetActivityStreamsCc(cc)
+		}
+	}
+	return update, nil
+}
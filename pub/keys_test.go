@@ -0,0 +1,275 @@
+package pub
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/go-fed/httpsig"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// sendRecorder is a FederatingActor recording what it is asked to send.
+type sendRecorder struct {
+	Actor
+	outboxIRI *url.URL
+	sent      vocab.Type
+}
+
+// Send records the value.
+func (s *sendRecorder) Send(c context.Context, outboxIRI *url.URL, t vocab.Type) (Activity, error) {
+	s.outboxIRI = outboxIRI
+	s.sent = t
+	return nil, nil
+}
+
+// TestBase58Encode ensures bytes are encoded in base58btc, keeping leading
+// zeros.
+func TestBase58Encode(t *testing.T) {
+	tests := []struct {
+		in       []byte
+		expected string
+	}{
+		{[]byte{}, ""},
+		{[]byte{0}, "1"},
+		{[]byte{0, 0, 1}, "112"},
+		{[]byte("hello world"), "StV1DL6CwTryKyV"},
+	}
+	for _, test := range tests {
+		assertEqual(t, base58Encode(test.in), test.expected)
+	}
+}
+
+// TestKeyManager ensures actor keys are generated, published, rotated, and
+// used to sign requests.
+func TestKeyManager(t *testing.T) {
+	ctx := context.Background()
+	actorIRI := mustParse("https://example.com/addison")
+	setupFn := func(ctl *gomock.Controller) (store *MockKeyStore, db *MockDatabase, m *KeyManager) {
+		store = NewMockKeyStore(ctl)
+		db = NewMockDatabase(ctl)
+		clock := NewMockClock(ctl)
+		clock.EXPECT().Now().Return(now()).AnyTimes()
+		m = NewKeyManager(store, db, clock)
+		m.RSABits = 1024
+		return
+	}
+	newKey := func(m *KeyManager, keyType KeyType) *ActorKey {
+		k, err := m.newKey(actorIRI, keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	person := func() vocab.ActivityStreamsPerson {
+		p := streams.NewActivityStreamsPerson()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(actorIRI)
+		p.SetActivityStreamsId(id)
+		return p
+	}
+	t.Run("GenerateStoresNewestFirst", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		store, _, m := setupFn(ctl)
+		old := newKey(m, RSAKey)
+		var stored []*ActorKey
+		store.EXPECT().GetKeys(ctx, actorIRI).Return([]*ActorKey{old}, nil)
+		store.EXPECT().SetKeys(ctx, actorIRI, gomock.Any()).DoAndReturn(func(c context.Context, iri *url.URL, keys []*ActorKey) error {
+			stored = keys
+			return nil
+		})
+		// Run
+		k, err := m.Generate(ctx, actorIRI, Ed25519Key)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, k.Type, Ed25519Key)
+		assertEqual(t, k.Owner, actorIRI)
+		assertEqual(t, strings.HasPrefix(k.Id.String(), actorIRI.String()+"#key-"), true)
+		assertEqual(t, len(stored), 2)
+		assertEqual(t, stored[0], k)
+		assertEqual(t, stored[1], old)
+	})
+	t.Run("PublishSetsPublicKey", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		store, _, m := setupFn(ctl)
+		k := newKey(m, RSAKey)
+		store.EXPECT().GetKeys(ctx, actorIRI).Return([]*ActorKey{k}, nil)
+		p := person()
+		// Run
+		err := m.Publish(ctx, p)
+		// Verify
+		assertEqual(t, err, nil)
+		js, err := Serialize(p)
+		assertEqual(t, err, nil)
+		block := js["publicKey"].(map[string]interface{})
+		assertEqual(t, block["id"], k.Id.String())
+		assertEqual(t, block["owner"], actorIRI.String())
+		pk, err := publicKeyFromJSON(js, k.Id)
+		assertEqual(t, err, nil)
+		assertEqual(t, pk.Owner.String(), actorIRI.String())
+	})
+	t.Run("PublishListsSeveralKeysNewestFirst", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		store, _, m := setupFn(ctl)
+		newer, older := newKey(m, RSAKey), newKey(m, RSAKey)
+		store.EXPECT().GetKeys(ctx, actorIRI).Return([]*ActorKey{newer, older}, nil)
+		p := person()
+		// Run
+		err := m.Publish(ctx, p)
+		// Verify
+		assertEqual(t, err, nil)
+		js, err := Serialize(p)
+		assertEqual(t, err, nil)
+		blocks := js["publicKey"].([]interface{})
+		assertEqual(t, len(blocks), 2)
+		assertEqual(t, blocks[0].(map[string]interface{})["id"], newer.Id.String())
+		_, err = publicKeyFromJSON(js, older.Id)
+		assertEqual(t, err, nil)
+	})
+	t.Run("PublishEd25519KeysAsAssertionMethods", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		store, _, m := setupFn(ctl)
+		rsaKey, edKey := newKey(m, RSAKey), newKey(m, Ed25519Key)
+		store.EXPECT().GetKeys(ctx, actorIRI).Return([]*ActorKey{edKey, rsaKey}, nil)
+		p := person()
+		// Run
+		err := m.Publish(ctx, p)
+		// Verify
+		assertEqual(t, err, nil)
+		js, err := Serialize(p)
+		assertEqual(t, err, nil)
+		block := js["publicKey"].(map[string]interface{})
+		assertEqual(t, block["id"], rsaKey.Id.String())
+		methods := js["assertionMethod"].([]interface{})
+		assertEqual(t, len(methods), 1)
+		method := methods[0].(map[string]interface{})
+		assertEqual(t, method["id"], edKey.Id.String())
+		assertEqual(t, method["type"], "Multikey")
+		assertEqual(t, method["controller"], actorIRI.String())
+		assertEqual(t, strings.HasPrefix(method["publicKeyMultibase"].(string), "z6Mk"), true)
+	})
+	t.Run("SigningKeySkipsEd25519", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		store, _, m := setupFn(ctl)
+		rsaKey, edKey := newKey(m, RSAKey), newKey(m, Ed25519Key)
+		store.EXPECT().GetKeys(ctx, actorIRI).Return([]*ActorKey{edKey, rsaKey}, nil)
+		// Run
+		k, err := m.SigningKey(ctx, actorIRI)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, k, rsaKey)
+	})
+	t.Run("LookupPublicKey", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		store, _, m := setupFn(ctl)
+		k := newKey(m, RSAKey)
+		store.EXPECT().GetKeys(ctx, actorIRI).Return([]*ActorKey{k}, nil).Times(2)
+		// Run
+		found, err := m.LookupPublicKey(ctx, k.Id)
+		missing, missingErr := m.LookupPublicKey(ctx, mustParse(actorIRI.String()+"#other"))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, found.Id, k.Id)
+		assertEqual(t, found.Owner, actorIRI)
+		assertEqual(t, missingErr, nil)
+		assertEqual(t, missing == nil, true)
+	})
+	t.Run("RotateReplacesKeyAndSendsUpdate", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		store, db, m := setupFn(ctl)
+		oldRSA, edKey := newKey(m, RSAKey), newKey(m, Ed25519Key)
+		var stored []*ActorKey
+		p := person()
+		followers := streams.NewActivityStreamsFollowersProperty()
+		followers.SetIRI(mustParse("https://example.com/addison/followers"))
+		p.SetActivityStreamsFollowers(followers)
+		outboxIRI := mustParse(testMyOutboxIRI)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(actorIRI, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			store.EXPECT().GetKeys(ctx, actorIRI).Return([]*ActorKey{oldRSA, edKey}, nil),
+			store.EXPECT().SetKeys(ctx, actorIRI, gomock.Any()).DoAndReturn(func(c context.Context, iri *url.URL, keys []*ActorKey) error {
+				stored = keys
+				return nil
+			}),
+			db.EXPECT().Lock(ctx, actorIRI),
+			db.EXPECT().Get(ctx, actorIRI).Return(p, nil),
+			db.EXPECT().Update(ctx, p).Return(nil),
+			db.EXPECT().Unlock(ctx, actorIRI),
+		)
+		sender := &sendRecorder{}
+		// Run
+		k, err := m.Rotate(ctx, sender, outboxIRI, RSAKey)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(stored), 2)
+		assertEqual(t, stored[0], k)
+		assertEqual(t, stored[1], edKey)
+		assertEqual(t, sender.outboxIRI, outboxIRI)
+		update, ok := sender.sent.(vocab.ActivityStreamsUpdate)
+		assertEqual(t, ok, true)
+		js, err := Serialize(update)
+		assertEqual(t, err, nil)
+		assertEqual(t, js["to"], PublicActivityPubIRI)
+		assertEqual(t, js["cc"], "https://example.com/addison/followers")
+		obj := js["object"].(map[string]interface{})
+		_, err = publicKeyFromJSON(obj, k.Id)
+		assertEqual(t, err, nil)
+		_, err = publicKeyFromJSON(obj, oldRSA.Id)
+		assertNotEqual(t, err, nil)
+	})
+	t.Run("TransportSignsWithSigningKey", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		store, db, m := setupFn(ctl)
+		k := newKey(m, RSAKey)
+		inboxIRI := mustParse(testMyInboxIRI)
+		client := NewMockHttpClient(ctl)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, inboxIRI).Return(nil, testErr),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(actorIRI, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			store.EXPECT().GetKeys(ctx, actorIRI).Return([]*ActorKey{k}, nil),
+		)
+		var req *http.Request
+		client.EXPECT().Do(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+			req = r
+			return &http.Response{
+				StatusCode: http.StatusAccepted,
+				Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			}, nil
+		})
+		// Run
+		tp, err := m.TransportFunc(client, "myApp", nil)(ctx, inboxIRI, goFedUserAgent())
+		assertEqual(t, err, nil)
+		err = tp.Deliver(ctx, []byte(`{"type":"Create"}`), mustParse(testFederatedActorIRI))
+		// Verify
+		assertEqual(t, err, nil)
+		v, err := httpsig.NewVerifier(req)
+		assertEqual(t, err, nil)
+		assertEqual(t, v.KeyId(), k.Id.String())
+		assertEqual(t, v.Verify(k.PublicKey(), httpsig.RSA_SHA256), nil)
+	})
+}
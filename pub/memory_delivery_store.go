@@ -0,0 +1,80 @@
+package pub
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryDeliveryStore must satisfy the DeliveryStore interface.
+var _ DeliveryStore = &memoryDeliveryStore{}
+
+// memoryDeliveryStore is a DeliveryStore that keeps pending deliveries in
+// memory only.
+type memoryDeliveryStore struct {
+	mu      sync.Mutex
+	pending map[string]PendingDelivery
+}
+
+// NewMemoryDeliveryStore returns a DeliveryStore that keeps pending deliveries
+// in memory.
+//
+// Pending deliveries are lost when the application stops, so it is only
+// suitable for tests and applications that can tolerate losing them.
+// Abandoned deliveries are discarded.
+func NewMemoryDeliveryStore() DeliveryStore {
+	return &memoryDeliveryStore{
+		pending: make(map[string]PendingDelivery),
+	}
+}
+
+// Push saves new pending deliveries.
+func (m *memoryDeliveryStore) Push(c context.Context, d []PendingDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, elem := range d {
+		m.pending[elem.Id] = elem
+	}
+	return nil
+}
+
+// Due returns the earliest pending deliveries that are due.
+func (m *memoryDeliveryStore) Due(c context.Context, now time.Time, max int) ([]PendingDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []PendingDelivery
+	for _, elem := range m.pending {
+		if !elem.NextAttempt.After(now) {
+			due = append(due, elem)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+	if max > 0 && len(due) > max {
+		due = due[:max]
+	}
+	return due, nil
+}
+
+// Reschedule saves the updated pending delivery.
+func (m *memoryDeliveryStore) Reschedule(c context.Context, d PendingDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[d.Id] = d
+	return nil
+}
+
+// Complete removes the pending delivery.
+func (m *memoryDeliveryStore) Complete(c context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, id)
+	return nil
+}
+
+// Abandon removes the pending delivery.
+func (m *memoryDeliveryStore) Abandon(c context.Context, d PendingDelivery, cause error) error {
+	return m.Complete(c, d.Id)
+}
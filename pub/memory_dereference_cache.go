@@ -0,0 +1,90 @@
+package pub
+
+import (
+	"container/list"
+	"context"
+	"net/url"
+	"sync"
+)
+
+// defaultMemoryDereferenceCacheSize is the number of values cached when a
+// CachingTransport is not given a DereferenceCache.
+const defaultMemoryDereferenceCacheSize = 1024
+
+// memoryDereferenceCache must satisfy the DereferenceCache interface.
+var _ DereferenceCache = &memoryDereferenceCache{}
+
+// memoryDereferenceCache is a DereferenceCache that keeps a bounded number of
+// values in memory, evicting the least recently used ones first.
+type memoryDereferenceCache struct {
+	max int
+	mu  sync.Mutex
+	// lru holds the *memoryDereferenceEntry values, the most recently used
+	// at the front.
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// memoryDereferenceEntry is an element of the memoryDereferenceCache lru.
+type memoryDereferenceEntry struct {
+	key   string
+	entry CachedDereference
+}
+
+// NewMemoryDereferenceCache returns a DereferenceCache that keeps up to
+// maxEntries values in memory, evicting the least recently used values first.
+// A zero or negative maxEntries does not bound the cache.
+func NewMemoryDereferenceCache(maxEntries int) DereferenceCache {
+	return &memoryDereferenceCache{
+		max:     maxEntries,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the entry for the IRI, if there is one.
+func (m *memoryDereferenceCache) Get(c context.Context, iri *url.URL) (entry CachedDereference, found bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[iri.String()]
+	if !ok {
+		return
+	}
+	m.lru.MoveToFront(e)
+	return e.Value.(*memoryDereferenceEntry).entry, true, nil
+}
+
+// Set saves the entry for the IRI, evicting the least recently used entry if
+// the cache is full.
+func (m *memoryDereferenceCache) Set(c context.Context, iri *url.URL, entry CachedDereference) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := iri.String()
+	if e, ok := m.entries[key]; ok {
+		e.Value.(*memoryDereferenceEntry).entry = entry
+		m.lru.MoveToFront(e)
+		return nil
+	}
+	m.entries[key] = m.lru.PushFront(&memoryDereferenceEntry{
+		key:   key,
+		entry: entry,
+	})
+	if m.max > 0 && m.lru.Len() > m.max {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryDereferenceEntry).key)
+	}
+	return nil
+}
+
+// Delete removes the entry for the IRI, if there is one.
+func (m *memoryDereferenceCache) Delete(c context.Context, iri *url.URL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := iri.String()
+	if e, ok := m.entries[key]; ok {
+		m.lru.Remove(e)
+		delete(m.entries, key)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/url"
+	"sync"
+)
+
+// memoryPendingFollowStore must satisfy the PendingFollowStore interface.
+var _ PendingFollowStore = &memoryPendingFollowStore{}
+
+// memoryPendingFollowStore is a PendingFollowStore that keeps pending Follow
+// requests in memory only.
+type memoryPendingFollowStore struct {
+	mu sync.Mutex
+	// pending maps actor IRIs to their pending Follow requests, oldest
+	// first.
+	pending map[string][]vocab.ActivityStreamsFollow
+}
+
+// NewMemoryPendingFollowStore returns a PendingFollowStore that keeps pending
+// Follow requests in memory.
+//
+// Pending Follow requests are lost when the application stops, so it is only
+// suitable for tests and applications that can tolerate losing them.
+func NewMemoryPendingFollowStore() PendingFollowStore {
+	return &memoryPendingFollowStore{
+		pending: make(map[string][]vocab.ActivityStreamsFollow),
+	}
+}
+
+// AddPendingFollow saves the Follow request, replacing one with the same id.
+func (m *memoryPendingFollowStore) AddPendingFollow(c context.Context, actorIRI *url.URL, follow vocab.ActivityStreamsFollow) error {
+	id, err := GetId(follow)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	follows := m.pending[actorIRI.String()]
+	if i := indexOfFollow(follows, id); i >= 0 {
+		follows[i] = follow
+		return nil
+	}
+	m.pending[actorIRI.String()] = append(follows, follow)
+	return nil
+}
+
+// GetPendingFollow returns the Follow request with the id, if any.
+func (m *memoryPendingFollowStore) GetPendingFollow(c context.Context, actorIRI, followIRI *url.URL) (vocab.ActivityStreamsFollow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	follows := m.pending[actorIRI.String()]
+	if i := indexOfFollow(follows, followIRI); i >= 0 {
+		return follows[i], nil
+	}
+	return nil, nil
+}
+
+// PendingFollows returns a copy of the Follow requests, oldest first.
+func (m *memoryPendingFollowStore) PendingFollows(c context.Context, actorIRI *url.URL) ([]vocab.ActivityStreamsFollow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	follows := m.pending[actorIRI.String()]
+	return append([]vocab.ActivityStreamsFollow(nil), follows...), nil
+}
+
+// RemovePendingFollow removes the Follow request with the id, if any.
+func (m *memoryPendingFollowStore) RemovePendingFollow(c context.Context, actorIRI, followIRI *url.URL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	follows := m.pending[actorIRI.String()]
+	i := indexOfFollow(follows, followIRI)
+	if i < 0 {
+		return nil
+	}
+	follows = append(follows[:i], follows[i+1:]...)
+	if len(follows) == 0 {
+		delete(m.pending, actorIRI.String())
+	} else {
+		m.pending[actorIRI.String()] = follows
+	}
+	return nil
+}
+
+// indexOfFollow returns the index of the Follow with the id, or -1.
+func indexOfFollow(follows []vocab.ActivityStreamsFollow, id *url.URL) int {
+	for i, f := range follows {
+		if fid, err := GetId(f); err == nil && fid.String() == id.String() {
+			return i
+		}
+	}
+	return -1
+}
@@ -45,3 +45,54 @@ func (mr *MockClockMockRecorder) Now() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Now", reflect.TypeOf((*MockClock)(nil).Now))
 }
+
+// MockTimerClock is a mock of TimerClock interface
+type MockTimerClock struct {
+	ctrl     *gomock.Controller
+	recorder *MockTimerClockMockRecorder
+}
+
+// MockTimerClockMockRecorder is the mock recorder for MockTimerClock
+type MockTimerClockMockRecorder struct {
+	mock *MockTimerClock
+}
+
+// NewMockTimerClock creates a new mock instance
+func NewMockTimerClock(ctrl *gomock.Controller) *MockTimerClock {
+	mock := &MockTimerClock{ctrl: ctrl}
+	mock.recorder = &MockTimerClockMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockTimerClock) EXPECT() *MockTimerClockMockRecorder {
+	return m.recorder
+}
+
+// Now mocks base method
+func (m *MockTimerClock) Now() time.Time {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Now")
+	ret0, _ := ret[0].(time.Time)
+	return ret0
+}
+
+// Now indicates an expected call of Now
+func (mr *MockTimerClockMockRecorder) Now() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Now", reflect.TypeOf((*MockTimerClock)(nil).Now))
+}
+
+// After mocks base method
+func (m *MockTimerClock) After(d time.Duration) <-chan time.Time {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "After", d)
+	ret0, _ := ret[0].(<-chan time.Time)
+	return ret0
+}
+
+// After indicates an expected call of After
+func (mr *MockTimerClockMockRecorder) After(d interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "After", reflect.TypeOf((*MockTimerClock)(nil).After), d)
+}
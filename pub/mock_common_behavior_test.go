@@ -79,3 +79,17 @@ func (mr *MockCommonBehaviorMockRecorder) NewTransport(c, actorBoxIRI, gofedAgen
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewTransport", reflect.TypeOf((*MockCommonBehavior)(nil).NewTransport), c, actorBoxIRI, gofedAgent)
 }
+
+// Visibility mocks base method
+func (m *MockCommonBehavior) Visibility(c context.Context) *VisibilityPolicy {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Visibility", c)
+	ret0, _ := ret[0].(*VisibilityPolicy)
+	return ret0
+}
+
+// Visibility indicates an expected call of Visibility
+func (mr *MockCommonBehaviorMockRecorder) Visibility(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Visibility", reflect.TypeOf((*MockCommonBehavior)(nil).Visibility), c)
+}
@@ -78,33 +78,33 @@ func (mr *MockDatabaseMockRecorder) InboxContains(c, inbox, id interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InboxContains", reflect.TypeOf((*MockDatabase)(nil).InboxContains), c, inbox, id)
 }
 
-// GetInbox mocks base method
-func (m *MockDatabase) GetInbox(c context.Context, inboxIRI *url.URL) (vocab.ActivityStreamsOrderedCollectionPage, error) {
+// PrependInboxItem mocks base method
+func (m *MockDatabase) PrependInboxItem(c context.Context, inboxIRI, item *url.URL) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetInbox", c, inboxIRI)
-	ret0, _ := ret[0].(vocab.ActivityStreamsOrderedCollectionPage)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "PrependInboxItem", c, inboxIRI, item)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// GetInbox indicates an expected call of GetInbox
-func (mr *MockDatabaseMockRecorder) GetInbox(c, inboxIRI interface{}) *gomock.Call {
+// PrependInboxItem indicates an expected call of PrependInboxItem
+func (mr *MockDatabaseMockRecorder) PrependInboxItem(c, inboxIRI, item interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInbox", reflect.TypeOf((*MockDatabase)(nil).GetInbox), c, inboxIRI)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrependInboxItem", reflect.TypeOf((*MockDatabase)(nil).PrependInboxItem), c, inboxIRI, item)
 }
 
-// SetInbox mocks base method
-func (m *MockDatabase) SetInbox(c context.Context, inbox vocab.ActivityStreamsOrderedCollectionPage) error {
+// GetInboxPage mocks base method
+func (m *MockDatabase) GetInboxPage(c context.Context, inboxIRI *url.URL, q BoxPageQuery) (BoxPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetInbox", c, inbox)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "GetInboxPage", c, inboxIRI, q)
+	ret0, _ := ret[0].(BoxPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// SetInbox indicates an expected call of SetInbox
-func (mr *MockDatabaseMockRecorder) SetInbox(c, inbox interface{}) *gomock.Call {
+// GetInboxPage indicates an expected call of GetInboxPage
+func (mr *MockDatabaseMockRecorder) GetInboxPage(c, inboxIRI, q interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetInbox", reflect.TypeOf((*MockDatabase)(nil).SetInbox), c, inbox)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInboxPage", reflect.TypeOf((*MockDatabase)(nil).GetInboxPage), c, inboxIRI, q)
 }
 
 // Owns mocks base method
@@ -224,33 +224,33 @@ func (mr *MockDatabaseMockRecorder) Delete(c, id interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockDatabase)(nil).Delete), c, id)
 }
 
-// GetOutbox mocks base method
-func (m *MockDatabase) GetOutbox(c context.Context, inboxIRI *url.URL) (vocab.ActivityStreamsOrderedCollectionPage, error) {
+// PrependOutboxItem mocks base method
+func (m *MockDatabase) PrependOutboxItem(c context.Context, outboxIRI, item *url.URL) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOutbox", c, inboxIRI)
-	ret0, _ := ret[0].(vocab.ActivityStreamsOrderedCollectionPage)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "PrependOutboxItem", c, outboxIRI, item)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-// GetOutbox indicates an expected call of GetOutbox
-func (mr *MockDatabaseMockRecorder) GetOutbox(c, inboxIRI interface{}) *gomock.Call {
+// PrependOutboxItem indicates an expected call of PrependOutboxItem
+func (mr *MockDatabaseMockRecorder) PrependOutboxItem(c, outboxIRI, item interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOutbox", reflect.TypeOf((*MockDatabase)(nil).GetOutbox), c, inboxIRI)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrependOutboxItem", reflect.TypeOf((*MockDatabase)(nil).PrependOutboxItem), c, outboxIRI, item)
 }
 
-// SetOutbox mocks base method
-func (m *MockDatabase) SetOutbox(c context.Context, inbox vocab.ActivityStreamsOrderedCollectionPage) error {
+// GetOutboxPage mocks base method
+func (m *MockDatabase) GetOutboxPage(c context.Context, outboxIRI *url.URL, q BoxPageQuery) (BoxPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetOutbox", c, inbox)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "GetOutboxPage", c, outboxIRI, q)
+	ret0, _ := ret[0].(BoxPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// SetOutbox indicates an expected call of SetOutbox
-func (mr *MockDatabaseMockRecorder) SetOutbox(c, inbox interface{}) *gomock.Call {
+// GetOutboxPage indicates an expected call of GetOutboxPage
+func (mr *MockDatabaseMockRecorder) GetOutboxPage(c, outboxIRI, q interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetOutbox", reflect.TypeOf((*MockDatabase)(nil).SetOutbox), c, inbox)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOutboxPage", reflect.TypeOf((*MockDatabase)(nil).GetOutboxPage), c, outboxIRI, q)
 }
 
 // NewId mocks base method
@@ -298,6 +298,21 @@ func (mr *MockDatabaseMockRecorder) Following(c, actorIRI interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Following", reflect.TypeOf((*MockDatabase)(nil).Following), c, actorIRI)
 }
 
+// LocalFollowers mocks base method
+func (m *MockDatabase) LocalFollowers(c context.Context, actorIRI *url.URL) ([]*url.URL, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LocalFollowers", c, actorIRI)
+	ret0, _ := ret[0].([]*url.URL)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LocalFollowers indicates an expected call of LocalFollowers
+func (mr *MockDatabaseMockRecorder) LocalFollowers(c, actorIRI interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LocalFollowers", reflect.TypeOf((*MockDatabase)(nil).LocalFollowers), c, actorIRI)
+}
+
 // Liked mocks base method
 func (m *MockDatabase) Liked(c context.Context, actorIRI *url.URL) (vocab.ActivityStreamsCollection, error) {
 	m.ctrl.T.Helper()
@@ -312,3 +327,18 @@ func (mr *MockDatabaseMockRecorder) Liked(c, actorIRI interface{}) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Liked", reflect.TypeOf((*MockDatabase)(nil).Liked), c, actorIRI)
 }
+
+// Blocked mocks base method
+func (m *MockDatabase) Blocked(c context.Context, actorIRI *url.URL) (vocab.ActivityStreamsCollection, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Blocked", c, actorIRI)
+	ret0, _ := ret[0].(vocab.ActivityStreamsCollection)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Blocked indicates an expected call of Blocked
+func (mr *MockDatabaseMockRecorder) Blocked(c, actorIRI interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Blocked", reflect.TypeOf((*MockDatabase)(nil).Blocked), c, actorIRI)
+}
@@ -94,6 +94,21 @@ func (mr *MockDelegateActorMockRecorder) PostInbox(c, inboxIRI, activity interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PostInbox", reflect.TypeOf((*MockDelegateActor)(nil).PostInbox), c, inboxIRI, activity)
 }
 
+// SharedInboxRecipients mocks base method
+func (m *MockDelegateActor) SharedInboxRecipients(c context.Context, sharedInboxIRI *url.URL, activity Activity) ([]*url.URL, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SharedInboxRecipients", c, sharedInboxIRI, activity)
+	ret0, _ := ret[0].([]*url.URL)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SharedInboxRecipients indicates an expected call of SharedInboxRecipients
+func (mr *MockDelegateActorMockRecorder) SharedInboxRecipients(c, sharedInboxIRI, activity interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SharedInboxRecipients", reflect.TypeOf((*MockDelegateActor)(nil).SharedInboxRecipients), c, sharedInboxIRI, activity)
+}
+
 // InboxForwarding mocks base method
 func (m *MockDelegateActor) InboxForwarding(c context.Context, inboxIRI *url.URL, activity Activity) error {
 	m.ctrl.T.Helper()
@@ -197,10 +212,10 @@ func (mr *MockDelegateActorMockRecorder) WrapInCreate(c, value, outboxIRI interf
 }
 
 // GetOutbox mocks base method
-func (m *MockDelegateActor) GetOutbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error) {
+func (m *MockDelegateActor) GetOutbox(c context.Context, r *http.Request) (vocab.Type, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetOutbox", c, r)
-	ret0, _ := ret[0].(vocab.ActivityStreamsOrderedCollectionPage)
+	ret0, _ := ret[0].(vocab.Type)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -212,10 +227,10 @@ func (mr *MockDelegateActorMockRecorder) GetOutbox(c, r interface{}) *gomock.Cal
 }
 
 // GetInbox mocks base method
-func (m *MockDelegateActor) GetInbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error) {
+func (m *MockDelegateActor) GetInbox(c context.Context, r *http.Request) (vocab.Type, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetInbox", c, r)
-	ret0, _ := ret[0].(vocab.ActivityStreamsOrderedCollectionPage)
+	ret0, _ := ret[0].(vocab.Type)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
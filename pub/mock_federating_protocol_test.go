@@ -6,7 +6,6 @@ package pub
 
 import (
 	context "context"
-	vocab "github.com/go-fed/activity/streams/vocab"
 	gomock "github.com/golang/mock/gomock"
 	http "net/http"
 	url "net/url"
@@ -123,6 +122,32 @@ func (mr *MockFederatingProtocolMockRecorder) MaxDeliveryRecursionDepth(c interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MaxDeliveryRecursionDepth", reflect.TypeOf((*MockFederatingProtocol)(nil).MaxDeliveryRecursionDepth), c)
 }
 
+// DeliveryQueue mocks base method
+func (m *MockFederatingProtocol) DeliveryQueue(c context.Context) *DeliveryQueue {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeliveryQueue", c)
+	ret0, _ := ret[0].(*DeliveryQueue)
+	return ret0
+}
+
+// DeliveryQueue indicates an expected call of DeliveryQueue
+func (mr *MockFederatingProtocolMockRecorder) DeliveryQueue(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeliveryQueue", reflect.TypeOf((*MockFederatingProtocol)(nil).DeliveryQueue), c)
+}
+
+// ReportDelivery mocks base method
+func (m *MockFederatingProtocol) ReportDelivery(c context.Context, report DeliveryReport) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ReportDelivery", c, report)
+}
+
+// ReportDelivery indicates an expected call of ReportDelivery
+func (mr *MockFederatingProtocolMockRecorder) ReportDelivery(c, report interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportDelivery", reflect.TypeOf((*MockFederatingProtocol)(nil).ReportDelivery), c, report)
+}
+
 // FilterForwarding mocks base method
 func (m *MockFederatingProtocol) FilterForwarding(c context.Context, potentialRecipients []*url.URL, a Activity) ([]*url.URL, error) {
 	m.ctrl.T.Helper()
@@ -139,16 +164,16 @@ func (mr *MockFederatingProtocolMockRecorder) FilterForwarding(c, potentialRecip
 }
 
 // GetInbox mocks base method
-func (m *MockFederatingProtocol) GetInbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error) {
+func (m *MockFederatingProtocol) GetInbox(c context.Context, r *http.Request, inboxIRI *url.URL, q BoxPageQuery, page BoxPage) (BoxPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetInbox", c, r)
-	ret0, _ := ret[0].(vocab.ActivityStreamsOrderedCollectionPage)
+	ret := m.ctrl.Call(m, "GetInbox", c, r, inboxIRI, q, page)
+	ret0, _ := ret[0].(BoxPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetInbox indicates an expected call of GetInbox
-func (mr *MockFederatingProtocolMockRecorder) GetInbox(c, r interface{}) *gomock.Call {
+func (mr *MockFederatingProtocolMockRecorder) GetInbox(c, r, inboxIRI, q, page interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInbox", reflect.TypeOf((*MockFederatingProtocol)(nil).GetInbox), c, r)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInbox", reflect.TypeOf((*MockFederatingProtocol)(nil).GetInbox), c, r, inboxIRI, q, page)
 }
@@ -0,0 +1,63 @@
+package pub
+
+// Code generated by MockGen. DO NOT EDIT.
+// Source: nodeinfo.go
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+)
+
+// MockNodeInfoProvider is a mock of NodeInfoProvider interface
+type MockNodeInfoProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockNodeInfoProviderMockRecorder
+}
+
+// MockNodeInfoProviderMockRecorder is the mock recorder for MockNodeInfoProvider
+type MockNodeInfoProviderMockRecorder struct {
+	mock *MockNodeInfoProvider
+}
+
+// NewMockNodeInfoProvider creates a new mock instance
+func NewMockNodeInfoProvider(ctrl *gomock.Controller) *MockNodeInfoProvider {
+	mock := &MockNodeInfoProvider{ctrl: ctrl}
+	mock.recorder = &MockNodeInfoProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockNodeInfoProvider) EXPECT() *MockNodeInfoProviderMockRecorder {
+	return m.recorder
+}
+
+// Usage mocks base method
+func (m *MockNodeInfoProvider) Usage(c context.Context) (NodeInfoUsage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Usage", c)
+	ret0, _ := ret[0].(NodeInfoUsage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Usage indicates an expected call of Usage
+func (mr *MockNodeInfoProviderMockRecorder) Usage(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Usage", reflect.TypeOf((*MockNodeInfoProvider)(nil).Usage), c)
+}
+
+// OpenRegistrations mocks base method
+func (m *MockNodeInfoProvider) OpenRegistrations(c context.Context) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OpenRegistrations", c)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OpenRegistrations indicates an expected call of OpenRegistrations
+func (mr *MockNodeInfoProviderMockRecorder) OpenRegistrations(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenRegistrations", reflect.TypeOf((*MockNodeInfoProvider)(nil).OpenRegistrations), c)
+}
@@ -6,9 +6,9 @@ package pub
 
 import (
 	context "context"
-	vocab "github.com/go-fed/activity/streams/vocab"
 	gomock "github.com/golang/mock/gomock"
 	http "net/http"
+	url "net/url"
 	reflect "reflect"
 )
 
@@ -80,16 +80,16 @@ func (mr *MockSocialProtocolMockRecorder) DefaultCallback(c, activity interface{
 }
 
 // GetOutbox mocks base method
-func (m *MockSocialProtocol) GetOutbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error) {
+func (m *MockSocialProtocol) GetOutbox(c context.Context, r *http.Request, outboxIRI *url.URL, q BoxPageQuery, page BoxPage) (BoxPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOutbox", c, r)
-	ret0, _ := ret[0].(vocab.ActivityStreamsOrderedCollectionPage)
+	ret := m.ctrl.Call(m, "GetOutbox", c, r, outboxIRI, q, page)
+	ret0, _ := ret[0].(BoxPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetOutbox indicates an expected call of GetOutbox
-func (mr *MockSocialProtocolMockRecorder) GetOutbox(c, r interface{}) *gomock.Call {
+func (mr *MockSocialProtocolMockRecorder) GetOutbox(c, r, outboxIRI, q, page interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOutbox", reflect.TypeOf((*MockSocialProtocol)(nil).GetOutbox), c, r)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOutbox", reflect.TypeOf((*MockSocialProtocol)(nil).GetOutbox), c, r, outboxIRI, q, page)
 }
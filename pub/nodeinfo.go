@@ -0,0 +1,212 @@
+package pub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// NodeInfoWellKnownPath is the path of the NodeInfo discovery document.
+	NodeInfoWellKnownPath = "/.well-known/nodeinfo"
+	// nodeInfo20Path and nodeInfo21Path are the paths of the NodeInfo
+	// documents linked from the discovery document.
+	nodeInfo20Path = "/nodeinfo/2.0"
+	nodeInfo21Path = "/nodeinfo/2.1"
+	// nodeInfo20Schema and nodeInfo21Schema are the relations and profiles
+	// of the NodeInfo documents.
+	nodeInfo20Schema = "http://nodeinfo.diaspora.software/ns/schema/2.0"
+	nodeInfo21Schema = "http://nodeinfo.diaspora.software/ns/schema/2.1"
+	// defaultNodeInfoSoftwareName is the software reported when the
+	// application does not name its own.
+	defaultNodeInfoSoftwareName = "go-fed"
+	// activityPubProtocol is the NodeInfo name of the ActivityPub protocol.
+	activityPubProtocol = "activitypub"
+)
+
+// NodeInfoUsage is the usage of the server reported in its NodeInfo.
+type NodeInfoUsage struct {
+	// TotalUsers is the number of registered local users.
+	TotalUsers int
+	// ActiveHalfyear is the number of local users active in the last 180
+	// days.
+	ActiveHalfyear int
+	// ActiveMonth is the number of local users active in the last 30 days.
+	ActiveMonth int
+	// LocalPosts is the number of posts made by local users.
+	LocalPosts int
+}
+
+// NodeInfoProvider supplies the statistics of the server served by a
+// HandlerFunc from NewNodeInfoHandler.
+type NodeInfoProvider interface {
+	// Usage returns the current usage of the server.
+	Usage(c context.Context) (u NodeInfoUsage, err error)
+	// OpenRegistrations returns whether new users may register on the
+	// server.
+	OpenRegistrations(c context.Context) (open bool, err error)
+}
+
+// NodeInfoSoftware describes the software of the server in its NodeInfo. Zero
+// fields are replaced with the defaults of go-fed.
+type NodeInfoSoftware struct {
+	// Name is the name of the software, which may only contain lowercase
+	// letters, digits, and hyphens. Defaults to "go-fed".
+	Name string
+	// Version is the version of the software. Defaults to the version of
+	// go-fed sent in its User-Agent.
+	Version string
+	// Repository is the URL of the source code of the software. It is
+	// only served in NodeInfo 2.1.
+	Repository string
+	// Homepage is the URL of the homepage of the software. It is only
+	// served in NodeInfo 2.1.
+	Homepage string
+	// Protocols are the protocols supported by the server. Defaults to
+	// "activitypub".
+	Protocols []string
+	// Metadata is free form information about the server, such as its
+	// name.
+	Metadata map[string]interface{}
+}
+
+// NewNodeInfoHandler creates a HandlerFunc to serve the NodeInfo discovery
+// document at NodeInfoWellKnownPath, and the NodeInfo 2.0 and 2.1 documents
+// it links to at "/nodeinfo/2.0" and "/nodeinfo/2.1", on the requested host.
+//
+// Requests for other paths are not handled, so that 'isASRequest' is false,
+// and the calling function may continue processing the request.
+//
+// An error is returned if the Name of the software is not a valid NodeInfo
+// software name.
+func NewNodeInfoHandler(provider NodeInfoProvider, software NodeInfoSoftware) (HandlerFunc, error) {
+	if len(software.Name) == 0 {
+		software.Name = defaultNodeInfoSoftwareName
+	} else if !isNodeInfoSoftwareName(software.Name) {
+		return nil, fmt.Errorf("invalid NodeInfo software name %q: only lowercase letters, digits, and hyphens are allowed", software.Name)
+	}
+	if len(software.Version) == 0 {
+		software.Version = version
+	}
+	if len(software.Protocols) == 0 {
+		software.Protocols = []string{activityPubProtocol}
+	}
+	if software.Metadata == nil {
+		software.Metadata = make(map[string]interface{})
+	}
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) (isASRequest bool, err error) {
+		var schema string
+		switch r.URL.Path {
+		case NodeInfoWellKnownPath:
+			schema = ""
+		case nodeInfo20Path:
+			schema = nodeInfo20Schema
+		case nodeInfo21Path:
+			schema = nodeInfo21Schema
+		default:
+			return
+		}
+		isASRequest = true
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var m map[string]interface{}
+		if len(schema) == 0 {
+			m = nodeInfoDiscovery(r)
+		} else if m, err = nodeInfoDocument(c, provider, software, schema); err != nil {
+			return
+		}
+		raw, err := json.Marshal(m)
+		if err != nil {
+			return
+		}
+		contentType := "application/json"
+		if len(schema) > 0 {
+			contentType += "; profile=\"" + schema + "#\""
+		}
+		w.Header().Set(contentTypeHeader, contentType)
+		// Directories fetch NodeInfo from browsers.
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(raw)
+		return
+	}, nil
+}
+
+// nodeInfoDiscovery returns the discovery document linking to the NodeInfo
+// documents on the requested host.
+func nodeInfoDiscovery(r *http.Request) map[string]interface{} {
+	link := func(schema, path string) map[string]interface{} {
+		u := &url.URL{Scheme: "https", Host: r.Host, Path: path}
+		return map[string]interface{}{
+			"rel":  schema,
+			"href": u.String(),
+		}
+	}
+	return map[string]interface{}{
+		"links": []interface{}{
+			link(nodeInfo20Schema, nodeInfo20Path),
+			link(nodeInfo21Schema, nodeInfo21Path),
+		},
+	}
+}
+
+// isNodeInfoSoftwareName determines whether the name only contains lowercase
+// letters, digits, and hyphens, as required by the NodeInfo schemas.
+func isNodeInfoSoftwareName(name string) bool {
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
+// nodeInfoDocument returns the NodeInfo document of the schema.
+func nodeInfoDocument(c context.Context, provider NodeInfoProvider, software NodeInfoSoftware, schema string) (m map[string]interface{}, err error) {
+	usage, err := provider.Usage(c)
+	if err != nil {
+		return
+	}
+	open, err := provider.OpenRegistrations(c)
+	if err != nil {
+		return
+	}
+	s := map[string]interface{}{
+		"name":    software.Name,
+		"version": software.Version,
+	}
+	if schema == nodeInfo21Schema {
+		if len(software.Repository) > 0 {
+			s["repository"] = software.Repository
+		}
+		if len(software.Homepage) > 0 {
+			s["homepage"] = software.Homepage
+		}
+	}
+	m = map[string]interface{}{
+		"version":   strings.TrimPrefix(schema, "http://nodeinfo.diaspora.software/ns/schema/"),
+		"software":  s,
+		"protocols": software.Protocols,
+		"services": map[string]interface{}{
+			"inbound":  []interface{}{},
+			"outbound": []interface{}{},
+		},
+		"openRegistrations": open,
+		"usage": map[string]interface{}{
+			"users": map[string]interface{}{
+				"total":          usage.TotalUsers,
+				"activeHalfyear": usage.ActiveHalfyear,
+				"activeMonth":    usage.ActiveMonth,
+			},
+			"localPosts": usage.LocalPosts,
+		},
+		"metadata": software.Metadata,
+	}
+	return
+}
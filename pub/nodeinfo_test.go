@@ -0,0 +1,151 @@
+package pub
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestNewNodeInfoHandler ensures the NodeInfo discovery and 2.x documents are
+// served.
+func TestNewNodeInfoHandler(t *testing.T) {
+	ctx := context.Background()
+	usage := NodeInfoUsage{
+		TotalUsers:     3,
+		ActiveHalfyear: 2,
+		ActiveMonth:    1,
+		LocalPosts:     42,
+	}
+	setupFn := func(t *testing.T, ctl *gomock.Controller, software NodeInfoSoftware) (p *MockNodeInfoProvider, h HandlerFunc) {
+		p = NewMockNodeInfoProvider(ctl)
+		h, err := NewNodeInfoHandler(p, software)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	serve := func(h HandlerFunc, path string) (resp *httptest.ResponseRecorder, m map[string]interface{}) {
+		resp = httptest.NewRecorder()
+		handled, err := h(ctx, resp, httptest.NewRequest("GET", "https://example.com"+path, nil))
+		if err != nil {
+			t.Fatal(err)
+		} else if !handled {
+			t.Fatalf("%s was not handled", path)
+		}
+		if err := json.Unmarshal(resp.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	t.Run("IgnoresOtherPaths", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, h := setupFn(t, ctl, NodeInfoSoftware{})
+		resp := httptest.NewRecorder()
+		// Run
+		handled, err := h(ctx, resp, httptest.NewRequest("GET", "https://example.com/users/alice", nil))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, false)
+		assertEqual(t, resp.Body.Len(), 0)
+	})
+	t.Run("ServesDiscovery", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, h := setupFn(t, ctl, NodeInfoSoftware{})
+		// Run
+		resp, m := serve(h, NodeInfoWellKnownPath)
+		// Verify
+		assertEqual(t, resp.Code, http.StatusOK)
+		assertEqual(t, resp.Header().Get(contentTypeHeader), "application/json")
+		links := m["links"].([]interface{})
+		assertEqual(t, len(links), 2)
+		link := links[1].(map[string]interface{})
+		assertEqual(t, link["rel"], nodeInfo21Schema)
+		assertEqual(t, link["href"], "https://example.com/nodeinfo/2.1")
+	})
+	t.Run("ServesDefaults", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		p, h := setupFn(t, ctl, NodeInfoSoftware{Repository: "https://github.com/go-fed/activity"})
+		p.EXPECT().Usage(ctx).Return(usage, nil)
+		p.EXPECT().OpenRegistrations(ctx).Return(true, nil)
+		// Run
+		resp, m := serve(h, "/nodeinfo/2.0")
+		// Verify
+		assertEqual(t, resp.Header().Get(contentTypeHeader), "application/json; profile=\""+nodeInfo20Schema+"#\"")
+		assertEqual(t, m["version"], "2.0")
+		software := m["software"].(map[string]interface{})
+		assertEqual(t, software["name"], "go-fed")
+		assertEqual(t, software["version"], version)
+		assertEqual(t, software["repository"], nil)
+		protocols := m["protocols"].([]interface{})
+		assertEqual(t, len(protocols), 1)
+		assertEqual(t, protocols[0], "activitypub")
+		assertEqual(t, m["openRegistrations"], true)
+		u := m["usage"].(map[string]interface{})
+		assertEqual(t, u["localPosts"], float64(42))
+		users := u["users"].(map[string]interface{})
+		assertEqual(t, users["total"], float64(3))
+		assertEqual(t, users["activeHalfyear"], float64(2))
+		assertEqual(t, users["activeMonth"], float64(1))
+		assertNotEqual(t, m["services"], nil)
+		assertNotEqual(t, m["metadata"], nil)
+	})
+	t.Run("Serves21Software", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		p, h := setupFn(t, ctl, NodeInfoSoftware{
+			Name:       "myapp",
+			Version:    "1.2.3",
+			Repository: "https://example.com/myapp.git",
+			Protocols:  []string{"activitypub", "diaspora"},
+		})
+		p.EXPECT().Usage(ctx).Return(usage, nil)
+		p.EXPECT().OpenRegistrations(ctx).Return(false, nil)
+		// Run
+		_, m := serve(h, "/nodeinfo/2.1")
+		// Verify
+		assertEqual(t, m["version"], "2.1")
+		software := m["software"].(map[string]interface{})
+		assertEqual(t, software["name"], "myapp")
+		assertEqual(t, software["version"], "1.2.3")
+		assertEqual(t, software["repository"], "https://example.com/myapp.git")
+		assertEqual(t, len(m["protocols"].([]interface{})), 2)
+		assertEqual(t, m["openRegistrations"], false)
+	})
+	t.Run("RejectsInvalidSoftwareName", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		p := NewMockNodeInfoProvider(ctl)
+		for _, name := range []string{"MyApp", "my app", "my_app", "café"} {
+			// Run
+			h, err := NewNodeInfoHandler(p, NodeInfoSoftware{Name: name})
+			// Verify
+			assertNotEqual(t, err, nil)
+			assertEqual(t, h == nil, true)
+		}
+	})
+	t.Run("ReturnsProviderError", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		p, h := setupFn(t, ctl, NodeInfoSoftware{})
+		p.EXPECT().Usage(ctx).Return(NodeInfoUsage{}, testErr)
+		resp := httptest.NewRecorder()
+		// Run
+		handled, err := h(ctx, resp, httptest.NewRequest("GET", "https://example.com/nodeinfo/2.1", nil))
+		// Verify
+		assertEqual(t, err, testErr)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Body.Len(), 0)
+	})
+}
@@ -0,0 +1,412 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker of a peer host.
+type CircuitState int
+
+const (
+	// CircuitClosed permits all requests to the host.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen permits no requests to the host, until it is time to
+	// probe it again.
+	CircuitOpen
+	// CircuitHalfOpen permits a single probe request to the host, whose
+	// outcome determines whether the circuit closes or opens again.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// OpenCircuitAction determines what happens to a delivery to a host whose
+// circuit is open.
+type OpenCircuitAction int
+
+const (
+	// DeferOpenCircuit fails the delivery with a retryable error, so that a
+	// DeliveryQueue attempts it again once the host is due to be probed.
+	DeferOpenCircuit OpenCircuitAction = iota
+	// SkipOpenCircuit fails the delivery with an error that is not
+	// retryable, so it is dropped.
+	SkipOpenCircuit
+)
+
+// PeerHealthPolicy configures when the circuit of a peer host opens and how
+// long it stays open.
+type PeerHealthPolicy struct {
+	// FailureThreshold is the number of consecutive failed requests to a
+	// host that open its circuit.
+	FailureThreshold int
+	// MinProbeInterval is how long a circuit stays open after it first
+	// opens. Each failed probe doubles it, up to MaxProbeInterval.
+	MinProbeInterval time.Duration
+	MaxProbeInterval time.Duration
+	// OnOpen determines what happens to deliveries to a host whose circuit
+	// is open.
+	OnOpen OpenCircuitAction
+	// MaxHosts is the number of hosts whose health is tracked. Once it is
+	// reached, the host that was least recently requested is forgotten to
+	// track a new one, preferring hosts whose circuit is closed. Defaults
+	// to 10000 when zero or less.
+	MaxHosts int
+}
+
+// defaultPeerHealthMaxHosts is the number of hosts tracked when the
+// PeerHealthPolicy does not set MaxHosts.
+const defaultPeerHealthMaxHosts = 10000
+
+// DefaultPeerHealthPolicy opens a circuit after five consecutive failures, and
+// probes the host after one minute, backing off to once every six hours.
+// Deliveries to hosts with an open circuit are deferred. The health of up to
+// 10000 hosts is tracked.
+var DefaultPeerHealthPolicy = PeerHealthPolicy{
+	FailureThreshold: 5,
+	MinProbeInterval: time.Minute,
+	MaxProbeInterval: 6 * time.Hour,
+	OnOpen:           DeferOpenCircuit,
+	MaxHosts:         defaultPeerHealthMaxHosts,
+}
+
+// HostHealth is a snapshot of the health of a peer host.
+type HostHealth struct {
+	// Host is the host of the peer, including any port.
+	Host string
+	// State is the state of the host's circuit.
+	State CircuitState
+	// ConsecutiveFailures is the number of requests that failed since the
+	// last successful one.
+	ConsecutiveFailures int
+	// Successes and Failures count all of the requests to the host.
+	Successes int64
+	Failures  int64
+	// LastSuccess and LastFailure are the times of the latest successful
+	// and failed requests, and are zero if there were none.
+	LastSuccess time.Time
+	LastFailure time.Time
+	// LastError is the error of the latest failed request.
+	LastError error
+	// NextProbe is when a request is next permitted to a host whose
+	// circuit is open.
+	NextProbe time.Time
+}
+
+// CircuitOpenError is returned instead of making a request to a peer host
+// whose circuit is open.
+type CircuitOpenError struct {
+	// Host is the peer host.
+	Host string
+	// NextProbe is when a request is next permitted to the host.
+	NextProbe time.Time
+	// Deferred is true if the delivery should be attempted again after
+	// NextProbe, and false if it was skipped.
+	Deferred bool
+}
+
+// Error returns a description of the refused request.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s until %s", e.Host, e.NextProbe.Format(time.RFC3339))
+}
+
+// PeerHealth tracks the outcome of the requests made to each peer host, and
+// opens a circuit breaker for a host after repeated failures so that no
+// requests are made to it until it is due to be probed again.
+//
+// Only failures that suggest the host is unavailable count against it: network
+// errors, timeouts, and the status codes that isRetryableError considers
+// temporary. Any other response, including a 4xx status, shows that the host
+// is up.
+//
+// A single PeerHealth should be shared by all the Transports of an
+// application, by wrapping each of them with NewPeerHealthTransport.
+//
+// It is safe to use concurrently.
+type PeerHealth struct {
+	clock  Clock
+	policy PeerHealthPolicy
+	// mu guards hosts.
+	mu    sync.Mutex
+	hosts map[string]*hostHealth
+}
+
+// hostHealth is the mutable health of a single host.
+type hostHealth struct {
+	HostHealth
+	// probeInterval is how long the circuit stays open the next time it
+	// opens.
+	probeInterval time.Duration
+	// probing is true while the single probe of a half-open circuit is
+	// being made.
+	probing bool
+}
+
+// NewPeerHealth returns a new PeerHealth.
+func NewPeerHealth(clock Clock, policy PeerHealthPolicy) *PeerHealth {
+	if policy.FailureThreshold <= 0 {
+		policy.FailureThreshold = 1
+	}
+	if policy.MaxProbeInterval < policy.MinProbeInterval {
+		policy.MaxProbeInterval = policy.MinProbeInterval
+	}
+	if policy.MaxHosts <= 0 {
+		policy.MaxHosts = defaultPeerHealthMaxHosts
+	}
+	return &PeerHealth{
+		clock:  clock,
+		policy: policy,
+		hosts:  make(map[string]*hostHealth),
+	}
+}
+
+// Host returns the health of a peer host. Hosts no requests were made to are
+// reported with a closed circuit.
+func (p *PeerHealth) Host(host string) HostHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.hosts[host]; ok {
+		return p.snapshot(h)
+	}
+	return HostHealth{Host: host, State: CircuitClosed}
+}
+
+// Hosts returns the health of every peer host requests were made to, sorted by
+// host.
+func (p *PeerHealth) Hosts() []HostHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hh := make([]HostHealth, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		hh = append(hh, p.snapshot(h))
+	}
+	sort.Slice(hh, func(i, j int) bool {
+		return hh[i].Host < hh[j].Host
+	})
+	return hh
+}
+
+// Reset closes the circuit of a peer host and forgets its history, such as
+// when an administrator knows that it is available again.
+func (p *PeerHealth) Reset(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.hosts, host)
+}
+
+// snapshot returns a copy of the health of the host, which is half-open if it
+// is due to be probed. The caller must hold mu.
+func (p *PeerHealth) snapshot(h *hostHealth) HostHealth {
+	s := h.HostHealth
+	if s.State == CircuitOpen && !p.clock.Now().Before(s.NextProbe) {
+		s.State = CircuitHalfOpen
+	}
+	return s
+}
+
+// allow determines whether a request may be made to the host. It returns a
+// CircuitOpenError if not. Otherwise, the outcome of the request must be
+// passed to record.
+func (p *PeerHealth) allow(host string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.hosts[host]
+	if !ok || h.State == CircuitClosed {
+		return nil
+	}
+	now := p.clock.Now()
+	nextProbe := h.NextProbe
+	if h.probing {
+		// The outcome of the probe in flight is not known yet, so the
+		// next one is at least one interval away.
+		nextProbe = now.Add(h.probeInterval)
+	} else if !now.Before(h.NextProbe) {
+		h.State = CircuitHalfOpen
+		h.probing = true
+		return nil
+	}
+	return &CircuitOpenError{
+		Host:      host,
+		NextProbe: nextProbe,
+		Deferred:  p.policy.OnOpen == DeferOpenCircuit,
+	}
+}
+
+// record updates the health of the host with the outcome of a request made to
+// it with the given context.
+func (p *PeerHealth) record(c context.Context, host string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.hosts[host]
+	if !ok {
+		if len(p.hosts) >= p.policy.MaxHosts {
+			p.evict()
+		}
+		h = &hostHealth{
+			HostHealth:    HostHealth{Host: host},
+			probeInterval: p.policy.MinProbeInterval,
+		}
+		p.hosts[host] = h
+	}
+	now := p.clock.Now()
+	// Canceling a request says nothing about the health of the host.
+	if err != nil && c.Err() != nil {
+		h.probing = false
+		return
+	}
+	h.probing = false
+	if err == nil || !isRetryableError(err) {
+		h.Successes++
+		h.LastSuccess = now
+		h.ConsecutiveFailures = 0
+		h.State = CircuitClosed
+		h.NextProbe = time.Time{}
+		h.probeInterval = p.policy.MinProbeInterval
+		return
+	}
+	h.Failures++
+	h.LastFailure = now
+	h.LastError = err
+	h.ConsecutiveFailures++
+	if h.State == CircuitHalfOpen || h.ConsecutiveFailures >= p.policy.FailureThreshold {
+		if h.State == CircuitHalfOpen {
+			h.probeInterval *= 2
+			if h.probeInterval > p.policy.MaxProbeInterval {
+				h.probeInterval = p.policy.MaxProbeInterval
+			}
+		}
+		h.State = CircuitOpen
+		h.NextProbe = now.Add(h.probeInterval)
+	}
+}
+
+// evict forgets the host that was least recently requested, preferring hosts
+// whose circuit is closed, and never one being probed. The caller must hold mu.
+func (p *PeerHealth) evict() {
+	var oldest *hostHealth
+	for _, h := range p.hosts {
+		if h.probing {
+			continue
+		} else if oldest == nil {
+			oldest = h
+		} else if closed, oldestClosed := h.State == CircuitClosed, oldest.State == CircuitClosed; closed != oldestClosed {
+			if closed {
+				oldest = h
+			}
+		} else if h.lastRequest().Before(oldest.lastRequest()) {
+			oldest = h
+		}
+	}
+	if oldest != nil {
+		delete(p.hosts, oldest.Host)
+	}
+}
+
+// lastRequest returns the time of the latest request to the host.
+func (h *hostHealth) lastRequest() time.Time {
+	if h.LastFailure.After(h.LastSuccess) {
+		return h.LastFailure
+	}
+	return h.LastSuccess
+}
+
+// peerHealthTransport must be a Transport.
+var _ Transport = &peerHealthTransport{}
+
+// peerHealthTransport makes requests with the wrapped Transport only to the
+// hosts whose circuit is not open, and records their outcome.
+type peerHealthTransport struct {
+	t Transport
+	p *PeerHealth
+}
+
+// NewPeerHealthTransport returns a Transport that refuses to make requests to
+// peer hosts whose circuit is open, returning a CircuitOpenError instead, and
+// otherwise makes them with the wrapped Transport while recording their
+// outcome in the PeerHealth.
+//
+// When BatchDeliver refuses to deliver to some recipients, it returns a
+// BatchDeliverError whose Results contain the CircuitOpenErrors.
+func NewPeerHealthTransport(t Transport, p *PeerHealth) Transport {
+	return &peerHealthTransport{
+		t: t,
+		p: p,
+	}
+}
+
+// Dereference fetches the IRI with the wrapped Transport, unless the circuit of
+// its host is open.
+func (t *peerHealthTransport) Dereference(c context.Context, iri *url.URL) ([]byte, error) {
+	if err := t.p.allow(iri.Host); err != nil {
+		return nil, err
+	}
+	b, err := t.t.Dereference(c, iri)
+	t.p.record(c, iri.Host, err)
+	return b, err
+}
+
+// Deliver sends the payload with the wrapped Transport, unless the circuit of
+// the recipient's host is open.
+func (t *peerHealthTransport) Deliver(c context.Context, b []byte, to *url.URL) error {
+	if err := t.p.allow(to.Host); err != nil {
+		return err
+	}
+	err := t.t.Deliver(c, b, to)
+	t.p.record(c, to.Host, err)
+	return err
+}
+
+// BatchDeliver sends the payload with the wrapped Transport to the recipients
+// whose host's circuit is not open.
+func (t *peerHealthTransport) BatchDeliver(c context.Context, b []byte, recipients []*url.URL) error {
+	var allowed []*url.URL
+	var skipped []DeliveryResult
+	for _, to := range recipients {
+		if err := t.p.allow(to.Host); err != nil {
+			skipped = append(skipped, DeliveryResult{Recipient: to, Err: err})
+		} else {
+			allowed = append(allowed, to)
+		}
+	}
+	var results []DeliveryResult
+	if len(allowed) > 0 {
+		err := t.t.BatchDeliver(c, b, allowed)
+		if batchErr, ok := err.(*BatchDeliverError); ok {
+			results = batchErr.Results
+		} else if err != nil {
+			// The wrapped Transport did not report the outcome of
+			// each delivery, so they all failed in the same way.
+			for _, to := range allowed {
+				results = append(results, DeliveryResult{Recipient: to, Err: err})
+			}
+		} else {
+			for _, to := range allowed {
+				results = append(results, DeliveryResult{Recipient: to})
+			}
+		}
+		for _, r := range results {
+			t.p.record(c, r.Recipient.Host, r.Err)
+		}
+	}
+	batchErr := &BatchDeliverError{Results: append(results, skipped...)}
+	if len(batchErr.Failed()) == 0 {
+		return nil
+	}
+	return batchErr
+}
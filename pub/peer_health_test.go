@@ -0,0 +1,183 @@
+package pub
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestPeerHealthTransport ensures requests to failing hosts are refused once
+// their circuit opens, and that the hosts are probed again.
+func TestPeerHealthTransport(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte(`{"type":"Create"}`)
+	policy := PeerHealthPolicy{
+		FailureThreshold: 2,
+		MinProbeInterval: time.Minute,
+		MaxProbeInterval: time.Hour,
+		OnOpen:           DeferOpenCircuit,
+	}
+	setupFn := func(ctl *gomock.Controller) (mt *MockTransport, cl *MockClock, ph *PeerHealth, tp Transport) {
+		mt = NewMockTransport(ctl)
+		cl = NewMockClock(ctl)
+		ph = NewPeerHealth(cl, policy)
+		tp = NewPeerHealthTransport(mt, ph)
+		return
+	}
+	// Run tests
+	t.Run("OpensCircuitAfterRepeatedFailures", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		mt, cl, ph, tp := setupFn(ctl)
+		cl.EXPECT().Now().Return(now()).AnyTimes()
+		mt.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(testErr).Times(2)
+		// Run
+		tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		err := tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		// Verify
+		coErr, ok := err.(*CircuitOpenError)
+		assertEqual(t, ok, true)
+		assertEqual(t, coErr.Deferred, true)
+		assertEqual(t, coErr.NextProbe.Equal(now().Add(time.Minute)), true)
+		h := ph.Host(mustParse(testFederatedActorIRI).Host)
+		assertEqual(t, h.State, CircuitOpen)
+		assertEqual(t, h.Failures, int64(2))
+	})
+	t.Run("ClientErrorsDoNotOpenCircuit", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		mt, cl, ph, tp := setupFn(ctl)
+		cl.EXPECT().Now().Return(now()).AnyTimes()
+		mt.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(&HttpStatusError{StatusCode: 404}).Times(3)
+		// Run
+		for i := 0; i < 3; i++ {
+			tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		}
+		// Verify
+		h := ph.Host(mustParse(testFederatedActorIRI).Host)
+		assertEqual(t, h.State, CircuitClosed)
+		assertEqual(t, h.Successes, int64(3))
+	})
+	t.Run("ProbesAndClosesCircuit", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		mt, cl, ph, tp := setupFn(ctl)
+		later := now().Add(2 * time.Minute)
+		gomock.InOrder(
+			cl.EXPECT().Now().Return(now()).Times(2),
+			cl.EXPECT().Now().Return(later).AnyTimes(),
+		)
+		gomock.InOrder(
+			mt.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(testErr).Times(2),
+			mt.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(nil),
+		)
+		// Run
+		tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		err := tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		// Verify
+		assertEqual(t, err, nil)
+		h := ph.Host(mustParse(testFederatedActorIRI).Host)
+		assertEqual(t, h.State, CircuitClosed)
+		assertEqual(t, h.ConsecutiveFailures, 0)
+	})
+	t.Run("NextProbeIsAheadWhileProbing", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, cl, ph, _ := setupFn(ctl)
+		host := mustParse(testFederatedActorIRI).Host
+		later := now().Add(2 * time.Minute)
+		gomock.InOrder(
+			cl.EXPECT().Now().Return(now()).Times(2),
+			cl.EXPECT().Now().Return(later).AnyTimes(),
+		)
+		ph.record(ctx, host, testErr)
+		ph.record(ctx, host, testErr)
+		assertEqual(t, ph.allow(host), nil)
+		// Run
+		err := ph.allow(host)
+		// Verify
+		coErr, ok := err.(*CircuitOpenError)
+		assertEqual(t, ok, true)
+		assertEqual(t, coErr.NextProbe.Equal(later.Add(time.Minute)), true)
+	})
+	t.Run("EvictsLeastRecentlyRequestedHosts", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		cl := NewMockClock(ctl)
+		ph := NewPeerHealth(cl, PeerHealthPolicy{FailureThreshold: 1, MaxHosts: 2})
+		gomock.InOrder(
+			cl.EXPECT().Now().Return(now()),
+			cl.EXPECT().Now().Return(now().Add(time.Second)),
+			cl.EXPECT().Now().Return(now().Add(2*time.Second)),
+			cl.EXPECT().Now().Return(now().Add(3*time.Second)),
+		)
+		// Run
+		ph.record(ctx, "a.example", nil)
+		ph.record(ctx, "b.example", nil)
+		ph.record(ctx, "a.example", nil)
+		ph.record(ctx, "c.example", nil)
+		// Verify
+		hosts := ph.Hosts()
+		assertEqual(t, len(hosts), 2)
+		assertEqual(t, hosts[0].Host, "a.example")
+		assertEqual(t, hosts[1].Host, "c.example")
+	})
+	t.Run("EvictsClosedCircuitsFirst", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		cl := NewMockClock(ctl)
+		ph := NewPeerHealth(cl, PeerHealthPolicy{FailureThreshold: 1, MinProbeInterval: time.Minute, MaxHosts: 2})
+		gomock.InOrder(
+			cl.EXPECT().Now().Return(now()),
+			cl.EXPECT().Now().Return(now().Add(time.Second)),
+			cl.EXPECT().Now().Return(now().Add(2*time.Second)),
+			cl.EXPECT().Now().Return(now().Add(3*time.Second)).AnyTimes(),
+		)
+		// Run
+		ph.record(ctx, "a.example", testErr)
+		ph.record(ctx, "b.example", nil)
+		ph.record(ctx, "c.example", nil)
+		// Verify
+		hosts := ph.Hosts()
+		assertEqual(t, len(hosts), 2)
+		assertEqual(t, hosts[0].Host, "a.example")
+		assertEqual(t, hosts[0].State, CircuitOpen)
+		assertEqual(t, hosts[1].Host, "c.example")
+	})
+	t.Run("BatchDeliverReportsSkippedRecipients", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		mt, cl, _, tp := setupFn(ctl)
+		cl.EXPECT().Now().Return(now()).AnyTimes()
+		other, err := url.Parse("https://another.example.com/inbox")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt.EXPECT().Deliver(ctx, payload, mustParse(testFederatedActorIRI)).Return(testErr).Times(2)
+		mt.EXPECT().BatchDeliver(ctx, payload, []*url.URL{other}).Return(nil)
+		tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+		// Run
+		err = tp.BatchDeliver(ctx, payload, []*url.URL{mustParse(testFederatedActorIRI), other})
+		// Verify
+		batchErr, ok := err.(*BatchDeliverError)
+		assertEqual(t, ok, true)
+		assertEqual(t, len(batchErr.Results), 2)
+		failed := batchErr.Failed()
+		assertEqual(t, len(failed), 1)
+		assertEqual(t, failed[0].Recipient.String(), testFederatedActorIRI)
+		_, ok = failed[0].Err.(*CircuitOpenError)
+		assertEqual(t, ok, true)
+	})
+}
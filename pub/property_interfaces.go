@@ -87,12 +87,46 @@ type inboxer interface {
 	GetActivityStreamsInbox() vocab.ActivityStreamsInboxProperty
 }
 
+// outboxer is an ActivityStreams type with an 'outbox' property
+type outboxer interface {
+	GetActivityStreamsOutbox() vocab.ActivityStreamsOutboxProperty
+}
+
+// unknownPropertieser is an ActivityStreams type with properties outside of
+// the ActivityStreams vocabulary, which are kept when it is serialized.
+type unknownPropertieser interface {
+	GetUnknownProperties() map[string]interface{}
+}
+
+// followerser is an ActivityStreams type with a 'followers' property
+type followerser interface {
+	GetActivityStreamsFollowers() vocab.ActivityStreamsFollowersProperty
+}
+
 // attributedToer is an ActivityStreams type with an 'attributedTo' property
 type attributedToer interface {
 	GetActivityStreamsAttributedTo() vocab.ActivityStreamsAttributedToProperty
 	SetActivityStreamsAttributedTo(i vocab.ActivityStreamsAttributedToProperty)
 }
 
+// attachmenter is an ActivityStreams type with an 'attachment' property
+type attachmenter interface {
+	GetActivityStreamsAttachment() vocab.ActivityStreamsAttachmentProperty
+	SetActivityStreamsAttachment(i vocab.ActivityStreamsAttachmentProperty)
+}
+
+// iconer is an ActivityStreams type with an 'icon' property
+type iconer interface {
+	GetActivityStreamsIcon() vocab.ActivityStreamsIconProperty
+	SetActivityStreamsIcon(i vocab.ActivityStreamsIconProperty)
+}
+
+// imager is an ActivityStreams type with an 'image' property
+type imager interface {
+	GetActivityStreamsImage() vocab.ActivityStreamsImageProperty
+	SetActivityStreamsImage(i vocab.ActivityStreamsImageProperty)
+}
+
 // likeser is an ActivityStreams type with a 'likes' property
 type likeser interface {
 	GetActivityStreamsLikes() vocab.ActivityStreamsLikesProperty
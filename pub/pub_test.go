@@ -16,6 +16,8 @@ import (
 
 const (
 	testMyInboxIRI            = "https://example.com/addison/inbox"
+	testMyOtherInboxIRI       = "https://example.com/riley/inbox"
+	testMySharedInboxIRI      = "https://example.com/inbox"
 	testMyOutboxIRI           = "https://example.com/addison/outbox"
 	testFederatedActivityIRI  = "https://other.example.com/activity/1"
 	testFederatedActivityIRI2 = "https://other.example.com/activity/2"
@@ -80,17 +82,8 @@ var (
 	// testOrderedCollectionDedupedElemsString is the JSON-LD version of the
 	// testOrderedCollectionDedupedElems value with duplicates removed
 	testOrderedCollectionDedupedElemsString string
-	// testEmptyOrderedCollection is an empty OrderedCollectionPage.
-	testEmptyOrderedCollection vocab.ActivityStreamsOrderedCollectionPage
-	// testOrderedCollectionWithFederatedId has the federated Activity id.
-	testOrderedCollectionWithFederatedId vocab.ActivityStreamsOrderedCollectionPage
 	// testListen is a test Listen Activity.
 	testListen vocab.ActivityStreamsListen
-	// testOrderedCollectionWithFederatedId2 has the second federated
-	// Activity id.
-	testOrderedCollectionWithFederatedId2 vocab.ActivityStreamsOrderedCollectionPage
-	// testOrderedCollectionWithBothFederatedIds has both federated Activity id.
-	testOrderedCollectionWithBothFederatedIds vocab.ActivityStreamsOrderedCollectionPage
 )
 
 // The test data cannot be created at init time since that is when the hooks of
@@ -177,17 +170,6 @@ func setupData() {
 		testOrderedCollectionDupedElems.SetActivityStreamsOrderedItems(oi)
 		testOrderedCollectionDedupedElemsString = `{"@context":"https://www.w3.org/TR/activitystreams-vocabulary","orderedItems":"https://example.com/note/1","type":"OrderedCollectionPage"}`
 	}()
-	// testEmptyOrderedCollection
-	func() {
-		testEmptyOrderedCollection = streams.NewActivityStreamsOrderedCollectionPage()
-	}()
-	// testOrderedCollectionWithFederatedId
-	func() {
-		testOrderedCollectionWithFederatedId = streams.NewActivityStreamsOrderedCollectionPage()
-		oi := streams.NewActivityStreamsOrderedItemsProperty()
-		oi.AppendIRI(mustParse(testFederatedActivityIRI))
-		testOrderedCollectionWithFederatedId.SetActivityStreamsOrderedItems(oi)
-	}()
 	// testListen
 	func() {
 		testListen = streams.NewActivityStreamsListen()
@@ -201,21 +183,6 @@ func setupData() {
 		op.AppendActivityStreamsNote(testFederatedNote)
 		testListen.SetActivityStreamsObject(op)
 	}()
-	// testOrderedCollectionWithFederatedId2
-	func() {
-		testOrderedCollectionWithFederatedId2 = streams.NewActivityStreamsOrderedCollectionPage()
-		oi := streams.NewActivityStreamsOrderedItemsProperty()
-		oi.AppendIRI(mustParse(testFederatedActivityIRI2))
-		testOrderedCollectionWithFederatedId2.SetActivityStreamsOrderedItems(oi)
-	}()
-	// testOrderedCollectionWithBothFederatedIds
-	func() {
-		testOrderedCollectionWithBothFederatedIds = streams.NewActivityStreamsOrderedCollectionPage()
-		oi := streams.NewActivityStreamsOrderedItemsProperty()
-		oi.AppendIRI(mustParse(testFederatedActivityIRI))
-		oi.AppendIRI(mustParse(testFederatedActivityIRI2))
-		testOrderedCollectionWithBothFederatedIds.SetActivityStreamsOrderedItems(oi)
-	}()
 }
 
 // wrappedInCreate returns a Create activity wrapping the given type.
@@ -314,6 +281,21 @@ func toPostInboxRequest(t vocab.Type) *http.Request {
 	return httptest.NewRequest("POST", testMyInboxIRI, buf)
 }
 
+// toPostSharedInboxRequest creates a POST request to the shared inbox with the
+// given ActivityStreams value.
+func toPostSharedInboxRequest(t vocab.Type) *http.Request {
+	m, err := serialize(t)
+	if err != nil {
+		panic(err)
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	buf := bytes.NewBuffer(b)
+	return httptest.NewRequest("POST", testMySharedInboxIRI, buf)
+}
+
 // toPostOutboxRequest creates a new POST HTTP request with the given type as
 // the payload.
 func toPostOutboxRequest(t vocab.Type) *http.Request {
@@ -0,0 +1,78 @@
+package pubtest
+
+import (
+	"github.com/go-fed/activity/pub"
+	"sync"
+	"time"
+)
+
+// Clock must be a pub.TimerClock.
+var _ pub.TimerClock = &Clock{}
+
+// Clock is a pub.TimerClock whose time only changes when the test changes it.
+//
+// It is safe to use concurrently.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// waiter is a channel waiting for the Clock to reach a time.
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewClock returns a Clock set to the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel receiving the current time once the Clock has been
+// moved forward by d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := waiter{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	c.fire()
+	return w.ch
+}
+
+// Set changes the current time of the Clock.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	c.fire()
+}
+
+// Advance moves the current time of the Clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// fire sends the current time to the waiters it has reached. The lock must be
+// held.
+func (c *Clock) fire() {
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if c.now.Before(w.at) {
+			waiting = append(waiting, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = waiting
+}
@@ -0,0 +1,491 @@
+package pubtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// blockedFragment is the fragment of the ids of blocked collections, which
+// cannot be requested over HTTP.
+const blockedFragment = "blocked"
+
+// Database must be a pub.Database.
+var _ pub.Database = &Database{}
+
+// Database is a pub.Database that keeps every value in memory.
+//
+// It owns the IRIs of a single host. Values are stored as JSON, so that like a
+// real database, modifying a value obtained from it has no effect until it is
+// saved again.
+//
+// Lock and Unlock take a real lock for each IRI, so that a test exercises the
+// same contention as an application would. Acquiring a lock that is already
+// held blocks until it is released or the context is done.
+//
+// It is safe to use concurrently.
+type Database struct {
+	host string
+	// locksMu guards locks.
+	locksMu sync.Mutex
+	locks   map[string]chan struct{}
+	// mu guards the remaining fields.
+	mu sync.Mutex
+	// values maps ids to JSON values.
+	values map[string][]byte
+	// blocked maps the ids of the private blocked collections of actors to
+	// their JSON values. They are kept apart from values so that Get never
+	// returns them.
+	blocked map[string][]byte
+	// inboxes and outboxes map box IRIs to the IRIs of their actors.
+	inboxes  map[string]string
+	outboxes map[string]string
+	// boxItems maps box IRIs to their items, newest first.
+	boxItems map[string][]*url.URL
+	// nextId is the number of the next id returned by NewId.
+	nextId int
+}
+
+// NewDatabase returns an empty Database that owns the IRIs of the host.
+func NewDatabase(host string) *Database {
+	return &Database{
+		host:     host,
+		locks:    make(map[string]chan struct{}),
+		values:   make(map[string][]byte),
+		blocked:  make(map[string][]byte),
+		inboxes:  make(map[string]string),
+		outboxes: make(map[string]string),
+		boxItems: make(map[string][]*url.URL),
+		nextId:   1,
+	}
+}
+
+// Host returns the host whose IRIs the Database owns.
+func (d *Database) Host() string {
+	return d.host
+}
+
+// Lock takes the lock for the id, waiting until it is available or the
+// context is done.
+func (d *Database) Lock(c context.Context, id *url.URL) error {
+	d.locksMu.Lock()
+	l, ok := d.locks[id.String()]
+	if !ok {
+		l = make(chan struct{}, 1)
+		d.locks[id.String()] = l
+	}
+	d.locksMu.Unlock()
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-c.Done():
+		return c.Err()
+	}
+}
+
+// Unlock releases the lock for the id. It returns an error if the lock was not
+// held, which is a bug in the caller.
+func (d *Database) Unlock(c context.Context, id *url.URL) error {
+	d.locksMu.Lock()
+	l, ok := d.locks[id.String()]
+	d.locksMu.Unlock()
+	if ok {
+		select {
+		case <-l:
+			return nil
+		default:
+		}
+	}
+	return fmt.Errorf("unlock of %s which is not locked", id)
+}
+
+// InboxContains returns true if the inbox contains the id.
+func (d *Database) InboxContains(c context.Context, inbox, id *url.URL) (contains bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, elem := range d.boxItems[inbox.String()] {
+		if elem.String() == id.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PrependInboxItem adds the item to the front of the inbox.
+func (d *Database) PrependInboxItem(c context.Context, inboxIRI, item *url.URL) error {
+	d.prependBoxItem(inboxIRI, item)
+	return nil
+}
+
+// GetInboxPage returns the page of the inbox selected by the query. An inbox
+// without items has an empty page.
+func (d *Database) GetInboxPage(c context.Context, inboxIRI *url.URL, q pub.BoxPageQuery) (page pub.BoxPage, err error) {
+	return d.getBoxPage(inboxIRI, q), nil
+}
+
+// Owns returns true if the id is an IRI of the Database's host with a value.
+func (d *Database) Owns(c context.Context, id *url.URL) (owns bool, err error) {
+	if id.Host != d.host {
+		return false, nil
+	}
+	return d.Exists(c, id)
+}
+
+// ActorForOutbox returns the IRI of the actor whose outbox is outboxIRI.
+func (d *Database) ActorForOutbox(c context.Context, outboxIRI *url.URL) (actorIRI *url.URL, err error) {
+	d.mu.Lock()
+	id, ok := d.outboxes[outboxIRI.String()]
+	d.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no actor for outbox %s", outboxIRI)
+	}
+	return url.Parse(id)
+}
+
+// ActorForInbox returns the IRI of the actor whose inbox is inboxIRI.
+func (d *Database) ActorForInbox(c context.Context, inboxIRI *url.URL) (actorIRI *url.URL, err error) {
+	d.mu.Lock()
+	id, ok := d.inboxes[inboxIRI.String()]
+	d.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no actor for inbox %s", inboxIRI)
+	}
+	return url.Parse(id)
+}
+
+// Exists returns true if there is a value for the id.
+func (d *Database) Exists(c context.Context, id *url.URL) (exists bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, exists = d.values[id.String()]
+	return
+}
+
+// Get returns the value for the id.
+func (d *Database) Get(c context.Context, id *url.URL) (value vocab.Type, err error) {
+	m, err := d.getJSON(id)
+	if err != nil {
+		return nil, err
+	}
+	return streams.ToType(c, m)
+}
+
+// Create saves a new value.
+func (d *Database) Create(c context.Context, asType vocab.Type) error {
+	return d.set(asType)
+}
+
+// Update saves an existing value. Blocked collections are saved apart from the
+// other values.
+func (d *Database) Update(c context.Context, asType vocab.Type) error {
+	id, err := pub.GetId(asType)
+	if err != nil {
+		return err
+	}
+	if id.Host != d.host || id.Fragment != blockedFragment {
+		return d.set(asType)
+	}
+	m, err := pub.Serialize(asType)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.blocked[id.String()] = b
+	return nil
+}
+
+// Delete removes the value for the id.
+func (d *Database) Delete(c context.Context, id *url.URL) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.values, id.String())
+	for box, actor := range d.inboxes {
+		if actor == id.String() {
+			delete(d.inboxes, box)
+			delete(d.boxItems, box)
+		}
+	}
+	for box, actor := range d.outboxes {
+		if actor == id.String() {
+			delete(d.outboxes, box)
+			delete(d.boxItems, box)
+		}
+	}
+	return nil
+}
+
+// PrependOutboxItem adds the item to the front of the outbox.
+func (d *Database) PrependOutboxItem(c context.Context, outboxIRI, item *url.URL) error {
+	d.prependBoxItem(outboxIRI, item)
+	return nil
+}
+
+// GetOutboxPage returns the page of the outbox selected by the query. An
+// outbox without items has an empty page.
+func (d *Database) GetOutboxPage(c context.Context, outboxIRI *url.URL, q pub.BoxPageQuery) (page pub.BoxPage, err error) {
+	return d.getBoxPage(outboxIRI, q), nil
+}
+
+// NewId returns a new IRI on the Database's host, such as
+// "https://example.com/note/1" for a Note.
+func (d *Database) NewId(c context.Context, t vocab.Type) (id *url.URL, err error) {
+	d.mu.Lock()
+	n := d.nextId
+	d.nextId++
+	d.mu.Unlock()
+	return &url.URL{
+		Scheme: "https",
+		Host:   d.host,
+		Path:   fmt.Sprintf("/%s/%d", strings.ToLower(t.GetTypeName()), n),
+	}, nil
+}
+
+// Followers returns the collection of the actor's 'followers' property.
+func (d *Database) Followers(c context.Context, actorIRI *url.URL) (followers vocab.ActivityStreamsCollection, err error) {
+	return d.actorCollection(c, actorIRI, "followers")
+}
+
+// Following returns the collection of the actor's 'following' property.
+func (d *Database) Following(c context.Context, actorIRI *url.URL) (following vocab.ActivityStreamsCollection, err error) {
+	return d.actorCollection(c, actorIRI, "following")
+}
+
+// LocalFollowers returns the actors of the Database's host whose 'following'
+// collection contains the actor.
+func (d *Database) LocalFollowers(c context.Context, actorIRI *url.URL) (followers []*url.URL, err error) {
+	d.mu.Lock()
+	actors := make([]string, 0, len(d.inboxes))
+	for _, actor := range d.inboxes {
+		actors = append(actors, actor)
+	}
+	d.mu.Unlock()
+	sort.Strings(actors)
+	for _, actor := range actors {
+		id, err := url.Parse(actor)
+		if err != nil {
+			return nil, err
+		} else if id.Host != d.host {
+			continue
+		}
+		following, err := d.Following(c, id)
+		if err != nil {
+			continue
+		}
+		items := following.GetActivityStreamsItems()
+		if items == nil {
+			continue
+		}
+		for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+			if iid, err := pub.ToId(iter); err == nil && iid.String() == actorIRI.String() {
+				followers = append(followers, id)
+				break
+			}
+		}
+	}
+	return
+}
+
+// Liked returns the collection of the actor's 'liked' property.
+func (d *Database) Liked(c context.Context, actorIRI *url.URL) (liked vocab.ActivityStreamsCollection, err error) {
+	return d.actorCollection(c, actorIRI, "liked")
+}
+
+// Blocked returns the private collection of the actors blocked by the actor,
+// creating it the first time it is needed. Its id is the actor's id with the
+// "blocked" fragment, and it is kept apart from the other values, so that it is
+// never served.
+func (d *Database) Blocked(c context.Context, actorIRI *url.URL) (blocked vocab.ActivityStreamsCollection, err error) {
+	id := *actorIRI
+	id.Fragment = blockedFragment
+	d.mu.Lock()
+	b, ok := d.blocked[id.String()]
+	d.mu.Unlock()
+	if !ok {
+		col := streams.NewActivityStreamsCollection()
+		setId(col, &id)
+		col.SetActivityStreamsItems(streams.NewActivityStreamsItemsProperty())
+		return col, nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	t, err := streams.ToType(c, m)
+	if err != nil {
+		return nil, err
+	}
+	col, ok := t.(vocab.ActivityStreamsCollection)
+	if !ok {
+		return nil, fmt.Errorf("blocked of %s is not a Collection", actorIRI)
+	}
+	if col.GetActivityStreamsItems() == nil {
+		col.SetActivityStreamsItems(streams.NewActivityStreamsItemsProperty())
+	}
+	return col, nil
+}
+
+// ItemIds returns the ids of the items of the inbox or outbox with the given
+// id, newest first, or else of the 'items' or 'orderedItems' of the collection
+// with the given id, in order. It is a convenience for making assertions in
+// tests.
+func (d *Database) ItemIds(c context.Context, id *url.URL) (ids []*url.URL, err error) {
+	d.mu.Lock()
+	boxIds, isBox := d.boxItems[id.String()]
+	d.mu.Unlock()
+	if isBox {
+		return append(ids, boxIds...), nil
+	}
+	m, err := d.getJSON(id)
+	if err != nil {
+		return nil, err
+	}
+	var items interface{}
+	if v, ok := m["orderedItems"]; ok {
+		items = v
+	} else {
+		items = m["items"]
+	}
+	var arr []interface{}
+	switch v := items.(type) {
+	case nil:
+	case []interface{}:
+		arr = v
+	default:
+		arr = []interface{}{v}
+	}
+	for _, elem := range arr {
+		s, ok := elem.(string)
+		if !ok {
+			obj, isObj := elem.(map[string]interface{})
+			if !isObj {
+				return nil, fmt.Errorf("item of %s is neither an IRI nor an object", id)
+			}
+			s, ok = obj["id"].(string)
+			if !ok {
+				return nil, fmt.Errorf("item of %s has no id", id)
+			}
+		}
+		iid, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, iid)
+	}
+	return
+}
+
+// prependBoxItem adds the item to the front of the inbox or outbox.
+func (d *Database) prependBoxItem(boxIRI, item *url.URL) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.boxItems[boxIRI.String()] = append([]*url.URL{item}, d.boxItems[boxIRI.String()]...)
+}
+
+// getBoxPage returns the page of the inbox or outbox selected by the query.
+func (d *Database) getBoxPage(boxIRI *url.URL, q pub.BoxPageQuery) pub.BoxPage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return pub.PageBoxItems(d.boxItems[boxIRI.String()], q)
+}
+
+// actorCollection returns the collection that is the value of the property of
+// the actor.
+func (d *Database) actorCollection(c context.Context, actorIRI *url.URL, property string) (vocab.ActivityStreamsCollection, error) {
+	m, err := d.getJSON(actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := m[property].(string)
+	if !ok {
+		return nil, fmt.Errorf("actor %s has no %s IRI", actorIRI, property)
+	}
+	id, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	t, err := d.Get(c, id)
+	if err != nil {
+		return nil, err
+	}
+	col, ok := t.(vocab.ActivityStreamsCollection)
+	if !ok {
+		return nil, fmt.Errorf("%s of %s is not a Collection", property, actorIRI)
+	}
+	// The library expects to be able to add items to the collection.
+	if col.GetActivityStreamsItems() == nil {
+		col.SetActivityStreamsItems(streams.NewActivityStreamsItemsProperty())
+	}
+	return col, nil
+}
+
+// getJSON returns the JSON value with the id.
+func (d *Database) getJSON(id *url.URL) (map[string]interface{}, error) {
+	d.mu.Lock()
+	b, ok := d.values[id.String()]
+	d.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no value for %s", id)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// set saves the value as JSON, keyed by its id, and records which local actor
+// its inbox and outbox belong to.
+func (d *Database) set(t vocab.Type) error {
+	id, err := pub.GetId(t)
+	if err != nil {
+		return err
+	}
+	m, err := pub.Serialize(t)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.values[id.String()] = b
+	if inbox, ok := d.localBox(m, id, "inbox"); ok {
+		d.inboxes[inbox] = id.String()
+	}
+	if outbox, ok := d.localBox(m, id, "outbox"); ok {
+		d.outboxes[outbox] = id.String()
+	}
+	return nil
+}
+
+// localBox returns the IRI of the inbox or outbox named by the property of the
+// stored value, if both the value and the box are on the Database's host.
+// Federated values must not make local boxes resolve to them.
+func (d *Database) localBox(m map[string]interface{}, id *url.URL, property string) (string, bool) {
+	if id.Host != d.host {
+		return "", false
+	}
+	box, ok := m[property].(string)
+	if !ok {
+		return "", false
+	}
+	boxIRI, err := url.Parse(box)
+	if err != nil || boxIRI.Host != d.host {
+		return "", false
+	}
+	return box, true
+}
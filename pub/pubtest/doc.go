@@ -0,0 +1,8 @@
+// Package pubtest provides in-memory implementations of the interfaces required
+// by the pub package, for testing applications without a database or network.
+//
+// A Network routes HTTP requests between fake Servers, each hosting the actors
+// of one domain with its own Database and pub.Actor. Together with a Clock
+// under the test's control, this allows a test to federate activities between
+// several servers and to assert on the side effects on each of them.
+package pubtest
@@ -0,0 +1,190 @@
+package pubtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+)
+
+const (
+	// activityStreamsMediaType is the Accept header value of dereferences.
+	activityStreamsMediaType = "application/activity+json"
+	// ldMediaType is the Content-Type header value of deliveries.
+	ldMediaType = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+)
+
+// Network must be a pub.HttpClient.
+var _ pub.HttpClient = &Network{}
+
+// Network routes HTTP requests to the handlers of fake hosts in the same
+// process, without using the network.
+//
+// It is a pub.HttpClient, so it may also be used by an HttpSigTransport to test
+// signed requests.
+//
+// It is safe to use concurrently.
+type Network struct {
+	clock *Clock
+	// mu guards hosts.
+	mu    sync.RWMutex
+	hosts map[string]http.Handler
+}
+
+// NewNetwork returns a Network with no hosts.
+func NewNetwork(clock *Clock) *Network {
+	return &Network{
+		clock: clock,
+		hosts: make(map[string]http.Handler),
+	}
+}
+
+// Clock returns the Clock shared by the Servers of the Network.
+func (n *Network) Clock() *Clock {
+	return n.clock
+}
+
+// Handle routes the requests to the host to the handler, replacing any
+// previous handler. A nil handler removes the host, after which requests to it
+// fail as if it was unreachable.
+func (n *Network) Handle(host string, h http.Handler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if h == nil {
+		delete(n.hosts, host)
+	} else {
+		n.hosts[host] = h
+	}
+}
+
+// Do sends the request to the handler of its host, returning an error if no
+// handler serves the host.
+//
+// The handler receives the request with its absolute URL, as the pub package
+// expects.
+func (n *Network) Do(req *http.Request) (*http.Response, error) {
+	n.mu.RLock()
+	h, ok := n.hosts[req.URL.Host]
+	n.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no such host: %s", req.URL.Host)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	r := req.Clone(req.Context())
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.RequestURI = req.URL.RequestURI()
+	r.RemoteAddr = "192.0.2.1:1234"
+	if len(r.Host) == 0 {
+		r.Host = req.URL.Host
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// NewTransport returns a pub.Transport that sends unsigned requests over the
+// Network on behalf of the actor of the box.
+func (n *Network) NewTransport(actorBoxIRI *url.URL, gofedAgent string) pub.Transport {
+	return &transport{
+		n:     n,
+		agent: gofedAgent,
+	}
+}
+
+// transport must be a pub.Transport.
+var _ pub.Transport = &transport{}
+
+// transport makes unsigned requests over a Network.
+type transport struct {
+	n     *Network
+	agent string
+}
+
+// Dereference sends a GET request for the ActivityStreams value of the IRI.
+func (t *transport) Dereference(c context.Context, iri *url.URL) ([]byte, error) {
+	req, err := http.NewRequest("GET", iri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(c)
+	req.Header.Set("Accept", activityStreamsMediaType)
+	t.addHeaders(req)
+	resp, err := t.n.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &pub.HttpStatusError{
+			Method:     "GET",
+			IRI:        iri,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Deliver sends a POST request with the payload. Any 2xx response is a
+// successful delivery.
+func (t *transport) Deliver(c context.Context, b []byte, to *url.URL) error {
+	req, err := http.NewRequest("POST", to.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(c)
+	req.Header.Set("Content-Type", ldMediaType)
+	t.addHeaders(req)
+	resp, err := t.n.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &pub.HttpStatusError{
+			Method:     "POST",
+			IRI:        to,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	return nil
+}
+
+// BatchDeliver delivers the payload to each recipient in turn, returning a
+// pub.BatchDeliverError if any of the deliveries failed.
+func (t *transport) BatchDeliver(c context.Context, b []byte, recipients []*url.URL) error {
+	results := make([]pub.DeliveryResult, 0, len(recipients))
+	failed := false
+	for _, to := range recipients {
+		err := t.Deliver(c, b, to)
+		failed = failed || err != nil
+		results = append(results, pub.DeliveryResult{Recipient: to, Err: err})
+	}
+	if failed {
+		return &pub.BatchDeliverError{Results: results}
+	}
+	return nil
+}
+
+// addHeaders sets the headers common to all requests.
+func (t *transport) addHeaders(req *http.Request) {
+	req.Header.Set("Date", t.n.clock.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("User-Agent", t.agent)
+}
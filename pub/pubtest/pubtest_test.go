@@ -0,0 +1,247 @@
+package pubtest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// follow builds a Follow of the object by the actor.
+func follow(actor, object vocab.ActivityStreamsPerson) vocab.ActivityStreamsFollow {
+	f := streams.NewActivityStreamsFollow()
+	a := streams.NewActivityStreamsActorProperty()
+	a.AppendIRI(actor.GetActivityStreamsId().Get())
+	f.SetActivityStreamsActor(a)
+	attrTo := streams.NewActivityStreamsAttributedToProperty()
+	attrTo.AppendIRI(actor.GetActivityStreamsId().Get())
+	f.SetActivityStreamsAttributedTo(attrTo)
+	op := streams.NewActivityStreamsObjectProperty()
+	op.AppendIRI(object.GetActivityStreamsId().Get())
+	f.SetActivityStreamsObject(op)
+	to := streams.NewActivityStreamsToProperty()
+	to.AppendIRI(object.GetActivityStreamsId().Get())
+	f.SetActivityStreamsTo(to)
+	return f
+}
+
+// TestFederation ensures activities are federated between the Servers of a
+// Network.
+func TestFederation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Setup
+	n := NewNetwork(NewClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+	var servers []*Server
+	var people []vocab.ActivityStreamsPerson
+	for _, host := range []string{"a.example", "b.example", "c.example"} {
+		s := n.NewServer(host)
+		p, err := s.NewPerson(ctx, "alex")
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, s)
+		people = append(people, p)
+	}
+	// Run
+	var follows []*url.URL
+	for i, s := range servers {
+		id, err := s.PostOutbox(ctx, people[i].GetActivityStreamsOutbox().GetIRI(), follow(people[i], people[(i+1)%len(people)]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		follows = append(follows, id)
+	}
+	// Verify
+	for i, s := range servers {
+		outbox, err := s.Database().ItemIds(ctx, people[i].GetActivityStreamsOutbox().GetIRI())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(outbox) != 1 || outbox[0].String() != follows[i].String() {
+			t.Errorf("outbox of %s: got %v, want %s", s.Host(), outbox, follows[i])
+		}
+		prev := (i + len(servers) - 1) % len(servers)
+		contains, err := s.Database().InboxContains(ctx, people[i].GetActivityStreamsInbox().GetIRI(), follows[prev])
+		if err != nil {
+			t.Fatal(err)
+		} else if !contains {
+			t.Errorf("inbox of %s does not contain %s", s.Host(), follows[prev])
+		}
+		reports := s.Reports()
+		if len(reports) != 1 || reports[0].Delivered() != 1 {
+			t.Errorf("reports of %s: got %v, want a single delivery", s.Host(), reports)
+		}
+	}
+}
+
+// TestSharedInboxLikeAddedOnce ensures a Like delivered to the shared inbox
+// for several local actors is added once to the 'likes' of its object.
+func TestSharedInboxLikeAddedOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Setup
+	n := NewNetwork(NewClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+	s := n.NewServer("a.example")
+	var people []vocab.ActivityStreamsPerson
+	for _, name := range []string{"alex", "blake"} {
+		p, err := s.NewPerson(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		people = append(people, p)
+	}
+	noteId := &url.URL{Scheme: "https", Host: "a.example", Path: "/users/alex/note/1"}
+	note := streams.NewActivityStreamsNote()
+	setId(note, noteId)
+	if err := s.Database().Create(ctx, note); err != nil {
+		t.Fatal(err)
+	}
+	like := streams.NewActivityStreamsLike()
+	setId(like, &url.URL{Scheme: "https", Host: "b.example", Path: "/users/casey/like/1"})
+	actor := streams.NewActivityStreamsActorProperty()
+	actor.AppendIRI(&url.URL{Scheme: "https", Host: "b.example", Path: "/users/casey"})
+	like.SetActivityStreamsActor(actor)
+	op := streams.NewActivityStreamsObjectProperty()
+	op.AppendIRI(noteId)
+	like.SetActivityStreamsObject(op)
+	to := streams.NewActivityStreamsToProperty()
+	for _, p := range people {
+		to.AppendIRI(p.GetActivityStreamsId().Get())
+	}
+	like.SetActivityStreamsTo(to)
+	m, err := pub.Serialize(like)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Run
+	err = n.NewTransport(nil, "test").Deliver(ctx, b, &url.URL{Scheme: "https", Host: "a.example", Path: sharedInboxPath})
+	// Verify
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range people {
+		contains, err := s.Database().InboxContains(ctx, p.GetActivityStreamsInbox().GetIRI(), like.GetActivityStreamsId().Get())
+		if err != nil {
+			t.Fatal(err)
+		} else if !contains {
+			t.Errorf("inbox of %s does not contain the Like", p.GetActivityStreamsId().Get())
+		}
+	}
+	v, err := s.Database().Get(ctx, noteId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	likes := v.(vocab.ActivityStreamsNote).GetActivityStreamsLikes()
+	if likes == nil {
+		t.Fatal("no likes on the Note")
+	}
+	items := likes.GetActivityStreamsCollection().GetActivityStreamsItems()
+	if items.Len() != 1 {
+		t.Errorf("got %d likes, want 1", items.Len())
+	}
+}
+
+// TestUnreachableHost ensures deliveries to a host without a Server fail.
+func TestUnreachableHost(t *testing.T) {
+	ctx := context.Background()
+	// Setup
+	n := NewNetwork(NewClock(time.Now()))
+	tp := n.NewTransport(nil, "test")
+	// Run
+	err := tp.Deliver(ctx, []byte(`{}`), &url.URL{Scheme: "https", Host: "gone.example", Path: "/inbox"})
+	// Verify
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, ok := err.(*pub.HttpStatusError); ok {
+		t.Fatalf("got a response from an unreachable host: %v", err)
+	}
+}
+
+// TestDatabaseLock ensures the lock of an IRI is exclusive.
+func TestDatabaseLock(t *testing.T) {
+	db := NewDatabase("a.example")
+	id := &url.URL{Scheme: "https", Host: "a.example", Path: "/note/1"}
+	if err := db.Lock(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := db.Lock(ctx, id); err == nil {
+		t.Fatal("took a lock that is already held")
+	}
+	if err := db.Unlock(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Unlock(context.Background(), id); err == nil {
+		t.Fatal("released a lock that is not held")
+	}
+}
+
+// TestDatabaseFederatedValueDoesNotClaimBoxes ensures a federated actor naming
+// a local inbox does not make the inbox resolve to it.
+func TestDatabaseFederatedValueDoesNotClaimBoxes(t *testing.T) {
+	ctx := context.Background()
+	// Setup
+	s := NewNetwork(NewClock(time.Now())).NewServer("a.example")
+	local, err := s.NewPerson(ctx, "alex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inboxIRI := local.GetActivityStreamsInbox().GetIRI()
+	remote := streams.NewActivityStreamsPerson()
+	id := streams.NewActivityStreamsIdProperty()
+	id.Set(&url.URL{Scheme: "https", Host: "b.example", Path: "/users/sam"})
+	remote.SetActivityStreamsId(id)
+	inbox := streams.NewActivityStreamsInboxProperty()
+	inbox.SetIRI(inboxIRI)
+	remote.SetActivityStreamsInbox(inbox)
+	outboxIRI := &url.URL{Scheme: "https", Host: "b.example", Path: "/users/sam/outbox"}
+	outbox := streams.NewActivityStreamsOutboxProperty()
+	outbox.SetIRI(outboxIRI)
+	remote.SetActivityStreamsOutbox(outbox)
+	// Run
+	err = s.Database().Create(ctx, remote)
+	// Verify
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Database().ActorForInbox(ctx, inboxIRI); err != nil || got.String() != local.GetActivityStreamsId().Get().String() {
+		t.Fatalf("got actor %v err=%v for the local inbox", got, err)
+	}
+	if _, err := s.Database().ActorForOutbox(ctx, outboxIRI); err == nil {
+		t.Fatal("federated outbox resolves to an actor")
+	}
+}
+
+// TestClockAfter ensures the channels returned by After receive the time once
+// the Clock reaches it.
+func TestClockAfter(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := NewClock(start)
+	ch := clock.After(time.Minute)
+	clock.Advance(30 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("received the time before it was reached")
+	default:
+	}
+	clock.Advance(30 * time.Second)
+	select {
+	case now := <-ch:
+		if !now.Equal(start.Add(time.Minute)) {
+			t.Errorf("got %s, want %s", now, start.Add(time.Minute))
+		}
+	default:
+		t.Fatal("did not receive the time once it was reached")
+	}
+}
@@ -0,0 +1,329 @@
+package pubtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// sharedInboxPath is the path of the shared inbox of each Server.
+	sharedInboxPath = "/inbox"
+	// usersPath is the path under which each Server's actors live.
+	usersPath = "/users/"
+)
+
+// Server is a fake federated server hosting the actors of a single domain on
+// a Network. It has its own Database and a pub.Actor supporting both the
+// Social and Federating Protocols.
+//
+// Every request is authenticated and authorized, no actor is blocked, and
+// deliveries are made immediately with unsigned requests over the Network. The
+// outcome of each delivery is kept, and is available from Reports.
+//
+// Actors created by NewPerson live at "https://<host>/users/<name>", and their
+// inbox, outbox, followers, following, and liked collections at
+// "https://<host>/users/<name>/inbox" and so on. The shared inbox is
+// "https://<host>/inbox". The boxes and collections are served in pages.
+type Server struct {
+	// FederatingCallbacks and SocialCallbacks are used by the Actor when
+	// handling activities in inboxes and outboxes, respectively. They must
+	// be set before the Server handles any request.
+	FederatingCallbacks pub.FederatingWrappedCallbacks
+	SocialCallbacks     pub.SocialWrappedCallbacks
+
+	host    string
+	db      *Database
+	actor   pub.Actor
+	network *Network
+	handler pub.HandlerFunc
+	// collections serves the followers, following, and liked collections.
+	collections pub.HandlerFunc
+	// mu guards reports.
+	mu      sync.Mutex
+	reports []pub.DeliveryReport
+}
+
+// NewServer returns a Server for the host, and routes the Network's requests to
+// the host to it.
+func (n *Network) NewServer(host string) *Server {
+	s := &Server{
+		host:    host,
+		db:      NewDatabase(host),
+		network: n,
+	}
+	s.actor = pub.NewActor(common{s}, social{s}, federating{s}, s.db, n.clock)
+	permit := func(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+		return false, nil
+	}
+	s.handler = pub.NewActivityStreamsHandler(permit, s.db, n.clock)
+	s.collections = pub.NewCollectionHandler(permit, pub.NewDatabaseCollectionPager(s.db), n.clock)
+	n.Handle(host, s)
+	return s
+}
+
+// Host returns the domain of the Server.
+func (s *Server) Host() string {
+	return s.host
+}
+
+// Database returns the Database of the Server.
+func (s *Server) Database() *Database {
+	return s.db
+}
+
+// Actor returns the pub.Actor handling the Server's requests.
+func (s *Server) Actor() pub.Actor {
+	return s.actor
+}
+
+// Reports returns the DeliveryReports of every delivery made by the Server, in
+// the order the deliveries were made.
+func (s *Server) Reports() []pub.DeliveryReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := make([]pub.DeliveryReport, len(s.reports))
+	copy(r, s.reports)
+	return r
+}
+
+// NewPerson creates a Person with the given name on the Server, along with its
+// empty inbox, outbox, and collections.
+func (s *Server) NewPerson(c context.Context, name string) (vocab.ActivityStreamsPerson, error) {
+	actorIRI := &url.URL{Scheme: "https", Host: s.host, Path: usersPath + name}
+	iri := func(suffix string) *url.URL {
+		u := *actorIRI
+		u.Path += "/" + suffix
+		return &u
+	}
+	p := streams.NewActivityStreamsPerson()
+	setId(p, actorIRI)
+	username := streams.NewActivityStreamsPreferredUsernameProperty()
+	username.SetXMLSchemaString(name)
+	p.SetActivityStreamsPreferredUsername(username)
+	inbox := streams.NewActivityStreamsInboxProperty()
+	inbox.SetIRI(iri("inbox"))
+	p.SetActivityStreamsInbox(inbox)
+	outbox := streams.NewActivityStreamsOutboxProperty()
+	outbox.SetIRI(iri("outbox"))
+	p.SetActivityStreamsOutbox(outbox)
+	followers := streams.NewActivityStreamsFollowersProperty()
+	followers.SetIRI(iri("followers"))
+	p.SetActivityStreamsFollowers(followers)
+	following := streams.NewActivityStreamsFollowingProperty()
+	following.SetIRI(iri("following"))
+	p.SetActivityStreamsFollowing(following)
+	liked := streams.NewActivityStreamsLikedProperty()
+	liked.SetIRI(iri("liked"))
+	p.SetActivityStreamsLiked(liked)
+	for _, suffix := range []string{"followers", "following", "liked"} {
+		col := streams.NewActivityStreamsCollection()
+		setId(col, iri(suffix))
+		col.SetActivityStreamsItems(streams.NewActivityStreamsItemsProperty())
+		if err := s.db.Create(c, col); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.db.Create(c, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PostOutbox posts the value to the outbox over the Network, as a client of the
+// Social Protocol would, and returns the id of the resulting activity.
+func (s *Server) PostOutbox(c context.Context, outboxIRI *url.URL, t vocab.Type) (id *url.URL, err error) {
+	m, err := pub.Serialize(t)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", outboxIRI.String(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(c)
+	req.Header.Set("Content-Type", ldMediaType)
+	resp, err := s.network.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("post to %s failed: %s", outboxIRI, resp.Status)
+	}
+	return url.Parse(resp.Header.Get("Location"))
+}
+
+// ServeHTTP routes the request to the Actor, or serves the requested value
+// from the Database.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := r.Context()
+	var handled bool
+	var err error
+	switch path := r.URL.Path; {
+	case path == sharedInboxPath:
+		handled, err = s.actor.PostSharedInbox(c, w, r)
+	case strings.HasSuffix(path, "/inbox"):
+		if handled, err = s.actor.PostInbox(c, w, r); err == nil && !handled {
+			handled, err = s.actor.GetInbox(c, w, r)
+		}
+	case strings.HasSuffix(path, "/outbox"):
+		if handled, err = s.actor.PostOutbox(c, w, r); err == nil && !handled {
+			handled, err = s.actor.GetOutbox(c, w, r)
+		}
+	default:
+		var exists bool
+		if exists, err = s.db.Exists(c, &url.URL{Scheme: r.URL.Scheme, Host: r.URL.Host, Path: path}); err == nil && !exists {
+			http.NotFound(w, r)
+			return
+		} else if err == nil && isCollection(path) {
+			handled, err = s.collections(c, w, r)
+		} else if err == nil {
+			handled, err = s.handler(c, w, r)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else if !handled {
+		http.NotFound(w, r)
+	}
+}
+
+// isCollection returns true if the path is of an actor's followers, following,
+// or liked collection, which are served in pages.
+func isCollection(path string) bool {
+	return strings.HasSuffix(path, "/followers") ||
+		strings.HasSuffix(path, "/following") ||
+		strings.HasSuffix(path, "/liked")
+}
+
+// common implements the pub.CommonBehavior of a Server.
+type common struct {
+	s *Server
+}
+
+// AuthenticateGetInbox permits every request.
+func (p common) AuthenticateGetInbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	return true, nil
+}
+
+// AuthenticateGetOutbox permits every request.
+func (p common) AuthenticateGetOutbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	return true, nil
+}
+
+// NewTransport returns a Transport over the Server's Network.
+func (p common) NewTransport(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (pub.Transport, error) {
+	return p.s.network.NewTransport(actorBoxIRI, gofedAgent), nil
+}
+
+// Visibility returns nil, so that every item of the boxes is served.
+func (p common) Visibility(c context.Context) *pub.VisibilityPolicy {
+	return nil
+}
+
+// social implements the pub.SocialProtocol of a Server.
+type social struct {
+	s *Server
+}
+
+// AuthenticatePostOutbox permits every request.
+func (p social) AuthenticatePostOutbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	return true, nil
+}
+
+// Callbacks returns the Server's SocialCallbacks.
+func (p social) Callbacks(c context.Context) (pub.SocialWrappedCallbacks, []interface{}) {
+	return p.s.SocialCallbacks, nil
+}
+
+// DefaultCallback ignores activities without a callback.
+func (p social) DefaultCallback(c context.Context, activity pub.Activity) error {
+	return nil
+}
+
+// GetOutbox serves the whole page to every request.
+func (p social) GetOutbox(c context.Context, r *http.Request, outboxIRI *url.URL, q pub.BoxPageQuery, page pub.BoxPage) (pub.BoxPage, error) {
+	return page, nil
+}
+
+// federating implements the pub.FederatingProtocol of a Server.
+type federating struct {
+	s *Server
+}
+
+// AuthenticatePostInbox permits every request.
+func (p federating) AuthenticatePostInbox(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	return true, nil
+}
+
+// Blocked blocks no actor.
+func (p federating) Blocked(c context.Context, actorIRIs []*url.URL) (bool, error) {
+	return false, nil
+}
+
+// Callbacks returns the Server's FederatingCallbacks.
+func (p federating) Callbacks(c context.Context) (pub.FederatingWrappedCallbacks, []interface{}) {
+	return p.s.FederatingCallbacks, nil
+}
+
+// DefaultCallback ignores activities without a callback.
+func (p federating) DefaultCallback(c context.Context, activity pub.Activity) error {
+	return nil
+}
+
+// MaxInboxForwardingRecursionDepth does not limit the recursion.
+func (p federating) MaxInboxForwardingRecursionDepth(c context.Context) int {
+	return 0
+}
+
+// MaxDeliveryRecursionDepth does not limit the recursion.
+func (p federating) MaxDeliveryRecursionDepth(c context.Context) int {
+	return 0
+}
+
+// DeliveryQueue returns nil, so that deliveries are made immediately.
+func (p federating) DeliveryQueue(c context.Context) *pub.DeliveryQueue {
+	return nil
+}
+
+// ReportDelivery keeps the report for Reports.
+func (p federating) ReportDelivery(c context.Context, report pub.DeliveryReport) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+	p.s.reports = append(p.s.reports, report)
+}
+
+// FilterForwarding forwards to every recipient.
+func (p federating) FilterForwarding(c context.Context, potentialRecipients []*url.URL, a pub.Activity) ([]*url.URL, error) {
+	return potentialRecipients, nil
+}
+
+// GetInbox serves the whole page to every request.
+func (p federating) GetInbox(c context.Context, r *http.Request, inboxIRI *url.URL, q pub.BoxPageQuery, page pub.BoxPage) (pub.BoxPage, error) {
+	return page, nil
+}
+
+// idSetter is a value with an 'id' property.
+type idSetter interface {
+	SetActivityStreamsId(i vocab.ActivityStreamsIdProperty)
+}
+
+// setId sets the 'id' property of the value.
+func setId(t idSetter, id *url.URL) {
+	idProp := streams.NewActivityStreamsIdProperty()
+	idProp.Set(id)
+	t.SetActivityStreamsId(idProp)
+}
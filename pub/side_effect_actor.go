@@ -8,7 +8,6 @@ import (
 	"github.com/go-fed/activity/streams/vocab"
 	"net/http"
 	"net/url"
-	"strings"
 )
 
 // sideEffectActor must satisfy the DelegateActor interface.
@@ -34,8 +33,12 @@ func (a *sideEffectActor) AuthenticatePostInbox(c context.Context, w http.Respon
 	return a.s2s.AuthenticatePostInbox(c, w, r)
 }
 
-// AuthenticateGetInbox defers to the delegate to authenticate the request.
+// AuthenticateGetInbox refuses requests from rejected domains, and otherwise
+// defers to the delegate to authenticate the request.
 func (a *sideEffectActor) AuthenticateGetInbox(c context.Context, w http.ResponseWriter, r *http.Request) (authenticated bool, err error) {
+	if a.rejectsRequest(w, r) {
+		return false, nil
+	}
 	return a.common.AuthenticateGetInbox(c, w, r)
 }
 
@@ -44,26 +47,95 @@ func (a *sideEffectActor) AuthenticatePostOutbox(c context.Context, w http.Respo
 	return a.c2s.AuthenticatePostOutbox(c, w, r)
 }
 
-// AuthenticateGetOutbox defers to the delegate to authenticate the request.
+// AuthenticateGetOutbox refuses requests from rejected domains, and otherwise
+// defers to the delegate to authenticate the request.
 func (a *sideEffectActor) AuthenticateGetOutbox(c context.Context, w http.ResponseWriter, r *http.Request) (authenticated bool, err error) {
+	if a.rejectsRequest(w, r) {
+		return false, nil
+	}
 	return a.common.AuthenticateGetOutbox(c, w, r)
 }
 
-// GetOutbox delegates to the SocialProtocol.
-func (a *sideEffectActor) GetOutbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error) {
-	// Compiler bug? Cannot directly return here:
-	// cannot use <T> as type vocab.ActivityStreamsOrderedCollectionPage in return argument
-	v1, v2 := a.c2s.GetOutbox(c, r)
-	return v1, v2
+// rejectsRequest answers with http.StatusForbidden and returns true if the
+// request is signed with a key on a domain rejected by the InstancePolicy of
+// the FederatingProtocol, if any.
+func (a *sideEffectActor) rejectsRequest(w http.ResponseWriter, r *http.Request) bool {
+	provider, ok := a.s2s.(InstancePolicyProvider)
+	if !ok {
+		return false
+	}
+	if p := provider.InstancePolicy(); p != nil && p.RejectsRequest(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// GetOutbox obtains the outbox, or the requested page of it, from the
+// database, and passes it to the SocialProtocol. A page only has the items the
+// requester may see, if the application has a VisibilityPolicy.
+func (a *sideEffectActor) GetOutbox(c context.Context, r *http.Request) (vocab.Type, error) {
+	outboxIRI, q, isPage, err := parsePageRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	var hook boxHookFunc
+	if a.c2s != nil {
+		hook = func(c context.Context, boxIRI *url.URL, q BoxPageQuery, page BoxPage) (BoxPage, error) {
+			return a.c2s.GetOutbox(c, r, boxIRI, q, page)
+		}
+	}
+	filter, err := a.visibilityFilter(c, r, isPage)
+	if err != nil {
+		return nil, err
+	}
+	return getBox(c, a.db, a.db.GetOutboxPage, outboxIRI, q, isPage, hook, filter)
+}
+
+// GetInbox obtains the inbox, or the requested page of it, from the database,
+// and passes it to the FederatingProtocol. A page only has the items the
+// requester may see, if the application has a VisibilityPolicy.
+func (a *sideEffectActor) GetInbox(c context.Context, r *http.Request) (vocab.Type, error) {
+	inboxIRI, q, isPage, err := parsePageRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	var hook boxHookFunc
+	if a.s2s != nil {
+		hook = func(c context.Context, boxIRI *url.URL, q BoxPageQuery, page BoxPage) (BoxPage, error) {
+			return a.s2s.GetInbox(c, r, boxIRI, q, page)
+		}
+	}
+	filter, err := a.visibilityFilter(c, r, isPage)
+	if err != nil {
+		return nil, err
+	}
+	return getBox(c, a.db, a.db.GetInboxPage, inboxIRI, q, isPage, hook, filter)
 }
 
-// GetInbox delegates to the FederatingProtocol.
-func (a *sideEffectActor) GetInbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error) {
-	return a.s2s.GetInbox(c, r)
+// visibilityFilter returns a filter keeping the items of a page of an inbox or
+// outbox that the requester may see. It is nil if no page is requested or the
+// application has no VisibilityPolicy.
+func (a *sideEffectActor) visibilityFilter(c context.Context, r *http.Request, isPage bool) (boxFilterFunc, error) {
+	if !isPage {
+		return nil, nil
+	}
+	policy := a.common.Visibility(c)
+	if policy == nil {
+		return nil, nil
+	}
+	requester, err := policy.Requester(c, r)
+	if err != nil {
+		return nil, err
+	}
+	return func(c context.Context, items []*url.URL) ([]*url.URL, error) {
+		return policy.FilterVisible(c, requester, items)
+	}, nil
 }
 
 // AuthorizePostInbox defers to the federating protocol whether the peer request
-// is authorized based on the actors' ids.
+// is authorized based on the actors' ids, after applying its InstancePolicy, if
+// any.
 func (a *sideEffectActor) AuthorizePostInbox(c context.Context, w http.ResponseWriter, activity Activity) (authorized bool, err error) {
 	authorized = false
 	actor := activity.GetActivityStreamsActor()
@@ -79,6 +151,13 @@ func (a *sideEffectActor) AuthorizePostInbox(c context.Context, w http.ResponseW
 			return
 		}
 	}
+	// Apply the instance policy to the domains of the actor(s).
+	if provider, ok := a.s2s.(InstancePolicyProvider); ok {
+		if p := provider.InstancePolicy(); p != nil && !p.authorize(iris, activity) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
 	// Determine if the actor(s) sending this request are blocked.
 	var blocked bool
 	if blocked, err = a.s2s.Blocked(c, iris); err != nil {
@@ -92,9 +171,21 @@ func (a *sideEffectActor) AuthorizePostInbox(c context.Context, w http.ResponseW
 }
 
 // PostInbox handles the side effects of determining whether to block the peer's
-// request, adding the activity to the actor's inbox, and triggering side
-// effects based on the activity's type.
+// request, applying the InboxPolicies of the actor, adding the activity to the
+// actor's inbox, and triggering side effects based on the activity's type.
 func (a *sideEffectActor) PostInbox(c context.Context, inboxIRI *url.URL, activity Activity) error {
+	// Refuse activities from actors blocked by the owner of the inbox.
+	if blocked, err := a.isBlockedByInboxActor(c, inboxIRI, activity); err != nil {
+		return err
+	} else if blocked {
+		return ErrBlocked
+	}
+	// Accept, reject, or rewrite the activity according to the policies of
+	// the owner of the inbox.
+	activity, err := a.applyInboxPolicies(c, inboxIRI, activity)
+	if err != nil {
+		return err
+	}
 	isNew, err := a.addToInboxIfNew(c, inboxIRI, activity)
 	if err != nil {
 		return err
@@ -105,6 +196,7 @@ func (a *sideEffectActor) PostInbox(c context.Context, inboxIRI *url.URL, activi
 		wrapped.db = a.db
 		wrapped.inboxIRI = inboxIRI
 		wrapped.newTransport = a.common.NewTransport
+		wrapped.send = a.sendFromInbox
 		res, err := streams.NewTypeResolver(wrapped.callbacks(other)...)
 		if err != nil {
 			return err
@@ -121,6 +213,152 @@ func (a *sideEffectActor) PostInbox(c context.Context, inboxIRI *url.URL, activi
 	return nil
 }
 
+// SharedInboxRecipients finds the inboxes of the actors owned by this server
+// that are either directly addressed by the activity, or that follow the
+// activity's actor when the activity is addressed to the Public special
+// collection or to the followers collection of that actor.
+func (a *sideEffectActor) SharedInboxRecipients(c context.Context, sharedInboxIRI *url.URL, activity Activity) (inboxIRIs []*url.URL, err error) {
+	var r []*url.URL
+	if to := activity.GetActivityStreamsTo(); to != nil {
+		for iter := to.Begin(); iter != to.End(); iter = iter.Next() {
+			var val *url.URL
+			val, err = ToId(iter)
+			if err != nil {
+				return
+			}
+			r = append(r, val)
+		}
+	}
+	if bto := activity.GetActivityStreamsBto(); bto != nil {
+		for iter := bto.Begin(); iter != bto.End(); iter = iter.Next() {
+			var val *url.URL
+			val, err = ToId(iter)
+			if err != nil {
+				return
+			}
+			r = append(r, val)
+		}
+	}
+	if cc := activity.GetActivityStreamsCc(); cc != nil {
+		for iter := cc.Begin(); iter != cc.End(); iter = iter.Next() {
+			var val *url.URL
+			val, err = ToId(iter)
+			if err != nil {
+				return
+			}
+			r = append(r, val)
+		}
+	}
+	if bcc := activity.GetActivityStreamsBcc(); bcc != nil {
+		for iter := bcc.Begin(); iter != bcc.End(); iter = iter.Next() {
+			var val *url.URL
+			val, err = ToId(iter)
+			if err != nil {
+				return
+			}
+			r = append(r, val)
+		}
+	}
+	if audience := activity.GetActivityStreamsAudience(); audience != nil {
+		for iter := audience.Begin(); iter != audience.End(); iter = iter.Next() {
+			var val *url.URL
+			val, err = ToId(iter)
+			if err != nil {
+				return
+			}
+			r = append(r, val)
+		}
+	}
+	// 1. Find the actors owned by this server that are addressed directly,
+	//    keeping track of the other IRIs in case they are the followers
+	//    collection of the activity's actor.
+	isPublic := false
+	others := make(map[string]bool, len(r))
+	var localActors []*url.URL
+	for _, iri := range r {
+		if IsPublic(iri.String()) {
+			isPublic = true
+			continue
+		}
+		var owns bool
+		owns, err = a.ownsIRI(c, iri)
+		if err != nil {
+			return
+		} else if !owns {
+			others[iri.String()] = true
+			continue
+		}
+		localActors = append(localActors, iri)
+	}
+	// 2. Find the actors owned by this server that follow the activity's
+	//    actor, if they are its audience.
+	var actorIRIs []*url.URL
+	if actor := activity.GetActivityStreamsActor(); actor != nil {
+		for iter := actor.Begin(); iter != actor.End(); iter = iter.Next() {
+			var val *url.URL
+			val, err = ToId(iter)
+			if err != nil {
+				return
+			}
+			actorIRIs = append(actorIRIs, val)
+		}
+	}
+	for _, actorIRI := range actorIRIs {
+		// The actor's followers collection is not owned by this
+		// server, so do not look up the actor if every IRI is.
+		if !isPublic && len(others) == 0 {
+			continue
+		}
+		err = a.db.Lock(c, actorIRI)
+		if err != nil {
+			return
+		}
+		// WARNING: Unlock is not deferred
+		var followers []*url.URL
+		followers, err = a.db.LocalFollowers(c, actorIRI)
+		a.db.Unlock(c, actorIRI)
+		// Unlock by this point and in every branch above.
+		if err != nil {
+			return
+		} else if len(followers) == 0 {
+			continue
+		}
+		if !isPublic {
+			// The actor may need to be dereferenced, which is done
+			// on behalf of one of its local followers, as the
+			// shared inbox belongs to no actor.
+			var inbox *url.URL
+			inbox, err = a.firstLocalInbox(c, followers)
+			if err != nil {
+				return
+			} else if inbox == nil {
+				continue
+			}
+			var followersIRI *url.URL
+			followersIRI, err = a.followersOf(c, inbox, actorIRI)
+			if err != nil {
+				return
+			} else if followersIRI == nil || !others[followersIRI.String()] {
+				continue
+			}
+		}
+		localActors = append(localActors, followers...)
+	}
+	// 3. Obtain the inboxes of the addressed actors. Any owned IRI that is
+	//    not an actor, such as a collection, does not have an inbox and
+	//    is not a recipient.
+	for _, iri := range dedupeIRIs(localActors, nil) {
+		var inbox *url.URL
+		inbox, err = a.localInbox(c, iri)
+		if err != nil {
+			return
+		} else if inbox != nil {
+			inboxIRIs = append(inboxIRIs, inbox)
+		}
+	}
+	return
+}
+
 // InboxForwarding implements the 3-part inbox forwarding algorithm specified in
 // the ActivityPub specification. Does not modify the Activity, but may send
 // outbound requests as a side effect.
@@ -159,29 +397,32 @@ func (a *sideEffectActor) InboxForwarding(c context.Context, inboxIRI *url.URL,
 	// 2. The values of 'to', 'cc', or 'audience' are Collections owned by
 	//    this server.
 	var r []*url.URL
-	to := activity.GetActivityStreamsTo()
-	for iter := to.Begin(); iter != to.End(); iter = iter.Next() {
-		val, err := ToId(iter)
-		if err != nil {
-			return err
+	if to := activity.GetActivityStreamsTo(); to != nil {
+		for iter := to.Begin(); iter != to.End(); iter = iter.Next() {
+			val, err := ToId(iter)
+			if err != nil {
+				return err
+			}
+			r = append(r, val)
 		}
-		r = append(r, val)
 	}
-	cc := activity.GetActivityStreamsCc()
-	for iter := cc.Begin(); iter != cc.End(); iter = iter.Next() {
-		val, err := ToId(iter)
-		if err != nil {
-			return err
+	if cc := activity.GetActivityStreamsCc(); cc != nil {
+		for iter := cc.Begin(); iter != cc.End(); iter = iter.Next() {
+			val, err := ToId(iter)
+			if err != nil {
+				return err
+			}
+			r = append(r, val)
 		}
-		r = append(r, val)
 	}
-	audience := activity.GetActivityStreamsAudience()
-	for iter := audience.Begin(); iter != audience.End(); iter = iter.Next() {
-		val, err := ToId(iter)
-		if err != nil {
-			return err
+	if audience := activity.GetActivityStreamsAudience(); audience != nil {
+		for iter := audience.Begin(); iter != audience.End(); iter = iter.Next() {
+			val, err := ToId(iter)
+			if err != nil {
+				return err
+			}
+			r = append(r, val)
 		}
-		r = append(r, val)
 	}
 	// Find all IRIs owned by this server. We need to find all of them so
 	// that forwarding can properly occur.
@@ -297,6 +538,21 @@ func (a *sideEffectActor) InboxForwarding(c context.Context, inboxIRI *url.URL,
 // This implementation assumes all types are meant to be delivered except for
 // the ActivityStreams Block type.
 func (a *sideEffectActor) PostOutbox(c context.Context, activity Activity, outboxIRI *url.URL, rawJSON map[string]interface{}) (deliverable bool, err error) {
+	// Without the Social API, an activity sent by the application has no
+	// side effects beyond being added to the outbox, and the objects of a
+	// Create being stored so that they can be served.
+	if a.c2s == nil {
+		deliverable = true
+		if create, ok := activity.(vocab.ActivityStreamsCreate); ok {
+			if op := create.GetActivityStreamsObject(); op != nil {
+				if err = createObjects(c, a.db, op); err != nil {
+					return
+				}
+			}
+		}
+		err = a.addToOutbox(c, outboxIRI, activity)
+		return
+	}
 	wrapped, other := a.c2s.Callbacks(c)
 	// Populate side channels.
 	wrapped.db = a.db
@@ -359,7 +615,7 @@ func (a *sideEffectActor) AddNewIds(c context.Context, activity Activity) error
 // deliver will complete the peer-to-peer sending of a federated message to
 // another server.
 //
-// Must only be called if both social and federated protocols are supported.
+// Must only be called if the federated protocol is supported.
 func (a *sideEffectActor) Deliver(c context.Context, outboxIRI *url.URL, activity Activity) error {
 	recipients, err := a.prepare(c, outboxIRI, activity)
 	if err != nil {
@@ -387,6 +643,9 @@ func (a *sideEffectActor) WrapInCreate(c context.Context, obj vocab.Type, outbox
 
 // deliverToRecipients will take a prepared Activity and send it to specific
 // recipients on behalf of an actor.
+//
+// If the application provides a DeliveryQueue, the deliveries are enqueued
+// and made later instead.
 func (a *sideEffectActor) deliverToRecipients(c context.Context, boxIRI *url.URL, activity Activity, recipients []*url.URL) error {
 	m, err := serialize(activity)
 	if err != nil {
@@ -396,25 +655,43 @@ func (a *sideEffectActor) deliverToRecipients(c context.Context, boxIRI *url.URL
 	if err != nil {
 		return err
 	}
+	report := DeliveryReport{
+		BoxIRI:     boxIRI,
+		ActivityId: activity.GetActivityStreamsId().Get(),
+	}
+	if q := a.s2s.DeliveryQueue(c); q != nil {
+		if err = q.Enqueue(c, boxIRI, report.ActivityId, b, recipients); err != nil {
+			return err
+		}
+		for _, to := range recipients {
+			report.Recipients = append(report.Recipients, RecipientReport{
+				Recipient: to,
+				Queued:    true,
+			})
+		}
+		a.s2s.ReportDelivery(c, report)
+		return nil
+	}
 	tp, err := a.common.NewTransport(c, boxIRI, goFedUserAgent())
 	if err != nil {
+		for _, to := range recipients {
+			report.Recipients = append(report.Recipients, RecipientReport{
+				Recipient: to,
+				Class:     InternalDeliveryError,
+				Retryable: true,
+				Err:       err,
+			})
+		}
+		a.s2s.ReportDelivery(c, report)
 		return err
 	}
-	mErr := make(map[string]error)
 	for _, to := range recipients {
+		start := a.clock.Now()
 		err := tp.Deliver(c, b, to)
-		if err != nil {
-			mErr[to.String()] = err
-		}
+		report.Recipients = append(report.Recipients, newRecipientReport(c, to, a.clock.Now().Sub(start), err))
 	}
-	if len(mErr) > 0 {
-		s := make([]string, 0, len(mErr))
-		for k, v := range mErr {
-			s = append(s, fmt.Sprintf("%s=%s", k, v.Error()))
-		}
-		return fmt.Errorf("requests failed: %s", strings.Join(s, ";"))
-	}
-	return nil
+	a.s2s.ReportDelivery(c, report)
+	return report.err()
 }
 
 // addToOutbox adds the activity to the outbox and creates the activity in the
@@ -442,20 +719,8 @@ func (a *sideEffectActor) addToOutbox(c context.Context, outboxIRI *url.URL, act
 		return err
 	}
 	defer a.db.Unlock(c, outboxIRI)
-	outbox, err := a.db.GetOutbox(c, outboxIRI)
-	if err != nil {
-		return err
-	}
-	// Prepend the activity to the list of 'orderedItems'.
-	oi := outbox.GetActivityStreamsOrderedItems()
-	if oi == nil {
-		oi = streams.NewActivityStreamsOrderedItemsProperty()
-	}
-	oi.PrependIRI(id.Get())
-	outbox.SetActivityStreamsOrderedItems(oi)
-	// Save in the database.
-	err = a.db.SetOutbox(c, outbox)
-	return err
+	// Prepend the activity to the items of the outbox.
+	return a.db.PrependOutboxItem(c, outboxIRI, id.Get())
 }
 
 // addToInboxIfNew will add the activity to the inbox at the specified IRI if
@@ -480,22 +745,143 @@ func (a *sideEffectActor) addToInboxIfNew(c context.Context, inboxIRI *url.URL,
 	} else if contains {
 		return
 	}
-	// It is a new id, acquire the inbox.
+	// It is a new id, prepend it to the items of the inbox.
 	isNew = true
-	inbox, err := a.db.GetInbox(c, inboxIRI)
+	err = a.db.PrependInboxItem(c, inboxIRI, id.Get())
+	return
+}
+
+// ownsIRI determines whether the IRI is owned by this server.
+func (a *sideEffectActor) ownsIRI(c context.Context, iri *url.URL) (bool, error) {
+	err := a.db.Lock(c, iri)
 	if err != nil {
-		return
+		return false, err
 	}
-	// Prepend the activity to the list of 'orderedItems'.
-	oi := inbox.GetActivityStreamsOrderedItems()
-	if oi == nil {
-		oi = streams.NewActivityStreamsOrderedItemsProperty()
+	defer a.db.Unlock(c, iri)
+	return a.db.Owns(c, iri)
+}
+
+// localInbox obtains the inbox of an actor owned by this server. A nil IRI is
+// returned if the value at the IRI has no inbox.
+func (a *sideEffectActor) localInbox(c context.Context, actorIRI *url.URL) (*url.URL, error) {
+	err := a.db.Lock(c, actorIRI)
+	if err != nil {
+		return nil, err
 	}
-	oi.PrependIRI(id.Get())
-	inbox.SetActivityStreamsOrderedItems(oi)
-	// Save in the database.
-	err = a.db.SetInbox(c, inbox)
-	return
+	defer a.db.Unlock(c, actorIRI)
+	t, err := a.db.Get(c, actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.(inboxer); !ok {
+		return nil, nil
+	}
+	return getInbox(t)
+}
+
+// firstLocalInbox obtains the inbox of the first of the actors owned by this
+// server that has one. A nil IRI is returned if none do.
+func (a *sideEffectActor) firstLocalInbox(c context.Context, actorIRIs []*url.URL) (*url.URL, error) {
+	for _, actorIRI := range actorIRIs {
+		inbox, err := a.localInbox(c, actorIRI)
+		if err != nil {
+			return nil, err
+		} else if inbox != nil {
+			return inbox, nil
+		}
+	}
+	return nil, nil
+}
+
+// localOutbox obtains the outbox of an actor owned by this server.
+func (a *sideEffectActor) localOutbox(c context.Context, actorIRI *url.URL) (*url.URL, error) {
+	err := a.db.Lock(c, actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	defer a.db.Unlock(c, actorIRI)
+	t, err := a.db.Get(c, actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	return getOutbox(t)
+}
+
+// sendFromInbox sends an activity generated in response to one received in
+// the inbox, on behalf of the actor owning the inbox.
+//
+// It takes the same path as activities sent with FederatingActor.Send, so it
+// has the side effects of an activity posted by a client to the actor's outbox
+// before being delivered to its recipients.
+func (a *sideEffectActor) sendFromInbox(c context.Context, inboxIRI *url.URL, activity Activity) error {
+	err := a.db.Lock(c, inboxIRI)
+	if err != nil {
+		return err
+	}
+	// WARNING: Unlock not deferred.
+	actorIRI, err := a.db.ActorForInbox(c, inboxIRI)
+	a.db.Unlock(c, inboxIRI)
+	if err != nil {
+		return err
+	}
+	// Unlock must be called by now and every branch above.
+	outboxIRI, err := a.localOutbox(c, actorIRI)
+	if err != nil {
+		return err
+	}
+	return send(c, a, outboxIRI, activity)
+}
+
+// followersOf obtains the IRI of the followers collection of an actor. The
+// actor is looked up in the database first, and is otherwise dereferenced on
+// behalf of the actor owning the box. A nil IRI is returned if the actor has no
+// followers collection.
+func (a *sideEffectActor) followersOf(c context.Context, boxIRI, actorIRI *url.URL) (*url.URL, error) {
+	err := a.db.Lock(c, actorIRI)
+	if err != nil {
+		return nil, err
+	}
+	// WARNING: Unlock is not deferred
+	var t vocab.Type
+	if exists, err := a.db.Exists(c, actorIRI); err != nil {
+		a.db.Unlock(c, actorIRI)
+		return nil, err
+	} else if exists {
+		t, err = a.db.Get(c, actorIRI)
+		if err != nil {
+			a.db.Unlock(c, actorIRI)
+			return nil, err
+		}
+	}
+	a.db.Unlock(c, actorIRI)
+	// Unlock by this point and in every branch above.
+	if t == nil {
+		tport, err := a.common.NewTransport(c, boxIRI, goFedUserAgent())
+		if err != nil {
+			return nil, err
+		}
+		b, err := tport.Dereference(c, actorIRI)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]interface{}
+		if err = json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		t, err = streams.ToType(c, m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, ok := t.(followerser)
+	if !ok {
+		return nil, nil
+	}
+	followers := f.GetActivityStreamsFollowers()
+	if followers == nil {
+		return nil, nil
+	}
+	return ToId(followers)
 }
 
 // Given an ActivityStreams value, recursively examines ownership of the id or
@@ -641,14 +1027,15 @@ func (a *sideEffectActor) prepare(c context.Context, outboxIRI *url.URL, activit
 			r = append(r, val)
 		}
 	}
-	// 1. When an object is being delivered to the originating actor's
-	//    followers, a server MAY reduce the number of receiving actors
-	//    delivered to by identifying all followers which share the same
-	//    sharedInbox who would otherwise be individual recipients and
-	//    instead deliver objects to said sharedInbox.
-	// 2. If an object is addressed to the Public special collection, a
-	//    server MAY deliver that object to all known sharedInbox endpoints
-	//    on the network.
+	// Whether the activity is public must be determined before the Public
+	// special collection is removed from the recipients.
+	isPublic := false
+	for _, iri := range r {
+		if IsPublic(iri.String()) {
+			isPublic = true
+			break
+		}
+	}
 	r = filterURLs(r, IsPublic)
 	t, err := a.common.NewTransport(c, outboxIRI, goFedUserAgent())
 	if err != nil {
@@ -658,7 +1045,30 @@ func (a *sideEffectActor) prepare(c context.Context, outboxIRI *url.URL, activit
 	if err != nil {
 		return nil, err
 	}
-	targets, err := getInboxes(receiverActors)
+	// Do not deliver to the actors blocked by the sender.
+	receiverActors, err = a.withoutBlocked(c, outboxIRI, receiverActors)
+	if err != nil {
+		return nil, err
+	}
+	// When an object is being delivered to the originating actor's
+	// followers, a server MAY reduce the number of receiving actors
+	// delivered to by identifying all followers which share the same
+	// sharedInbox who would otherwise be individual recipients and instead
+	// deliver objects to said sharedInbox.
+	//
+	// Only do so for public activities without hidden recipients. Anything
+	// else must reach exactly the addressed actors, so it is delivered to
+	// each of their personal inboxes instead. Actors without a sharedInbox
+	// are always delivered to their personal inbox.
+	//
+	// Note that go-fed does not deliver public activities to every known
+	// sharedInbox on the network, which the specification also permits.
+	var targets []*url.URL
+	if isPublic && !hasHiddenRecipients(activity) {
+		targets, err = getSharedInboxes(receiverActors)
+	} else {
+		targets, err = getInboxes(receiverActors)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -676,7 +1086,9 @@ func (a *sideEffectActor) prepare(c context.Context, outboxIRI *url.URL, activit
 	a.db.Unlock(c, outboxIRI)
 	// Make sure this matches the 'attributedTo' on the activity.
 	attrTo := activity.GetActivityStreamsAttributedTo()
-	if attrTo.Len() != 1 {
+	if attrTo == nil {
+		return nil, fmt.Errorf("federated c2s object has no attributedTo value")
+	} else if attrTo.Len() != 1 {
 		return nil, fmt.Errorf("federated c2s object does not have exactly one attributedTo value: %d", attrTo.Len())
 	} else if attrToIRI, err := ToId(attrTo.At(0)); err != nil {
 		return nil, err
@@ -732,7 +1144,9 @@ func (a *sideEffectActor) resolveInboxes(c context.Context, t Transport, r []*ur
 		if err != nil {
 			return
 		}
-		actors = append(actors, act)
+		if act != nil {
+			actors = append(actors, act)
+		}
 		actors = append(actors, recurActors...)
 	}
 	return
@@ -740,6 +1154,9 @@ func (a *sideEffectActor) resolveInboxes(c context.Context, t Transport, r []*ur
 
 // dereferenceForResolvingInboxes dereferences an IRI solely for finding an
 // actor's inbox IRI to deliver to.
+//
+// If the IRI is a Collection or OrderedCollection, then its items are
+// returned to be resolved in turn and no actor is returned.
 func (a *sideEffectActor) dereferenceForResolvingInboxes(c context.Context, t Transport, actorIRI *url.URL) (actor vocab.Type, moreActorIRIs []*url.URL, err error) {
 	var resp []byte
 	resp, err = t.Dereference(c, actorIRI)
@@ -757,6 +1174,7 @@ func (a *sideEffectActor) dereferenceForResolvingInboxes(c context.Context, t Tr
 	// Attempt to see if the 'actor' is really some sort of type that has
 	// an 'items' or 'orderedItems' property.
 	if v, ok := actor.(itemser); ok {
+		actor = nil
 		i := v.GetActivityStreamsItems()
 		for iter := i.Begin(); iter != i.End(); iter = iter.Next() {
 			var id *url.URL
@@ -767,6 +1185,7 @@ func (a *sideEffectActor) dereferenceForResolvingInboxes(c context.Context, t Tr
 			moreActorIRIs = append(moreActorIRIs, id)
 		}
 	} else if v, ok := actor.(orderedItemser); ok {
+		actor = nil
 		i := v.GetActivityStreamsOrderedItems()
 		for iter := i.Begin(); iter != i.End(); iter = iter.Next() {
 			var id *url.URL
@@ -2,8 +2,10 @@ package pub
 
 import (
 	"context"
+	"github.com/go-fed/activity/streams"
 	"github.com/go-fed/activity/streams/vocab"
 	"github.com/golang/mock/gomock"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
@@ -83,34 +85,160 @@ func TestPassThroughMethods(t *testing.T) {
 		assertEqual(t, b, true)
 		assertEqual(t, err, testErr)
 	})
-	t.Run("GetOutbox", func(t *testing.T) {
+	t.Run("GetOutboxSummarizesOutbox", func(t *testing.T) {
 		// Setup
 		ctl := gomock.NewController(t)
 		defer ctl.Finish()
-		_, _, sp, _, _, a := setupFn(ctl)
+		_, _, sp, db, _, a := setupFn(ctl)
 		req := toAPRequest(toGetOutboxRequest())
-		sp.EXPECT().GetOutbox(ctx, req).Return(testOrderedCollectionUniqueElems, testErr)
+		outboxIRI := mustParse(testMyOutboxIRI)
+		q := BoxPageQuery{Limit: defaultBoxPageLimit}
+		page := BoxPage{
+			Items:      []*url.URL{mustParse(testFederatedActivityIRI)},
+			TotalItems: 1,
+		}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().GetOutboxPage(ctx, outboxIRI, q).Return(page, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			sp.EXPECT().GetOutbox(ctx, req, outboxIRI, q, page).Return(page, nil),
+		)
 		// Run
 		p, err := a.GetOutbox(ctx, req)
 		// Verify
-		assertEqual(t, p, testOrderedCollectionUniqueElems)
-		assertEqual(t, err, testErr)
+		assertEqual(t, err, nil)
+		m, err := serialize(p)
+		assertEqual(t, err, nil)
+		assertEqual(t, m["type"], "OrderedCollection")
+		assertEqual(t, m["id"], testMyOutboxIRI)
+		assertEqual(t, m["totalItems"], 1)
+		assertEqual(t, m["first"], testMyOutboxIRI+"?page=true")
+		_, hasItems := m["orderedItems"]
+		assertEqual(t, hasItems, false)
+	})
+	t.Run("GetInboxServesPageWithCursors", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		c, fp, _, db, _, a := setupFn(ctl)
+		req := toAPRequest(httptest.NewRequest("GET", testMyInboxIRI+"?page=true&max_id="+url.QueryEscape(testNoteId1), nil))
+		c.EXPECT().Visibility(ctx).Return(nil)
+		inboxIRI := mustParse(testMyInboxIRI)
+		q := BoxPageQuery{MaxId: mustParse(testNoteId1), Limit: defaultBoxPageLimit}
+		page := BoxPage{
+			Items:      []*url.URL{mustParse(testFederatedActivityIRI), mustParse(testFederatedActivityIRI2)},
+			TotalItems: 5,
+			HasOlder:   true,
+			HasNewer:   true,
+		}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().GetInboxPage(ctx, inboxIRI, q).Return(page, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			fp.EXPECT().GetInbox(ctx, req, inboxIRI, q, page).Return(page, nil),
+		)
+		// Run
+		p, err := a.GetInbox(ctx, req)
+		// Verify
+		assertEqual(t, err, nil)
+		m, err := serialize(p)
+		assertEqual(t, err, nil)
+		assertEqual(t, m["type"], "OrderedCollectionPage")
+		assertEqual(t, m["partOf"], testMyInboxIRI)
+		assertEqual(t, m["totalItems"], 5)
+		assertEqual(t, m["next"], testMyInboxIRI+"?max_id="+url.QueryEscape(testFederatedActivityIRI2)+"&page=true")
+		assertEqual(t, m["prev"], testMyInboxIRI+"?min_id="+url.QueryEscape(testFederatedActivityIRI)+"&page=true")
+		items, ok := m["orderedItems"].([]interface{})
+		assertEqual(t, ok, true)
+		assertEqual(t, len(items), 2)
+	})
+	t.Run("GetOutboxServesVisibleItems", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		c, _, sp, db, _, a := setupFn(ctl)
+		req := toAPRequest(httptest.NewRequest("GET", testMyOutboxIRI+"?page=true", nil))
+		outboxIRI := mustParse(testMyOutboxIRI)
+		requester := mustParse(testFederatedActorIRI)
+		c.EXPECT().Visibility(ctx).Return(NewVisibilityPolicy(func(c context.Context, r *http.Request) (*url.URL, error) {
+			return requester, nil
+		}, db))
+		public := streams.NewActivityStreamsNote()
+		setIdAndTo(public, mustParse(testNoteId1), mustParse(PublicActivityPubIRI))
+		direct := streams.NewActivityStreamsNote()
+		setIdAndTo(direct, mustParse(testNoteId2), mustParse(testFederatedActorIRI2))
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().GetOutboxPage(ctx, outboxIRI, BoxPageQuery{Limit: defaultBoxPageLimit}).Return(BoxPage{
+				Items:      []*url.URL{mustParse(testNoteId1), mustParse(testNoteId2)},
+				TotalItems: 2,
+			}, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+		)
+		sp.EXPECT().GetOutbox(ctx, req, outboxIRI, gomock.Any(), gomock.Any()).DoAndReturn(func(c context.Context, r *http.Request, outboxIRI *url.URL, q BoxPageQuery, page BoxPage) (BoxPage, error) {
+			return page, nil
+		})
+		for _, n := range []vocab.ActivityStreamsNote{public, direct} {
+			id := n.GetActivityStreamsId().Get()
+			db.EXPECT().Lock(ctx, id)
+			db.EXPECT().Exists(ctx, id).Return(true, nil)
+			db.EXPECT().Get(ctx, id).Return(n, nil)
+			db.EXPECT().Unlock(ctx, id)
+		}
+		recipient := mustParse(testFederatedActorIRI2)
+		db.EXPECT().Lock(ctx, recipient)
+		db.EXPECT().Owns(ctx, recipient).Return(false, nil)
+		db.EXPECT().Unlock(ctx, recipient)
+		// Run
+		p, err := a.GetOutbox(ctx, req)
+		// Verify
+		assertEqual(t, err, nil)
+		m, err := serialize(p)
+		assertEqual(t, err, nil)
+		assertEqual(t, m["orderedItems"], testNoteId1)
 	})
-	t.Run("GetInbox", func(t *testing.T) {
+	t.Run("GetInboxServesPageFromFederatingProtocol", func(t *testing.T) {
 		// Setup
 		ctl := gomock.NewController(t)
 		defer ctl.Finish()
-		_, fp, _, _, _, a := setupFn(ctl)
-		req := toAPRequest(toGetInboxRequest())
-		fp.EXPECT().GetInbox(ctx, req).Return(testOrderedCollectionUniqueElems, testErr)
+		c, fp, _, db, _, a := setupFn(ctl)
+		req := toAPRequest(httptest.NewRequest("GET", testMyInboxIRI+"?page=true", nil))
+		c.EXPECT().Visibility(ctx).Return(nil)
+		inboxIRI := mustParse(testMyInboxIRI)
+		q := BoxPageQuery{Limit: defaultBoxPageLimit}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().GetInboxPage(ctx, inboxIRI, q).Return(BoxPage{
+				Items:      []*url.URL{mustParse(testFederatedActivityIRI), mustParse(testFederatedActivityIRI2)},
+				TotalItems: 2,
+			}, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			fp.EXPECT().GetInbox(ctx, req, inboxIRI, q, gomock.Any()).Return(BoxPage{
+				Items:      []*url.URL{mustParse(testFederatedActivityIRI2)},
+				TotalItems: 1,
+			}, nil),
+		)
 		// Run
 		p, err := a.GetInbox(ctx, req)
 		// Verify
-		assertEqual(t, p, testOrderedCollectionUniqueElems)
-		assertEqual(t, err, testErr)
+		assertEqual(t, err, nil)
+		m, err := serialize(p)
+		assertEqual(t, err, nil)
+		assertEqual(t, m["totalItems"], 1)
+		assertEqual(t, m["orderedItems"], testFederatedActivityIRI2)
 	})
 }
 
+// setIdAndTo sets the 'id' and 'to' properties of the Note.
+func setIdAndTo(n vocab.ActivityStreamsNote, id, to *url.URL) {
+	idProp := streams.NewActivityStreamsIdProperty()
+	idProp.Set(id)
+	n.SetActivityStreamsId(idProp)
+	toProp := streams.NewActivityStreamsToProperty()
+	toProp.AppendIRI(to)
+	n.SetActivityStreamsTo(toProp)
+}
+
 // TestAuthorizePostInbox tests the Authorization for a federated message, which
 // is only based on blocks.
 func TestAuthorizePostInbox(t *testing.T) {
@@ -203,6 +331,25 @@ func TestPostInbox(t *testing.T) {
 		}
 		return
 	}
+	// expectBlocked expects the 'blocked' collection of the actor owning the
+	// inbox to be consulted.
+	expectBlocked := func(db *MockDatabase, inboxIRI *url.URL, blocked ...*url.URL) {
+		actorIRI := mustParse("https://example.com/addison")
+		col := streams.NewActivityStreamsCollection()
+		items := streams.NewActivityStreamsItemsProperty()
+		for _, iri := range blocked {
+			items.AppendIRI(iri)
+		}
+		col.SetActivityStreamsItems(items)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(actorIRI, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			db.EXPECT().Lock(ctx, actorIRI),
+			db.EXPECT().Blocked(ctx, actorIRI).Return(col, nil),
+			db.EXPECT().Unlock(ctx, actorIRI),
+		)
+	}
 	// Run tests
 	t.Run("AddsToEmptyInbox", func(t *testing.T) {
 		// Setup
@@ -210,11 +357,11 @@ func TestPostInbox(t *testing.T) {
 		defer ctl.Finish()
 		_, fp, _, db, _, a := setupFn(ctl)
 		inboxIRI := mustParse(testMyInboxIRI)
+		expectBlocked(db, inboxIRI)
 		gomock.InOrder(
 			db.EXPECT().Lock(ctx, inboxIRI),
 			db.EXPECT().InboxContains(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(false, nil),
-			db.EXPECT().GetInbox(ctx, inboxIRI).Return(testEmptyOrderedCollection, nil),
-			db.EXPECT().SetInbox(ctx, testOrderedCollectionWithFederatedId).Return(nil),
+			db.EXPECT().PrependInboxItem(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(nil),
 			db.EXPECT().Unlock(ctx, inboxIRI),
 		)
 		fp.EXPECT().Callbacks(ctx).Return(FederatingWrappedCallbacks{}, nil)
@@ -230,6 +377,7 @@ func TestPostInbox(t *testing.T) {
 		defer ctl.Finish()
 		_, _, _, db, _, a := setupFn(ctl)
 		inboxIRI := mustParse(testMyInboxIRI)
+		expectBlocked(db, inboxIRI)
 		gomock.InOrder(
 			db.EXPECT().Lock(ctx, inboxIRI),
 			db.EXPECT().InboxContains(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(true, nil),
@@ -240,37 +388,17 @@ func TestPostInbox(t *testing.T) {
 		// Verify
 		assertEqual(t, err, nil)
 	})
-	t.Run("AddsToInbox", func(t *testing.T) {
-		// Setup
-		ctl := gomock.NewController(t)
-		defer ctl.Finish()
-		_, fp, _, db, _, a := setupFn(ctl)
-		inboxIRI := mustParse(testMyInboxIRI)
-		gomock.InOrder(
-			db.EXPECT().Lock(ctx, inboxIRI),
-			db.EXPECT().InboxContains(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(false, nil),
-			db.EXPECT().GetInbox(ctx, inboxIRI).Return(testOrderedCollectionWithFederatedId2, nil),
-			db.EXPECT().SetInbox(ctx, testOrderedCollectionWithBothFederatedIds).Return(nil),
-			db.EXPECT().Unlock(ctx, inboxIRI),
-		)
-		fp.EXPECT().Callbacks(ctx).Return(FederatingWrappedCallbacks{}, nil)
-		fp.EXPECT().DefaultCallback(ctx, testListen).Return(nil)
-		// Run
-		err := a.PostInbox(ctx, inboxIRI, testListen)
-		// Verify
-		assertEqual(t, err, nil)
-	})
 	t.Run("ResolvesToCustomFunction", func(t *testing.T) {
 		// Setup
 		ctl := gomock.NewController(t)
 		defer ctl.Finish()
 		_, fp, _, db, _, a := setupFn(ctl)
 		inboxIRI := mustParse(testMyInboxIRI)
+		expectBlocked(db, inboxIRI)
 		gomock.InOrder(
 			db.EXPECT().Lock(ctx, inboxIRI),
 			db.EXPECT().InboxContains(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(false, nil),
-			db.EXPECT().GetInbox(ctx, inboxIRI).Return(testEmptyOrderedCollection, nil),
-			db.EXPECT().SetInbox(ctx, testOrderedCollectionWithFederatedId).Return(nil),
+			db.EXPECT().PrependInboxItem(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(nil),
 			db.EXPECT().Unlock(ctx, inboxIRI),
 		)
 		pass := false
@@ -292,11 +420,11 @@ func TestPostInbox(t *testing.T) {
 		defer ctl.Finish()
 		_, fp, _, db, _, a := setupFn(ctl)
 		inboxIRI := mustParse(testMyInboxIRI)
+		expectBlocked(db, inboxIRI)
 		gomock.InOrder(
 			db.EXPECT().Lock(ctx, inboxIRI),
 			db.EXPECT().InboxContains(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(false, nil),
-			db.EXPECT().GetInbox(ctx, inboxIRI).Return(testEmptyOrderedCollection, nil),
-			db.EXPECT().SetInbox(ctx, testOrderedCollectionWithFederatedId).Return(nil),
+			db.EXPECT().PrependInboxItem(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(nil),
 			db.EXPECT().Unlock(ctx, inboxIRI),
 		)
 		pass := false
@@ -318,11 +446,11 @@ func TestPostInbox(t *testing.T) {
 		defer ctl.Finish()
 		_, fp, _, db, _, a := setupFn(ctl)
 		inboxIRI := mustParse(testMyInboxIRI)
+		expectBlocked(db, inboxIRI)
 		gomock.InOrder(
 			db.EXPECT().Lock(ctx, inboxIRI),
 			db.EXPECT().InboxContains(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(false, nil),
-			db.EXPECT().GetInbox(ctx, inboxIRI).Return(testEmptyOrderedCollection, nil),
-			db.EXPECT().SetInbox(ctx, testOrderedCollectionWithFederatedId).Return(nil),
+			db.EXPECT().PrependInboxItem(ctx, inboxIRI, mustParse(testFederatedActivityIRI)).Return(nil),
 			db.EXPECT().Unlock(ctx, inboxIRI),
 		)
 		pass := false
@@ -333,6 +461,7 @@ func TestPostInbox(t *testing.T) {
 			},
 		}, nil)
 		db.EXPECT().Lock(ctx, mustParse(testNoteId1))
+		db.EXPECT().Exists(ctx, mustParse(testNoteId1)).Return(false, nil)
 		db.EXPECT().Create(ctx, testFederatedNote)
 		db.EXPECT().Unlock(ctx, mustParse(testNoteId1))
 		// Run
@@ -341,10 +470,125 @@ func TestPostInbox(t *testing.T) {
 		assertEqual(t, err, nil)
 		assertEqual(t, pass, true)
 	})
+	t.Run("RefusesBlockedActor", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, _, _, db, _, a := setupFn(ctl)
+		inboxIRI := mustParse(testMyInboxIRI)
+		expectBlocked(db, inboxIRI, mustParse(testFederatedActorIRI))
+		// Run
+		err := a.PostInbox(ctx, inboxIRI, testListen)
+		// Verify
+		assertEqual(t, err, ErrBlocked)
+	})
+}
+
+// TestSharedInboxRecipients ensures the actors of this server that are the
+// audience of an activity received by the shared inbox are found.
+func TestSharedInboxRecipients(t *testing.T) {
+	ctx := context.Background()
+	setupFn := func(ctl *gomock.Controller) (c *MockCommonBehavior, db *MockDatabase, a DelegateActor) {
+		setupData()
+		c = NewMockCommonBehavior(ctl)
+		db = NewMockDatabase(ctl)
+		a = &sideEffectActor{
+			common: c,
+			s2s:    NewMockFederatingProtocol(ctl),
+			db:     db,
+			clock:  NewMockClock(ctl),
+		}
+		return
+	}
+	localActorFn := func(id, inbox string) vocab.ActivityStreamsPerson {
+		p := streams.NewActivityStreamsPerson()
+		idProp := streams.NewActivityStreamsIdProperty()
+		idProp.Set(mustParse(id))
+		p.SetActivityStreamsId(idProp)
+		inboxProp := streams.NewActivityStreamsInboxProperty()
+		inboxProp.SetIRI(mustParse(inbox))
+		p.SetActivityStreamsInbox(inboxProp)
+		return p
+	}
+	activityFn := func(to string) Activity {
+		act := streams.NewActivityStreamsListen()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(mustParse(testFederatedActivityIRI))
+		act.SetActivityStreamsId(id)
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(mustParse(testFederatedActorIRI))
+		act.SetActivityStreamsActor(actor)
+		toProp := streams.NewActivityStreamsToProperty()
+		toProp.AppendIRI(mustParse(to))
+		act.SetActivityStreamsTo(toProp)
+		return act
+	}
+	// Run tests
+	t.Run("FindsDirectlyAddressedActors", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, db, a := setupFn(ctl)
+		actorIRI := mustParse("https://example.com/addison")
+		db.EXPECT().Lock(ctx, actorIRI).Times(2)
+		db.EXPECT().Unlock(ctx, actorIRI).Times(2)
+		db.EXPECT().Owns(ctx, actorIRI).Return(true, nil)
+		db.EXPECT().Get(ctx, actorIRI).Return(localActorFn("https://example.com/addison", testMyInboxIRI), nil)
+		// Run
+		inboxes, err := a.SharedInboxRecipients(ctx, mustParse(testMySharedInboxIRI), activityFn("https://example.com/addison"))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(inboxes), 1)
+		assertEqual(t, inboxes[0].String(), testMyInboxIRI)
+	})
+	t.Run("FindsFollowersIfPublic", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, db, a := setupFn(ctl)
+		actorIRI := mustParse("https://example.com/riley")
+		db.EXPECT().Lock(ctx, mustParse(testFederatedActorIRI))
+		db.EXPECT().LocalFollowers(ctx, mustParse(testFederatedActorIRI)).Return([]*url.URL{actorIRI}, nil)
+		db.EXPECT().Unlock(ctx, mustParse(testFederatedActorIRI))
+		db.EXPECT().Lock(ctx, actorIRI)
+		db.EXPECT().Get(ctx, actorIRI).Return(localActorFn("https://example.com/riley", testMyOtherInboxIRI), nil)
+		db.EXPECT().Unlock(ctx, actorIRI)
+		// Run
+		inboxes, err := a.SharedInboxRecipients(ctx, mustParse(testMySharedInboxIRI), activityFn(PublicActivityPubIRI))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(inboxes), 1)
+		assertEqual(t, inboxes[0].String(), testMyOtherInboxIRI)
+	})
+	t.Run("DereferencesActorOnBehalfOfLocalFollower", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		c, db, a := setupFn(ctl)
+		tp := NewMockTransport(ctl)
+		actorIRI := mustParse("https://example.com/riley")
+		peerIRI := mustParse(testFederatedActorIRI)
+		followersIRI := testFederatedActorIRI + "/followers"
+		db.EXPECT().Lock(ctx, gomock.Any()).AnyTimes()
+		db.EXPECT().Unlock(ctx, gomock.Any()).AnyTimes()
+		db.EXPECT().Owns(ctx, mustParse(followersIRI)).Return(false, nil)
+		db.EXPECT().LocalFollowers(ctx, peerIRI).Return([]*url.URL{actorIRI}, nil)
+		db.EXPECT().Get(ctx, actorIRI).Return(localActorFn("https://example.com/riley", testMyOtherInboxIRI), nil).Times(2)
+		db.EXPECT().Exists(ctx, peerIRI).Return(false, nil)
+		c.EXPECT().NewTransport(ctx, mustParse(testMyOtherInboxIRI), goFedUserAgent()).Return(tp, nil)
+		tp.EXPECT().Dereference(ctx, peerIRI).Return([]byte(`{"@context":"https://www.w3.org/ns/activitystreams","type":"Person","id":"`+testFederatedActorIRI+`","followers":"`+followersIRI+`"}`), nil)
+		// Run
+		inboxes, err := a.SharedInboxRecipients(ctx, mustParse(testMySharedInboxIRI), activityFn(followersIRI))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(inboxes), 1)
+		assertEqual(t, inboxes[0].String(), testMyOtherInboxIRI)
+	})
 }
 
 // TestInboxForwarding ensures that the inbox forwarding logic is correct.
 func TestInboxForwarding(t *testing.T) {
+	ctx := context.Background()
 	t.Run("DoesNotForwardIfAlreadyExists", func(t *testing.T) {
 		t.Fail()
 	})
@@ -381,6 +625,54 @@ func TestInboxForwarding(t *testing.T) {
 	t.Run("ForwardsToRecipientsIfChainNeedsDereferencing", func(t *testing.T) {
 		t.Fail()
 	})
+	t.Run("DoesNotForwardIfNotAddressed", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		a := &sideEffectActor{db: db}
+		create := streams.NewActivityStreamsCreate()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(mustParse(testFederatedActivityIRI))
+		create.SetActivityStreamsId(id)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, mustParse(testFederatedActivityIRI)),
+			db.EXPECT().Exists(ctx, mustParse(testFederatedActivityIRI)).Return(false, nil),
+			db.EXPECT().Create(ctx, create),
+			db.EXPECT().Unlock(ctx, mustParse(testFederatedActivityIRI)),
+		)
+		// Run
+		err := a.InboxForwarding(ctx, mustParse(testMyInboxIRI), create)
+		// Verify
+		assertEqual(t, err, nil)
+	})
+	t.Run("DoesNotForwardIfOnlyCcIsNotOwned", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		a := &sideEffectActor{db: db}
+		create := streams.NewActivityStreamsCreate()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(mustParse(testFederatedActivityIRI))
+		create.SetActivityStreamsId(id)
+		cc := streams.NewActivityStreamsCcProperty()
+		cc.AppendIRI(mustParse(testFederatedActorIRI2))
+		create.SetActivityStreamsCc(cc)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, mustParse(testFederatedActivityIRI)),
+			db.EXPECT().Exists(ctx, mustParse(testFederatedActivityIRI)).Return(false, nil),
+			db.EXPECT().Create(ctx, create),
+			db.EXPECT().Unlock(ctx, mustParse(testFederatedActivityIRI)),
+			db.EXPECT().Lock(ctx, mustParse(testFederatedActorIRI2)),
+			db.EXPECT().Owns(ctx, mustParse(testFederatedActorIRI2)).Return(false, nil),
+			db.EXPECT().Unlock(ctx, mustParse(testFederatedActorIRI2)),
+		)
+		// Run
+		err := a.InboxForwarding(ctx, mustParse(testMyInboxIRI), create)
+		// Verify
+		assertEqual(t, err, nil)
+	})
 }
 
 // TestPostOutbox ensures that the main application side effects of receiving a
@@ -401,6 +693,50 @@ func TestPostOutbox(t *testing.T) {
 	t.Run("ResolvesToDefaultFunction", func(t *testing.T) {
 		t.Fail()
 	})
+	t.Run("StoresCreatedObjectsWithoutSocialAPI", func(t *testing.T) {
+		// Setup
+		ctx := context.Background()
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		a := &sideEffectActor{db: db}
+		outboxIRI := mustParse(testMyOutboxIRI)
+		activityIRI := mustParse(testNewActivityIRI)
+		noteIRI := mustParse(testNoteId1)
+		note := streams.NewActivityStreamsNote()
+		noteId := streams.NewActivityStreamsIdProperty()
+		noteId.Set(noteIRI)
+		note.SetActivityStreamsId(noteId)
+		create := streams.NewActivityStreamsCreate()
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(activityIRI)
+		create.SetActivityStreamsId(id)
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(mustParse("https://example.com/addison"))
+		create.SetActivityStreamsActor(actor)
+		to := streams.NewActivityStreamsToProperty()
+		to.AppendIRI(mustParse(testFederatedActorIRI))
+		create.SetActivityStreamsTo(to)
+		op := streams.NewActivityStreamsObjectProperty()
+		op.AppendActivityStreamsNote(note)
+		create.SetActivityStreamsObject(op)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, noteIRI),
+			db.EXPECT().Create(ctx, note),
+			db.EXPECT().Unlock(ctx, noteIRI),
+			db.EXPECT().Lock(ctx, activityIRI),
+			db.EXPECT().Create(ctx, create),
+			db.EXPECT().Unlock(ctx, activityIRI),
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().PrependOutboxItem(ctx, outboxIRI, activityIRI),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+		)
+		// Run
+		deliverable, err := a.PostOutbox(ctx, create, outboxIRI, nil)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, deliverable, true)
+	})
 }
 
 // TestAddNewIds ensures that new 'id' properties are set on an activity and all
@@ -420,6 +756,9 @@ func TestAddNewIds(t *testing.T) {
 // TestDeliver ensures federated delivery of an activity happens correctly to
 // the ActivityPub specification.
 func TestDeliver(t *testing.T) {
+	ctx := context.Background()
+	me := mustParse("https://example.com/addison")
+	outboxIRI := mustParse(testMyOutboxIRI)
 	t.Run("SendToRecipientsInTo", func(t *testing.T) {
 		t.Fail()
 	})
@@ -465,6 +804,149 @@ func TestDeliver(t *testing.T) {
 	t.Run("ReturnsErrorIfAnyTransportRequestsFail", func(t *testing.T) {
 		t.Fail()
 	})
+	t.Run("DoesNotSendIfNoAttributedTo", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		c := NewMockCommonBehavior(ctl)
+		fp := NewMockFederatingProtocol(ctl)
+		db := NewMockDatabase(ctl)
+		a := &sideEffectActor{
+			common: c,
+			s2s:    fp,
+			db:     db,
+		}
+		c.EXPECT().NewTransport(ctx, outboxIRI, goFedUserAgent()).Return(NewMockTransport(ctl), nil)
+		fp.EXPECT().MaxDeliveryRecursionDepth(ctx).Return(1)
+		db.EXPECT().Lock(ctx, gomock.Any()).AnyTimes()
+		db.EXPECT().Unlock(ctx, gomock.Any()).AnyTimes()
+		db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil).AnyTimes()
+		db.EXPECT().Blocked(ctx, me).Return(streams.NewActivityStreamsCollection(), nil)
+		// Run
+		_, err := a.prepare(ctx, outboxIRI, streams.NewActivityStreamsCreate())
+		// Verify
+		assertNotEqual(t, err, nil)
+	})
+	t.Run("SendsToCcWithoutToOrAudience", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		c := NewMockCommonBehavior(ctl)
+		fp := NewMockFederatingProtocol(ctl)
+		db := NewMockDatabase(ctl)
+		tp := NewMockTransport(ctl)
+		a := &sideEffectActor{
+			common: c,
+			s2s:    fp,
+			db:     db,
+		}
+		self := streams.NewActivityStreamsPerson()
+		inbox := streams.NewActivityStreamsInboxProperty()
+		inbox.SetIRI(mustParse(testMyInboxIRI))
+		self.SetActivityStreamsInbox(inbox)
+		create := streams.NewActivityStreamsCreate()
+		attrTo := streams.NewActivityStreamsAttributedToProperty()
+		attrTo.AppendIRI(me)
+		create.SetActivityStreamsAttributedTo(attrTo)
+		cc := streams.NewActivityStreamsCcProperty()
+		cc.AppendIRI(mustParse(testFederatedActorIRI))
+		create.SetActivityStreamsCc(cc)
+		c.EXPECT().NewTransport(ctx, outboxIRI, goFedUserAgent()).Return(tp, nil)
+		fp.EXPECT().MaxDeliveryRecursionDepth(ctx).Return(1)
+		db.EXPECT().Lock(ctx, gomock.Any()).AnyTimes()
+		db.EXPECT().Unlock(ctx, gomock.Any()).AnyTimes()
+		db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil).AnyTimes()
+		db.EXPECT().Blocked(ctx, me).Return(streams.NewActivityStreamsCollection(), nil)
+		db.EXPECT().Get(ctx, me).Return(self, nil)
+		tp.EXPECT().Dereference(ctx, mustParse(testFederatedActorIRI)).Return([]byte(`{"@context":"https://www.w3.org/ns/activitystreams","type":"Person","id":"`+testFederatedActorIRI+`","inbox":"`+testFederatedActorIRI+`/inbox"}`), nil)
+		// Run
+		inboxes, err := a.prepare(ctx, outboxIRI, create)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(inboxes), 1)
+		assertEqual(t, inboxes[0].String(), testFederatedActorIRI+"/inbox")
+	})
+}
+
+// TestPrepareSharedInboxes ensures public activities are delivered to the
+// sharedInbox of their recipients, unless they have hidden recipients.
+func TestPrepareSharedInboxes(t *testing.T) {
+	ctx := context.Background()
+	me := mustParse("https://example.com/addison")
+	outboxIRI := mustParse(testMyOutboxIRI)
+	sharedInbox := "https://other.example.com/inbox"
+	actorJSON := func(id string) []byte {
+		return []byte(`{"@context":"https://www.w3.org/ns/activitystreams","type":"Person","id":"` + id + `","inbox":"` + id + `/inbox","endpoints":{"sharedInbox":"` + sharedInbox + `"}}`)
+	}
+	setupFn := func(ctl *gomock.Controller) (tp *MockTransport, a *sideEffectActor) {
+		c := NewMockCommonBehavior(ctl)
+		fp := NewMockFederatingProtocol(ctl)
+		db := NewMockDatabase(ctl)
+		tp = NewMockTransport(ctl)
+		a = &sideEffectActor{
+			common: c,
+			s2s:    fp,
+			db:     db,
+		}
+		self := streams.NewActivityStreamsPerson()
+		inbox := streams.NewActivityStreamsInboxProperty()
+		inbox.SetIRI(mustParse(testMyInboxIRI))
+		self.SetActivityStreamsInbox(inbox)
+		c.EXPECT().NewTransport(ctx, outboxIRI, goFedUserAgent()).Return(tp, nil)
+		fp.EXPECT().MaxDeliveryRecursionDepth(ctx).Return(1)
+		db.EXPECT().Lock(ctx, gomock.Any()).AnyTimes()
+		db.EXPECT().Unlock(ctx, gomock.Any()).AnyTimes()
+		db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil).AnyTimes()
+		db.EXPECT().Blocked(ctx, me).Return(streams.NewActivityStreamsCollection(), nil)
+		db.EXPECT().Get(ctx, me).Return(self, nil)
+		tp.EXPECT().Dereference(ctx, mustParse(testFederatedActorIRI)).Return(actorJSON(testFederatedActorIRI), nil)
+		tp.EXPECT().Dereference(ctx, mustParse(testFederatedActorIRI2)).Return(actorJSON(testFederatedActorIRI2), nil)
+		return
+	}
+	activityFn := func() vocab.ActivityStreamsCreate {
+		create := streams.NewActivityStreamsCreate()
+		attrTo := streams.NewActivityStreamsAttributedToProperty()
+		attrTo.AppendIRI(me)
+		create.SetActivityStreamsAttributedTo(attrTo)
+		to := streams.NewActivityStreamsToProperty()
+		to.AppendIRI(mustParse(PublicActivityPubIRI))
+		to.AppendIRI(mustParse(testFederatedActorIRI))
+		create.SetActivityStreamsTo(to)
+		return create
+	}
+	// Run tests
+	t.Run("PublicDeliversToSharedInbox", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, a := setupFn(ctl)
+		create := activityFn()
+		create.GetActivityStreamsTo().AppendIRI(mustParse(testFederatedActorIRI2))
+		// Run
+		inboxes, err := a.prepare(ctx, outboxIRI, create)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(inboxes), 1)
+		assertEqual(t, inboxes[0].String(), sharedInbox)
+	})
+	t.Run("HiddenRecipientsDeliverToPersonalInboxes", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, a := setupFn(ctl)
+		create := activityFn()
+		bcc := streams.NewActivityStreamsBccProperty()
+		bcc.AppendIRI(mustParse(testFederatedActorIRI2))
+		create.SetActivityStreamsBcc(bcc)
+		// Run
+		inboxes, err := a.prepare(ctx, outboxIRI, create)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(inboxes), 2)
+		assertEqual(t, inboxes[0].String(), testFederatedActorIRI+"/inbox")
+		assertEqual(t, inboxes[1].String(), testFederatedActorIRI2+"/inbox")
+		assertEqual(t, create.GetActivityStreamsBcc().Len(), 0)
+	})
 }
 
 // TestWrapInCreate ensures an object received by the Social Protocol is
@@ -2,8 +2,8 @@ package pub
 
 import (
 	"context"
-	"github.com/go-fed/activity/streams/vocab"
 	"net/http"
+	"net/url"
 )
 
 // SocialProtocol contains behaviors an application needs to satisfy for the
@@ -62,13 +62,18 @@ type SocialProtocol interface {
 	// type and extension, so the unhandled ones are passed to
 	// DefaultCallback.
 	DefaultCallback(c context.Context, activity Activity) error
-	// GetOutbox returns the OrderedCollection inbox of the actor for this
-	// context. It is up to the implementation to provide the correct
-	// collection for the kind of authorization given in the request.
+	// GetOutbox is called with the page of the outbox selected by the
+	// request, as returned by the Database's GetOutboxPage, and returns
+	// the page to serve. When no page is requested, only the TotalItems of
+	// the returned page are served.
+	//
+	// It is up to the implementation to remove the items that the kind
+	// of authorization given in the request does not permit, or to simply
+	// return the page unchanged. The VisibilityPolicy, if any, is applied
+	// to the items of the returned page afterwards.
 	//
 	// AuthenticateGetOutbox will be called prior to this.
 	//
-	// Always called, regardless whether the Federated Protocol or Social
-	// API is enabled.
-	GetOutbox(c context.Context, r *http.Request) (vocab.ActivityStreamsOrderedCollectionPage, error)
+	// Only called if the Social API is enabled.
+	GetOutbox(c context.Context, r *http.Request, outboxIRI *url.URL, q BoxPageQuery, page BoxPage) (BoxPage, error)
 }
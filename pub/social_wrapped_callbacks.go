@@ -36,9 +36,14 @@ type SocialWrappedCallbacks struct {
 	// Follow handles additional side effects for the Follow ActivityStreams
 	// type.
 	//
-	// The wrapping callback only ensures the 'Follow' has at least one
-	// 'object' entry, but otherwise has no default side effect.
+	// The wrapping callback ensures the 'Follow' has at least one 'object'
+	// entry, and saves it as pending in OutgoingFollows if not nil.
 	Follow func(context.Context, vocab.ActivityStreamsFollow) error
+	// OutgoingFollows, if not nil, keeps the Follows sent by the actor
+	// until they are accepted, rejected, or undone, so the application
+	// can list the outstanding requests. It should be the same PendingFollowStore
+	// as the OutgoingFollows of the FederatingWrappedCallbacks.
+	OutgoingFollows PendingFollowStore
 	// Add handles additional side effects for the Add ActivityStreams
 	// type.
 	//
@@ -69,16 +74,22 @@ type SocialWrappedCallbacks struct {
 	// It enforces that the actors on the Undo must correspond to all of the
 	// 'object' actors in some manner.
 	//
-	// It is expected that the application will implement the proper
-	// reversal of activities that are being undone.
+	// The wrapping function then reverses the default side effects of the
+	// undone Follows, Likes, and Blocks, unless disabled by DisableUndo.
+	// Any other reversal is left to the application.
 	Undo func(context.Context, vocab.ActivityStreamsUndo) error
+	// DisableUndo lists the types of Activities whose default side effects
+	// are not reversed when they are undone, such as when the application
+	// reverses them itself. By default, all are reversed.
+	DisableUndo UndoSideEffects
 	// Block handles additional side effects for the Block ActivityStreams
 	// type.
 	//
-	// The wrapping callback only ensures the 'Block' has at least one
-	// 'object' entry, but otherwise has no default side effect. It is up
-	// to the wrapped application function to properly enforce the new
-	// blocking behavior.
+	// The wrapping callback ensures the 'Block' has at least one 'object'
+	// entry. The blocked actors are then added to the actor's 'blocked'
+	// collection and removed from its 'followers' and 'following'. Their
+	// activities are no longer accepted in the actor's inbox, and nothing
+	// is delivered to them anymore.
 	//
 	// Note that go-fed does not federate 'Block' activities received in the
 	// Social Protocol.
@@ -241,6 +252,20 @@ func (w SocialWrappedCallbacks) create(c context.Context, a vocab.ActivityStream
 	if err := normalizeRecipients(a); err != nil {
 		return err
 	}
+	// Persist all objects we've created, which will include sensitive
+	// recipients such as 'bcc' and 'bto'.
+	if err := createObjects(c, w.db, op); err != nil {
+		return err
+	}
+	if w.Create != nil {
+		return w.Create(c, a)
+	}
+	return nil
+}
+
+// createObjects stores each value of a Create's 'object' property in the
+// database.
+func createObjects(c context.Context, db Database, op vocab.ActivityStreamsObjectProperty) error {
 	// Create anonymous loop function to be able to properly scope the defer
 	// for the database lock at each iteration.
 	loopFn := func(i int) error {
@@ -249,26 +274,21 @@ func (w SocialWrappedCallbacks) create(c context.Context, a vocab.ActivityStream
 		if err != nil {
 			return err
 		}
-		err = w.db.Lock(c, id)
+		err = db.Lock(c, id)
 		if err != nil {
 			return err
 		}
-		defer w.db.Unlock(c, id)
-		if err := w.db.Create(c, obj); err != nil {
+		defer db.Unlock(c, id)
+		if err := db.Create(c, obj); err != nil {
 			return err
 		}
 		return nil
 	}
-	// Persist all objects we've created, which will include sensitive
-	// recipients such as 'bcc' and 'bto'.
 	for i := 0; i < op.Len(); i++ {
 		if err := loopFn(i); err != nil {
 			return err
 		}
 	}
-	if w.Create != nil {
-		return w.Create(c, a)
-	}
 	return nil
 }
 
@@ -394,6 +414,21 @@ func (w SocialWrappedCallbacks) follow(c context.Context, a vocab.ActivityStream
 	if op == nil || op.Len() == 0 {
 		return ErrObjectRequired
 	}
+	if w.OutgoingFollows != nil {
+		if err := w.db.Lock(c, w.outboxIRI); err != nil {
+			return err
+		}
+		// WARNING: Unlock not deferred.
+		actorIRI, err := w.db.ActorForOutbox(c, w.outboxIRI)
+		w.db.Unlock(c, w.outboxIRI)
+		if err != nil {
+			return err
+		}
+		// Unlock must be called by now and every branch above.
+		if err := w.OutgoingFollows.AddPendingFollow(c, actorIRI, a); err != nil {
+			return err
+		}
+	}
 	if w.Follow != nil {
 		return w.Follow(c, a)
 	}
@@ -499,7 +534,11 @@ func (w SocialWrappedCallbacks) undo(c context.Context, a vocab.ActivityStreamsU
 		return ErrObjectRequired
 	}
 	actors := a.GetActivityStreamsActor()
-	if err := mustHaveActivityActorsMatchObjectActors(c, actors, op, w.newTransport, w.outboxIRI); err != nil {
+	undone, err := mustHaveActivityActorsMatchObjectActors(c, actors, op, w.db, w.newTransport, w.outboxIRI)
+	if err != nil {
+		return err
+	}
+	if err := undoActivities(c, undone, w.DisableUndo, w.undoFollow, w.undoLike, nil, w.undoBlock); err != nil {
 		return err
 	}
 	if w.Undo != nil {
@@ -515,6 +554,9 @@ func (w SocialWrappedCallbacks) block(c context.Context, a vocab.ActivityStreams
 	if op == nil || op.Len() == 0 {
 		return ErrObjectRequired
 	}
+	if err := w.blockObjects(c, op); err != nil {
+		return err
+	}
 	if w.Block != nil {
 		return w.Block(c, a)
 	}
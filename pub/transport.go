@@ -17,6 +17,9 @@ const (
 	// acceptHeaderValue is the Accept header value indicating that the
 	// response should contain an ActivityStreams object.
 	acceptHeaderValue = "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\""
+	// maxBatchDeliverConcurrency is the maximum number of requests a single
+	// call to BatchDeliver makes at the same time.
+	maxBatchDeliverConcurrency = 16
 )
 
 // Transport makes ActivityStreams calls to other servers in order to send or
@@ -46,7 +49,7 @@ var _ Transport = &HttpSigTransport{}
 // HttpSigTransport makes a dereference call using HTTP signatures to
 // authenticate the request on behalf of a particular actor.
 //
-// No rate limiting is applied.
+// Deliveries are rate limited only if a DeliveryLimiter is provided.
 //
 // Only one request is tried per call.
 type HttpSigTransport struct {
@@ -54,9 +57,14 @@ type HttpSigTransport struct {
 	appAgent   string
 	gofedAgent string
 	clock      Clock
-	signer     httpsig.Signer
+	getSigner  httpsig.Signer
+	postSigner httpsig.Signer
 	pubKeyId   string
 	privKey    crypto.PrivateKey
+	limiter    *DeliveryLimiter
+	// signMu guards the signers, which are not safe to use concurrently
+	// but are used by the concurrent deliveries of BatchDeliver.
+	signMu *sync.Mutex
 }
 
 // NewHttpSigTransport returns a new Transport.
@@ -66,6 +74,16 @@ type HttpSigTransport struct {
 // requires an actor's private key, a unique identifier for their public key,
 // and an HTTP Signature signing algorithm.
 //
+// The getSigner is used to sign GET requests and the postSigner to sign POST
+// requests. The headers each one is created with are the ones included in the
+// signature. The postSigner must include the "digest" header, which is always
+// set on POST requests, and deliveries signed without it fail with an error.
+// Peers commonly also require the "(request-target)", "host", and "date"
+// headers.
+//
+// The limiter bounds and paces deliveries, and should be shared between all
+// transports. It may be nil, in which case deliveries are not limited.
+//
 // The client lets users issue requests through any HTTP client, including the
 // standard library's HTTP client.
 //
@@ -78,49 +96,109 @@ func NewHttpSigTransport(
 	client HttpClient,
 	appAgent string,
 	clock Clock,
-	signer httpsig.Signer,
+	getSigner, postSigner httpsig.Signer,
 	pubKeyId string,
-	privKey crypto.PrivateKey) *HttpSigTransport {
+	privKey crypto.PrivateKey,
+	limiter *DeliveryLimiter) *HttpSigTransport {
 	return &HttpSigTransport{
 		client:     client,
 		appAgent:   appAgent,
 		gofedAgent: goFedUserAgent(),
 		clock:      clock,
-		signer:     signer,
+		getSigner:  getSigner,
+		postSigner: postSigner,
 		pubKeyId:   pubKeyId,
 		privKey:    privKey,
+		limiter:    limiter,
+		signMu:     &sync.Mutex{},
 	}
 }
 
 // Dereference sends a GET request signed with an HTTP Signature to obtain an
 // ActivityStreams value.
 func (h HttpSigTransport) Dereference(c context.Context, iri *url.URL) ([]byte, error) {
-	req, err := http.NewRequest("GET", iri.String(), nil)
+	resp, err := h.dereference(c, iri, "", "")
 	if err != nil {
 		return nil, err
 	}
-	req.WithContext(c)
+	return resp.Body, nil
+}
+
+// ConditionalDereference sends a GET request signed with an HTTP Signature,
+// made conditional on the ETag and Last-Modified values when they are not
+// empty, to obtain an ActivityStreams value.
+func (h HttpSigTransport) ConditionalDereference(c context.Context, iri *url.URL, etag, lastModified string) (DereferenceResponse, error) {
+	return h.dereference(c, iri, etag, lastModified)
+}
+
+// dereference sends a GET request signed with an HTTP Signature, which is
+// conditional if either of the etag or lastModified validators are not empty.
+func (h HttpSigTransport) dereference(c context.Context, iri *url.URL, etag, lastModified string) (DereferenceResponse, error) {
+	req, err := http.NewRequest("GET", iri.String(), nil)
+	if err != nil {
+		return DereferenceResponse{}, err
+	}
+	req = req.WithContext(c)
 	req.Header.Add(acceptHeader, acceptHeaderValue)
 	req.Header.Add("Accept-Charset", "utf-8")
 	req.Header.Add("Date", h.clock.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05")+" GMT")
 	req.Header.Add("User-Agent", fmt.Sprintf("%s %s", h.appAgent, h.gofedAgent))
-	err = h.signer.SignRequest(h.privKey, h.pubKeyId, req)
+	req.Header.Add(hostHeader, iri.Host)
+	if len(etag) > 0 {
+		req.Header.Add(ifNoneMatchHeader, etag)
+	}
+	if len(lastModified) > 0 {
+		req.Header.Add(ifModifiedSinceHeader, lastModified)
+	}
+	h.signMu.Lock()
+	err = h.getSigner.SignRequest(h.privKey, h.pubKeyId, req)
+	h.signMu.Unlock()
 	if err != nil {
-		return nil, err
+		return DereferenceResponse{}, err
 	}
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return nil, err
+		return DereferenceResponse{}, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GET request to %s failed (%d): %s", iri.String(), resp.StatusCode, resp.Status)
+	conditional := len(etag) > 0 || len(lastModified) > 0
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return DereferenceResponse{
+			NotModified: true,
+			Header:      resp.Header,
+		}, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return DereferenceResponse{}, &HttpStatusError{
+			Method:     "GET",
+			IRI:        iri,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return DereferenceResponse{}, err
 	}
-	return ioutil.ReadAll(resp.Body)
+	return DereferenceResponse{
+		Body:   b,
+		Header: resp.Header,
+	}, nil
 }
 
-// Deliver sends a POST request with an HTTP Signature.
+// Deliver sends a POST request with an HTTP Signature and a SHA-256 Digest of
+// the payload.
+//
+// Any 2xx response from the peer is considered a successful delivery.
 func (h HttpSigTransport) Deliver(c context.Context, b []byte, to *url.URL) error {
+	// Wait for the limiter before dating and signing the request, so that
+	// a throttled delivery is not sent with a stale signature.
+	if h.limiter != nil {
+		release, err := h.limiter.acquire(c, to.Host)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 	byteCopy := make([]byte, len(b))
 	copy(byteCopy, b)
 	buf := bytes.NewBuffer(byteCopy)
@@ -128,56 +206,127 @@ func (h HttpSigTransport) Deliver(c context.Context, b []byte, to *url.URL) erro
 	if err != nil {
 		return err
 	}
-	req.WithContext(c)
+	req = req.WithContext(c)
 	req.Header.Add(contentTypeHeader, contentTypeHeaderValue)
 	req.Header.Add("Accept-Charset", "utf-8")
 	req.Header.Add("Date", h.clock.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05")+" GMT")
 	req.Header.Add("User-Agent", fmt.Sprintf("%s %s", h.appAgent, h.gofedAgent))
-	err = h.signer.SignRequest(h.privKey, h.pubKeyId, req)
+	req.Header.Add(hostHeader, to.Host)
+	// RFC 3230 and RFC 5843
+	req.Header.Add(digestHeader, digestHeaderValue(b))
+	h.signMu.Lock()
+	err = h.postSigner.SignRequest(h.privKey, h.pubKeyId, req)
+	h.signMu.Unlock()
 	if err != nil {
 		return err
 	}
+	// An unsigned Digest would let the payload be tampered with.
+	if !signedHeaders(req.Header)[strings.ToLower(digestHeader)] {
+		return fmt.Errorf("http signature of delivery to %s does not cover the digest header", to)
+	}
 	resp, err := h.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("POST request to %s failed (%d): %s", to.String(), resp.StatusCode, resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &HttpStatusError{
+			Method:     "POST",
+			IRI:        to,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
 	}
 	return nil
 }
 
-// BatchDeliver sends concurrent POST requests. Returns an error if any of the
-// requests had an error.
+// BatchDeliver sends concurrent POST requests, at most
+// maxBatchDeliverConcurrency at a time. Returns a BatchDeliverError if any of
+// the requests had an error.
+//
+// Recipients not yet delivered to when the context is done are not delivered
+// to, and their result contains the context's error.
 func (h HttpSigTransport) BatchDeliver(c context.Context, b []byte, recipients []*url.URL) error {
 	var wg sync.WaitGroup
-	errCh := make(chan error, len(recipients))
-	for _, recipient := range recipients {
+	sem := make(chan struct{}, maxBatchDeliverConcurrency)
+	results := make([]DeliveryResult, len(recipients))
+	for i, recipient := range recipients {
+		results[i].Recipient = recipient
+		select {
+		case sem <- struct{}{}:
+		case <-c.Done():
+			results[i].Err = c.Err()
+			continue
+		}
 		wg.Add(1)
-		go func(r *url.URL) {
+		go func(res *DeliveryResult) {
 			defer wg.Done()
-			if err := h.Deliver(c, b, r); err != nil {
-				errCh <- err
-			}
-		}(recipient)
+			defer func() { <-sem }()
+			res.Err = h.Deliver(c, b, res.Recipient)
+		}(&results[i])
 	}
 	wg.Wait()
-	errs := make([]string, 0, len(recipients))
-	for {
-		select {
-		case e := <-errCh:
-			errs = append(errs, e.Error())
-		default:
-			break
+	for _, res := range results {
+		if res.Err != nil {
+			return &BatchDeliverError{Results: results}
 		}
 	}
-	if len(errs) > 0 {
-		return fmt.Errorf("batch deliver had at least one failure: %s", strings.Join(errs, "; "))
-	}
 	return nil
 }
 
+// DeliveryResult is the outcome of delivering to a single recipient.
+type DeliveryResult struct {
+	// Recipient is the inbox IRI delivered to.
+	Recipient *url.URL
+	// Err is nil if the delivery succeeded.
+	Err error
+}
+
+// BatchDeliverError is returned by the HttpSigTransport's BatchDeliver when at
+// least one of the deliveries failed.
+type BatchDeliverError struct {
+	// Results contains the outcome for each recipient, including the
+	// successful ones, in the order the recipients were given.
+	Results []DeliveryResult
+}
+
+// Error returns a description of the failed deliveries.
+func (e *BatchDeliverError) Error() string {
+	var errs []string
+	for _, res := range e.Failed() {
+		errs = append(errs, res.Err.Error())
+	}
+	return fmt.Sprintf("batch deliver had at least one failure: %s", strings.Join(errs, "; "))
+}
+
+// Failed returns the results of the deliveries that failed.
+func (e *BatchDeliverError) Failed() (failed []DeliveryResult) {
+	for _, res := range e.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return
+}
+
+// HttpStatusError is returned by the HttpSigTransport when a peer responds to
+// a request with an unexpected HTTP status code.
+type HttpStatusError struct {
+	// Method is the HTTP method of the failed request.
+	Method string
+	// IRI is the target of the failed request.
+	IRI *url.URL
+	// StatusCode is the HTTP status code the peer responded with.
+	StatusCode int
+	// Status is the HTTP status line the peer responded with.
+	Status string
+}
+
+// Error returns a description of the failed request.
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("%s request to %s failed (%d): %s", e.Method, e.IRI.String(), e.StatusCode, e.Status)
+}
+
 // HttpClient sends http requests, and is an abstraction only needed by the
 // HttpSigTransport. The standard library's Client satisfies this interface.
 type HttpClient interface {
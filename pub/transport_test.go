@@ -0,0 +1,175 @@
+package pub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/go-fed/httpsig"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestHttpSigTransportDeliver ensures deliveries carry a signed Digest of the
+// payload.
+func TestHttpSigTransportDeliver(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte(`{"type":"Create"}`)
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postSigner, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, []string{httpsig.RequestTarget, "host", "date", "digest"}, httpsig.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Setup
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	client := NewMockHttpClient(ctl)
+	cl := NewMockClock(ctl)
+	cl.EXPECT().Now().Return(now())
+	tp := NewHttpSigTransport(client, "myApp", cl, nil, postSigner, testMyOutboxIRI+"#main-key", privKey, nil)
+	var req *http.Request
+	client.EXPECT().Do(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+		req = r
+		return &http.Response{
+			StatusCode: http.StatusAccepted,
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	})
+	// Run
+	err = tp.Deliver(ctx, payload, mustParse(testFederatedActorIRI))
+	// Verify
+	assertEqual(t, err, nil)
+	assertEqual(t, req.Header.Get(digestHeader), digestHeaderValue(payload))
+	assertEqual(t, VerifyDigest(req), nil)
+	assertEqual(t, strings.Contains(req.Header.Get(signatureHeader), `headers="(request-target) host date digest"`), true)
+	v, err := httpsig.NewVerifier(req)
+	assertEqual(t, err, nil)
+	assertEqual(t, v.Verify(&privKey.PublicKey, httpsig.RSA_SHA256), nil)
+}
+
+// TestHttpSigTransportDeliverRequiresSignedDigest ensures deliveries are not
+// made if the signature does not cover the Digest header.
+func TestHttpSigTransportDeliverRequiresSignedDigest(t *testing.T) {
+	ctx := context.Background()
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postSigner, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, []string{httpsig.RequestTarget, "host", "date"}, httpsig.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Setup
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	client := NewMockHttpClient(ctl)
+	cl := NewMockClock(ctl)
+	cl.EXPECT().Now().Return(now())
+	tp := NewHttpSigTransport(client, "myApp", cl, nil, postSigner, testMyOutboxIRI+"#main-key", privKey, nil)
+	// Run
+	err = tp.Deliver(ctx, []byte(`{"type":"Create"}`), mustParse(testFederatedActorIRI))
+	// Verify
+	assertNotEqual(t, err, nil)
+}
+
+// TestHttpSigTransportDeliverWaitsForLimiter ensures deliveries are dated and
+// signed only once the DeliveryLimiter permits them.
+func TestHttpSigTransportDeliverWaitsForLimiter(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte(`{"type":"Create"}`)
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Setup
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	postSigner, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, []string{httpsig.RequestTarget, "host", "date", "digest"}, httpsig.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewDeliveryLimiter(1, 1, 0, 0)
+	// The clock must not be read to date the request while waiting.
+	tp := NewHttpSigTransport(NewMockHttpClient(ctl), "myApp", NewMockClock(ctl), nil, postSigner, testMyOutboxIRI+"#main-key", privKey, limiter)
+	release, err := limiter.acquire(ctx, mustParse(testFederatedActorIRI).Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	// Run
+	err = tp.Deliver(cctx, payload, mustParse(testFederatedActorIRI))
+	// Verify
+	assertEqual(t, err, context.Canceled)
+}
+
+// TestHttpSigTransportBatchDeliver ensures the outcome of each delivery is
+// reported.
+func TestHttpSigTransportBatchDeliver(t *testing.T) {
+	ctx := context.Background()
+	payload := []byte(`{"type":"Create"}`)
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupFn := func(ctl *gomock.Controller) (client *MockHttpClient, tp *HttpSigTransport) {
+		postSigner, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, []string{httpsig.RequestTarget, "host", "date", "digest"}, httpsig.Signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client = NewMockHttpClient(ctl)
+		cl := NewMockClock(ctl)
+		cl.EXPECT().Now().Return(now()).AnyTimes()
+		tp = NewHttpSigTransport(client, "myApp", cl, nil, postSigner, testMyOutboxIRI+"#main-key", privKey, NewDeliveryLimiter(1, 1, 0, 0))
+		return
+	}
+	// Run tests
+	t.Run("ReturnsResultForEachRecipient", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		client, tp := setupFn(ctl)
+		client.EXPECT().Do(gomock.Any()).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+			code := http.StatusOK
+			if r.URL.String() == testFederatedActorIRI2 {
+				code = http.StatusGone
+			}
+			return &http.Response{
+				StatusCode: code,
+				Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			}, nil
+		}).Times(2)
+		// Run
+		err := tp.BatchDeliver(ctx, payload, []*url.URL{mustParse(testFederatedActorIRI), mustParse(testFederatedActorIRI2)})
+		// Verify
+		batchErr, ok := err.(*BatchDeliverError)
+		assertEqual(t, ok, true)
+		assertEqual(t, len(batchErr.Results), 2)
+		assertEqual(t, batchErr.Results[0].Err, nil)
+		failed := batchErr.Failed()
+		assertEqual(t, len(failed), 1)
+		assertEqual(t, failed[0].Recipient.String(), testFederatedActorIRI2)
+	})
+	t.Run("DoesNotDeliverOnceCancelled", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, tp := setupFn(ctl)
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		// Run
+		err := tp.BatchDeliver(cctx, payload, []*url.URL{mustParse(testFederatedActorIRI), mustParse(testFederatedActorIRI2)})
+		// Verify
+		batchErr, ok := err.(*BatchDeliverError)
+		assertEqual(t, ok, true)
+		assertEqual(t, len(batchErr.Failed()), 2)
+	})
+}
@@ -0,0 +1,303 @@
+package pub
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/url"
+)
+
+// UndoSideEffects is a set of the types of Activities whose side effects go-fed
+// reverses when they are undone. Values are combined with a bitwise OR.
+type UndoSideEffects int
+
+const (
+	// UndoFollow reverses a Follow, removing the following actor from the
+	// 'followers' of the followed actor in the Federating Protocol, and
+	// the followed actor from the 'following' of the following actor in
+	// the Social API.
+	UndoFollow UndoSideEffects = 1 << iota
+	// UndoLike reverses a Like, removing it from the 'likes' of the liked
+	// objects in the Federating Protocol, and the liked objects from the
+	// 'liked' of the actor in the Social API.
+	UndoLike
+	// UndoAnnounce reverses an Announce, removing it from the 'shares' of
+	// the announced objects in the Federating Protocol.
+	UndoAnnounce
+	// UndoBlock reverses a Block, removing the blocked actors from the
+	// 'blocked' collection of the actor in the Social API. A Block received
+	// in the Federating Protocol has no default side effects to reverse.
+	UndoBlock
+)
+
+// undoActivities reverses the default side effects of the undone Activities
+// with the given function for each type, unless disabled.
+func undoActivities(c context.Context,
+	undone []vocab.Type,
+	disabled UndoSideEffects,
+	undoFollow func(context.Context, vocab.ActivityStreamsFollow) error,
+	undoLike func(context.Context, vocab.ActivityStreamsLike) error,
+	undoAnnounce func(context.Context, vocab.ActivityStreamsAnnounce) error,
+	undoBlock func(context.Context, vocab.ActivityStreamsBlock) error) error {
+	for _, t := range undone {
+		var err error
+		switch v := t.(type) {
+		case vocab.ActivityStreamsFollow:
+			if disabled&UndoFollow == 0 && undoFollow != nil {
+				err = undoFollow(c, v)
+			}
+		case vocab.ActivityStreamsLike:
+			if disabled&UndoLike == 0 && undoLike != nil {
+				err = undoLike(c, v)
+			}
+		case vocab.ActivityStreamsAnnounce:
+			if disabled&UndoAnnounce == 0 && undoAnnounce != nil {
+				err = undoAnnounce(c, v)
+			}
+		case vocab.ActivityStreamsBlock:
+			if disabled&UndoBlock == 0 && undoBlock != nil {
+				err = undoBlock(c, v)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoFollow removes the actors of the Follow from the 'followers' of the actor
+// owning the inbox, if the Follow is of that actor. A Follow awaiting approval is
+// also removed from the PendingFollows.
+func (w FederatingWrappedCallbacks) undoFollow(c context.Context, follow vocab.ActivityStreamsFollow) error {
+	if err := w.db.Lock(c, w.inboxIRI); err != nil {
+		return err
+	}
+	// WARNING: Unlock not deferred.
+	actorIRI, err := w.db.ActorForInbox(c, w.inboxIRI)
+	w.db.Unlock(c, w.inboxIRI)
+	if err != nil {
+		return err
+	}
+	// Unlock must be called by now and every branch above.
+	objects, err := objectIds(follow.GetActivityStreamsObject())
+	if err != nil {
+		return err
+	} else if !objects[actorIRI.String()] {
+		return nil
+	}
+	// A Follow without an id cannot be pending.
+	if id, err := GetId(follow); err == nil && w.PendingFollows != nil {
+		if err := w.PendingFollows.RemovePendingFollow(c, actorIRI, id); err != nil {
+			return err
+		}
+	}
+	followers, err := actorIds(follow.GetActivityStreamsActor())
+	if err != nil {
+		return err
+	}
+	if err := w.db.Lock(c, actorIRI); err != nil {
+		return err
+	}
+	defer w.db.Unlock(c, actorIRI)
+	col, err := w.db.Followers(c, actorIRI)
+	if err != nil {
+		return err
+	}
+	if err := removeIds(col, followers); err != nil {
+		return err
+	}
+	return w.db.Update(c, col)
+}
+
+// undoLike removes the Like from the 'likes' of its objects owned by this
+// server.
+func (w FederatingWrappedCallbacks) undoLike(c context.Context, like vocab.ActivityStreamsLike) error {
+	return removeFromObjects(c, w.db, like, like.GetActivityStreamsObject(), func(t vocab.Type) (vocab.Type, error) {
+		l, ok := t.(likeser)
+		if !ok {
+			return nil, fmt.Errorf("cannot remove Like from likes collection for type %T", t)
+		}
+		if likes := l.GetActivityStreamsLikes(); likes != nil {
+			return likes.GetType(), nil
+		}
+		return nil, nil
+	})
+}
+
+// undoAnnounce removes the Announce from the 'shares' of its objects owned by
+// this server.
+func (w FederatingWrappedCallbacks) undoAnnounce(c context.Context, announce vocab.ActivityStreamsAnnounce) error {
+	return removeFromObjects(c, w.db, announce, announce.GetActivityStreamsObject(), func(t vocab.Type) (vocab.Type, error) {
+		s, ok := t.(shareser)
+		if !ok {
+			return nil, fmt.Errorf("cannot remove Announce from shares collection for type %T", t)
+		}
+		if shares := s.GetActivityStreamsShares(); shares != nil {
+			return shares.GetType(), nil
+		}
+		return nil, nil
+	})
+}
+
+// undoFollow removes the objects of the Follow from the 'following' of the
+// actor owning the outbox. A Follow that was never answered is also removed
+// from the OutgoingFollows, so that a late Accept is ignored.
+func (w SocialWrappedCallbacks) undoFollow(c context.Context, follow vocab.ActivityStreamsFollow) error {
+	followed, err := objectIds(follow.GetActivityStreamsObject())
+	if err != nil {
+		return err
+	}
+	// A Follow without an id cannot be pending.
+	if id, err := GetId(follow); err == nil && w.OutgoingFollows != nil {
+		if err := w.db.Lock(c, w.outboxIRI); err != nil {
+			return err
+		}
+		// WARNING: Unlock not deferred.
+		actorIRI, err := w.db.ActorForOutbox(c, w.outboxIRI)
+		w.db.Unlock(c, w.outboxIRI)
+		if err != nil {
+			return err
+		}
+		// Unlock must be called by now and every branch above.
+		if err := w.OutgoingFollows.RemovePendingFollow(c, actorIRI, id); err != nil {
+			return err
+		}
+	}
+	return w.removeFromActorCollection(c, followed, w.db.Following)
+}
+
+// undoLike removes the objects of the Like from the 'liked' of the actor owning
+// the outbox.
+func (w SocialWrappedCallbacks) undoLike(c context.Context, like vocab.ActivityStreamsLike) error {
+	liked, err := objectIds(like.GetActivityStreamsObject())
+	if err != nil {
+		return err
+	}
+	return w.removeFromActorCollection(c, liked, w.db.Liked)
+}
+
+// undoBlock removes the objects of the Block from the 'blocked' collection of
+// the actor owning the outbox.
+func (w SocialWrappedCallbacks) undoBlock(c context.Context, block vocab.ActivityStreamsBlock) error {
+	blocked, err := objectIds(block.GetActivityStreamsObject())
+	if err != nil {
+		return err
+	}
+	return w.removeFromActorCollection(c, blocked, w.db.Blocked)
+}
+
+// removeFromActorCollection removes the ids from the collection of the actor
+// owning the outbox obtained with the function.
+func (w SocialWrappedCallbacks) removeFromActorCollection(c context.Context,
+	ids map[string]bool,
+	collectionFn func(c context.Context, actorIRI *url.URL) (vocab.ActivityStreamsCollection, error)) error {
+	if err := w.db.Lock(c, w.outboxIRI); err != nil {
+		return err
+	}
+	// WARNING: Unlock not deferred.
+	actorIRI, err := w.db.ActorForOutbox(c, w.outboxIRI)
+	w.db.Unlock(c, w.outboxIRI)
+	if err != nil {
+		return err
+	}
+	// Unlock must be called by now and every branch above.
+	if err := w.db.Lock(c, actorIRI); err != nil {
+		return err
+	}
+	defer w.db.Unlock(c, actorIRI)
+	col, err := collectionFn(c, actorIRI)
+	if err != nil {
+		return err
+	}
+	if err := removeIds(col, ids); err != nil {
+		return err
+	}
+	return w.db.Update(c, col)
+}
+
+// removeFromObjects removes the activity from a collection, obtained with the
+// function, of each object owned by this server.
+func removeFromObjects(c context.Context,
+	db Database,
+	activity Activity,
+	op vocab.ActivityStreamsObjectProperty,
+	collectionFn func(t vocab.Type) (vocab.Type, error)) error {
+	if op == nil {
+		return nil
+	}
+	id, err := GetId(activity)
+	if err != nil {
+		return err
+	}
+	ids := map[string]bool{id.String(): true}
+	// Create anonymous loop function to be able to properly scope the defer
+	// for the database lock at each iteration.
+	loopFn := func(iter vocab.ActivityStreamsObjectPropertyIterator) error {
+		objId, err := ToId(iter)
+		if err != nil {
+			return err
+		}
+		if err := db.Lock(c, objId); err != nil {
+			return err
+		}
+		defer db.Unlock(c, objId)
+		if owns, err := db.Owns(c, objId); err != nil {
+			return err
+		} else if !owns {
+			return nil
+		}
+		t, err := db.Get(c, objId)
+		if err != nil {
+			return err
+		}
+		col, err := collectionFn(t)
+		if err != nil {
+			return err
+		} else if col == nil {
+			return nil
+		}
+		if err := removeIds(col, ids); err != nil {
+			return err
+		}
+		return db.Update(c, t)
+	}
+	for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
+		if err := loopFn(iter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectIds returns the ids of the values of an 'object' property.
+func objectIds(op vocab.ActivityStreamsObjectProperty) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	if op == nil {
+		return ids, nil
+	}
+	for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
+		id, err := ToId(iter)
+		if err != nil {
+			return nil, err
+		}
+		ids[id.String()] = true
+	}
+	return ids, nil
+}
+
+// actorIds returns the ids of the values of an 'actor' property.
+func actorIds(ap vocab.ActivityStreamsActorProperty) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	if ap == nil {
+		return ids, nil
+	}
+	for iter := ap.Begin(); iter != ap.End(); iter = iter.Next() {
+		id, err := ToId(iter)
+		if err != nil {
+			return nil, err
+		}
+		ids[id.String()] = true
+	}
+	return ids, nil
+}
@@ -0,0 +1,400 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/golang/mock/gomock"
+	"net/url"
+	"testing"
+)
+
+// TestUndoSideEffects ensures the default side effects of undone activities
+// are reversed unless disabled.
+func TestUndoSideEffects(t *testing.T) {
+	ctx := context.Background()
+	me := mustParse("https://example.com/addison")
+	inboxIRI := mustParse(testMyInboxIRI)
+	outboxIRI := mustParse(testMyOutboxIRI)
+	peer := mustParse(testFederatedActorIRI)
+	actorProp := func(iri *url.URL) vocab.ActivityStreamsActorProperty {
+		p := streams.NewActivityStreamsActorProperty()
+		p.AppendIRI(iri)
+		return p
+	}
+	objectProp := func(iri *url.URL) vocab.ActivityStreamsObjectProperty {
+		p := streams.NewActivityStreamsObjectProperty()
+		p.AppendIRI(iri)
+		return p
+	}
+	idProp := func(iri *url.URL) vocab.ActivityStreamsIdProperty {
+		p := streams.NewActivityStreamsIdProperty()
+		p.Set(iri)
+		return p
+	}
+	collection := func(iris ...*url.URL) vocab.ActivityStreamsCollection {
+		col := streams.NewActivityStreamsCollection()
+		items := streams.NewActivityStreamsItemsProperty()
+		for _, iri := range iris {
+			items.AppendIRI(iri)
+		}
+		col.SetActivityStreamsItems(items)
+		return col
+	}
+	itemIds := func(col vocab.ActivityStreamsCollection) (ids []string) {
+		items := col.GetActivityStreamsItems()
+		for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+			ids = append(ids, iter.GetIRI().String())
+		}
+		return
+	}
+	undoOf := func(actor *url.URL, a vocab.Type) vocab.ActivityStreamsUndo {
+		undo := streams.NewActivityStreamsUndo()
+		undo.SetActivityStreamsActor(actorProp(actor))
+		op := streams.NewActivityStreamsObjectProperty()
+		switch v := a.(type) {
+		case vocab.ActivityStreamsFollow:
+			op.AppendActivityStreamsFollow(v)
+		case vocab.ActivityStreamsLike:
+			op.AppendActivityStreamsLike(v)
+		case vocab.ActivityStreamsBlock:
+			op.AppendActivityStreamsBlock(v)
+		}
+		undo.SetActivityStreamsObject(op)
+		return undo
+	}
+	followOf := func(actor, object *url.URL) vocab.ActivityStreamsFollow {
+		follow := streams.NewActivityStreamsFollow()
+		follow.SetActivityStreamsActor(actorProp(actor))
+		follow.SetActivityStreamsObject(objectProp(object))
+		return follow
+	}
+	t.Run("FederatedUndoFollowRemovesFollower", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		w := FederatingWrappedCallbacks{db: db, inboxIRI: inboxIRI}
+		followers := collection(peer, mustParse(testFederatedActorIRI2))
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Followers(ctx, me).Return(followers, nil),
+			db.EXPECT().Update(ctx, followers).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := w.undo(ctx, undoOf(peer, followOf(peer, me)))
+		// Verify
+		assertEqual(t, err, nil)
+		ids := itemIds(followers)
+		assertEqual(t, len(ids), 1)
+		assertEqual(t, ids[0], testFederatedActorIRI2)
+	})
+	t.Run("FederatedUndoFollowWithdrawsPendingFollow", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		pending := NewMemoryPendingFollowStore()
+		w := FederatingWrappedCallbacks{db: db, inboxIRI: inboxIRI, PendingFollows: pending}
+		followIRI := mustParse(testFederatedActivityIRI)
+		follow := followOf(peer, me)
+		follow.SetActivityStreamsId(idProp(followIRI))
+		err := pending.AddPendingFollow(ctx, me, follow)
+		assertEqual(t, err, nil)
+		followers := collection()
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, followIRI),
+			db.EXPECT().Exists(ctx, followIRI).Return(false, nil),
+			db.EXPECT().Unlock(ctx, followIRI),
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Followers(ctx, me).Return(followers, nil),
+			db.EXPECT().Update(ctx, followers).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err = w.undo(ctx, undoOf(peer, follow))
+		// Verify
+		assertEqual(t, err, nil)
+		follows, err := pending.PendingFollows(ctx, me)
+		assertEqual(t, err, nil)
+		assertEqual(t, len(follows), 0)
+	})
+	t.Run("FederatedUndoFollowOfOtherIgnored", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		w := FederatingWrappedCallbacks{db: db, inboxIRI: inboxIRI}
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, inboxIRI),
+			db.EXPECT().ActorForInbox(ctx, inboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, inboxIRI),
+		)
+		// Run
+		err := w.undo(ctx, undoOf(peer, followOf(peer, mustParse(testFederatedActorIRI2))))
+		// Verify
+		assertEqual(t, err, nil)
+	})
+	t.Run("FederatedUndoFollowDisabled", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		called := false
+		w := FederatingWrappedCallbacks{
+			db:          db,
+			inboxIRI:    inboxIRI,
+			DisableUndo: UndoFollow | UndoLike,
+			Undo: func(c context.Context, u vocab.ActivityStreamsUndo) error {
+				called = true
+				return nil
+			},
+		}
+		// Run
+		err := w.undo(ctx, undoOf(peer, followOf(peer, me)))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, called, true)
+	})
+	t.Run("FederatedUndoLikeRemovesFromLikes", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		w := FederatingWrappedCallbacks{db: db, inboxIRI: inboxIRI}
+		likeIRI := mustParse(testFederatedActivityIRI)
+		like := streams.NewActivityStreamsLike()
+		like.SetActivityStreamsId(idProp(likeIRI))
+		like.SetActivityStreamsActor(actorProp(peer))
+		like.SetActivityStreamsObject(objectProp(mustParse(testNoteId1)))
+		note := streams.NewActivityStreamsNote()
+		likesCol := collection(likeIRI, mustParse(testFederatedActivityIRI2))
+		likes := streams.NewActivityStreamsLikesProperty()
+		likes.SetActivityStreamsCollection(likesCol)
+		note.SetActivityStreamsLikes(likes)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, likeIRI),
+			db.EXPECT().Exists(ctx, likeIRI).Return(true, nil),
+			db.EXPECT().Get(ctx, likeIRI).Return(like, nil),
+			db.EXPECT().Unlock(ctx, likeIRI),
+			db.EXPECT().Lock(ctx, mustParse(testNoteId1)),
+			db.EXPECT().Owns(ctx, mustParse(testNoteId1)).Return(true, nil),
+			db.EXPECT().Get(ctx, mustParse(testNoteId1)).Return(note, nil),
+			db.EXPECT().Update(ctx, note).Return(nil),
+			db.EXPECT().Unlock(ctx, mustParse(testNoteId1)),
+		)
+		// Run
+		err := w.undo(ctx, undoOf(peer, like))
+		// Verify
+		assertEqual(t, err, nil)
+		ids := itemIds(likesCol)
+		assertEqual(t, len(ids), 1)
+		assertEqual(t, ids[0], testFederatedActivityIRI2)
+	})
+	t.Run("FederatedUndoOfForgedLikeRejected", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		w := FederatingWrappedCallbacks{db: db, inboxIRI: inboxIRI}
+		forger := mustParse("https://forger.example.com/sam")
+		likeIRI := mustParse(testFederatedActivityIRI)
+		stored := streams.NewActivityStreamsLike()
+		stored.SetActivityStreamsId(idProp(likeIRI))
+		stored.SetActivityStreamsActor(actorProp(peer))
+		stored.SetActivityStreamsObject(objectProp(mustParse(testNoteId1)))
+		forged := streams.NewActivityStreamsLike()
+		forged.SetActivityStreamsId(idProp(likeIRI))
+		forged.SetActivityStreamsActor(actorProp(forger))
+		forged.SetActivityStreamsObject(objectProp(mustParse(testNoteId1)))
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, likeIRI),
+			db.EXPECT().Exists(ctx, likeIRI).Return(true, nil),
+			db.EXPECT().Get(ctx, likeIRI).Return(stored, nil),
+			db.EXPECT().Unlock(ctx, likeIRI),
+		)
+		// Run
+		err := w.undo(ctx, undoOf(forger, forged))
+		// Verify
+		assertNotEqual(t, err, nil)
+	})
+	t.Run("FederatedUndoOfUnknownForeignLikeDereferenced", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		tp := NewMockTransport(ctl)
+		w := FederatingWrappedCallbacks{
+			db:       db,
+			inboxIRI: inboxIRI,
+			newTransport: func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (Transport, error) {
+				return tp, nil
+			},
+		}
+		forger := mustParse("https://forger.example.com/sam")
+		likeIRI := mustParse(testFederatedActivityIRI)
+		forged := streams.NewActivityStreamsLike()
+		forged.SetActivityStreamsId(idProp(likeIRI))
+		forged.SetActivityStreamsActor(actorProp(forger))
+		forged.SetActivityStreamsObject(objectProp(mustParse(testNoteId1)))
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, likeIRI),
+			db.EXPECT().Exists(ctx, likeIRI).Return(false, nil),
+			db.EXPECT().Unlock(ctx, likeIRI),
+			tp.EXPECT().Dereference(ctx, likeIRI).Return([]byte(`{"@context":"https://www.w3.org/ns/activitystreams","type":"Like","id":"`+testFederatedActivityIRI+`","actor":"`+testFederatedActorIRI+`","object":"`+testNoteId1+`"}`), nil),
+		)
+		// Run
+		err := w.undo(ctx, undoOf(forger, forged))
+		// Verify
+		assertNotEqual(t, err, nil)
+	})
+	t.Run("SocialUndoLikeByIRIRemovesFromLiked", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		deliverable := false
+		w := SocialWrappedCallbacks{db: db, outboxIRI: outboxIRI, deliverable: &deliverable}
+		likeIRI := mustParse(testNewActivityIRI)
+		like := streams.NewActivityStreamsLike()
+		like.SetActivityStreamsId(idProp(likeIRI))
+		like.SetActivityStreamsActor(actorProp(me))
+		like.SetActivityStreamsObject(objectProp(mustParse(testFederatedActivityIRI)))
+		undo := streams.NewActivityStreamsUndo()
+		undo.SetActivityStreamsActor(actorProp(me))
+		undo.SetActivityStreamsObject(objectProp(likeIRI))
+		liked := collection(mustParse(testFederatedActivityIRI), mustParse(testFederatedActivityIRI2))
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, likeIRI),
+			db.EXPECT().Exists(ctx, likeIRI).Return(true, nil),
+			db.EXPECT().Get(ctx, likeIRI).Return(like, nil),
+			db.EXPECT().Unlock(ctx, likeIRI),
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Liked(ctx, me).Return(liked, nil),
+			db.EXPECT().Update(ctx, liked).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := w.undo(ctx, undo)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, deliverable, true)
+		ids := itemIds(liked)
+		assertEqual(t, len(ids), 1)
+		assertEqual(t, ids[0], testFederatedActivityIRI2)
+	})
+	t.Run("SocialUndoFollowWithdrawsOutgoingFollow", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		deliverable := false
+		outgoing := NewMemoryPendingFollowStore()
+		w := SocialWrappedCallbacks{db: db, outboxIRI: outboxIRI, deliverable: &deliverable, OutgoingFollows: outgoing}
+		followIRI := mustParse(testNewActivityIRI)
+		follow := followOf(me, peer)
+		follow.SetActivityStreamsId(idProp(followIRI))
+		err := outgoing.AddPendingFollow(ctx, me, follow)
+		assertEqual(t, err, nil)
+		following := collection()
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, followIRI),
+			db.EXPECT().Exists(ctx, followIRI).Return(true, nil),
+			db.EXPECT().Get(ctx, followIRI).Return(follow, nil),
+			db.EXPECT().Unlock(ctx, followIRI),
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Following(ctx, me).Return(following, nil),
+			db.EXPECT().Update(ctx, following).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err = w.undo(ctx, undoOf(me, follow))
+		// Verify
+		assertEqual(t, err, nil)
+		pending, err := outgoing.GetPendingFollow(ctx, me, followIRI)
+		assertEqual(t, err, nil)
+		assertEqual(t, pending == nil, true)
+	})
+	t.Run("SocialUndoBlockRemovesBlocked", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		deliverable := false
+		w := SocialWrappedCallbacks{db: db, outboxIRI: outboxIRI, deliverable: &deliverable}
+		blockIRI := mustParse(testNewActivityIRI)
+		block := streams.NewActivityStreamsBlock()
+		block.SetActivityStreamsId(idProp(blockIRI))
+		block.SetActivityStreamsActor(actorProp(me))
+		block.SetActivityStreamsObject(objectProp(peer))
+		blocked := collection(peer)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, blockIRI),
+			db.EXPECT().Exists(ctx, blockIRI).Return(true, nil),
+			db.EXPECT().Get(ctx, blockIRI).Return(block, nil),
+			db.EXPECT().Unlock(ctx, blockIRI),
+			db.EXPECT().Lock(ctx, outboxIRI),
+			db.EXPECT().ActorForOutbox(ctx, outboxIRI).Return(me, nil),
+			db.EXPECT().Unlock(ctx, outboxIRI),
+			db.EXPECT().Lock(ctx, me),
+			db.EXPECT().Blocked(ctx, me).Return(blocked, nil),
+			db.EXPECT().Update(ctx, blocked).Return(nil),
+			db.EXPECT().Unlock(ctx, me),
+		)
+		// Run
+		err := w.undo(ctx, undoOf(me, block))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, len(itemIds(blocked)), 0)
+	})
+	t.Run("SocialUndoBlockDisabled", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		deliverable := false
+		w := SocialWrappedCallbacks{db: db, outboxIRI: outboxIRI, deliverable: &deliverable, DisableUndo: UndoBlock}
+		blockIRI := mustParse(testNewActivityIRI)
+		block := streams.NewActivityStreamsBlock()
+		block.SetActivityStreamsId(idProp(blockIRI))
+		block.SetActivityStreamsActor(actorProp(me))
+		block.SetActivityStreamsObject(objectProp(peer))
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, blockIRI),
+			db.EXPECT().Exists(ctx, blockIRI).Return(true, nil),
+			db.EXPECT().Get(ctx, blockIRI).Return(block, nil),
+			db.EXPECT().Unlock(ctx, blockIRI),
+		)
+		// Run
+		err := w.undo(ctx, undoOf(me, block))
+		// Verify
+		assertEqual(t, err, nil)
+	})
+	t.Run("RejectsUndoByOtherActor", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db := NewMockDatabase(ctl)
+		w := FederatingWrappedCallbacks{db: db, inboxIRI: inboxIRI}
+		// Run
+		err := w.undo(ctx, undoOf(mustParse(testFederatedActorIRI2), followOf(peer, me)))
+		// Verify
+		assertNotEqual(t, err, nil)
+	})
+}
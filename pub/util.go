@@ -10,8 +10,10 @@ import (
 	"fmt"
 	"github.com/go-fed/activity/streams"
 	"github.com/go-fed/activity/streams/vocab"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -31,6 +33,11 @@ var (
 	// set. Can be returned by DelegateActor's PostInbox or PostOutbox so a
 	// Bad Request response is set.
 	ErrTargetRequired = errors.New("target property required on the provided activity")
+	// ErrBlocked indicates the actor of the activity is blocked by the
+	// actor owning the inbox. Can be returned by DelegateActor's PostInbox
+	// so the activity is dropped while an Accepted response is set, which
+	// does not reveal the block.
+	ErrBlocked = errors.New("actor of the provided activity is blocked")
 )
 
 // activityStreamsMediaTypes contains all of the accepted ActivityStreams media
@@ -130,6 +137,12 @@ const (
 	jsonLDContext = "@context"
 )
 
+// Serialize turns an ActivityStreams value into a JSON-LD map, including its
+// '@context', ready to be marshalled as JSON.
+func Serialize(a vocab.Type) (m map[string]interface{}, e error) {
+	return serialize(a)
+}
+
 // addJSONLDContext adds the context vocabularies contained within the type
 // into the JSON-LD @context field, and aliases them appropriately.
 func serialize(a vocab.Type) (m map[string]interface{}, e error) {
@@ -172,10 +185,26 @@ const (
 	locationHeader = "Location"
 	// Contains the ActivityStreams Content-Type value.
 	contentTypeHeaderValue = "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\""
+	// The media types negotiated with the Accept header. Clients may
+	// prefer the ActivityPub one over the ActivityStreams one.
+	activityJSONMediaType = "application/activity+json"
+	ldJSONMediaType       = "application/ld+json"
+	// The Vary header.
+	varyHeader = "Vary"
 	// The Date header.
 	dateHeader = "Date"
 	// The Digest header.
 	digestHeader = "Digest"
+	// The Host header, which the standard library keeps apart from the
+	// other headers of a request.
+	hostHeader = "Host"
+	// Headers used to cache responses and make conditional requests.
+	cacheControlHeader    = "Cache-Control"
+	expiresHeader         = "Expires"
+	etagHeader            = "ETag"
+	lastModifiedHeader    = "Last-Modified"
+	ifNoneMatchHeader     = "If-None-Match"
+	ifModifiedSinceHeader = "If-Modified-Since"
 	// The delimiter used in the Digest header.
 	digestDelimiter = "="
 	// SHA-256 string for the Digest header.
@@ -189,12 +218,183 @@ func addResponseHeaders(h http.Header, c Clock, responseContent []byte) {
 	// RFC 7231 §7.1.1.2
 	h.Set(dateHeader, c.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05")+" GMT")
 	// RFC 3230 and RFC 5843
+	h.Set(digestHeader, digestHeaderValue(responseContent))
+}
+
+// writeResponse writes the serialized ActivityStreams value as the response to
+// a GET request, with the given status code.
+//
+// The Content-Type is negotiated with the request's Accept header. An ETag
+// is always set, and so is Last-Modified when lastModified is not zero. A
+// http.StatusOK response to a conditional request whose If-None-Match or
+// If-Modified-Since header matches is replaced by http.StatusNotModified,
+// without a body.
+func writeResponse(w http.ResponseWriter, r *http.Request, c Clock, raw []byte, status int, lastModified time.Time) error {
+	contentType := negotiateContentType(r)
+	h := w.Header()
+	// RFC 7231 §7.1.4
+	h.Add(varyHeader, acceptHeader)
+	// RFC 7232 §2.3
+	etag := etagValue(contentType, raw)
+	h.Set(etagHeader, etag)
+	// RFC 7232 §2.2
+	if !lastModified.IsZero() {
+		h.Set(lastModifiedHeader, lastModified.UTC().Format(http.TimeFormat))
+	}
+	if status == http.StatusOK && isNotModified(r, etag, lastModified) {
+		h.Set(dateHeader, c.Now().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	addResponseHeaders(h, c, raw)
+	h.Set(contentTypeHeader, contentType)
+	w.WriteHeader(status)
+	n, err := w.Write(raw)
+	if err != nil {
+		return err
+	} else if n != len(raw) {
+		return fmt.Errorf("only wrote %d of %d bytes", n, len(raw))
+	}
+	return nil
+}
+
+// negotiateContentType returns the Content-Type preferred by the request's
+// Accept header between the ActivityPub and the ActivityStreams media types,
+// using their quality values. Ties are won by the ActivityStreams media type,
+// which every client must accept.
+func negotiateContentType(r *http.Request) string {
+	activityQ, ldQ := -1.0, -1.0
+	for _, accept := range r.Header.Values(acceptHeader) {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			params := strings.Split(mediaRange, ";")
+			q := 1.0
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || strings.TrimSpace(kv[0]) != "q" {
+					continue
+				}
+				if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = v
+				}
+			}
+			switch strings.ToLower(strings.TrimSpace(params[0])) {
+			case activityJSONMediaType:
+				if q > activityQ {
+					activityQ = q
+				}
+			case ldJSONMediaType:
+				if q > ldQ {
+					ldQ = q
+				}
+			}
+		}
+	}
+	if activityQ > ldQ {
+		return activityJSONMediaType
+	}
+	return contentTypeHeaderValue
+}
+
+// etagValue returns a strong ETag for the content served with the
+// Content-Type, which is part of the representation.
+func etagValue(contentType string, content []byte) string {
+	hashed := sha256.Sum256(append([]byte(contentType+"\n"), content...))
+	return "\"" + base64.RawURLEncoding.EncodeToString(hashed[:]) + "\""
+}
+
+// isNotModified determines whether the client's copy of the response is
+// current, according to the conditional request headers. If-None-Match takes
+// precedence over If-Modified-Since, as in RFC 7232 §6.
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get(ifNoneMatchHeader); len(inm) > 0 {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// GET uses the weak comparison function.
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get(ifModifiedSinceHeader); len(ims) > 0 && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// lastModified returns the 'updated' time of the value, or else its
+// 'published' time. It is zero if neither is known.
+func lastModified(t vocab.Type) time.Time {
+	if u, ok := t.(updateder); ok {
+		if p := u.GetActivityStreamsUpdated(); p != nil && p.IsXMLSchemaDateTime() {
+			return p.Get()
+		}
+	}
+	if pub, ok := t.(publisheder); ok {
+		if p := pub.GetActivityStreamsPublished(); p != nil && p.IsXMLSchemaDateTime() {
+			return p.Get()
+		}
+	}
+	return time.Time{}
+}
+
+// digestHeaderValue returns the value of the Digest header for the content,
+// which is its SHA-256 digest.
+func digestHeaderValue(content []byte) string {
 	var b bytes.Buffer
 	b.WriteString(sha256Digest)
 	b.WriteString(digestDelimiter)
-	hashed := sha256.Sum256(responseContent)
+	hashed := sha256.Sum256(content)
 	b.WriteString(base64.StdEncoding.EncodeToString(hashed[:]))
-	h.Set(digestHeader, b.String())
+	return b.String()
+}
+
+// VerifyDigest ensures the Digest header of an incoming request matches its
+// body, as set by the HttpSigTransport when delivering. It is intended to be
+// used alongside verifying the HTTP Signature of the request, which must cover
+// the Digest header.
+//
+// The body of the request is restored so that it can be read again.
+func VerifyDigest(r *http.Request) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return verifyDigest(r.Header, body)
+}
+
+// verifyDigest ensures the Digest header matches the content. At least one of
+// the digests must be a SHA-256 one, and every SHA-256 digest must match.
+//
+// RFC 3230 and RFC 5843
+func verifyDigest(h http.Header, content []byte) error {
+	digest := h.Get(digestHeader)
+	if len(digest) == 0 {
+		return fmt.Errorf("missing %s header", digestHeader)
+	}
+	hashed := sha256.Sum256(content)
+	expected := base64.StdEncoding.EncodeToString(hashed[:])
+	found := false
+	for _, d := range strings.Split(digest, ",") {
+		kv := strings.SplitN(strings.TrimSpace(d), digestDelimiter, 2)
+		if len(kv) != 2 || !strings.EqualFold(kv[0], sha256Digest) {
+			continue
+		}
+		if kv[1] != expected {
+			return fmt.Errorf("%s header does not match the content", digestHeader)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%s header has no %s digest", digestHeader, sha256Digest)
+	}
+	return nil
 }
 
 // IdProperty is a property that can readily have its id obtained
@@ -423,6 +623,74 @@ func getInbox(t vocab.Type) (u *url.URL, err error) {
 	return ToId(inbox)
 }
 
+// getOutbox extracts the 'outbox' IRI from an actor type.
+func getOutbox(t vocab.Type) (u *url.URL, err error) {
+	ob, ok := t.(outboxer)
+	if !ok {
+		err = fmt.Errorf("actor type %T has no outbox", t)
+		return
+	}
+	outbox := ob.GetActivityStreamsOutbox()
+	if outbox == nil {
+		err = fmt.Errorf("actor type %T has no outbox", t)
+		return
+	}
+	return ToId(outbox)
+}
+
+// getSharedInboxes extracts the 'sharedInbox' IRIs from actor types, using
+// the 'inbox' IRI for those actors without one.
+func getSharedInboxes(t []vocab.Type) (u []*url.URL, err error) {
+	for _, elem := range t {
+		var iri *url.URL
+		iri, err = getSharedInbox(elem)
+		if err != nil {
+			return
+		} else if iri == nil {
+			iri, err = getInbox(elem)
+			if err != nil {
+				return
+			}
+		}
+		u = append(u, iri)
+	}
+	return
+}
+
+// getSharedInbox extracts the 'sharedInbox' IRI within the 'endpoints' of an
+// actor type. A nil IRI is returned if the actor has no sharedInbox.
+//
+// The 'endpoints' property is not part of the ActivityStreams vocabulary, so it
+// is obtained from the serialized actor.
+func getSharedInbox(t vocab.Type) (u *url.URL, err error) {
+	var m map[string]interface{}
+	m, err = t.Serialize()
+	if err != nil {
+		return
+	}
+	endpoints, ok := m["endpoints"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	sharedInbox, ok := endpoints["sharedInbox"].(string)
+	if !ok || len(sharedInbox) == 0 {
+		return
+	}
+	return url.Parse(sharedInbox)
+}
+
+// hasHiddenRecipients determines whether an activity has any 'bto' or 'bcc'
+// recipients.
+func hasHiddenRecipients(a Activity) bool {
+	if bto := a.GetActivityStreamsBto(); bto != nil && bto.Len() > 0 {
+		return true
+	}
+	if bcc := a.GetActivityStreamsBcc(); bcc != nil && bcc.Len() > 0 {
+		return true
+	}
+	return false
+}
+
 // dedupeIRIs will deduplicate final inbox IRIs. The ignore list is applied to
 // the final list.
 func dedupeIRIs(recipients, ignored []*url.URL) (out []*url.URL) {
@@ -777,59 +1045,140 @@ func toTombstone(obj vocab.Type, id *url.URL, now time.Time) vocab.ActivityStrea
 }
 
 // mustHaveActivityActorsMatchObjectActors ensures that the actors on types in
-// the 'object' property are all listed in the 'actor' property.
+// the 'object' property are all listed in the 'actor' property, and returns
+// those types.
+//
+// Objects that are IRIs are obtained from the Database if it has them, and are
+// otherwise dereferenced. So are embedded objects with an id, unless the id is
+// on the host of one of the actors: their embedded copy cannot be trusted to
+// have the actors of the value with that id.
 func mustHaveActivityActorsMatchObjectActors(c context.Context,
 	actors vocab.ActivityStreamsActorProperty,
 	op vocab.ActivityStreamsObjectProperty,
+	db Database,
 	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error),
-	boxIRI *url.URL) error {
+	boxIRI *url.URL) (objects []vocab.Type, err error) {
 	activityActorMap := make(map[string]bool, actors.Len())
+	actorHosts := make(map[string]bool, actors.Len())
 	for iter := actors.Begin(); iter != actors.End(); iter = iter.Next() {
 		id, err := ToId(iter)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		activityActorMap[id.String()] = true
+		actorHosts[id.Host] = true
 	}
 	for iter := op.Begin(); iter != op.End(); iter = iter.Next() {
 		t := iter.GetType()
 		if t == nil && iter.IsIRI() {
-			// Attempt to dereference the IRI instead
-			tport, err := newTransport(c, boxIRI, goFedUserAgent())
+			t, err = getOrDereference(c, iter.GetIRI(), db, newTransport, boxIRI)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			b, err := tport.Dereference(c, iter.GetIRI())
+		} else if t == nil {
+			return nil, fmt.Errorf("cannot verify actors: object is neither a value nor IRI")
+		} else if id, idErr := GetId(t); idErr == nil {
+			t, err = getStoredOrTrusted(c, t, id, actorHosts, db, newTransport, boxIRI)
 			if err != nil {
-				return err
-			}
-			var m map[string]interface{}
-			if err = json.Unmarshal(b, &m); err != nil {
-				return err
+				return nil, err
 			}
-			t, err = streams.ToType(c, m)
-			if err != nil {
-				return err
-			}
-		} else {
-			return fmt.Errorf("cannot verify actors: object is neither a value nor IRI")
 		}
 		ac, ok := t.(actorer)
 		if !ok {
-			return fmt.Errorf("cannot verify actors: object value has no 'actor' property")
+			return nil, fmt.Errorf("cannot verify actors: object value has no 'actor' property")
 		}
 		objActors := ac.GetActivityStreamsActor()
 		for iter := objActors.Begin(); iter != objActors.End(); iter = iter.Next() {
 			id, err := ToId(iter)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if !activityActorMap[id.String()] {
-				return fmt.Errorf("activity does not have all actors from its object's actors")
+				return nil, fmt.Errorf("activity does not have all actors from its object's actors")
 			}
 		}
+		objects = append(objects, t)
 	}
-	return nil
+	return objects, nil
+}
+
+// getStoredOrTrusted returns the copy of the embedded value with the id that is
+// stored in the Database, if any. Otherwise the embedded value is returned if
+// the id is on one of the trusted hosts, and else it is dereferenced on behalf
+// of the actor of the box.
+func getStoredOrTrusted(c context.Context,
+	t vocab.Type,
+	id *url.URL,
+	trustedHosts map[string]bool,
+	db Database,
+	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error),
+	boxIRI *url.URL) (vocab.Type, error) {
+	if err := db.Lock(c, id); err != nil {
+		return nil, err
+	}
+	// WARNING: Unlock not deferred
+	exists, err := db.Exists(c, id)
+	if err != nil {
+		db.Unlock(c, id)
+		return nil, err
+	} else if exists {
+		stored, err := db.Get(c, id)
+		db.Unlock(c, id)
+		return stored, err
+	}
+	db.Unlock(c, id)
+	// Unlock must have been called by this point and in every branch
+	// above.
+	if trustedHosts[id.Host] {
+		return t, nil
+	}
+	return dereference(c, id, newTransport, boxIRI)
+}
+
+// getOrDereference obtains the value with the IRI from the Database if it has
+// it, and otherwise dereferences it on behalf of the actor of the box.
+func getOrDereference(c context.Context,
+	iri *url.URL,
+	db Database,
+	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error),
+	boxIRI *url.URL) (t vocab.Type, err error) {
+	if err = db.Lock(c, iri); err != nil {
+		return
+	}
+	// WARNING: Unlock not deferred
+	exists, err := db.Exists(c, iri)
+	if err != nil {
+		db.Unlock(c, iri)
+		return
+	} else if exists {
+		t, err = db.Get(c, iri)
+		db.Unlock(c, iri)
+		return
+	}
+	db.Unlock(c, iri)
+	// Unlock must have been called by this point and in every branch
+	// above.
+	return dereference(c, iri, newTransport, boxIRI)
+}
+
+// dereference fetches the value with the IRI on behalf of the actor of the box.
+func dereference(c context.Context,
+	iri *url.URL,
+	newTransport func(c context.Context, actorBoxIRI *url.URL, gofedAgent string) (t Transport, err error),
+	boxIRI *url.URL) (t vocab.Type, err error) {
+	tport, err := newTransport(c, boxIRI, goFedUserAgent())
+	if err != nil {
+		return
+	}
+	b, err := tport.Dereference(c, iri)
+	if err != nil {
+		return
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return
+	}
+	return streams.ToType(c, m)
 }
 
 // add implements the logic of adding object ids to a target Collection or
@@ -949,44 +1298,8 @@ func remove(c context.Context,
 		if err != nil {
 			return err
 		}
-		if streams.ActivityStreamsOrderedCollectionIsExtendedBy(tp) {
-			oi, ok := tp.(orderedItemser)
-			if !ok {
-				return fmt.Errorf("type extending from OrderedCollection cannot convert to orderedItemser interface")
-			}
-			oiProp := oi.GetActivityStreamsOrderedItems()
-			if oiProp != nil {
-				for i := 0; i < oiProp.Len(); /*Conditional*/ {
-					id, err := ToId(oiProp.At(i))
-					if err != nil {
-						return err
-					}
-					if opIds[id.String()] {
-						oiProp.Remove(i)
-					} else {
-						i++
-					}
-				}
-			}
-		} else if streams.ActivityStreamsCollectionIsExtendedBy(tp) {
-			i, ok := tp.(itemser)
-			if !ok {
-				return fmt.Errorf("type extending from Collection cannot convert to itemser interface")
-			}
-			iProp := i.GetActivityStreamsItems()
-			if iProp != nil {
-				for i := 0; i < iProp.Len(); /*Conditional*/ {
-					id, err := ToId(iProp.At(i))
-					if err != nil {
-						return err
-					}
-					if opIds[id.String()] {
-						iProp.Remove(i)
-					} else {
-						i++
-					}
-				}
-			}
+		if err := removeIds(tp, opIds); err != nil {
+			return err
 		}
 		err = db.Update(c, tp)
 		if err != nil {
@@ -1002,6 +1315,80 @@ func remove(c context.Context,
 	return nil
 }
 
+// removeIds removes the items with the ids from the value if it is a
+// Collection or an OrderedCollection.
+func removeIds(t vocab.Type, ids map[string]bool) error {
+	if t.GetTypeName() == streams.ActivityStreamsOrderedCollectionName || streams.ActivityStreamsOrderedCollectionIsExtendedBy(t) {
+		oi, ok := t.(orderedItemser)
+		if !ok {
+			return fmt.Errorf("type extending from OrderedCollection cannot convert to orderedItemser interface")
+		}
+		oiProp := oi.GetActivityStreamsOrderedItems()
+		if oiProp != nil {
+			for i := 0; i < oiProp.Len(); /*Conditional*/ {
+				id, err := ToId(oiProp.At(i))
+				if err != nil {
+					return err
+				}
+				if ids[id.String()] {
+					oiProp.Remove(i)
+				} else {
+					i++
+				}
+			}
+		}
+	} else if t.GetTypeName() == streams.ActivityStreamsCollectionName || streams.ActivityStreamsCollectionIsExtendedBy(t) {
+		i, ok := t.(itemser)
+		if !ok {
+			return fmt.Errorf("type extending from Collection cannot convert to itemser interface")
+		}
+		iProp := i.GetActivityStreamsItems()
+		if iProp != nil {
+			for i := 0; i < iProp.Len(); /*Conditional*/ {
+				id, err := ToId(iProp.At(i))
+				if err != nil {
+					return err
+				}
+				if ids[id.String()] {
+					iProp.Remove(i)
+				} else {
+					i++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// containsId returns true if the id is one of the items of the Collection or
+// of the ordered items of the OrderedCollection.
+func containsId(t vocab.Type, id *url.URL) (bool, error) {
+	if col, ok := t.(itemser); ok {
+		if items := col.GetActivityStreamsItems(); items != nil {
+			for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+				itemId, err := ToId(iter)
+				if err != nil {
+					return false, err
+				} else if itemId.String() == id.String() {
+					return true, nil
+				}
+			}
+		}
+	} else if oCol, ok := t.(orderedItemser); ok {
+		if oItems := oCol.GetActivityStreamsOrderedItems(); oItems != nil {
+			for iter := oItems.Begin(); iter != oItems.End(); iter = iter.Next() {
+				itemId, err := ToId(iter)
+				if err != nil {
+					return false, err
+				} else if itemId.String() == id.String() {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
 // clearSensitiveFields removes the 'bto' and 'bcc' entries on the given value
 // and recursively on every 'object' property value.
 func clearSensitiveFields(obj vocab.Type) {
@@ -1,6 +1,9 @@
 package pub
 
 import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
 	"testing"
 )
 
@@ -74,3 +77,105 @@ func TestHeaderIsActivityPubMediaType(t *testing.T) {
 		})
 	}
 }
+
+func TestGetSharedInbox(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    map[string]interface{}
+		expected string
+	}{
+		{
+			"With Shared Inbox",
+			map[string]interface{}{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"type":     "Person",
+				"id":       "https://example.com/users/alice",
+				"inbox":    "https://example.com/users/alice/inbox",
+				"endpoints": map[string]interface{}{
+					"sharedInbox": "https://example.com/inbox",
+				},
+			},
+			"https://example.com/inbox",
+		},
+		{
+			"Without Endpoints",
+			map[string]interface{}{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"type":     "Person",
+				"id":       "https://example.com/users/alice",
+				"inbox":    "https://example.com/users/alice/inbox",
+			},
+			"",
+		},
+		{
+			"Without Shared Inbox",
+			map[string]interface{}{
+				"@context":  "https://www.w3.org/ns/activitystreams",
+				"type":      "Person",
+				"id":        "https://example.com/users/alice",
+				"inbox":     "https://example.com/users/alice/inbox",
+				"endpoints": map[string]interface{}{},
+			},
+			"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actor, err := streams.ToType(context.Background(), test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := getSharedInbox(actor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := ""
+			if u != nil {
+				actual = u.String()
+			}
+			if actual != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRemoveIds(t *testing.T) {
+	collection := streams.NewActivityStreamsCollection()
+	items := streams.NewActivityStreamsItemsProperty()
+	items.AppendIRI(mustParse(testFederatedActorIRI))
+	items.AppendIRI(mustParse(testFederatedActorIRI2))
+	collection.SetActivityStreamsItems(items)
+	ordered := streams.NewActivityStreamsOrderedCollection()
+	orderedItems := streams.NewActivityStreamsOrderedItemsProperty()
+	orderedItems.AppendIRI(mustParse(testFederatedActorIRI))
+	orderedItems.AppendIRI(mustParse(testFederatedActorIRI2))
+	ordered.SetActivityStreamsOrderedItems(orderedItems)
+	tests := []struct {
+		name  string
+		input vocab.Type
+		items func() int
+	}{
+		{
+			"Collection",
+			collection,
+			func() int { return collection.GetActivityStreamsItems().Len() },
+		},
+		{
+			"OrderedCollection",
+			ordered,
+			func() int { return ordered.GetActivityStreamsOrderedItems().Len() },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := removeIds(test.input, map[string]bool{testFederatedActorIRI: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := test.items(); n != 1 {
+				t.Fatalf("got %d items, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams/vocab"
+	"net/http"
+	"net/url"
+)
+
+// RequesterFunc determines the actor on whose behalf a GET request is made,
+// such as the owner of the key of a signed fetch, or the actor of a client's
+// credentials. A nil requester, with a nil error, is anonymous.
+type RequesterFunc func(c context.Context, r *http.Request) (requester *url.URL, err error)
+
+// VisibilityPolicy determines whether a requester may see ActivityStreams
+// values, based on how the values are addressed.
+//
+// A value is visible to everyone if it is addressed to the Public collection,
+// or if it is not addressed at all, such as an actor or a Tombstone. An
+// activity that is not addressed at all, such as a Block, is instead visible
+// only to its actors. Otherwise it is visible only to a requester that is its
+// 'actor' or 'attributedTo', that is addressed in its 'to', 'bto', 'cc', 'bcc',
+// or 'audience', or that is an item of a collection owned by this server
+// addressed there, such as the followers of its author. The collections of
+// peers are not dereferenced.
+type VisibilityPolicy struct {
+	// RevealForbidden responds to a GET of a value the requester may not
+	// see with http.StatusForbidden. By default, http.StatusNotFound is
+	// used instead so that the existence of the value is not revealed.
+	RevealForbidden bool
+
+	requester RequesterFunc
+	db        Database
+}
+
+// NewVisibilityPolicy returns a VisibilityPolicy using the RequesterFunc to
+// determine the requester, and the Database to look up the items of addressed
+// collections.
+func NewVisibilityPolicy(requester RequesterFunc, db Database) *VisibilityPolicy {
+	return &VisibilityPolicy{
+		requester: requester,
+		db:        db,
+	}
+}
+
+// Requester determines the actor making the request with the RequesterFunc. It
+// is nil if the request is anonymous.
+func (p *VisibilityPolicy) Requester(c context.Context, r *http.Request) (*url.URL, error) {
+	return p.requester(c, r)
+}
+
+// IsVisible determines whether the requester, which is nil if anonymous, may
+// see the value.
+func (p *VisibilityPolicy) IsVisible(c context.Context, requester *url.URL, t vocab.Type) (visible bool, err error) {
+	addressed, err := addressedIRIs(t)
+	if err != nil {
+		return
+	} else if len(addressed) == 0 {
+		return isUnaddressedVisible(requester, t)
+	}
+	for _, iri := range addressed {
+		if IsPublic(iri.String()) {
+			return true, nil
+		}
+	}
+	if requester == nil {
+		return false, nil
+	}
+	owners, err := ownerIRIs(t)
+	if err != nil {
+		return
+	}
+	for _, iri := range append(owners, addressed...) {
+		if iri.String() == requester.String() {
+			return true, nil
+		}
+	}
+	for _, iri := range addressed {
+		visible, err = p.collectionContains(c, iri, requester)
+		if err != nil || visible {
+			return
+		}
+	}
+	return false, nil
+}
+
+// FilterVisible returns the ids, in order, of the values in the Database that
+// the requester may see.
+func (p *VisibilityPolicy) FilterVisible(c context.Context, requester *url.URL, ids []*url.URL) (visible []*url.URL, err error) {
+	for _, id := range ids {
+		var t vocab.Type
+		t, err = p.get(c, id)
+		if err != nil {
+			return
+		} else if t == nil {
+			continue
+		}
+		var ok bool
+		ok, err = p.IsVisible(c, requester, t)
+		if err != nil {
+			return
+		} else if ok {
+			visible = append(visible, id)
+		}
+	}
+	return
+}
+
+// hiddenStatus returns the status code of the response to a GET of a value the
+// requester may not see.
+func (p *VisibilityPolicy) hiddenStatus() int {
+	if p.RevealForbidden {
+		return http.StatusForbidden
+	}
+	return http.StatusNotFound
+}
+
+// get obtains the value from the Database, or nil if it does not exist.
+func (p *VisibilityPolicy) get(c context.Context, id *url.URL) (t vocab.Type, err error) {
+	err = p.db.Lock(c, id)
+	if err != nil {
+		return
+	}
+	defer p.db.Unlock(c, id)
+	exists, err := p.db.Exists(c, id)
+	if err != nil || !exists {
+		return
+	}
+	return p.db.Get(c, id)
+}
+
+// collectionContains determines whether the IRI is of a collection owned by
+// this server which has the requester as an item.
+func (p *VisibilityPolicy) collectionContains(c context.Context, iri, requester *url.URL) (contains bool, err error) {
+	err = p.db.Lock(c, iri)
+	if err != nil {
+		return
+	}
+	// WARNING: Unlock not deferred
+	owns, err := p.db.Owns(c, iri)
+	if err != nil || !owns {
+		p.db.Unlock(c, iri)
+		return
+	}
+	exists, err := p.db.Exists(c, iri)
+	if err != nil || !exists {
+		p.db.Unlock(c, iri)
+		return
+	}
+	t, err := p.db.Get(c, iri)
+	p.db.Unlock(c, iri)
+	if err != nil {
+		return
+	}
+	// Unlock must have been called by this point and in every branch
+	// above.
+	var ids []*url.URL
+	if oc, ok := t.(orderedItemser); ok {
+		if oi := oc.GetActivityStreamsOrderedItems(); oi != nil {
+			for iter := oi.Begin(); iter != oi.End(); iter = iter.Next() {
+				var id *url.URL
+				if id, err = ToId(iter); err != nil {
+					return
+				}
+				ids = append(ids, id)
+			}
+		}
+	}
+	if col, ok := t.(itemser); ok {
+		if items := col.GetActivityStreamsItems(); items != nil {
+			for iter := items.Begin(); iter != items.End(); iter = iter.Next() {
+				var id *url.URL
+				if id, err = ToId(iter); err != nil {
+					return
+				}
+				ids = append(ids, id)
+			}
+		}
+	}
+	for _, id := range ids {
+		if id.String() == requester.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isUnaddressedVisible determines whether the requester may see a value that is
+// not addressed. Activities are visible only to their actors, and other values
+// to everyone.
+func isUnaddressedVisible(requester *url.URL, t vocab.Type) (bool, error) {
+	ac, ok := t.(actorer)
+	if !ok {
+		return true, nil
+	}
+	actors := ac.GetActivityStreamsActor()
+	if actors == nil || actors.Len() == 0 {
+		return true, nil
+	} else if requester == nil {
+		return false, nil
+	}
+	for iter := actors.Begin(); iter != actors.End(); iter = iter.Next() {
+		id, err := ToId(iter)
+		if err != nil {
+			return false, err
+		}
+		if id.String() == requester.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addressedIRIs returns the IRIs in the 'to', 'bto', 'cc', 'bcc', and
+// 'audience' properties of the value.
+func addressedIRIs(t vocab.Type) (iris []*url.URL, err error) {
+	appendId := func(i IdProperty) error {
+		id, err := ToId(i)
+		if err != nil {
+			return err
+		}
+		iris = append(iris, id)
+		return nil
+	}
+	if v, ok := t.(toer); ok {
+		if p := v.GetActivityStreamsTo(); p != nil {
+			for iter := p.Begin(); iter != p.End(); iter = iter.Next() {
+				if err = appendId(iter); err != nil {
+					return
+				}
+			}
+		}
+	}
+	if v, ok := t.(btoer); ok {
+		if p := v.GetActivityStreamsBto(); p != nil {
+			for iter := p.Begin(); iter != p.End(); iter = iter.Next() {
+				if err = appendId(iter); err != nil {
+					return
+				}
+			}
+		}
+	}
+	if v, ok := t.(ccer); ok {
+		if p := v.GetActivityStreamsCc(); p != nil {
+			for iter := p.Begin(); iter != p.End(); iter = iter.Next() {
+				if err = appendId(iter); err != nil {
+					return
+				}
+			}
+		}
+	}
+	if v, ok := t.(bccer); ok {
+		if p := v.GetActivityStreamsBcc(); p != nil {
+			for iter := p.Begin(); iter != p.End(); iter = iter.Next() {
+				if err = appendId(iter); err != nil {
+					return
+				}
+			}
+		}
+	}
+	if v, ok := t.(audiencer); ok {
+		if p := v.GetActivityStreamsAudience(); p != nil {
+			for iter := p.Begin(); iter != p.End(); iter = iter.Next() {
+				if err = appendId(iter); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// ownerIRIs returns the IRIs in the 'actor' and 'attributedTo' properties of
+// the value.
+func ownerIRIs(t vocab.Type) (iris []*url.URL, err error) {
+	if v, ok := t.(actorer); ok {
+		if p := v.GetActivityStreamsActor(); p != nil {
+			for iter := p.Begin(); iter != p.End(); iter = iter.Next() {
+				var id *url.URL
+				if id, err = ToId(iter); err != nil {
+					return
+				}
+				iris = append(iris, id)
+			}
+		}
+	}
+	if v, ok := t.(attributedToer); ok {
+		if p := v.GetActivityStreamsAttributedTo(); p != nil {
+			for iter := p.Begin(); iter != p.End(); iter = iter.Next() {
+				var id *url.URL
+				if id, err = ToId(iter); err != nil {
+					return
+				}
+				iris = append(iris, id)
+			}
+		}
+	}
+	return
+}
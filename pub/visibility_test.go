@@ -0,0 +1,188 @@
+package pub
+
+import (
+	"context"
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+	"github.com/golang/mock/gomock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestVisibilityPolicy ensures values are visible only to the requesters they
+// are addressed to.
+func TestVisibilityPolicy(t *testing.T) {
+	ctx := context.Background()
+	followersIRI := mustParse("https://example.com/addison/followers")
+	requester := mustParse(testFederatedActorIRI)
+	setupFn := func(ctl *gomock.Controller) (db *MockDatabase, p *VisibilityPolicy) {
+		db = NewMockDatabase(ctl)
+		p = NewVisibilityPolicy(func(c context.Context, r *http.Request) (*url.URL, error) {
+			return nil, nil
+		}, db)
+		return
+	}
+	noteTo := func(to ...*url.URL) vocab.ActivityStreamsNote {
+		n := streams.NewActivityStreamsNote()
+		if len(to) > 0 {
+			toProp := streams.NewActivityStreamsToProperty()
+			for _, iri := range to {
+				toProp.AppendIRI(iri)
+			}
+			n.SetActivityStreamsTo(toProp)
+		}
+		return n
+	}
+	t.Run("UnaddressedIsVisible", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, p := setupFn(ctl)
+		// Run
+		visible, err := p.IsVisible(ctx, nil, streams.NewActivityStreamsPerson())
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, visible, true)
+	})
+	t.Run("UnaddressedActivityIsVisibleToActorOnly", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, p := setupFn(ctl)
+		me := mustParse("https://example.com/addison")
+		block := streams.NewActivityStreamsBlock()
+		actor := streams.NewActivityStreamsActorProperty()
+		actor.AppendIRI(me)
+		block.SetActivityStreamsActor(actor)
+		object := streams.NewActivityStreamsObjectProperty()
+		object.AppendIRI(requester)
+		block.SetActivityStreamsObject(object)
+		// Run
+		anonymous, aErr := p.IsVisible(ctx, nil, block)
+		blocked, bErr := p.IsVisible(ctx, requester, block)
+		self, sErr := p.IsVisible(ctx, me, block)
+		// Verify
+		assertEqual(t, aErr, nil)
+		assertEqual(t, bErr, nil)
+		assertEqual(t, sErr, nil)
+		assertEqual(t, anonymous, false)
+		assertEqual(t, blocked, false)
+		assertEqual(t, self, true)
+	})
+	t.Run("PublicIsVisibleToAnonymous", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, p := setupFn(ctl)
+		// Run
+		visible, err := p.IsVisible(ctx, nil, noteTo(followersIRI, mustParse(PublicActivityPubIRI)))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, visible, true)
+	})
+	t.Run("DirectIsHiddenFromAnonymous", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, p := setupFn(ctl)
+		// Run
+		visible, err := p.IsVisible(ctx, nil, noteTo(requester))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, visible, false)
+	})
+	t.Run("DirectIsVisibleToRecipient", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, p := setupFn(ctl)
+		// Run
+		visible, err := p.IsVisible(ctx, requester, noteTo(requester))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, visible, true)
+	})
+	t.Run("DirectIsVisibleToAuthor", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		_, p := setupFn(ctl)
+		n := noteTo(mustParse(testFederatedActorIRI2))
+		attrTo := streams.NewActivityStreamsAttributedToProperty()
+		attrTo.AppendIRI(requester)
+		n.SetActivityStreamsAttributedTo(attrTo)
+		// Run
+		visible, err := p.IsVisible(ctx, requester, n)
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, visible, true)
+	})
+	t.Run("FollowersOnlyIsVisibleToFollower", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db, p := setupFn(ctl)
+		followers := streams.NewActivityStreamsCollection()
+		items := streams.NewActivityStreamsItemsProperty()
+		items.AppendIRI(requester)
+		followers.SetActivityStreamsItems(items)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, followersIRI),
+			db.EXPECT().Owns(ctx, followersIRI).Return(true, nil),
+			db.EXPECT().Exists(ctx, followersIRI).Return(true, nil),
+			db.EXPECT().Get(ctx, followersIRI).Return(followers, nil),
+			db.EXPECT().Unlock(ctx, followersIRI),
+		)
+		// Run
+		visible, err := p.IsVisible(ctx, requester, noteTo(followersIRI))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, visible, true)
+	})
+	t.Run("FollowersOnlyIsHiddenFromOthers", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db, p := setupFn(ctl)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, followersIRI),
+			db.EXPECT().Owns(ctx, followersIRI).Return(true, nil),
+			db.EXPECT().Exists(ctx, followersIRI).Return(true, nil),
+			db.EXPECT().Get(ctx, followersIRI).Return(streams.NewActivityStreamsCollection(), nil),
+			db.EXPECT().Unlock(ctx, followersIRI),
+		)
+		// Run
+		visible, err := p.IsVisible(ctx, requester, noteTo(followersIRI))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, visible, false)
+	})
+	t.Run("HandlerHidesValue", func(t *testing.T) {
+		// Setup
+		ctl := gomock.NewController(t)
+		defer ctl.Finish()
+		db, p := setupFn(ctl)
+		n := noteTo(requester)
+		id := streams.NewActivityStreamsIdProperty()
+		id.Set(mustParse(testNoteId1))
+		n.SetActivityStreamsId(id)
+		gomock.InOrder(
+			db.EXPECT().Lock(ctx, mustParse(testNoteId1)),
+			db.EXPECT().Get(ctx, mustParse(testNoteId1)).Return(n, nil),
+			db.EXPECT().Unlock(ctx, mustParse(testNoteId1)),
+		)
+		h := NewActivityStreamsHandlerWithOptions(func(c context.Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+			return false, nil
+		}, db, NewMockClock(ctl), ActivityStreamsHandlerOptions{Visibility: p})
+		resp := httptest.NewRecorder()
+		// Run
+		handled, err := h(ctx, resp, toAPRequest(httptest.NewRequest("GET", testNoteId1, nil)))
+		// Verify
+		assertEqual(t, err, nil)
+		assertEqual(t, handled, true)
+		assertEqual(t, resp.Code, http.StatusNotFound)
+		assertEqual(t, resp.Body.Len(), 0)
+	})
+}
@@ -0,0 +1,109 @@
+package webfinger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-fed/activity/pub"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxJRDBytes limits the size of the JRD read from a peer.
+const maxJRDBytes = 1 << 20
+
+// Resolver looks up resources, such as the actors of handles, with the
+// WebFinger endpoints of peers.
+type Resolver struct {
+	client   pub.HttpClient
+	appAgent string
+}
+
+// NewResolver returns a Resolver making WebFinger queries with the HttpClient.
+// The appAgent is sent as the User-Agent of the queries.
+func NewResolver(client pub.HttpClient, appAgent string) *Resolver {
+	return &Resolver{
+		client:   client,
+		appAgent: appAgent,
+	}
+}
+
+// Resolve returns the IRI of the ActivityPub actor with the handle, which is
+// one of "alice@example.com", "@alice@example.com", or
+// "acct:alice@example.com".
+func (r *Resolver) Resolve(c context.Context, handle string) (*url.URL, error) {
+	acct, host, err := parseHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	jrd, err := r.Finger(c, acct, host)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := jrd.ActorIRI()
+	if err != nil {
+		return nil, err
+	} else if actor == nil {
+		return nil, fmt.Errorf("webfinger: %s has no ActivityPub actor", acct)
+	}
+	return actor, nil
+}
+
+// Finger queries the WebFinger endpoint of the host for the resource and
+// returns its JRD.
+//
+// A response other than http.StatusOK is returned as a *pub.HttpStatusError.
+func (r *Resolver) Finger(c context.Context, resource, host string) (*JRD, error) {
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     WebFingerPath,
+		RawQuery: url.Values{"resource": []string{resource}}.Encode(),
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(c)
+	req.Header.Set("Accept", JRDMediaType)
+	req.Header.Set("User-Agent", r.appAgent)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &pub.HttpStatusError{
+			Method:     req.Method,
+			IRI:        u,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	b, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxJRDBytes})
+	if err != nil {
+		return nil, err
+	}
+	jrd := &JRD{}
+	if err = json.Unmarshal(b, jrd); err != nil {
+		return nil, err
+	}
+	return jrd, nil
+}
+
+// parseHandle returns the account URI and the host of the handle. The host is
+// after the last '@', so that it may have a port.
+func parseHandle(handle string) (acct, host string, err error) {
+	h := strings.TrimPrefix(strings.TrimPrefix(handle, acctScheme), "@")
+	i := strings.LastIndex(h, "@")
+	if i <= 0 || i == len(h)-1 {
+		err = fmt.Errorf("webfinger: %q is not a handle", handle)
+		return
+	}
+	acct = acctScheme + h
+	host = h[i+1:]
+	return
+}
@@ -0,0 +1,232 @@
+// Package webfinger implements WebFinger (RFC 7033), which servers of the
+// fediverse use to turn handles such as "alice@example.com" into the IRIs of
+// ActivityPub actors.
+//
+// NewHandler serves the "/.well-known/webfinger" and "/.well-known/host-meta"
+// endpoints of a server, and a Resolver looks up the handles of peers.
+package webfinger
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// WebFingerPath is the path of the WebFinger endpoint.
+	WebFingerPath = "/.well-known/webfinger"
+	// HostMetaPath is the path of the host-meta (RFC 6415) document, which
+	// points older clients to the WebFinger endpoint.
+	HostMetaPath = "/.well-known/host-meta"
+	// HostMetaJSONPath is the path of the JSON host-meta document.
+	HostMetaJSONPath = "/.well-known/host-meta.json"
+	// JRDMediaType is the media type of a JRD.
+	JRDMediaType = "application/jrd+json"
+	// xrdMediaType is the media type of the host-meta document.
+	xrdMediaType = "application/xrd+xml"
+	// SelfRel is the relation of the link to the ActivityPub actor.
+	SelfRel = "self"
+	// ProfilePageRel is the relation of the link to the web page of the
+	// actor.
+	ProfilePageRel = "http://webfinger.net/rel/profile-page"
+	// activityMediaType is the type of the link to the ActivityPub actor.
+	// Links with an "application/ld+json" type are also accepted.
+	activityMediaType = "application/activity+json"
+	// acctScheme is the scheme of account URIs (RFC 7565).
+	acctScheme = "acct:"
+	// lrddRel is the relation of the host-meta link to WebFinger.
+	lrddRel = "lrdd"
+)
+
+// JRD is a JSON Resource Descriptor, which describes the resource of a
+// WebFinger query.
+type JRD struct {
+	// Subject is the URI of the resource, such as
+	// "acct:alice@example.com".
+	Subject string `json:"subject"`
+	// Aliases are other URIs of the resource, such as the IRI of the
+	// actor.
+	Aliases []string `json:"aliases,omitempty"`
+	// Links point to representations of the resource.
+	Links []Link `json:"links,omitempty"`
+}
+
+// Link is a link of a JRD.
+type Link struct {
+	// Rel is the relation of the link.
+	Rel string `json:"rel"`
+	// Type is the media type of the target of the link.
+	Type string `json:"type,omitempty"`
+	// Href is the target of the link.
+	Href string `json:"href,omitempty"`
+	// Template is the target of the link, as a URI template.
+	Template string `json:"template,omitempty"`
+}
+
+// NewActorJRD returns the JRD of an actor with the account URI, linking to the
+// actor's IRI and, if it is not nil, to its profile page.
+func NewActorJRD(acct string, actorIRI, profilePage *url.URL) *JRD {
+	jrd := &JRD{
+		Subject: acct,
+		Aliases: []string{actorIRI.String()},
+		Links: []Link{
+			{
+				Rel:  SelfRel,
+				Type: activityMediaType,
+				Href: actorIRI.String(),
+			},
+		},
+	}
+	if profilePage != nil {
+		jrd.Links = append(jrd.Links, Link{
+			Rel:  ProfilePageRel,
+			Type: "text/html",
+			Href: profilePage.String(),
+		})
+	}
+	return jrd
+}
+
+// ActorIRI returns the IRI of the ActivityPub actor linked by the JRD, or nil
+// if there is none.
+func (j *JRD) ActorIRI() (*url.URL, error) {
+	for _, link := range j.Links {
+		if link.Rel != SelfRel || (link.Type != activityMediaType && !strings.HasPrefix(link.Type, "application/ld+json")) {
+			continue
+		}
+		return url.Parse(link.Href)
+	}
+	return nil, nil
+}
+
+// Lookup finds the resources of the server for a Handler.
+type Lookup interface {
+	// Lookup returns the JRD of the resource, which is either an account
+	// URI such as "acct:alice@example.com" or another URI of the resource,
+	// such as the IRI of an actor.
+	//
+	// If the server has no such resource, found must be false and the
+	// error nil.
+	Lookup(c context.Context, resource string) (jrd *JRD, found bool, err error)
+}
+
+// handler serves WebFinger and host-meta requests.
+type handler struct {
+	lookup Lookup
+}
+
+// NewHandler returns an http.Handler serving WebFinger queries from the
+// Lookup at WebFingerPath, and the host-meta documents at HostMetaPath and
+// HostMetaJSONPath. Other paths are not found.
+//
+// Only the links of the requested relations are served, if any are requested
+// with the "rel" query parameter.
+func NewHandler(lookup Lookup) http.Handler {
+	return &handler{lookup: lookup}
+}
+
+// ServeHTTP answers the WebFinger or host-meta request.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// RFC 7033 §5
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	switch r.URL.Path {
+	case WebFingerPath:
+		h.serveWebFinger(w, r)
+	case HostMetaPath:
+		h.serveHostMeta(w, r)
+	case HostMetaJSONPath:
+		writeJSON(w, JRDMediaType, &JRD{Links: []Link{hostMetaLink(r)}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveWebFinger answers a WebFinger query.
+func (h *handler) serveWebFinger(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	resource := query.Get("resource")
+	if len(resource) == 0 {
+		http.Error(w, "missing resource", http.StatusBadRequest)
+		return
+	}
+	jrd, found, err := h.lookup.Lookup(r.Context(), resource)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	} else if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if rels := query["rel"]; len(rels) > 0 {
+		filtered := *jrd
+		filtered.Links = nil
+		for _, link := range jrd.Links {
+			for _, rel := range rels {
+				if link.Rel == rel {
+					filtered.Links = append(filtered.Links, link)
+					break
+				}
+			}
+		}
+		jrd = &filtered
+	}
+	writeJSON(w, JRDMediaType, jrd)
+}
+
+// xrd is the host-meta XML document.
+type xrd struct {
+	XMLName xml.Name  `xml:"http://docs.oasis-open.org/ns/xri/xrd-1.0 XRD"`
+	Links   []xrdLink `xml:"Link"`
+}
+
+// xrdLink is a link of the host-meta XML document.
+type xrdLink struct {
+	Rel      string `xml:"rel,attr"`
+	Type     string `xml:"type,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+// serveHostMeta answers with the host-meta XML document.
+func (h *handler) serveHostMeta(w http.ResponseWriter, r *http.Request) {
+	link := hostMetaLink(r)
+	b, err := xml.Marshal(&xrd{Links: []xrdLink{{Rel: link.Rel, Type: link.Type, Template: link.Template}}})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", xrdMediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+// hostMetaLink returns the host-meta link to the WebFinger endpoint of the
+// requested host.
+func hostMetaLink(r *http.Request) Link {
+	u := url.URL{Scheme: "https", Host: r.Host, Path: WebFingerPath}
+	return Link{
+		Rel:      lrddRel,
+		Type:     JRDMediaType,
+		Template: u.String() + "?resource={uri}",
+	}
+}
+
+// writeJSON responds with the value as JSON.
+func writeJSON(w http.ResponseWriter, mediaType string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
@@ -0,0 +1,182 @@
+package webfinger
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-fed/activity/pub"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testAcct     = "acct:alice@example.com"
+	testActorIRI = "https://example.com/users/alice"
+)
+
+// mustParse parses the IRI or panics.
+func mustParse(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// mapLookup looks up resources in a map.
+type mapLookup map[string]*JRD
+
+// Lookup returns the JRD in the map.
+func (m mapLookup) Lookup(c context.Context, resource string) (*JRD, bool, error) {
+	jrd, ok := m[resource]
+	return jrd, ok, nil
+}
+
+// newLookup returns a Lookup of the test actor by its account URI and IRI.
+func newLookup() Lookup {
+	jrd := NewActorJRD(testAcct, mustParse(testActorIRI), mustParse("https://example.com/@alice"))
+	return mapLookup{
+		testAcct:     jrd,
+		testActorIRI: jrd,
+	}
+}
+
+// TestHandler ensures WebFinger and host-meta requests are answered.
+func TestHandler(t *testing.T) {
+	h := NewHandler(newLookup())
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, httptest.NewRequest(method, target, nil))
+		return resp
+	}
+	t.Run("ServesJRD", func(t *testing.T) {
+		// Run
+		resp := serve("GET", "https://example.com/.well-known/webfinger?resource="+url.QueryEscape(testAcct))
+		// Verify
+		if resp.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d", http.StatusOK, resp.Code)
+		}
+		if ct := resp.Header().Get("Content-Type"); ct != JRDMediaType {
+			t.Fatalf("expected %q, got %q", JRDMediaType, ct)
+		}
+		if o := resp.Header().Get("Access-Control-Allow-Origin"); o != "*" {
+			t.Fatalf("expected CORS header, got %q", o)
+		}
+		jrd := &JRD{}
+		if err := json.Unmarshal(resp.Body.Bytes(), jrd); err != nil {
+			t.Fatal(err)
+		}
+		if jrd.Subject != testAcct || len(jrd.Links) != 2 {
+			t.Fatalf("unexpected JRD: %+v", jrd)
+		}
+		actor, err := jrd.ActorIRI()
+		if err != nil {
+			t.Fatal(err)
+		} else if actor.String() != testActorIRI {
+			t.Fatalf("expected %s, got %s", testActorIRI, actor)
+		}
+	})
+	t.Run("FiltersLinksByRel", func(t *testing.T) {
+		// Run
+		resp := serve("GET", "https://example.com/.well-known/webfinger?rel=self&resource="+url.QueryEscape(testActorIRI))
+		// Verify
+		jrd := &JRD{}
+		if err := json.Unmarshal(resp.Body.Bytes(), jrd); err != nil {
+			t.Fatal(err)
+		}
+		if len(jrd.Links) != 1 || jrd.Links[0].Rel != SelfRel {
+			t.Fatalf("unexpected links: %+v", jrd.Links)
+		}
+	})
+	t.Run("RejectsMissingResource", func(t *testing.T) {
+		// Run
+		resp := serve("GET", "https://example.com/.well-known/webfinger")
+		// Verify
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d, got %d", http.StatusBadRequest, resp.Code)
+		}
+	})
+	t.Run("UnknownResourceIsNotFound", func(t *testing.T) {
+		// Run
+		resp := serve("GET", "https://example.com/.well-known/webfinger?resource=acct:bob@example.com")
+		// Verify
+		if resp.Code != http.StatusNotFound {
+			t.Fatalf("expected %d, got %d", http.StatusNotFound, resp.Code)
+		}
+	})
+	t.Run("RejectsPost", func(t *testing.T) {
+		// Run
+		resp := serve("POST", "https://example.com/.well-known/webfinger?resource="+url.QueryEscape(testAcct))
+		// Verify
+		if resp.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, resp.Code)
+		}
+	})
+	t.Run("ServesHostMeta", func(t *testing.T) {
+		// Run
+		resp := serve("GET", "https://example.com/.well-known/host-meta")
+		// Verify
+		if resp.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d", http.StatusOK, resp.Code)
+		}
+		const template = `template="https://example.com/.well-known/webfinger?resource={uri}"`
+		if body := resp.Body.String(); !strings.Contains(body, template) {
+			t.Fatalf("expected %s in %s", template, body)
+		}
+	})
+}
+
+// TestResolver ensures handles are resolved to actors with the WebFinger
+// endpoints of peers.
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	var gotAgent string
+	lookup := mapLookup{}
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAgent = r.Header.Get("User-Agent")
+		NewHandler(lookup).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	host := mustParse(ts.URL).Host
+	r := NewResolver(ts.Client(), "testApp")
+	t.Run("ResolvesHandleForms", func(t *testing.T) {
+		// Setup
+		acct := "acct:alice@" + host
+		lookup[acct] = NewActorJRD(acct, mustParse(testActorIRI), nil)
+		for _, handle := range []string{"alice@" + host, "@alice@" + host, acct} {
+			// Run
+			actor, err := r.Resolve(ctx, handle)
+			// Verify
+			if err != nil {
+				t.Fatalf("%s: %s", handle, err)
+			} else if actor.String() != testActorIRI {
+				t.Fatalf("%s: expected %s, got %s", handle, testActorIRI, actor)
+			} else if gotAgent != "testApp" {
+				t.Fatalf("expected user agent testApp, got %q", gotAgent)
+			}
+		}
+	})
+	t.Run("ReturnsStatusError", func(t *testing.T) {
+		// Run
+		_, err := r.Resolve(ctx, "bob@"+host)
+		// Verify
+		statusErr, ok := err.(*pub.HttpStatusError)
+		if !ok {
+			t.Fatalf("expected *pub.HttpStatusError, got %v", err)
+		} else if statusErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected %d, got %d", http.StatusNotFound, statusErr.StatusCode)
+		}
+	})
+	t.Run("RejectsInvalidHandle", func(t *testing.T) {
+		for _, handle := range []string{"alice", "@alice", "alice@", "acct:@example.com"} {
+			// Run
+			_, err := r.Resolve(ctx, handle)
+			// Verify
+			if err == nil {
+				t.Fatalf("%s: expected an error", handle)
+			}
+		}
+	})
+}